@@ -0,0 +1,194 @@
+package book
+
+// running open quantity and notional of one side, kept up to date from the events of the book
+type sideTotals struct {
+	volume   float64
+	notional float64
+}
+
+func (t *sideTotals) move(price float32, quantity float32) {
+	t.volume += float64(quantity)
+	t.notional += float64(price) * float64(quantity)
+}
+
+func (this *Orderbook) totals(bidOrAsk bool) *sideTotals {
+	if bidOrAsk {
+		return &this.bidTotals
+	}
+	return &this.askTotals
+}
+
+// follows every change of open quantity, fills take from the side of the resting order
+func (this *Orderbook) track(e Event) {
+	switch e.Type {
+	case EventAdded:
+		this.totals(e.BidOrAsk).move(e.Price, e.Quantity)
+	case EventFill:
+		this.totals(!e.BidOrAsk).move(e.Price, -e.Quantity)
+	case EventCancelled:
+		if !e.Expired {
+			this.totals(e.BidOrAsk).move(e.Price, -e.Quantity)
+		}
+	}
+}
+
+// an empty side starts again from zero, rounding does not pile up across levels
+func (this *Orderbook) resetTotals(bidOrAsk bool) {
+	side := this.Asks
+	if bidOrAsk {
+		side = this.Bids
+	}
+	if side.Size() == 0 {
+		*this.totals(bidOrAsk) = sideTotals{}
+	}
+}
+
+// open quantity resting on one side
+func (this *Orderbook) SideVolume(bidOrAsk bool) float32 {
+	return float32(this.totals(bidOrAsk).volume)
+}
+
+// sum of price times open quantity over one side
+func (this *Orderbook) SideNotional(bidOrAsk bool) float64 {
+	return this.totals(bidOrAsk).notional
+}
+
+// best bid and ask with their volumes, false if a side is empty
+func (this *Orderbook) top() (bid, bidVolume, ask, askVolume float32, ok bool) {
+	if this.BLength() == 0 || this.ALength() == 0 {
+		return 0, 0, 0, 0, false
+	}
+	bid, ask = this.GetBestBid(), this.GetBestOffer()
+	return bid, this.GetVolumeAtBidLimit(bid), ask, this.GetVolumeAtAskLimit(ask), true
+}
+
+// best ask minus best bid, false if a side is empty
+func (this *Orderbook) Spread() (float32, bool) {
+	bid, _, ask, _, ok := this.top()
+	return ask - bid, ok
+}
+
+// halfway between the best bid and ask, false if a side is empty
+func (this *Orderbook) Mid() (float32, bool) {
+	bid, _, ask, _, ok := this.top()
+	return (bid + ask) / 2, ok
+}
+
+// mid weighted by the volume of the opposite best level, it leans toward the side
+// about to be taken. false if a side is empty
+func (this *Orderbook) Microprice() (float32, bool) {
+	bid, bidVolume, ask, askVolume, ok := this.top()
+	if !ok {
+		return 0, false
+	}
+	total := float64(bidVolume) + float64(askVolume)
+	return float32((float64(bid)*float64(askVolume) + float64(ask)*float64(bidVolume)) / total), true
+}
+
+// (bids - asks) / (bids + asks) over the volume of the best n levels of each side,
+// from -1 when only asks rest to 1 when only bids do. n <= 0 takes the whole book from
+// the running totals, n > 0 walks the n levels on every call
+func (this *Orderbook) Imbalance(n int) float64 {
+	var bids, asks float64
+	if n <= 0 {
+		bids, asks = this.bidTotals.volume, this.askTotals.volume
+	} else {
+		bids, asks = this.topVolume(true, n), this.topVolume(false, n)
+	}
+	if bids+asks <= 0 {
+		return 0
+	}
+	return (bids - asks) / (bids + asks)
+}
+
+func (this *Orderbook) topVolume(bidOrAsk bool, n int) float64 {
+	var volume float64
+	this.walk(bidOrAsk, func(price float32, level *OrdersQueue) bool {
+		volume += float64(level.TotalVolume())
+		n--
+		return n > 0
+	})
+	return volume
+}
+
+// walks the levels of one side, best price first
+func (this *Orderbook) walk(bidOrAsk bool, fn func(price float32, level *OrdersQueue) bool) {
+	if bidOrAsk {
+		this.Bids.Descend(fn)
+	} else {
+		this.Asks.Ascend(fn)
+	}
+}
+
+// what taking liquidity from one side would get, worked out on the levels as they
+// rest without touching them
+type Sweep struct {
+	Quantity float32
+	Notional float64
+	// average price paid or received
+	VWAP float32
+	// best level of the side and last level reached
+	BestPrice  float32
+	WorstPrice float32
+	// VWAP away from the best price, positive when it costs more than the best level
+	Slippage float32
+	Levels   int
+	// false if the side ran out before the target was reached
+	Complete bool
+}
+
+// sweeps the bids (bidOrAsk) or the asks for quantity, walking every level it reaches
+// on each call
+func (this *Orderbook) SweepCost(bidOrAsk bool, quantity float32) Sweep {
+	left := float64(quantity)
+	return this.sweep(bidOrAsk, func(price float32, volume float64) (float64, bool) {
+		take := volume
+		if take >= left {
+			take = left
+		}
+		left -= take
+		return take, left <= 0
+	})
+}
+
+// cumulative depth of the bids (bidOrAsk) or the asks, best price first,
+// until notional is reached. the last level is taken only in part. like SweepCost
+// it walks the levels it reaches on each call
+func (this *Orderbook) DepthToNotional(bidOrAsk bool, notional float64) Sweep {
+	left := notional
+	return this.sweep(bidOrAsk, func(price float32, volume float64) (float64, bool) {
+		take := volume
+		if price > 0 && take*float64(price) >= left {
+			take = left / float64(price)
+		}
+		left -= take * float64(price)
+		return take, left <= 0
+	})
+}
+
+// take says how much of each level to use and whether the target is met
+func (this *Orderbook) sweep(bidOrAsk bool, take func(price float32, volume float64) (float64, bool)) Sweep {
+	var s Sweep
+	var quantity float64
+	this.walk(bidOrAsk, func(price float32, level *OrdersQueue) bool {
+		if s.Levels == 0 {
+			s.BestPrice = price
+		}
+		q, done := take(price, float64(level.TotalVolume()))
+		quantity += q
+		s.Notional += q * float64(price)
+		s.WorstPrice = price
+		s.Levels++
+		s.Complete = done
+		return !done
+	})
+	s.Quantity = float32(quantity)
+	if quantity > 0 {
+		s.VWAP = float32(s.Notional / quantity)
+		s.Slippage = s.VWAP - s.BestPrice
+		if bidOrAsk {
+			s.Slippage = -s.Slippage
+		}
+	}
+	return s
+}
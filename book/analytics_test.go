@@ -0,0 +1,136 @@
+package book
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"matching-engine-go/orders"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-4
+}
+
+// totals summed level by level, to check the running ones against
+func sideSums(book *Orderbook, bidOrAsk bool) (volume, notional float64) {
+	for _, level := range book.Depth(bidOrAsk, 0) {
+		volume += float64(level.Volume)
+		notional += float64(level.Price) * float64(level.Volume)
+	}
+	return volume, notional
+}
+
+func TestAnalyticsEmptyBook(t *testing.T) {
+	book := NewOrderbook()
+	if _, ok := book.Spread(); ok {
+		t.Errorf("no spread without quotes")
+	}
+	if _, ok := book.Microprice(); ok {
+		t.Errorf("no microprice without quotes")
+	}
+	if i := book.Imbalance(0); i != 0 {
+		t.Errorf("imbalance of an empty book should be 0, got %v", i)
+	}
+	if s := book.SweepCost(false, 1); s.Complete || s.Quantity != 0 {
+		t.Errorf("nothing to sweep, got %+v", s)
+	}
+}
+
+func TestAnalyticsTopOfBook(t *testing.T) {
+	// bids 100x1, 99x4, 98x2, asks 101x3, 102x3, 103x3
+	book := soundBook(NewRedBlackSide)
+
+	if s, ok := book.Spread(); !ok || s != 1 {
+		t.Errorf("spread should be 1, got %v %v", s, ok)
+	}
+	if m, ok := book.Mid(); !ok || m != 100.5 {
+		t.Errorf("mid should be 100.5, got %v %v", m, ok)
+	}
+	// 100*3/4 + 101*1/4
+	if m, ok := book.Microprice(); !ok || !near(float64(m), 100.25) {
+		t.Errorf("microprice should be 100.25, got %v %v", m, ok)
+	}
+	if i := book.Imbalance(1); !near(i, (1.0-3)/4) {
+		t.Errorf("top level imbalance should be -0.5, got %v", i)
+	}
+	if i := book.Imbalance(2); !near(i, (5.0-6)/11) {
+		t.Errorf("two level imbalance should be -1/11, got %v", i)
+	}
+	if i := book.Imbalance(0); !near(i, (7.0-9)/16) {
+		t.Errorf("whole book imbalance should be -1/8, got %v", i)
+	}
+}
+
+func TestAnalyticsSweepCost(t *testing.T) {
+	book := soundBook(NewRedBlackSide)
+	before := book.Snapshot()
+
+	buy := book.SweepCost(false, 4)
+	if !buy.Complete || buy.Quantity != 4 || buy.Levels != 2 || buy.WorstPrice != 102 {
+		t.Errorf("buying 4 should take 3@101 and 1@102, got %+v", buy)
+	}
+	if !near(buy.Notional, 405) || !near(float64(buy.VWAP), 101.25) || !near(float64(buy.Slippage), 0.25) {
+		t.Errorf("buying 4 should cost 405 at 101.25, got %+v", buy)
+	}
+
+	sell := book.SweepCost(true, 3)
+	if !sell.Complete || !near(float64(sell.VWAP), (100+2*99)/3.0) || !near(float64(sell.Slippage), 100-(100+2*99)/3.0) {
+		t.Errorf("selling 3 should take 1@100 and 2@99, got %+v", sell)
+	}
+
+	all := book.SweepCost(true, 10)
+	if all.Complete || all.Quantity != 7 || all.Levels != 3 {
+		t.Errorf("the bids only hold 7, got %+v", all)
+	}
+
+	if !reflect.DeepEqual(before, book.Snapshot()) {
+		t.Errorf("sweep cost must not touch the book")
+	}
+}
+
+func TestAnalyticsDepthToNotional(t *testing.T) {
+	book := soundBook(NewRedBlackSide)
+
+	d := book.DepthToNotional(false, 505)
+	// 303 at 101, then 202 of the 306 at 102
+	if !d.Complete || d.Levels != 2 || !near(float64(d.Quantity), 4.98039) || !near(d.Notional, 505) {
+		t.Errorf("505 of asks should reach into 102, got %+v", d)
+	}
+	d = book.DepthToNotional(false, 10000)
+	if d.Complete || d.Quantity != 9 || !near(d.Notional, 918) {
+		t.Errorf("the asks only hold 918, got %+v", d)
+	}
+}
+
+func TestAnalyticsRunningTotals(t *testing.T) {
+	for name, newSide := range map[string]func() BookSide{"tree": NewRedBlackSide, "ladder": NewPriceLadderSide} {
+		book := soundBook(newSide)
+		check := func(step string) {
+			for _, bidOrAsk := range []bool{true, false} {
+				volume, notional := sideSums(&book, bidOrAsk)
+				if !near(float64(book.SideVolume(bidOrAsk)), volume) || !near(book.SideNotional(bidOrAsk), notional) {
+					t.Errorf("%s %s side %v: running %v/%v, levels sum to %v/%v", name, step, bidOrAsk,
+						book.SideVolume(bidOrAsk), book.SideNotional(bidOrAsk), volume, notional)
+				}
+			}
+		}
+		check("placed")
+
+		book.Reduce(6, 1)
+		check("reduced")
+		book.Cancel(2)
+		check("cancelled")
+
+		o := orders.NewOrder(orders.NewIncomingOrder(102, 4, true, orders.LIMIT, 3), 20)
+		book.Execute(&o)
+		check("swept")
+
+		o = orders.NewOrder(orders.NewIncomingOrder(0, 100, false, orders.MARKET, 3), 21)
+		book.Execute(&o)
+		check("bids emptied")
+		if book.SideVolume(true) != 0 || book.SideNotional(true) != 0 {
+			t.Errorf("%s: an empty side should total 0", name)
+		}
+	}
+}
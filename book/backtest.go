@@ -0,0 +1,422 @@
+package book
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"matching-engine-go/orders"
+)
+
+type L3Type uint8
+
+const (
+	// a new order rests in the book
+	L3Add L3Type = iota
+	// a resting order is cancelled, in full or in part
+	L3Cancel
+	// a resting order trades against an aggressor of the feed
+	L3Execute
+)
+
+func (t L3Type) String() string {
+	switch t {
+	case L3Add:
+		return "add"
+	case L3Cancel:
+		return "cancel"
+	case L3Execute:
+		return "execute"
+	}
+	return "unknown"
+}
+
+// one record of a historical order by order feed
+type L3Event struct {
+	// nanoseconds, the feed is replayed in timestamp order
+	Timestamp int64
+	Type      L3Type
+	// id of the order in the feed
+	OrderId uint64
+	// side and price of an add, cancels and executes refer to the order added
+	BidOrAsk bool
+	Price    float32
+	// quantity added, cancelled or executed. a cancel of 0 removes the whole order
+	Quantity float32
+}
+
+// reads a feed from CSV with a header naming the columns timestamp, type, id, side, price
+// and quantity. type is add, cancel or execute and side is buy or sell
+func ReadL3CSV(r io.Reader) ([]L3Event, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("l3 header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"timestamp", "type", "id"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("l3 header: no %s column", name)
+		}
+	}
+
+	events := make([]L3Event, 0)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		e, err := parseL3Record(record, columns)
+		if err != nil {
+			return nil, fmt.Errorf("l3 line %d: %w", line, err)
+		}
+		events = append(events, e)
+	}
+}
+
+func parseL3Record(record []string, columns map[string]int) (L3Event, error) {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	var e L3Event
+	var err error
+	if e.Timestamp, err = strconv.ParseInt(field("timestamp"), 10, 64); err != nil {
+		return e, fmt.Errorf("timestamp: %w", err)
+	}
+	switch field("type") {
+	case "add":
+		e.Type = L3Add
+	case "cancel":
+		e.Type = L3Cancel
+	case "execute":
+		e.Type = L3Execute
+	default:
+		return e, fmt.Errorf("unknown type %q", field("type"))
+	}
+	if e.OrderId, err = strconv.ParseUint(field("id"), 10, 64); err != nil {
+		return e, fmt.Errorf("id: %w", err)
+	}
+	switch field("side") {
+	case "buy":
+		e.BidOrAsk = true
+	case "sell", "":
+	default:
+		return e, fmt.Errorf("unknown side %q", field("side"))
+	}
+	if s := field("price"); s != "" {
+		price, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return e, fmt.Errorf("price: %w", err)
+		}
+		e.Price = float32(price)
+	}
+	if s := field("quantity"); s != "" {
+		quantity, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return e, fmt.Errorf("quantity: %w", err)
+		}
+		e.Quantity = float32(quantity)
+	}
+	if e.Type == L3Add && e.Quantity <= 0 {
+		return e, ErrInvalidQuantity
+	}
+	return e, nil
+}
+
+// Strategy is the code under test in a backtest. Its callbacks may place and cancel orders
+// through the simulator.
+type Strategy interface {
+	// after each event of the feed has been applied to the book
+	OnEvent(sim *Simulator, e L3Event)
+	// for every fill of one of the strategy's orders
+	OnFill(sim *Simulator, f SimFill)
+}
+
+// a fill of one of the strategy's orders
+type SimFill struct {
+	Timestamp  int64
+	SequenceId uint32
+	BidOrAsk   bool
+	Price      float32
+	Quantity   float32
+	// quantity of the order still open after the fill
+	Remaining float32
+	// the order was resting and got hit, rather than crossing the spread itself
+	Maker bool
+}
+
+// outcome of a backtest
+type BacktestReport struct {
+	// feed events applied, and the ones about orders no longer in the book or not valid
+	Events  int
+	Skipped int
+
+	// orders and cancels of the strategy
+	Orders  int
+	Cancels int
+	Fills   int
+	// traded quantity as maker and as taker
+	MakerVolume float32
+	TakerVolume float32
+	// volume weighted prices of the buys and the sells, 0 without any
+	AvgBuyPrice  float32
+	AvgSellPrice float32
+
+	Position float32
+	Cash     float64
+	// the position is valued at the mid, or at the last trade when a side is empty
+	MarkPrice float32
+	PnL       float64
+}
+
+// Simulator replays a historical feed into an Orderbook alongside the orders of a strategy.
+// Feed executions are replayed as aggressive orders at the price of the order executed, so
+// they fill whatever is ahead of it in the queue first, strategy orders included. The fills
+// of the strategy follow from its actual queue position rather than from the feed.
+type Simulator struct {
+	book     Orderbook
+	strategy Strategy
+
+	// book sequenceIds of the feed orders
+	feed map[uint64]uint32
+	// working orders of the strategy
+	own  map[uint32]struct{}
+	next uint32
+	now  int64
+
+	// fills waiting for the strategy, they are handed over once the book is done matching
+	pending  []SimFill
+	flushing bool
+
+	report    BacktestReport
+	bought    float64
+	sold      float64
+	boughtQty float32
+	soldQty   float32
+	lastTrade float32
+}
+
+func NewSimulator(strategy Strategy) *Simulator {
+	s := &Simulator{
+		strategy: strategy,
+		feed:     make(map[uint64]uint32),
+		own:      make(map[uint32]struct{}),
+	}
+	// events carry the feed time
+	s.book = NewOrderbookWithConfig(BookConfig{Now: s.Now})
+	s.book.Subscribe(s.onEvent)
+	return s
+}
+
+// replays the feed in timestamp order, events with the same timestamp keep their order
+func (s *Simulator) Run(events []L3Event) BacktestReport {
+	sorted := append([]L3Event(nil), events...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp < sorted[j].Timestamp })
+	for _, e := range sorted {
+		s.apply(e)
+	}
+	return s.Report()
+}
+
+// the book as the strategy would see it, it must not be changed directly
+func (s *Simulator) Book() *Orderbook {
+	return &s.book
+}
+
+// timestamp of the feed event being replayed
+func (s *Simulator) Now() int64 {
+	return s.now
+}
+
+func (s *Simulator) Position() float32 {
+	return s.report.Position
+}
+
+// places an order of the strategy, returns its sequenceId
+func (s *Simulator) Submit(incoming orders.IncomingOrder) (uint32, error) {
+	if err := ValidateOrder(incoming); err != nil {
+		return 0, err
+	}
+	s.next++
+	o := orders.NewOrder(incoming, s.next)
+	s.own[o.SequenceId] = struct{}{}
+	s.report.Orders++
+	_, err := s.book.Execute(&o)
+	if _, resting := s.book.Order(o.SequenceId); !resting {
+		delete(s.own, o.SequenceId)
+	}
+	s.flush()
+	return o.SequenceId, err
+}
+
+// cancels a resting order of the strategy, false if it is not resting
+func (s *Simulator) Cancel(sequenceId uint32) bool {
+	if _, ok := s.own[sequenceId]; !ok {
+		return false
+	}
+	delete(s.own, sequenceId)
+	if _, ok := s.book.Cancel(sequenceId); !ok {
+		return false
+	}
+	s.report.Cancels++
+	return true
+}
+
+// open quantity and number of orders ahead of a resting order of the strategy
+func (s *Simulator) QueuePosition(sequenceId uint32) (float32, int, bool) {
+	return s.book.QueuePosition(sequenceId)
+}
+
+func (s *Simulator) Report() BacktestReport {
+	r := s.report
+	if s.boughtQty > 0 {
+		r.AvgBuyPrice = float32(s.bought / float64(s.boughtQty))
+	}
+	if s.soldQty > 0 {
+		r.AvgSellPrice = float32(s.sold / float64(s.soldQty))
+	}
+	r.MarkPrice = s.lastTrade
+	if s.book.BLength() > 0 && s.book.ALength() > 0 {
+		r.MarkPrice = (s.book.GetBestBid() + s.book.GetBestOffer()) / 2
+	}
+	r.PnL = r.Cash + float64(r.Position)*float64(r.MarkPrice)
+	return r
+}
+
+func (s *Simulator) apply(e L3Event) {
+	s.now = e.Timestamp
+	switch e.Type {
+	case L3Add:
+		incoming := orders.NewIncomingOrder(e.Price, e.Quantity, e.BidOrAsk, orders.LIMIT, 0)
+		if ValidateOrder(incoming) != nil {
+			s.report.Skipped++
+			return
+		}
+		s.next++
+		o := orders.NewOrder(incoming, s.next)
+		s.feed[e.OrderId] = o.SequenceId
+		s.book.Execute(&o)
+	case L3Cancel:
+		sequenceId, ok := s.resting(e.OrderId)
+		if !ok {
+			s.report.Skipped++
+			return
+		}
+		if e.Quantity <= 0 {
+			s.book.Cancel(sequenceId)
+		} else {
+			s.book.Reduce(sequenceId, e.Quantity)
+		}
+	case L3Execute:
+		sequenceId, ok := s.resting(e.OrderId)
+		if !ok {
+			s.report.Skipped++
+			return
+		}
+		resting, _ := s.book.Order(sequenceId)
+		// the aggressor of the feed, limited to the price of the order it executed
+		s.next++
+		aggressor := orders.NewOrder(orders.NewIncomingOrder(resting.Order.Price, e.Quantity, !resting.Order.BidOrAsk, orders.LIMIT, 0), s.next)
+		s.book.Execute(&aggressor)
+		// what the strategy took ahead of it must not rest on the other side
+		s.book.Cancel(aggressor.SequenceId)
+	}
+	s.report.Events++
+	s.flush()
+	s.strategy.OnEvent(s, e)
+}
+
+// book sequenceId of a feed order still resting, forgets the orders that are gone
+func (s *Simulator) resting(orderId uint64) (uint32, bool) {
+	sequenceId, ok := s.feed[orderId]
+	if !ok {
+		return 0, false
+	}
+	if _, ok := s.book.Order(sequenceId); !ok {
+		delete(s.feed, orderId)
+		return 0, false
+	}
+	return sequenceId, true
+}
+
+// book events, only the fills of the strategy matter
+func (s *Simulator) onEvent(ev Event) {
+	if ev.Type != EventFill {
+		return
+	}
+	s.lastTrade = ev.Price
+	if _, ok := s.own[ev.SequenceId]; ok {
+		s.fill(SimFill{
+			Timestamp:  s.now,
+			SequenceId: ev.SequenceId,
+			BidOrAsk:   ev.BidOrAsk,
+			Price:      ev.Price,
+			Quantity:   ev.Quantity,
+			Remaining:  ev.Remaining,
+		})
+	}
+	if _, ok := s.own[ev.MakerSequenceId]; ok {
+		s.fill(SimFill{
+			Timestamp:  s.now,
+			SequenceId: ev.MakerSequenceId,
+			BidOrAsk:   !ev.BidOrAsk,
+			Price:      ev.Price,
+			Quantity:   ev.Quantity,
+			Remaining:  ev.MakerRemaining,
+			Maker:      true,
+		})
+		if ev.MakerRemaining <= 0 {
+			delete(s.own, ev.MakerSequenceId)
+		}
+	}
+}
+
+func (s *Simulator) fill(f SimFill) {
+	notional := float64(f.Price) * float64(f.Quantity)
+	if f.BidOrAsk {
+		s.report.Position += f.Quantity
+		s.report.Cash -= notional
+		s.bought += notional
+		s.boughtQty += f.Quantity
+	} else {
+		s.report.Position -= f.Quantity
+		s.report.Cash += notional
+		s.sold += notional
+		s.soldQty += f.Quantity
+	}
+	if f.Maker {
+		s.report.MakerVolume += f.Quantity
+	} else {
+		s.report.TakerVolume += f.Quantity
+	}
+	s.report.Fills++
+	s.pending = append(s.pending, f)
+}
+
+// hands the pending fills to the strategy, orders it places from OnFill queue theirs behind
+func (s *Simulator) flush() {
+	if s.flushing {
+		return
+	}
+	s.flushing = true
+	defer func() { s.flushing = false }()
+
+	for len(s.pending) > 0 {
+		f := s.pending[0]
+		s.pending = s.pending[1:]
+		s.strategy.OnFill(s, f)
+	}
+}
@@ -0,0 +1,132 @@
+package book
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"matching-engine-go/orders"
+)
+
+// joins the bid once the first order is in, then sells half into the bid once filled
+type joinStrategy struct {
+	id    uint32
+	fills []SimFill
+	ahead []float32
+}
+
+func (j *joinStrategy) OnEvent(sim *Simulator, e L3Event) {
+	if j.id == 0 && e.OrderId == 1 {
+		j.id, _ = sim.Submit(orders.NewIncomingOrder(100, 2, true, orders.LIMIT, 0))
+	}
+	if ahead, _, ok := sim.QueuePosition(j.id); ok {
+		j.ahead = append(j.ahead, ahead)
+	}
+}
+
+func (j *joinStrategy) OnFill(sim *Simulator, f SimFill) {
+	j.fills = append(j.fills, f)
+	if f.Maker && f.Remaining == 0 {
+		sim.Submit(orders.NewIncomingOrder(0, 1, false, orders.MARKET, 0))
+	}
+}
+
+func TestSimulatorQueuePosition(t *testing.T) {
+	feed := []L3Event{
+		{Timestamp: 1, Type: L3Add, OrderId: 1, BidOrAsk: true, Price: 100, Quantity: 5},
+		{Timestamp: 2, Type: L3Add, OrderId: 2, BidOrAsk: true, Price: 100, Quantity: 5},
+		{Timestamp: 3, Type: L3Add, OrderId: 3, Price: 102, Quantity: 10},
+		// fills order 1 only, the strategy is behind it
+		{Timestamp: 5, Type: L3Execute, OrderId: 1, Quantity: 3},
+		{Timestamp: 4, Type: L3Cancel, OrderId: 1, Quantity: 1},
+		{Timestamp: 6, Type: L3Execute, OrderId: 1, Quantity: 1},
+		// the aggressor on order 2 hits the strategy first
+		{Timestamp: 7, Type: L3Execute, OrderId: 2, Quantity: 3},
+		{Timestamp: 8, Type: L3Cancel, OrderId: 1},
+		{Timestamp: 9, Type: L3Add, OrderId: 4, BidOrAsk: true, Price: 101, Quantity: 1},
+	}
+	strategy := &joinStrategy{}
+	report := NewSimulator(strategy).Run(feed)
+
+	if want := []float32{5, 5, 5, 4, 1, 0}; len(strategy.ahead) < len(want) || !equalFloats(strategy.ahead[:len(want)], want) {
+		t.Errorf("the queue position should shrink as the feed trades, got %v", strategy.ahead)
+	}
+	if len(strategy.fills) != 2 || !strategy.fills[0].Maker || strategy.fills[0].Timestamp != 7 ||
+		strategy.fills[1].Maker || strategy.fills[1].Price != 100 || strategy.fills[1].Quantity != 1 {
+		t.Fatalf("the strategy should be hit at 7 then sell into the bid, got %+v", strategy.fills)
+	}
+	if report.Events != 8 || report.Skipped != 1 {
+		t.Errorf("the cancel of an order gone should be skipped, got %d events %d skipped", report.Events, report.Skipped)
+	}
+	if report.Orders != 2 || report.Fills != 2 || report.MakerVolume != 2 || report.TakerVolume != 1 ||
+		report.AvgBuyPrice != 100 || report.AvgSellPrice != 100 || report.Position != 1 {
+		t.Errorf("unexpected fill statistics %+v", report)
+	}
+	// one left, marked at the mid of 101 and 102
+	if report.Cash != -100 || report.MarkPrice != 101.5 || report.PnL != 1.5 {
+		t.Errorf("unexpected P&L %+v", report)
+	}
+}
+
+func TestSimulatorRejectsInvalidOrders(t *testing.T) {
+	sim := NewSimulator(&joinStrategy{})
+	nan := float32(math.NaN())
+	sim.Run([]L3Event{
+		{Timestamp: 1, Type: L3Add, OrderId: 1, BidOrAsk: true, Price: nan, Quantity: 5},
+		{Timestamp: 2, Type: L3Add, OrderId: 2, Price: 101, Quantity: nan},
+	})
+	for _, incoming := range []orders.IncomingOrder{
+		orders.NewIncomingOrder(nan, 1, true, orders.LIMIT, 0),
+		orders.NewIncomingOrder(100, nan, true, orders.LIMIT, 0),
+		orders.NewIncomingOrder(0, 1, true, orders.LIMIT, 0),
+	} {
+		if _, err := sim.Submit(incoming); err == nil {
+			t.Errorf("%+v should be refused", incoming)
+		}
+	}
+	if report := sim.Report(); report.Skipped != 2 || report.Orders != 0 {
+		t.Errorf("the bad adds should be skipped, got %+v", report)
+	}
+	if sim.Book().BLength() != 0 || sim.Book().ALength() != 0 || len(sim.Book().CheckInvariants()) != 0 {
+		t.Errorf("nothing should reach the book")
+	}
+}
+
+func equalFloats(a []float32, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReadL3CSV(t *testing.T) {
+	events, err := ReadL3CSV(strings.NewReader("timestamp,type,id,side,price,quantity\n1,add,7,buy,10.5,3\n2,execute,7,,,1\n3,cancel,7,,,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []L3Event{
+		{Timestamp: 1, Type: L3Add, OrderId: 7, BidOrAsk: true, Price: 10.5, Quantity: 3},
+		{Timestamp: 2, Type: L3Execute, OrderId: 7, Quantity: 1},
+		{Timestamp: 3, Type: L3Cancel, OrderId: 7},
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d should be %+v, got %+v", i, want[i], events[i])
+		}
+	}
+
+	for _, bad := range []string{
+		"type,id\nadd,1\n",
+		"timestamp,type,id\n1,modify,1\n",
+		"timestamp,type,id,quantity\n1,add,1,0\n",
+	} {
+		if _, err := ReadL3CSV(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q should not parse", bad)
+		}
+	}
+}
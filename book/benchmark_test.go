@@ -1,8 +1,10 @@
-package main
+package book
 
 import (
 	"math/rand"
 	"testing"
+
+	"matching-engine-go/orders"
 )
 
 func benchmarkOrderbookLimitedRandomInsert(n int, b *testing.B) {
@@ -15,33 +17,33 @@ func benchmarkOrderbookLimitedRandomInsert(n int, b *testing.B) {
 	}
 
 	// preallocate empty orders
-	orders := make([]*Order, 0, b.N)
+	preallocated := make([]*orders.Order, 0, b.N)
 	for i := 0; i < b.N; i += 1 {
-		orders = append(orders, &Order{})
+		preallocated = append(preallocated, &orders.Order{})
 	}
 
 	// initialize the ringbuffer for each price
 	for i := 0; i < b.N; i += 1 {
 		price := limitslist[rand.Intn(len(limitslist))]
 		// create a new order
-		o := orders[i]
+		o := preallocated[i]
 		o.Order.Quantity = 1
 		o.Order.BidOrAsk = price < 0.5
 
 		// add to the book
-		book.Add(price, o)
+		book.add(price, o)
 	}
 	// measure insertion time after all ringbuffer are initialized
 	b.ResetTimer()
 	for i := 0; i < b.N; i += 1 {
 		price := limitslist[rand.Intn(len(limitslist))]
 		// create a new order
-		o := orders[i]
+		o := preallocated[i]
 		o.Order.Quantity = 1
 		o.Order.BidOrAsk = price < 0.5
 
 		// add to the book
-		book.Add(price, o)
+		book.add(price, o)
 	}
 
 	//fmt.Printf("bid size %d, ask size %d\n", book.BLength(), book.ALength())
@@ -51,3 +53,22 @@ func benchmarkOrderbookLimitedRandomInsert(n int, b *testing.B) {
 func BenchmarkOrderbook5kLevelsRandomInsert(b *testing.B) {
 	benchmarkOrderbookLimitedRandomInsert(10000, b)
 }
+
+// execute latency and throughput under each built-in profile
+func BenchmarkWorkload(b *testing.B) {
+	for _, profile := range DefaultProfiles() {
+		b.Run(profile.Name, func(b *testing.B) {
+			book := NewOrderbook()
+			runner := NewLoadRunner(&book)
+			// warm up the book so it has depth to match against
+			runner.Run(NewWorkload(profile), 10000, false)
+			profile.Seed++
+			b.ResetTimer()
+			result := runner.Run(NewWorkload(profile), b.N, false)
+			b.ReportMetric(result.Throughput, "ops/s")
+			b.ReportMetric(float64(result.Execute.Quantile(0.5)), "p50-ns")
+			b.ReportMetric(float64(result.Execute.Quantile(0.99)), "p99-ns")
+			b.ReportMetric(float64(result.Execute.Quantile(0.999)), "p99.9-ns")
+		})
+	}
+}
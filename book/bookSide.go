@@ -0,0 +1,58 @@
+package book
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrNotATree = errors.New("orderbook: book side is not a red black tree")
+
+// BookSide is one side of the orderbook: the set of price levels kept in price order.
+// The red black tree is the default backend, any other sorted structure can be plugged
+// into the orderbook through BookConfig.NewSide.
+type BookSide interface {
+	// number of price levels
+	Size() int
+	IsEmpty() bool
+	Contains(price float32) bool
+	// Get panics if the level does not exist
+	Get(price float32) *OrdersQueue
+	// Put inserts a new level or replaces the queue of an existing one
+	Put(price float32, level *OrdersQueue)
+	// Delete is a no-op if the level does not exist
+	Delete(price float32)
+	// Min and Max panic if the side is empty
+	Min() float32
+	Max() float32
+	// next level strictly above / below price, false if there is none
+	Higher(price float32) (float32, bool)
+	Lower(price float32) (float32, bool)
+	// in-order iteration, stops as soon as fn returns false
+	Ascend(fn func(price float32, level *OrdersQueue) bool)
+	Descend(fn func(price float32, level *OrdersQueue) bool)
+}
+
+// the red black tree as a book side, price levels keyed by price
+type levelTree = redBlackBST[float32, *OrdersQueue]
+type levelNode = nodeRedBlack[float32, *OrdersQueue]
+
+// NewRedBlackSide returns a book side backed by the red black tree
+func NewRedBlackSide() BookSide {
+	t := newRedBlackBST[float32, *OrdersQueue]()
+	return &t
+}
+
+// NewPriceLadderSide returns a book side backed by a sorted slice
+func NewPriceLadderSide() BookSide {
+	l := newPriceLadder()
+	return &l
+}
+
+// FprintTree writes the structure of a red black tree side to w, see redBlackBST.Fprint
+func FprintTree(w io.Writer, side BookSide) error {
+	t, ok := side.(*levelTree)
+	if !ok {
+		return ErrNotATree
+	}
+	return t.Fprint(w)
+}
@@ -0,0 +1,252 @@
+package book
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"matching-engine-go/orders"
+)
+
+// every BookSide backend has to pass the same conformance suite
+var bookSideBackends = map[string]func() BookSide{
+	"redBlackBST": NewRedBlackSide,
+	"priceLadder": NewPriceLadderSide,
+}
+
+func forEachBookSide(t *testing.T, test func(t *testing.T, newSide func() BookSide)) {
+	for name, newSide := range bookSideBackends {
+		t.Run(name, func(t *testing.T) {
+			test(t, newSide)
+		})
+	}
+}
+
+func TestBookSideEmpty(t *testing.T) {
+	forEachBookSide(t, func(t *testing.T, newSide func() BookSide) {
+		s := newSide()
+		if s.Size() != 0 || !s.IsEmpty() {
+			t.Errorf("book side should be empty")
+		}
+		if _, ok := s.Higher(0); ok {
+			t.Errorf("empty side should have no higher level")
+		}
+		if _, ok := s.Lower(0); ok {
+			t.Errorf("empty side should have no lower level")
+		}
+		// deleting a missing level is a no-op
+		s.Delete(1)
+		if !s.IsEmpty() {
+			t.Errorf("book side should be empty")
+		}
+	})
+}
+
+func TestBookSidePutGetDelete(t *testing.T) {
+	forEachBookSide(t, func(t *testing.T, newSide func() BookSide) {
+		s := newSide()
+		levels := make(map[float32]*OrdersQueue)
+		for i := 0; i < 100; i += 1 {
+			price := float32(rand.Intn(1000))
+			q := newOrdersQueue(price, orderByteSize)
+			levels[price] = &q
+			s.Put(price, &q)
+		}
+
+		if s.Size() != len(levels) {
+			t.Errorf("size should equals %d, got %d", len(levels), s.Size())
+		}
+		for price, q := range levels {
+			if !s.Contains(price) {
+				t.Errorf("side should contain %0.8f", price)
+			}
+			if s.Get(price) != q {
+				t.Errorf("side should return the queue stored at %0.8f", price)
+			}
+		}
+
+		// replacing the queue of an existing level keeps the size
+		var price float32
+		for price = range levels {
+			break
+		}
+		q := newOrdersQueue(price, orderByteSize)
+		s.Put(price, &q)
+		if s.Get(price) != &q || s.Size() != len(levels) {
+			t.Errorf("put on an existing level should replace its queue")
+		}
+
+		s.Delete(price)
+		delete(levels, price)
+		if s.Contains(price) || s.Size() != len(levels) {
+			t.Errorf("level %0.8f should be deleted", price)
+		}
+		// missing levels are ignored
+		s.Delete(-1)
+		s.Delete(1001)
+		if s.Size() != len(levels) {
+			t.Errorf("deleting a missing level should not change the side")
+		}
+	})
+}
+
+func TestBookSideMinMax(t *testing.T) {
+	forEachBookSide(t, func(t *testing.T, newSide func() BookSide) {
+		s := newSide()
+		for i := 0; i < 100; i += 1 {
+			s.Put(float32(100-i), nil)
+		}
+		if s.Min() != 1 || s.Max() != 100 {
+			t.Errorf("min %0.8f max %0.8f should be 1 and 100", s.Min(), s.Max())
+		}
+
+		for i := 1; i < 20; i += 1 {
+			s.Delete(float32(i))
+		}
+		for i := 100; i > 70; i -= 1 {
+			s.Delete(float32(i))
+		}
+		if s.Min() != 20 || s.Max() != 70 {
+			t.Errorf("min %0.8f max %0.8f should be 20 and 70", s.Min(), s.Max())
+		}
+	})
+}
+
+func TestBookSideHigherLower(t *testing.T) {
+	forEachBookSide(t, func(t *testing.T, newSide func() BookSide) {
+		s := newSide()
+		for i := 0; i < 10; i += 1 {
+			s.Put(float32(2*i), nil)
+		}
+
+		if p, ok := s.Higher(4); !ok || p != 6 {
+			t.Errorf("higher than a level should be the next level, got %0.8f", p)
+		}
+		if p, ok := s.Higher(5); !ok || p != 6 {
+			t.Errorf("higher than a gap should be the next level, got %0.8f", p)
+		}
+		if p, ok := s.Lower(4); !ok || p != 2 {
+			t.Errorf("lower than a level should be the previous level, got %0.8f", p)
+		}
+		if p, ok := s.Lower(5); !ok || p != 4 {
+			t.Errorf("lower than a gap should be the previous level, got %0.8f", p)
+		}
+		if _, ok := s.Higher(18); ok {
+			t.Errorf("max level should have no higher level")
+		}
+		if _, ok := s.Lower(0); ok {
+			t.Errorf("min level should have no lower level")
+		}
+	})
+}
+
+func TestBookSideAscendDescend(t *testing.T) {
+	forEachBookSide(t, func(t *testing.T, newSide func() BookSide) {
+		s := newSide()
+		keys := make(map[float32]bool)
+		for i := 0; i < 1000; i += 1 {
+			k := rand.Float32()
+			keys[k] = true
+			s.Put(k, nil)
+		}
+		sorted := make([]float32, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		i := 0
+		s.Ascend(func(price float32, level *OrdersQueue) bool {
+			if price != sorted[i] {
+				t.Errorf("ascend out of order at %d", i)
+				return false
+			}
+			i += 1
+			return true
+		})
+		if i != len(sorted) {
+			t.Errorf("ascend should visit %d levels, visited %d", len(sorted), i)
+		}
+
+		i = len(sorted) - 1
+		s.Descend(func(price float32, level *OrdersQueue) bool {
+			if price != sorted[i] {
+				t.Errorf("descend out of order at %d", i)
+				return false
+			}
+			i -= 1
+			return true
+		})
+		if i != -1 {
+			t.Errorf("descend should visit every level")
+		}
+
+		// iteration stops early
+		visited := 0
+		s.Ascend(func(price float32, level *OrdersQueue) bool {
+			visited += 1
+			return visited < 10
+		})
+		if visited != 10 {
+			t.Errorf("ascend should stop after 10 levels, visited %d", visited)
+		}
+	})
+}
+
+// walking the side with Higher / Lower must visit the same levels as Ascend / Descend
+func TestBookSideTraversal(t *testing.T) {
+	forEachBookSide(t, func(t *testing.T, newSide func() BookSide) {
+		s := newSide()
+		for i := 0; i < 500; i += 1 {
+			s.Put(float32(rand.Intn(2000)), nil)
+		}
+		for i := 0; i < 200; i += 1 {
+			s.Delete(float32(rand.Intn(2000)))
+		}
+
+		n := 1
+		for p, ok := s.Higher(s.Min()); ok; p, ok = s.Higher(p) {
+			n += 1
+		}
+		if n != s.Size() {
+			t.Errorf("walking up should visit %d levels, visited %d", s.Size(), n)
+		}
+
+		n = 1
+		for p, ok := s.Lower(s.Max()); ok; p, ok = s.Lower(p) {
+			n += 1
+		}
+		if n != s.Size() {
+			t.Errorf("walking down should visit %d levels, visited %d", s.Size(), n)
+		}
+	})
+}
+
+// the matching logic must not depend on the backend
+func TestBookSideOrderbookMatching(t *testing.T) {
+	forEachBookSide(t, func(t *testing.T, newSide func() BookSide) {
+		b := NewOrderbookWithConfig(BookConfig{NewSide: newSide})
+		for i := 0; i < 10; i += 1 {
+			bid := NewCustomOrder(float32(90+i), 10, true, orders.LIMIT, 1, uint32(i))
+			b.Execute(&bid)
+			ask := NewCustomOrder(float32(101+i), 10, false, orders.LIMIT, 1, uint32(10+i))
+			b.Execute(&ask)
+		}
+		if b.GetBestBid() != 99 || b.GetBestOffer() != 101 {
+			t.Errorf("best bid %0.8f best offer %0.8f should be 99 and 101", b.GetBestBid(), b.GetBestOffer())
+		}
+
+		// sweeps three ask levels and rests the remainder
+		bid := NewCustomOrder(103, 35, true, orders.LIMIT, 1, 20)
+		b.Execute(&bid)
+		if bid.ExecutedQuantity != 30 {
+			t.Errorf("bid should execute 30, executed %0.8f", bid.ExecutedQuantity)
+		}
+		if b.GetBestBid() != 103 || b.GetVolumeAtBidLimit(103) != 5 {
+			t.Errorf("the remainder should rest at 103")
+		}
+		if b.GetBestOffer() != 104 || b.ALength() != 7 {
+			t.Errorf("best offer should be 104 with 7 levels left")
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package book
+
+type EventType uint8
+
+const (
+	// the order, or what is left of it after matching, rests in the book
+	EventAdded EventType = iota
+	// the incoming order traded against a resting one
+	EventFill
+	// a resting order left the book without trading
+	EventCancelled
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAdded:
+		return "added"
+	case EventFill:
+		return "fill"
+	case EventCancelled:
+		return "cancelled"
+	}
+	return "unknown"
+}
+
+// something that happened to an order in the book
+type Event struct {
+	Type EventType
+	// instrument of the book the event comes from
+	Symbol string
+	// unix nanoseconds of the add, match or cancel, from BookConfig.Now
+	Time       int64
+	SequenceId uint32
+	AccountId  uint32
+	BidOrAsk   bool
+	Price      float32
+	// quantity added, filled or cancelled by this event
+	Quantity float32
+	// quantity of the order still open after the event
+	Remaining float32
+	// on cancels, the remainder of a market order that never rested in the book
+	Expired bool
+
+	// on fills, the resting order the incoming one traded against
+	MakerSequenceId uint32
+	MakerAccountId  uint32
+	MakerRemaining  float32
+	// on fills, the fees of each side, negative for a rebate. the book leaves them at 0,
+	// the engine fills them in from its fee model
+	MakerFee float64
+	TakerFee float64
+}
+
+// called synchronously from the matching loop, it must not call back into the book
+type EventHandler func(e Event)
+
+// registers a handler for every event of the book
+func (this *Orderbook) Subscribe(h EventHandler) {
+	this.handlers = append(this.handlers, h)
+}
+
+func (this *Orderbook) emit(e Event) {
+	e.Symbol = this.config.Symbol
+	e.Time = this.time
+	this.track(e)
+	this.trackLevels(e)
+	for _, h := range this.handlers {
+		h(e)
+	}
+}
@@ -0,0 +1,37 @@
+package book_test
+
+import (
+	"fmt"
+
+	"matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+func ExampleOrderbook() {
+	ob := book.NewOrderbook()
+	ob.Subscribe(func(e book.Event) {
+		if e.Type == book.EventFill {
+			fmt.Printf("fill %v @ %v, order %d hits %d\n", e.Quantity, e.Price, e.SequenceId, e.MakerSequenceId)
+		}
+	})
+
+	bid := orders.NewOrder(orders.NewIncomingOrder(100, 5, true, orders.LIMIT, 1), 1)
+	ob.Execute(&bid)
+	ask := orders.NewOrder(orders.NewIncomingOrder(99, 3, false, orders.LIMIT, 2), 2)
+	ob.Execute(&ask)
+
+	for _, level := range ob.Depth(true, 0) {
+		fmt.Printf("bid %v x %v\n", level.Price, level.Volume)
+	}
+	// Output:
+	// fill 3 @ 100, order 2 hits 1
+	// bid 100 x 2
+}
+
+func ExampleOrderbook_CheckInvariants() {
+	ob := book.NewOrderbookWithConfig(book.BookConfig{NewSide: book.NewPriceLadderSide})
+	o := orders.NewOrder(orders.NewIncomingOrder(10, 1, false, orders.LIMIT, 1), 1)
+	ob.Execute(&o)
+	fmt.Println(len(ob.CheckInvariants()))
+	// Output: 0
+}
@@ -0,0 +1,294 @@
+package book
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"matching-engine-go/orders"
+)
+
+// refBook is the reference matcher: sorted slices of levels, each a slice of orders in
+// time priority, nothing pooled or cached. It follows the rules of Orderbook.Execute:
+// a limit order with nothing to trade against rests at its own price, what a market
+// order could not fill is dropped.
+type refBook struct {
+	// best price first
+	bids []refLevel
+	asks []refLevel
+}
+
+type refLevel struct {
+	price  float32
+	orders []refOrder
+}
+
+type refOrder struct {
+	id   uint32
+	left float32
+}
+
+type refFill struct {
+	taker    uint32
+	maker    uint32
+	price    float32
+	quantity float32
+}
+
+func (b *refBook) execute(o orders.Order) []refFill {
+	fills := make([]refFill, 0)
+	left := o.Order.Quantity
+	bidOrAsk := o.Order.BidOrAsk
+	opposite := &b.asks
+	if !bidOrAsk {
+		opposite = &b.bids
+	}
+	for left > 0 && len(*opposite) > 0 {
+		best := &(*opposite)[0]
+		crosses := o.Order.OrderType == orders.MARKET ||
+			(bidOrAsk && o.Order.Price >= best.price) || (!bidOrAsk && o.Order.Price <= best.price)
+		if !crosses {
+			break
+		}
+		for left > 0 && len(best.orders) > 0 {
+			maker := &best.orders[0]
+			q := maker.left
+			if left < q {
+				q = left
+			}
+			maker.left -= q
+			left -= q
+			fills = append(fills, refFill{o.SequenceId, maker.id, best.price, q})
+			if maker.left == 0 {
+				best.orders = best.orders[1:]
+			}
+		}
+		if len(best.orders) == 0 {
+			*opposite = (*opposite)[1:]
+		}
+	}
+	if left > 0 && o.Order.OrderType != orders.MARKET {
+		b.rest(bidOrAsk, o.Order.Price, refOrder{o.SequenceId, left})
+	}
+	return fills
+}
+
+func (b *refBook) rest(bidOrAsk bool, price float32, o refOrder) {
+	side := &b.asks
+	better := func(p float32) bool { return p < price }
+	if bidOrAsk {
+		side = &b.bids
+		better = func(p float32) bool { return p > price }
+	}
+	i := 0
+	for i < len(*side) && better((*side)[i].price) {
+		i++
+	}
+	if i < len(*side) && (*side)[i].price == price {
+		(*side)[i].orders = append((*side)[i].orders, o)
+		return
+	}
+	*side = append(*side, refLevel{})
+	copy((*side)[i+1:], (*side)[i:])
+	(*side)[i] = refLevel{price: price, orders: []refOrder{o}}
+}
+
+// takes quantity off a resting order, all of it if more than is left. false if it does
+// not rest or quantity is not positive, as Orderbook.Reduce
+func (b *refBook) reduce(id uint32, quantity float32) bool {
+	if !(quantity > 0) {
+		return false
+	}
+	return b.take(id, quantity)
+}
+
+func (b *refBook) cancel(id uint32) bool {
+	return b.take(id, float32(math.Inf(1)))
+}
+
+func (b *refBook) take(id uint32, quantity float32) bool {
+	for _, side := range []*[]refLevel{&b.bids, &b.asks} {
+		for i := range *side {
+			level := &(*side)[i]
+			for j := range level.orders {
+				if level.orders[j].id != id {
+					continue
+				}
+				if quantity < level.orders[j].left {
+					level.orders[j].left -= quantity
+					return true
+				}
+				level.orders = append(level.orders[:j], level.orders[j+1:]...)
+				if len(level.orders) == 0 {
+					*side = append((*side)[:i], (*side)[i+1:]...)
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// the book as a snapshot, to compare with Orderbook.Snapshot
+func (b *refBook) snapshot() BookSnapshot {
+	side := func(levels []refLevel) []LevelSnapshot {
+		out := make([]LevelSnapshot, 0)
+		for _, l := range levels {
+			var volume float32
+			ids := make([]orders.Order, len(l.orders))
+			for i, o := range l.orders {
+				volume += o.left
+				ids[i] = orders.Order{SequenceId: o.id, ExecutedQuantity: o.left}
+			}
+			out = append(out, LevelSnapshot{l.price, volume, ids})
+		}
+		return out
+	}
+	return BookSnapshot{Bids: side(b.bids), Asks: side(b.asks)}
+}
+
+// the snapshot keeps the sequenceIds and open quantities only, as refBook.snapshot does
+func openQuantities(s BookSnapshot) BookSnapshot {
+	side := func(levels []LevelSnapshot) []LevelSnapshot {
+		out := make([]LevelSnapshot, 0)
+		for _, l := range levels {
+			open := make([]orders.Order, len(l.Orders))
+			for i, o := range l.Orders {
+				open[i] = orders.Order{SequenceId: o.SequenceId, ExecutedQuantity: o.Order.Quantity - o.ExecutedQuantity}
+			}
+			out = append(out, LevelSnapshot{l.Price, l.Volume, open})
+		}
+		return out
+	}
+	return BookSnapshot{Bids: side(s.Bids), Asks: side(s.Asks)}
+}
+
+// checks the structure of the book with CheckInvariants, and the level histograms
+// against the levels
+func checkBookStructure(book *Orderbook) error {
+	if violations := book.CheckInvariants(); len(violations) > 0 {
+		return fmt.Errorf("%d broken invariants, first %v", len(violations), violations[0])
+	}
+	for _, bidOrAsk := range []bool{true, false} {
+		if h, want := book.LevelHistogram(bidOrAsk), levelSums(book, bidOrAsk); h != want {
+			return fmt.Errorf("level histogram of side %v is %+v, the levels count %+v", bidOrAsk, h, want)
+		}
+	}
+	return nil
+}
+
+// ops decoded from one input at most, the books are compared after every op
+const maxDifferentialOps = 512
+
+// decodes ops of 4 bytes from data and runs them through both books, failing on the first
+// difference in fills or resting orders, or broken structure
+func differential(t *testing.T, newSide func() BookSide, data []byte) {
+	if len(data) > 4*maxDifferentialOps {
+		data = data[:4*maxDifferentialOps]
+	}
+	book := NewOrderbookWithConfig(BookConfig{NewSide: newSide})
+	ref := &refBook{}
+	var fills []refFill
+	book.Subscribe(func(e Event) {
+		if e.Type == EventFill {
+			fills = append(fills, refFill{e.SequenceId, e.MakerSequenceId, e.Price, e.Quantity})
+		}
+	})
+
+	var next uint32
+	for step := 0; len(data) >= 4; step, data = step+1, data[4:] {
+		op, a, b, c := data[0]%8, data[1], data[2], data[3]
+		var want []refFill
+		var what string
+		fills = fills[:0]
+		switch {
+		case op < 6:
+			// limit orders around 100 on a small grid so they meet, market orders now and then
+			next++
+			incoming := orders.NewIncomingOrder(float32(90+int(a>>1)%20), float32(1+b%16), a&1 == 0, orders.LIMIT, uint32(c%4))
+			if op == 5 {
+				incoming.OrderType = orders.MARKET
+				incoming.Price = 0
+			}
+			o := orders.NewOrder(incoming, next)
+			what = fmt.Sprintf("%+v", o)
+			want = ref.execute(o)
+			if _, err := book.Execute(&o); err != nil {
+				t.Fatalf("step %d: %s: %v", step, what, err)
+			}
+		case op == 6 && next > 0:
+			id := 1 + uint32(b)%next
+			what = fmt.Sprintf("cancel %d", id)
+			_, ok := book.Cancel(id)
+			if ok != ref.cancel(id) {
+				t.Fatalf("step %d: %s: the books disagree on whether it rests", step, what)
+			}
+		case op == 7 && next > 0:
+			id := 1 + uint32(b)%next
+			quantity := float32(c % 8)
+			what = fmt.Sprintf("reduce %d by %v", id, quantity)
+			_, ok := book.Reduce(id, quantity)
+			if ok != ref.reduce(id, quantity) {
+				t.Fatalf("step %d: %s: the books disagree on whether it rests", step, what)
+			}
+		default:
+			continue
+		}
+
+		if len(fills) != len(want) {
+			t.Fatalf("step %d: %s: %d fills, the reference has %d\ngot  %v\nwant %v", step, what, len(fills), len(want), fills, want)
+		}
+		for i := range want {
+			if fills[i] != want[i] {
+				t.Fatalf("step %d: %s: fill %d is %+v, the reference has %+v", step, what, i, fills[i], want[i])
+			}
+		}
+		if err := checkBookStructure(&book); err != nil {
+			t.Fatalf("step %d: %s: %v", step, what, err)
+		}
+		got, expected := openQuantities(book.Snapshot()), ref.snapshot()
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("step %d: %s: the books differ\ngot  %v\nwant %v", step, what, got, expected)
+		}
+	}
+}
+
+var fuzzSeeds = [][]byte{
+	// a bid and a crossing ask
+	{0, 20, 4, 0, 1, 19, 2, 1},
+	// levels on both sides swept by a market order, then cancels
+	{0, 10, 3, 0, 0, 12, 3, 1, 1, 11, 5, 2, 1, 13, 5, 3, 5, 1, 20, 0, 6, 0, 0, 0, 6, 0, 1, 0},
+	// a market order into an empty book is dropped, a bid rests, the reduce of the market finds nothing
+	{5, 1, 2, 0, 0, 10, 1, 0, 7, 0, 0, 0},
+}
+
+func FuzzOrderbookRedBlack(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		differential(t, NewRedBlackSide, data)
+	})
+}
+
+func FuzzOrderbookPriceLadder(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		differential(t, NewPriceLadderSide, data)
+	})
+}
+
+// long random streams, so plain go test covers more than the seeds
+func TestDifferentialRandomStreams(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 40; i++ {
+		data := make([]byte, 4*maxDifferentialOps)
+		r.Read(data)
+		differential(t, NewRedBlackSide, data)
+		differential(t, NewPriceLadderSide, data)
+	}
+}
@@ -0,0 +1,201 @@
+package book
+
+import (
+	"fmt"
+	"sort"
+
+	"matching-engine-go/orders"
+)
+
+type InvariantKind uint8
+
+const (
+	// the price keys of a limits cache differ from the levels of its side
+	InvariantCache InvariantKind = iota
+	// a level total differs from the open quantity of its orders
+	InvariantVolume
+	// the best bid is at or above the best ask
+	InvariantCrossed
+	// a level is left without orders
+	InvariantEmptyLevel
+	// the cached min or max is not the lowest or highest level
+	InvariantMinMax
+	// the tree is not a red black tree or its level links are out of order
+	InvariantTree
+	// the index of resting orders does not match the queues
+	InvariantIndex
+)
+
+func (k InvariantKind) String() string {
+	switch k {
+	case InvariantCache:
+		return "cache"
+	case InvariantVolume:
+		return "volume"
+	case InvariantCrossed:
+		return "crossed"
+	case InvariantEmptyLevel:
+		return "empty level"
+	case InvariantMinMax:
+		return "min/max"
+	case InvariantTree:
+		return "tree"
+	case InvariantIndex:
+		return "index"
+	}
+	return "unknown"
+}
+
+// one broken invariant of a book, found by CheckInvariants
+type Violation struct {
+	Kind InvariantKind
+	// side and price of the level at fault, the best bid for a crossed book
+	BidOrAsk bool
+	Price    float32
+	Detail   string
+}
+
+func (v Violation) String() string {
+	side := "asks"
+	if v.BidOrAsk {
+		side = "bids"
+	}
+	return fmt.Sprintf("%s: %s %v: %s", v.Kind, side, v.Price, v.Detail)
+}
+
+// CheckInvariants audits the structure of the book and returns what it finds broken, nil for
+// a sound book. It walks every level and order, so it is O(N) and meant to run now and then
+// between operations, not on the matching path. The tree is read through its nodes rather
+// than the cached min/max and links, so those are checked rather than trusted.
+func (this *Orderbook) CheckInvariants() []Violation {
+	var violations []Violation
+	report := func(kind InvariantKind, bidOrAsk bool, price float32, format string, args ...interface{}) {
+		violations = append(violations, Violation{kind, bidOrAsk, price, fmt.Sprintf(format, args...)})
+	}
+
+	var count int
+	bids := this.checkSide(this.Bids, this.bidLimitsCache, true, &count, report)
+	asks := this.checkSide(this.Asks, this.askLimitsCache, false, &count, report)
+	if len(bids) > 0 && len(asks) > 0 && bids[len(bids)-1] >= asks[0] {
+		report(InvariantCrossed, true, bids[len(bids)-1], "best bid at or above the best ask %v", asks[0])
+	}
+
+	for sequenceId, ref := range this.orders {
+		orderqueue := this.askLimitsCache[ref.price]
+		if ref.bidOrAsk {
+			orderqueue = this.bidLimitsCache[ref.price]
+		}
+		if orderqueue == nil {
+			report(InvariantIndex, ref.bidOrAsk, ref.price, "order %d indexed at a level that does not exist", sequenceId)
+			continue
+		}
+		if o, ok := orderqueue.Get(ref.handle); !ok || o.SequenceId != sequenceId {
+			report(InvariantIndex, ref.bidOrAsk, ref.price, "order %d indexed at a handle that does not hold it", sequenceId)
+		}
+	}
+	if len(this.orders) != count {
+		report(InvariantIndex, true, 0, "%d orders indexed, %d in the queues", len(this.orders), count)
+	}
+	return violations
+}
+
+// checks one side against its cache and returns its prices in ascending order, adding the
+// orders of its levels to count
+func (this *Orderbook) checkSide(side BookSide, cache map[float32]*OrdersQueue, bidOrAsk bool, count *int,
+	report func(kind InvariantKind, bidOrAsk bool, price float32, format string, args ...interface{})) []float32 {
+	prices := make([]float32, 0, side.Size())
+	levels := make([]*OrdersQueue, 0, side.Size())
+	tree, isTree := side.(*levelTree)
+	if isTree {
+		tree.inorder(tree.root, func(n *levelNode) {
+			prices = append(prices, n.Key)
+			levels = append(levels, n.Value)
+		})
+	} else {
+		side.Ascend(func(price float32, level *OrdersQueue) bool {
+			prices = append(prices, price)
+			levels = append(levels, level)
+			return true
+		})
+	}
+
+	// the cache must hold exactly the levels of the side
+	for i, price := range prices {
+		switch cached, ok := cache[price]; {
+		case !ok:
+			report(InvariantCache, bidOrAsk, price, "level missing from the cache")
+		case cached != levels[i]:
+			report(InvariantCache, bidOrAsk, price, "the cache holds another queue than the side")
+		}
+	}
+	if len(cache) != len(prices) {
+		missing := make([]float32, 0)
+		for price := range cache {
+			if !side.Contains(price) {
+				missing = append(missing, price)
+			}
+		}
+		sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+		for _, price := range missing {
+			report(InvariantCache, bidOrAsk, price, "cached level missing from the side")
+		}
+	}
+
+	for i, level := range levels {
+		price := prices[i]
+		if level.IsEmpty() {
+			report(InvariantEmptyLevel, bidOrAsk, price, "no orders")
+		}
+		if level.Price() != price {
+			report(InvariantCache, bidOrAsk, price, "the level holds the queue of %v", level.Price())
+		}
+		var volume float32
+		level.Each(func(o orders.Order) bool {
+			volume += o.Order.Quantity - o.ExecutedQuantity
+			return true
+		})
+		if level.TotalVolume() != volume {
+			report(InvariantVolume, bidOrAsk, price, "level total %v, its orders add up to %v", level.TotalVolume(), volume)
+		}
+		*count += level.Size()
+	}
+
+	// cached min/max against the actual extremes
+	if isTree {
+		var lowest, highest *levelNode
+		if tree.root != nil {
+			lowest, highest = tree.min(tree.root), tree.max(tree.root)
+		}
+		minMax := true
+		if tree.minC != lowest {
+			minMax = false
+			report(InvariantMinMax, bidOrAsk, cachedKey(tree.minC), "cached min is not the lowest level")
+		}
+		if tree.maxC != highest {
+			minMax = false
+			report(InvariantMinMax, bidOrAsk, cachedKey(tree.maxC), "cached max is not the highest level")
+		}
+		if !tree.IsRedBlack() {
+			report(InvariantTree, bidOrAsk, 0, "not a red black tree")
+		}
+		// a wrong min/max fails the links as well, it is reported once
+		if minMax && !tree.IsLinked() {
+			report(InvariantTree, bidOrAsk, 0, "the level links are out of order")
+		}
+	} else if len(prices) > 0 {
+		if side.Min() != prices[0] {
+			report(InvariantMinMax, bidOrAsk, side.Min(), "min is not the lowest level")
+		}
+		if side.Max() != prices[len(prices)-1] {
+			report(InvariantMinMax, bidOrAsk, side.Max(), "max is not the highest level")
+		}
+	}
+	return prices
+}
+
+func cachedKey(n *levelNode) float32 {
+	if n == nil {
+		return 0
+	}
+	return n.Key
+}
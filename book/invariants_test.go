@@ -0,0 +1,84 @@
+package book
+
+import (
+	"testing"
+
+	"matching-engine-go/orders"
+)
+
+func soundBook(newSide func() BookSide) Orderbook {
+	book := NewOrderbookWithConfig(BookConfig{NewSide: newSide})
+	for i, price := range []float32{98, 99, 99, 100} {
+		o := orders.NewOrder(orders.NewIncomingOrder(price, 2, true, orders.LIMIT, 1), uint32(i+1))
+		book.Execute(&o)
+	}
+	for i, price := range []float32{101, 102, 103} {
+		o := orders.NewOrder(orders.NewIncomingOrder(price, 3, false, orders.LIMIT, 2), uint32(i+5))
+		book.Execute(&o)
+	}
+	// partly fills the bid at 100
+	o := orders.NewOrder(orders.NewIncomingOrder(100, 1, false, orders.LIMIT, 3), 8)
+	book.Execute(&o)
+	return book
+}
+
+func hasViolation(violations []Violation, kind InvariantKind, bidOrAsk bool, price float32) bool {
+	for _, v := range violations {
+		if v.Kind == kind && v.BidOrAsk == bidOrAsk && v.Price == price {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckInvariantsSound(t *testing.T) {
+	for name, newSide := range map[string]func() BookSide{"tree": NewRedBlackSide, "ladder": NewPriceLadderSide} {
+		book := soundBook(newSide)
+		if v := book.CheckInvariants(); v != nil {
+			t.Errorf("%s: a sound book should pass, got %v", name, v)
+		}
+		empty := NewOrderbookWithConfig(BookConfig{NewSide: newSide})
+		if v := empty.CheckInvariants(); v != nil {
+			t.Errorf("%s: an empty book should pass, got %v", name, v)
+		}
+	}
+}
+
+func TestCheckInvariantsViolations(t *testing.T) {
+	cases := []struct {
+		name     string
+		corrupt  func(book *Orderbook)
+		kind     InvariantKind
+		bidOrAsk bool
+		price    float32
+	}{
+		{"volume", func(book *Orderbook) { book.bidLimitsCache[99].totalVolume = 5 }, InvariantVolume, true, 99},
+		{"cache missing", func(book *Orderbook) { delete(book.askLimitsCache, 102) }, InvariantCache, false, 102},
+		{"cache extra", func(book *Orderbook) {
+			q := newOrdersQueue(104, orderByteSize)
+			book.askLimitsCache[104] = &q
+		}, InvariantCache, false, 104},
+		{"empty level", func(book *Orderbook) {
+			q := newOrdersQueue(97, orderByteSize)
+			book.Bids.Put(97, &q)
+			book.bidLimitsCache[97] = &q
+		}, InvariantEmptyLevel, true, 97},
+		{"crossed", func(book *Orderbook) {
+			o := orders.NewOrder(orders.NewIncomingOrder(101, 1, true, orders.LIMIT, 1), 9)
+			book.add(101, &o)
+		}, InvariantCrossed, true, 101},
+		{"max", func(book *Orderbook) {
+			tree := book.Bids.(*levelTree)
+			tree.maxC = tree.maxC.Prev
+		}, InvariantMinMax, true, 99},
+		{"index", func(book *Orderbook) { book.orders[42] = book.orders[1] }, InvariantIndex, true, 98},
+	}
+	for _, c := range cases {
+		book := soundBook(NewRedBlackSide)
+		c.corrupt(&book)
+		violations := book.CheckInvariants()
+		if !hasViolation(violations, c.kind, c.bidOrAsk, c.price) {
+			t.Errorf("%s: expected a %s violation at %v, got %v", c.name, c.kind, c.price, violations)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package book
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"matching-engine-go/orders"
+)
+
+const (
+	JournalOrder  = "order"
+	JournalCancel = "cancel"
+)
+
+var ErrDuplicateOrder = errors.New("journal: order id already resting in the book")
+
+// JournalEntry is one line of a journal, the JSON Lines record of what was given to a book.
+// Applying the entries again in order rebuilds the same book with the same fills, stamped
+// with the same times.
+type JournalEntry struct {
+	// JournalOrder or JournalCancel, empty reads as an order
+	Op string `json:"op,omitempty"`
+	// sequenceId of the order, or of the resting order to cancel
+	Id       uint32  `json:"id"`
+	Side     string  `json:"side,omitempty"`
+	Type     string  `json:"type,omitempty"`
+	Price    float32 `json:"price,omitempty"`
+	Quantity float32 `json:"quantity,omitempty"`
+	Account  uint32  `json:"account,omitempty"`
+	// unix nanoseconds the order was received and accepted at
+	Received int64 `json:"received,omitempty"`
+	Accepted int64 `json:"accepted,omitempty"`
+	// unix nanoseconds the book executed or cancelled at, 0 takes the time of the book
+	Time int64 `json:"time,omitempty"`
+}
+
+func NewJournalOrder(o orders.Order) JournalEntry {
+	orderType := "limit"
+	if o.Order.OrderType == orders.MARKET {
+		orderType = "market"
+	}
+	side := "sell"
+	if o.Order.BidOrAsk {
+		side = "buy"
+	}
+	return JournalEntry{
+		Op:       JournalOrder,
+		Id:       o.SequenceId,
+		Side:     side,
+		Type:     orderType,
+		Price:    o.Order.Price,
+		Quantity: o.Order.Quantity,
+		Account:  o.Order.AccountId,
+		Received: o.ReceivedAt,
+		Accepted: o.AcceptedAt,
+	}
+}
+
+func NewJournalCancel(sequenceId uint32) JournalEntry {
+	return JournalEntry{Op: JournalCancel, Id: sequenceId}
+}
+
+// the entry as the book ran it, at the time of its last operation
+func (e JournalEntry) At(book *Orderbook) JournalEntry {
+	e.Time = book.Time()
+	return e
+}
+
+// the order an entry gives to the book
+func (e JournalEntry) Incoming() (orders.IncomingOrder, error) {
+	var bidOrAsk bool
+	switch e.Side {
+	case "buy":
+		bidOrAsk = true
+	case "sell":
+	default:
+		return orders.IncomingOrder{}, errors.New("journal: side must be buy or sell")
+	}
+	orderType := orders.LIMIT
+	switch e.Type {
+	case "", "limit":
+	case "market":
+		orderType = orders.MARKET
+	default:
+		return orders.IncomingOrder{}, errors.New("journal: type must be limit or market")
+	}
+	return orders.NewIncomingOrder(e.Price, e.Quantity, bidOrAsk, orderType, e.Account), nil
+}
+
+// executes the order or cancels the resting one, ErrUnknownOrder if there is nothing to cancel.
+// an order is checked as the engine would and may not reuse the id of a resting one.
+// the events are stamped with the time of the entry if it has one
+func (e JournalEntry) Apply(book *Orderbook) error {
+	if e.Time != 0 {
+		book.time = e.Time
+	} else {
+		book.stamp()
+	}
+	switch e.Op {
+	case "", JournalOrder:
+		incoming, err := e.Incoming()
+		if err != nil {
+			return err
+		}
+		if err := ValidateOrder(incoming); err != nil {
+			return err
+		}
+		if _, ok := book.orders[e.Id]; ok {
+			return ErrDuplicateOrder
+		}
+		o := orders.NewOrder(incoming, e.Id)
+		o.ReceivedAt, o.AcceptedAt = e.Received, e.Accepted
+		_, err = book.execute(&o)
+		return err
+	case JournalCancel:
+		if _, ok := book.cancel(e.Id); !ok {
+			return ErrUnknownOrder
+		}
+		return nil
+	}
+	return fmt.Errorf("journal: unknown op %q", e.Op)
+}
+
+// JournalWriter appends entries to a journal, one JSON object per line.
+type JournalWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func NewJournalWriter(w io.Writer) *JournalWriter {
+	b := bufio.NewWriter(w)
+	return &JournalWriter{w: b, enc: json.NewEncoder(b)}
+}
+
+func (j *JournalWriter) Append(e JournalEntry) error {
+	return j.enc.Encode(e)
+}
+
+// writes the buffered entries to the underlying writer
+func (j *JournalWriter) Flush() error {
+	return j.w.Flush()
+}
+
+// calls fn with every entry of the journal in order, errors carry the line number
+func ReadJournal(r io.Reader, fn func(e JournalEntry) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		b := scanner.Bytes()
+		if len(b) == 0 {
+			continue
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return fmt.Errorf("journal line %d: %w", line, err)
+		}
+		if err := fn(e); err != nil {
+			return fmt.Errorf("journal line %d: %w", line, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,113 @@
+package book
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"matching-engine-go/orders"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	book := NewOrderbook()
+	var buf bytes.Buffer
+	journal := NewJournalWriter(&buf)
+	for i, incoming := range []orders.IncomingOrder{
+		orders.NewIncomingOrder(10, 2, false, orders.LIMIT, 1),
+		orders.NewIncomingOrder(9, 1, true, orders.LIMIT, 2),
+		orders.NewIncomingOrder(0, 1, true, orders.MARKET, 3),
+	} {
+		o := orders.NewOrder(incoming, uint32(i+1))
+		book.Execute(&o)
+		journal.Append(NewJournalOrder(o))
+	}
+	book.Cancel(2)
+	journal.Append(NewJournalCancel(2))
+	journal.Flush()
+
+	replayed := NewOrderbook()
+	if err := ReadJournal(&buf, func(e JournalEntry) error { return e.Apply(&replayed) }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(book.Snapshot(), replayed.Snapshot()) {
+		t.Errorf("the replayed book should match\nwant %+v\ngot  %+v", book.Snapshot(), replayed.Snapshot())
+	}
+}
+
+func TestJournalErrors(t *testing.T) {
+	book := NewOrderbook()
+	if err := NewJournalCancel(7).Apply(&book); !errors.Is(err, ErrUnknownOrder) {
+		t.Errorf("cancelling an unknown order should fail, got %v", err)
+	}
+	if err := (JournalEntry{Op: "amend"}).Apply(&book); err == nil {
+		t.Errorf("an unknown op should fail")
+	}
+	if err := (JournalEntry{Side: "buy", Type: "stop"}).Apply(&book); err == nil {
+		t.Errorf("an unknown order type should fail")
+	}
+	for _, quantity := range []float32{0, -1, float32(math.NaN())} {
+		if err := (JournalEntry{Side: "buy", Price: 1, Quantity: quantity}).Apply(&book); !errors.Is(err, ErrInvalidQuantity) {
+			t.Errorf("quantity %v should fail, got %v", quantity, err)
+		}
+	}
+	if err := (JournalEntry{Id: 1, Side: "buy", Price: 1, Quantity: 1}).Apply(&book); err != nil {
+		t.Fatal(err)
+	}
+	if err := (JournalEntry{Id: 1, Side: "buy", Price: 2, Quantity: 1}).Apply(&book); !errors.Is(err, ErrDuplicateOrder) {
+		t.Errorf("reusing a resting id should fail, got %v", err)
+	}
+	if o, _ := book.Order(1); o.Order.Price != 1 || book.BLength() != 1 {
+		t.Errorf("the resting order should be left alone, got %+v", o)
+	}
+
+	err := ReadJournal(strings.NewReader("{\"side\":\"buy\",\"price\":1,\"quantity\":1}\n\nnot json\n"), func(JournalEntry) error { return nil })
+	if err == nil || !strings.HasPrefix(err.Error(), "journal line 3") {
+		t.Errorf("the error should name the line, got %v", err)
+	}
+}
+
+func TestJournalKeepsTimes(t *testing.T) {
+	now := int64(1000)
+	book := NewOrderbookWithConfig(BookConfig{Now: func() int64 { now += 10; return now }})
+	var recorded []Event
+	book.Subscribe(func(e Event) { recorded = append(recorded, e) })
+
+	var buf bytes.Buffer
+	journal := NewJournalWriter(&buf)
+	for i, incoming := range []orders.IncomingOrder{
+		orders.NewIncomingOrder(10, 2, false, orders.LIMIT, 1),
+		orders.NewIncomingOrder(9, 1, true, orders.LIMIT, 2),
+		orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 3),
+	} {
+		o := orders.NewOrder(incoming, uint32(i+1))
+		o.ReceivedAt, o.AcceptedAt = int64(i)*100, int64(i)*100+5
+		book.Execute(&o)
+		journal.Append(NewJournalOrder(o).At(&book))
+	}
+	book.Cancel(2)
+	journal.Append(NewJournalCancel(2).At(&book))
+	journal.Flush()
+
+	// the replay has its own clock, the journal times win
+	replayed := NewOrderbookWithConfig(BookConfig{Now: func() int64 { return 42 }})
+	var events []Event
+	replayed.Subscribe(func(e Event) { events = append(events, e) })
+	if err := ReadJournal(&buf, func(e JournalEntry) error { return e.Apply(&replayed) }); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(recorded, events) {
+		t.Errorf("the replayed events should carry the recorded times\nwant %+v\ngot  %+v", recorded, events)
+	}
+	if events[len(events)-1].Time != 1040 {
+		t.Errorf("the cancel should be stamped 1040, got %d", events[len(events)-1].Time)
+	}
+	if !reflect.DeepEqual(book.Snapshot(), replayed.Snapshot()) {
+		t.Errorf("the resting orders should keep their receive and accept times")
+	}
+	if o, _ := replayed.Order(1); o.ReceivedAt != 0 || o.AcceptedAt != 5 {
+		t.Errorf("order 1 should be received at 0 and accepted at 5, got %+v", o)
+	}
+}
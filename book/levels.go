@@ -0,0 +1,82 @@
+package book
+
+import "math/bits"
+
+// price levels of one side by the number of orders resting in them, kept up to date
+// from the events so it is read without walking the side. bucket i holds the levels
+// of more than 2^(i-1) and at most 2^i orders
+type LevelHistogram struct {
+	Buckets [64]uint64
+	Levels  uint64
+	Orders  uint64
+}
+
+func levelBucket(n int) int {
+	return bits.Len(uint(n - 1))
+}
+
+// a level goes from one number of orders to another, 0 when it is added or deleted
+func (h *LevelHistogram) resize(from int, to int) {
+	if from > 0 {
+		h.Buckets[levelBucket(from)]--
+		h.Levels--
+	}
+	if to > 0 {
+		h.Buckets[levelBucket(to)]++
+		h.Levels++
+	}
+	h.Orders = h.Orders - uint64(from) + uint64(to)
+}
+
+// levels holding at most n orders, n a power of two
+func (h *LevelHistogram) AtMost(n int) uint64 {
+	var levels uint64
+	for _, count := range h.Buckets[:levelBucket(n)+1] {
+		levels += count
+	}
+	return levels
+}
+
+func (this *Orderbook) levelHistogram(bidOrAsk bool) *LevelHistogram {
+	if bidOrAsk {
+		return &this.bidLevels
+	}
+	return &this.askLevels
+}
+
+// orders left in a level, the events are emitted before an empty level is deleted
+func (this *Orderbook) levelSize(bidOrAsk bool, price float32) int {
+	orderqueue := this.askLimitsCache[price]
+	if bidOrAsk {
+		orderqueue = this.bidLimitsCache[price]
+	}
+	if orderqueue == nil {
+		return 0
+	}
+	return orderqueue.Size()
+}
+
+// follows the levels that gain or lose an order
+func (this *Orderbook) trackLevels(e Event) {
+	switch e.Type {
+	case EventAdded:
+		n := this.levelSize(e.BidOrAsk, e.Price)
+		this.levelHistogram(e.BidOrAsk).resize(n-1, n)
+	case EventFill:
+		if e.MakerRemaining <= 0 {
+			n := this.levelSize(!e.BidOrAsk, e.Price)
+			this.levelHistogram(!e.BidOrAsk).resize(n+1, n)
+		}
+	case EventCancelled:
+		// a reduce leaves the order in its level, an expired market never had one
+		if !e.Expired && e.Remaining <= 0 {
+			n := this.levelSize(e.BidOrAsk, e.Price)
+			this.levelHistogram(e.BidOrAsk).resize(n+1, n)
+		}
+	}
+}
+
+// levels of one side by the number of their orders
+func (this *Orderbook) LevelHistogram(bidOrAsk bool) LevelHistogram {
+	return *this.levelHistogram(bidOrAsk)
+}
@@ -0,0 +1,49 @@
+package book
+
+import (
+	"testing"
+
+	"matching-engine-go/orders"
+)
+
+// the level histogram counted level by level
+func levelSums(book *Orderbook, bidOrAsk bool) LevelHistogram {
+	var h LevelHistogram
+	for _, level := range book.Depth(bidOrAsk, 0) {
+		h.resize(0, level.Orders)
+	}
+	return h
+}
+
+func TestLevelHistogram(t *testing.T) {
+	for name, newSide := range map[string]func() BookSide{"tree": NewRedBlackSide, "ladder": NewPriceLadderSide} {
+		book := soundBook(newSide)
+		check := func(step string) {
+			for _, bidOrAsk := range []bool{true, false} {
+				if h := book.LevelHistogram(bidOrAsk); h != levelSums(&book, bidOrAsk) {
+					t.Errorf("%s %s side %v: level histogram %+v, levels count %+v", name, step, bidOrAsk, h, levelSums(&book, bidOrAsk))
+				}
+			}
+		}
+		check("placed")
+		// bids of 1, 2 and 1 orders at 98, 99 and 100
+		if h := book.LevelHistogram(true); h.Levels != 3 || h.Orders != 4 || h.AtMost(1) != 2 || h.AtMost(2) != 3 {
+			t.Errorf("%s: unexpected bid histogram %+v", name, h)
+		}
+
+		book.Reduce(6, 1)
+		check("reduced")
+		book.Cancel(2)
+		check("cancelled")
+		o := orders.NewOrder(orders.NewIncomingOrder(102, 4, true, orders.LIMIT, 3), 20)
+		book.Execute(&o)
+		check("swept")
+		// more than the bids hold, the remainder expires
+		o = orders.NewOrder(orders.NewIncomingOrder(0, 10, false, orders.MARKET, 3), 21)
+		book.Execute(&o)
+		check("expired")
+		if h := book.LevelHistogram(true); h != (LevelHistogram{}) {
+			t.Errorf("%s: the bids are gone, got %+v", name, h)
+		}
+	}
+}
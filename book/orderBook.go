@@ -0,0 +1,704 @@
+// Package book is the limit order book: price levels on each side in price then time
+// priority, matching of incoming orders, cancels, and the tools built on a single book
+// such as the journal, the invariant checks, the workload generator and the backtest
+// simulator. A book is not safe for concurrent use, the engine package serializes access.
+package book
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+
+	"matching-engine-go/ds"
+	"matching-engine-go/orders"
+)
+
+// maximum limits per orderbook side to pre-allocate memory
+const MaxLimitsNum int = 10000
+
+var (
+	ErrUnknownOrder    = errors.New("orderbook: order is not resting in the book")
+	ErrInvalidQuantity = errors.New("orderbook: order quantity must be positive")
+	ErrInvalidPrice    = errors.New("orderbook: order price must be finite, and positive for a limit order")
+	// the order could not rest in the book without going over BookConfig.MemoryBudget
+	ErrMemoryBudget = errors.New("orderbook: memory budget exceeded")
+)
+
+type Orderbook struct {
+	Bids BookSide
+	Asks BookSide
+
+	bidLimitsCache map[float32]*OrdersQueue
+	askLimitsCache map[float32]*OrdersQueue
+	pool           *sync.Pool
+
+	// resting orders by sequenceId, to cancel them without searching the queues
+	orders map[uint32]orderRef
+	// sequenceIds of the resting orders of each account, for mass cancels
+	accountOrders map[uint32]map[uint32]struct{}
+	// sequenceIds of the cancel-on-disconnect orders of each session
+	sessionOrders map[uint32]map[uint32]struct{}
+
+	config BookConfig
+	// bytes held by the levels in the book
+	footprint int
+	// shared with the pool, which counts its misses
+	stats *BookStats
+
+	handlers []EventHandler
+	// incoming order being matched and its quantity left, for the fill events
+	taker     *orders.Order
+	takerLeft float32
+	// open quantity and notional of each side, for the analytics
+	bidTotals sideTotals
+	askTotals sideTotals
+	// levels by number of orders, for the metrics
+	bidLevels LevelHistogram
+	askLevels LevelHistogram
+	// unix nanoseconds of the operation under way, stamped on its events
+	time int64
+}
+
+// where a resting order sits in the book
+type orderRef struct {
+	price     float32
+	bidOrAsk  bool
+	handle    ds.Handle
+	accountId uint32
+	// session of a cancel-on-disconnect order, 0 otherwise
+	sessionId uint32
+}
+
+// orderbook settings, zero value gives the default book
+type BookConfig struct {
+	// instrument traded in the book, stamped on its events
+	Symbol string
+	// constructor for the price levels of each side, defaults to the red black tree
+	NewSide func() BookSide
+	// orders a new level has room for before resizing, defaults to RINGBUF_INI_SIZE
+	LevelSize int
+	// give the memory of a grown level back when it returns to the pool
+	ShrinkOnRelease bool
+	// upper bound in bytes on the levels in the book, 0 means unbounded.
+	// orders that would need more are not rested and Execute returns ErrMemoryBudget
+	MemoryBudget int
+	// unix nanoseconds the events are stamped with, taken once per operation.
+	// nil leaves them at 0
+	Now func() int64
+}
+
+// checks the fields of an order before it reaches a book. NaN fails every comparison,
+// so the bounds are written to reject it
+func ValidateOrder(incoming orders.IncomingOrder) error {
+	if !(incoming.Quantity > 0) || math.IsInf(float64(incoming.Quantity), 1) {
+		return ErrInvalidQuantity
+	}
+	if math.IsNaN(float64(incoming.Price)) || math.IsInf(float64(incoming.Price), 0) {
+		return ErrInvalidPrice
+	}
+	if incoming.OrderType == orders.LIMIT && !(incoming.Price > 0) {
+		return ErrInvalidPrice
+	}
+	return nil
+}
+
+func NewOrderbook() Orderbook {
+	return NewOrderbookWithConfig(BookConfig{})
+}
+
+func NewOrderbookWithConfig(config BookConfig) Orderbook {
+	if config.NewSide == nil {
+		config.NewSide = NewRedBlackSide
+	}
+	if config.LevelSize <= 0 {
+		config.LevelSize = RINGBUF_INI_SIZE
+	}
+	levelSize := config.LevelSize
+	stats := &BookStats{}
+	return Orderbook{
+		Bids:   config.NewSide(),
+		Asks:   config.NewSide(),
+		config: config,
+
+		bidLimitsCache: make(map[float32]*OrdersQueue, MaxLimitsNum),
+		askLimitsCache: make(map[float32]*OrdersQueue, MaxLimitsNum),
+		orders:         make(map[uint32]orderRef),
+		accountOrders:  make(map[uint32]map[uint32]struct{}),
+		sessionOrders:  make(map[uint32]map[uint32]struct{}),
+		stats:          stats,
+		pool: &sync.Pool{
+			New: func() interface{} {
+				stats.PoolMisses++
+				orderqueue := newOrdersQueueWithSize(0.0, levelSize, orderByteSize)
+				return &orderqueue
+			},
+		},
+	}
+}
+
+// entry point for order to either be queued or matched. an order not yet accepted
+// is accepted at the time stamped on its events
+func (this *Orderbook) Execute(o *orders.Order) (float32, error) {
+	this.stamp()
+	if o.AcceptedAt == 0 {
+		o.AcceptedAt = this.time
+	}
+	return this.execute(o)
+}
+
+func (this *Orderbook) execute(o *orders.Order) (float32, error) {
+	if o.Order.BidOrAsk {
+		return this.executeBid(o)
+	} else {
+		return this.executeAsk(o)
+	}
+}
+
+// entry point for bid
+func (this *Orderbook) executeBid(o *orders.Order) (float32, error) {
+	leftQuantity := o.Order.Quantity - o.ExecutedQuantity
+	// order fully filled, exit. checked first, a bid that swept the last ask must not rest
+	if leftQuantity == 0 {
+		return o.ExecutedQuantity, nil
+	}
+	// no best ask
+	if this.ALength() == 0 {
+		return o.ExecutedQuantity, this.rest(o)
+	}
+	best_ask := this.GetBestOffer()
+	this.taker, this.takerLeft = o, leftQuantity
+	// if order can be matched
+	if o.Order.Price >= best_ask || o.Order.OrderType == orders.MARKET {
+		v := this.GetVolumeAtAskLimit(best_ask)
+		// if the best ask can be swept
+		if leftQuantity >= v {
+			o.ExecutedQuantity += v
+			// execute each order in the ringbuffer
+			this.askLimitsCache[best_ask].execute(v, this)
+			// remove the ask from the cache&BST, return the ringbuffer to the pool
+			this.deleteAskLimit(best_ask)
+			// recursive call on the next best ask
+			return this.executeBid(o)
+		} else
+		// if the order would be fully filled in the current ask
+		{
+			this.askLimitsCache[best_ask].execute(leftQuantity, this)
+			o.ExecutedQuantity = o.Order.Quantity
+			return o.ExecutedQuantity, nil
+		}
+
+	} else
+	// if order can NOT be matched
+	{
+		return o.ExecutedQuantity, this.rest(o)
+	}
+}
+
+// entry point for ask
+func (this *Orderbook) executeAsk(o *orders.Order) (float32, error) {
+	leftQuantity := o.Order.Quantity - o.ExecutedQuantity
+	if leftQuantity == 0 {
+		return o.ExecutedQuantity, nil
+	}
+	// no best bid
+	if this.BLength() == 0 {
+		return o.ExecutedQuantity, this.rest(o)
+	}
+	best_bid := this.GetBestBid()
+	this.taker, this.takerLeft = o, leftQuantity
+	// if order can be matched
+	if o.Order.Price <= best_bid || o.Order.OrderType == orders.MARKET {
+		// if the best bid can be swept
+		v := this.GetVolumeAtBidLimit(best_bid)
+		if leftQuantity >= v {
+			o.ExecutedQuantity += v
+			// execute each order in the ringbuffer
+			this.bidLimitsCache[best_bid].execute(v, this)
+			// remove the bid from the cache&BST, return the ringbuffer to the pool
+			this.deleteBidLimit(best_bid)
+			// recursive call
+			return this.executeAsk(o)
+		} else {
+			// if the order would be fully filled in the current ask
+			this.bidLimitsCache[best_bid].execute(leftQuantity, this)
+			o.ExecutedQuantity = o.Order.Quantity
+			return o.ExecutedQuantity, nil
+		}
+	} else
+	// if order can NOT be matched
+	{
+		return o.ExecutedQuantity, this.rest(o)
+	}
+}
+
+// what is left of a limit order rests at its price. a market order is immediate or cancel,
+// its remainder is cancelled without resting
+func (this *Orderbook) rest(o *orders.Order) error {
+	if o.Order.OrderType != orders.MARKET {
+		return this.add(o.Order.Price, o)
+	}
+	this.emit(Event{
+		Type:       EventCancelled,
+		SequenceId: o.SequenceId,
+		AccountId:  o.Order.AccountId,
+		BidOrAsk:   o.Order.BidOrAsk,
+		Price:      o.Order.Price,
+		Quantity:   o.Order.Quantity - o.ExecutedQuantity,
+		Expired:    true,
+	})
+	return nil
+}
+
+// rests the order at price, fails with ErrMemoryBudget rather than going over the budget
+func (this *Orderbook) add(price float32, o *orders.Order) error {
+	var orderqueue *OrdersQueue
+	if o.Order.BidOrAsk {
+		orderqueue = this.bidLimitsCache[price]
+	} else {
+		orderqueue = this.askLimitsCache[price]
+	}
+
+	if orderqueue == nil {
+		// getting a new limit from pool
+		this.stats.PoolGets++
+		orderqueue = this.pool.Get().(*OrdersQueue)
+		if !this.fitsBudget(orderqueue.Footprint() + orderqueue.GrowthCost()) {
+			this.pool.Put(orderqueue)
+			return ErrMemoryBudget
+		}
+		orderqueue.price = price
+		this.footprint += orderqueue.Footprint()
+
+		// insert into the corresponding BST and cache
+		if o.Order.BidOrAsk {
+			this.Bids.Put(price, orderqueue)
+			this.bidLimitsCache[price] = orderqueue
+		} else {
+			this.Asks.Put(price, orderqueue)
+			this.askLimitsCache[price] = orderqueue
+		}
+	} else if !this.fitsBudget(orderqueue.GrowthCost()) {
+		return ErrMemoryBudget
+	}
+
+	// add order to the limit
+	if orderqueue.GrowthCost() > 0 {
+		this.stats.Resizes++
+	}
+	this.footprint -= orderqueue.Footprint()
+	h := orderqueue.placeOrder(o)
+	this.footprint += orderqueue.Footprint()
+	ref := orderRef{price, o.Order.BidOrAsk, h, o.Order.AccountId, 0}
+	if o.Order.CancelOnDisconnect {
+		ref.sessionId = o.Order.SessionId
+	}
+	this.index(o.SequenceId, ref)
+
+	left := o.Order.Quantity - o.ExecutedQuantity
+	this.emit(Event{
+		Type:       EventAdded,
+		SequenceId: o.SequenceId,
+		AccountId:  o.Order.AccountId,
+		BidOrAsk:   o.Order.BidOrAsk,
+		Price:      price,
+		Quantity:   left,
+		Remaining:  left,
+	})
+	return nil
+}
+
+func (this *Orderbook) stamp() {
+	if this.config.Now != nil {
+		this.time = this.config.Now()
+	}
+}
+
+// time of the last operation on the book, the one stamped on its events
+func (this *Orderbook) Time() int64 {
+	return this.time
+}
+
+func (this *Orderbook) fitsBudget(bytes int) bool {
+	return this.config.MemoryBudget <= 0 || this.footprint+bytes <= this.config.MemoryBudget
+}
+
+// bytes held by the price levels in the book
+func (this *Orderbook) Footprint() int {
+	return this.footprint
+}
+
+// counters kept by a book as it works
+type BookStats struct {
+	// levels taken from the pool, and the ones it had to allocate
+	PoolGets   uint64
+	PoolMisses uint64
+	// orders that made the deque of their level grow
+	Resizes uint64
+}
+
+func (this *Orderbook) Stats() BookStats {
+	return *this.stats
+}
+
+// a resting order as it stands, false if it is not resting in the book
+func (this *Orderbook) Order(sequenceId uint32) (orders.Order, bool) {
+	ref, ok := this.orders[sequenceId]
+	if !ok {
+		return orders.Order{}, false
+	}
+	orderqueue := this.askLimitsCache[ref.price]
+	if ref.bidOrAsk {
+		orderqueue = this.bidLimitsCache[ref.price]
+	}
+	if orderqueue == nil {
+		return orders.Order{}, false
+	}
+	return orderqueue.Get(ref.handle)
+}
+
+// removes a resting order from the book, the level is deleted once it is empty.
+// returns the cancelled order, false if it is not resting in the book
+func (this *Orderbook) Cancel(sequenceId uint32) (orders.Order, bool) {
+	this.stamp()
+	return this.cancel(sequenceId)
+}
+
+func (this *Orderbook) cancel(sequenceId uint32) (orders.Order, bool) {
+	ref, ok := this.orders[sequenceId]
+	if !ok {
+		return orders.Order{}, false
+	}
+	this.unindex(sequenceId, ref)
+
+	var orderqueue *OrdersQueue
+	if ref.bidOrAsk {
+		orderqueue = this.bidLimitsCache[ref.price]
+	} else {
+		orderqueue = this.askLimitsCache[ref.price]
+	}
+	if orderqueue == nil {
+		return orders.Order{}, false
+	}
+
+	order, ok := orderqueue.cancel(ref.handle)
+	if !ok {
+		return orders.Order{}, false
+	}
+	this.emit(Event{
+		Type:       EventCancelled,
+		SequenceId: order.SequenceId,
+		AccountId:  order.Order.AccountId,
+		BidOrAsk:   order.Order.BidOrAsk,
+		Price:      ref.price,
+		Quantity:   order.Order.Quantity - order.ExecutedQuantity,
+	})
+	if orderqueue.IsEmpty() {
+		if ref.bidOrAsk {
+			this.deleteBidLimit(ref.price)
+		} else {
+			this.deleteAskLimit(ref.price)
+		}
+	}
+	return order, true
+}
+
+// cuts the open quantity of a resting order by quantity, the order keeps its time priority.
+// cancels the order if nothing would be left. false if it is not resting in the book or
+// quantity is not positive
+func (this *Orderbook) Reduce(sequenceId uint32, quantity float32) (orders.Order, bool) {
+	if !(quantity > 0) {
+		return orders.Order{}, false
+	}
+	this.stamp()
+	ref, ok := this.orders[sequenceId]
+	if !ok {
+		return orders.Order{}, false
+	}
+	orderqueue := this.askLimitsCache[ref.price]
+	if ref.bidOrAsk {
+		orderqueue = this.bidLimitsCache[ref.price]
+	}
+	if orderqueue == nil {
+		return orders.Order{}, false
+	}
+	order, ok := orderqueue.Get(ref.handle)
+	if !ok {
+		return orders.Order{}, false
+	}
+	if quantity >= order.Order.Quantity-order.ExecutedQuantity {
+		return this.cancel(sequenceId)
+	}
+
+	order, _ = orderqueue.reduce(ref.handle, quantity)
+	this.emit(Event{
+		Type:       EventCancelled,
+		SequenceId: order.SequenceId,
+		AccountId:  order.Order.AccountId,
+		BidOrAsk:   order.Order.BidOrAsk,
+		Price:      ref.price,
+		Quantity:   quantity,
+		Remaining:  order.Order.Quantity - order.ExecutedQuantity,
+	})
+	return order, true
+}
+
+// open quantity and number of orders ahead of a resting order at its price level,
+// false if it is not resting in the book
+func (this *Orderbook) QueuePosition(sequenceId uint32) (float32, int, bool) {
+	ref, ok := this.orders[sequenceId]
+	if !ok {
+		return 0, 0, false
+	}
+	orderqueue := this.askLimitsCache[ref.price]
+	if ref.bidOrAsk {
+		orderqueue = this.bidLimitsCache[ref.price]
+	}
+	if orderqueue == nil {
+		return 0, 0, false
+	}
+	return orderqueue.Ahead(ref.handle)
+}
+
+// reports the trade, a resting order leaves the index once it is fully filled
+func (this *Orderbook) onFill(price float32, order *orders.Order, h ds.Handle, quantity float32) {
+	this.takerLeft -= quantity
+	if this.takerLeft < 0 {
+		this.takerLeft = 0
+	}
+	if this.taker != nil {
+		this.emit(Event{
+			Type:            EventFill,
+			SequenceId:      this.taker.SequenceId,
+			AccountId:       this.taker.Order.AccountId,
+			BidOrAsk:        this.taker.Order.BidOrAsk,
+			Price:           price,
+			Quantity:        quantity,
+			Remaining:       this.takerLeft,
+			MakerSequenceId: order.SequenceId,
+			MakerAccountId:  order.Order.AccountId,
+			MakerRemaining:  order.Order.Quantity - order.ExecutedQuantity,
+		})
+	}
+
+	if order.ExecutedQuantity < order.Order.Quantity {
+		return
+	}
+	if ref, ok := this.orders[order.SequenceId]; ok && ref.handle == h {
+		this.unindex(order.SequenceId, ref)
+	}
+}
+
+func (this *Orderbook) index(sequenceId uint32, ref orderRef) {
+	this.orders[sequenceId] = ref
+	addToSet(this.accountOrders, ref.accountId, sequenceId)
+	if ref.sessionId != 0 {
+		addToSet(this.sessionOrders, ref.sessionId, sequenceId)
+	}
+}
+
+func (this *Orderbook) unindex(sequenceId uint32, ref orderRef) {
+	delete(this.orders, sequenceId)
+	removeFromSet(this.accountOrders, ref.accountId, sequenceId)
+	if ref.sessionId != 0 {
+		removeFromSet(this.sessionOrders, ref.sessionId, sequenceId)
+	}
+}
+
+func addToSet(sets map[uint32]map[uint32]struct{}, key uint32, sequenceId uint32) {
+	ids := sets[key]
+	if ids == nil {
+		ids = make(map[uint32]struct{})
+		sets[key] = ids
+	}
+	ids[sequenceId] = struct{}{}
+}
+
+func removeFromSet(sets map[uint32]map[uint32]struct{}, key uint32, sequenceId uint32) {
+	ids := sets[key]
+	delete(ids, sequenceId)
+	if len(ids) == 0 {
+		delete(sets, key)
+	}
+}
+
+// set members in sequence order, oldest first
+func sortedSet(ids map[uint32]struct{}) []uint32 {
+	sorted := make([]uint32, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// sequenceIds of the resting orders of an account, oldest first
+func (this *Orderbook) AccountOrders(accountId uint32) []uint32 {
+	return sortedSet(this.accountOrders[accountId])
+}
+
+// sequenceIds of the resting cancel-on-disconnect orders of a session, oldest first
+func (this *Orderbook) SessionOrders(sessionId uint32) []uint32 {
+	return sortedSet(this.sessionOrders[sessionId])
+}
+
+// cancels every resting order of an account, oldest first.
+// each cancel emits its event and levels left empty are removed from the book
+func (this *Orderbook) MassCancel(accountId uint32) []orders.Order {
+	return this.cancelAll(this.AccountOrders(accountId))
+}
+
+// cancels the cancel-on-disconnect orders of a session, the others stay in the book
+func (this *Orderbook) CancelSession(sessionId uint32) []orders.Order {
+	return this.cancelAll(this.SessionOrders(sessionId))
+}
+
+// the cancels share one time
+func (this *Orderbook) cancelAll(ids []uint32) []orders.Order {
+	this.stamp()
+	cancelled := make([]orders.Order, 0, len(ids))
+	for _, id := range ids {
+		if o, ok := this.cancel(id); ok {
+			cancelled = append(cancelled, o)
+		}
+	}
+	return cancelled
+}
+
+func (this *Orderbook) deleteBidLimit(price float32) {
+	limit := this.bidLimitsCache[price]
+	if limit == nil {
+		return
+	}
+
+	this.deleteLimit(price, true)
+	delete(this.bidLimitsCache, price)
+	this.resetTotals(true)
+
+	// put limit back to the pool
+	this.release(limit)
+
+}
+
+func (this *Orderbook) deleteAskLimit(price float32) {
+	orderqueue := this.askLimitsCache[price]
+	if orderqueue == nil {
+		return
+	}
+
+	this.deleteLimit(price, false)
+	delete(this.askLimitsCache, price)
+	this.resetTotals(false)
+
+	// put limit back to the pool
+	this.release(orderqueue)
+}
+
+func (this *Orderbook) release(orderqueue *OrdersQueue) {
+	this.footprint -= orderqueue.Footprint()
+	orderqueue.clear()
+	if this.config.ShrinkOnRelease {
+		orderqueue.shrink(this.config.LevelSize)
+	}
+	this.pool.Put(orderqueue)
+}
+
+func (this *Orderbook) deleteLimit(price float32, bidOrAsk bool) {
+	if bidOrAsk {
+		this.Bids.Delete(price)
+	} else {
+		this.Asks.Delete(price)
+	}
+}
+
+func (this *Orderbook) GetVolumeAtBidLimit(price float32) float32 {
+	orderqueue := this.bidLimitsCache[price]
+	if orderqueue == nil {
+		return 0
+	}
+	return orderqueue.TotalVolume()
+}
+
+func (this *Orderbook) GetVolumeAtAskLimit(price float32) float32 {
+	orderqueue := this.askLimitsCache[price]
+	if orderqueue == nil {
+		return 0
+	}
+	return orderqueue.TotalVolume()
+}
+
+func (this *Orderbook) GetBestBid() float32 {
+	return this.Bids.Max()
+}
+
+func (this *Orderbook) GetBestOffer() float32 {
+	return this.Asks.Min()
+}
+
+func (this *Orderbook) BLength() int {
+	return len(this.bidLimitsCache)
+}
+
+func (this *Orderbook) ALength() int {
+	return len(this.askLimitsCache)
+}
+
+// aggregated view of one price level
+type PriceLevel struct {
+	Price  float32
+	Volume float32
+	Orders int
+}
+
+// best n levels of one side, best price first. n <= 0 returns the whole side.
+// each step to the next level is a single link in the red black tree, no search
+func (this *Orderbook) Depth(bidOrAsk bool, n int) []PriceLevel {
+	side := this.Asks
+	walk := side.Ascend
+	if bidOrAsk {
+		side = this.Bids
+		walk = side.Descend
+	}
+
+	size := side.Size()
+	if n > 0 && n < size {
+		size = n
+	}
+	levels := make([]PriceLevel, 0, size)
+	walk(func(price float32, level *OrdersQueue) bool {
+		levels = append(levels, PriceLevel{price, level.TotalVolume(), level.Size()})
+		return len(levels) < size
+	})
+	return levels
+}
+
+// resting orders of one price level, in time priority
+type LevelSnapshot struct {
+	Price  float32
+	Volume float32
+	Orders []orders.Order
+}
+
+// every resting order of the book, best price first on each side
+type BookSnapshot struct {
+	Bids []LevelSnapshot
+	Asks []LevelSnapshot
+}
+
+func (this *Orderbook) Snapshot() BookSnapshot {
+	return BookSnapshot{Bids: snapshotSide(this.Bids.Descend), Asks: snapshotSide(this.Asks.Ascend)}
+}
+
+func snapshotSide(walk func(fn func(price float32, level *OrdersQueue) bool)) []LevelSnapshot {
+	levels := make([]LevelSnapshot, 0)
+	walk(func(price float32, level *OrdersQueue) bool {
+		resting := make([]orders.Order, 0, level.Size())
+		level.Each(func(o orders.Order) bool {
+			resting = append(resting, o)
+			return true
+		})
+		levels = append(levels, LevelSnapshot{price, level.TotalVolume(), resting})
+		return true
+	})
+	return levels
+}
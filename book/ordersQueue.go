@@ -0,0 +1,163 @@
+package book
+
+import (
+	"unsafe"
+
+	"matching-engine-go/ds"
+	"matching-engine-go/orders"
+)
+
+// bytes of an order in the ringbuffer
+const orderByteSize = int(unsafe.Sizeof(orders.Order{}))
+
+// default order per tick before resizing the ringbuffer.
+// levels start small and double as orders queue up, so thousands of sparse levels stay cheap
+const RINGBUF_INI_SIZE = 1 << 4 // 16 order
+
+// orders are kept in FIFO order in a TombstoneDeque, so any of them can be cancelled in O(1)
+// through the handle returned by placeOrder
+type OrdersQueue struct {
+	price         float32
+	totalVolume   float32
+	ringbuffer    *ds.TombstoneDeque[orders.Order]
+	orderByteSize int
+}
+
+// notified for every resting order hit by OrdersQueue.Execute
+type fillListener interface {
+	onFill(price float32, order *orders.Order, h ds.Handle, quantity float32)
+}
+
+func (this *OrdersQueue) Price() float32 {
+	return this.price
+}
+
+func (this *OrdersQueue) TotalVolume() float32 {
+	return this.totalVolume
+}
+
+func newOrdersQueue(price float32, orderByteSize int) OrdersQueue {
+	return newOrdersQueueWithSize(price, RINGBUF_INI_SIZE, orderByteSize)
+}
+
+// queue with room for size orders before resizing
+func newOrdersQueueWithSize(price float32, size int, orderByteSize int) OrdersQueue {
+	var r = ds.NewTombstoneDeque[orders.Order](size)
+	return OrdersQueue{price, 0, r, orderByteSize}
+}
+
+// bytes held by the ringbuffer
+func (this *OrdersQueue) Footprint() int {
+	return this.ringbuffer.Footprint()
+}
+
+// bytes the next placeOrder would allocate
+func (this *OrdersQueue) GrowthCost() int {
+	return this.ringbuffer.GrowthCost()
+}
+
+func (this *OrdersQueue) Size() int {
+	return this.ringbuffer.Len()
+}
+
+func (this *OrdersQueue) IsEmpty() bool {
+	return this.ringbuffer.Len() == 0
+}
+
+func (this *OrdersQueue) placeOrder(o *orders.Order) ds.Handle {
+	q := o.Order.Quantity - o.ExecutedQuantity
+	this.totalVolume += float32(q)
+	// if the oldest order is not matched and the ringbuffer filled up, the ringbuffer would resize.
+	return this.ringbuffer.PushBack(*o)
+}
+
+// the order behind a handle, false if it has already been filled or cancelled
+func (this *OrdersQueue) Get(h ds.Handle) (orders.Order, bool) {
+	return this.ringbuffer.Get(h)
+}
+
+// visits the orders in time priority, stops as soon as fn returns false
+func (this *OrdersQueue) Each(fn func(o orders.Order) bool) {
+	this.ringbuffer.Each(func(_ ds.Handle, o orders.Order) bool { return fn(o) })
+}
+
+// removes the order from the queue, false if it has already been filled or cancelled
+func (this *OrdersQueue) cancel(h ds.Handle) (orders.Order, bool) {
+	order, ok := this.ringbuffer.Cancel(h)
+	if !ok {
+		return order, false
+	}
+	this.totalVolume -= order.Order.Quantity - order.ExecutedQuantity
+	return order, true
+}
+
+// cuts the open quantity of an order keeping its place in the queue, quantity must be less
+// than what is left of it. false if it has already been filled or cancelled
+func (this *OrdersQueue) reduce(h ds.Handle, quantity float32) (orders.Order, bool) {
+	order, ok := this.ringbuffer.Get(h)
+	if !ok {
+		return order, false
+	}
+	order.Order.Quantity -= quantity
+	this.ringbuffer.Set(h, order)
+	this.totalVolume -= quantity
+	return order, true
+}
+
+// open quantity and number of the orders ahead of an order, false if it is not in the queue
+func (this *OrdersQueue) Ahead(h ds.Handle) (float32, int, bool) {
+	var volume float32
+	count := 0
+	found := false
+	this.ringbuffer.Each(func(handle ds.Handle, o orders.Order) bool {
+		if handle == h {
+			found = true
+			return false
+		}
+		volume += o.Order.Quantity - o.ExecutedQuantity
+		count++
+		return true
+	})
+	return volume, count, found
+}
+
+// the queue doesnt care price level.
+// fills the orders from the front until quantity is used up, returns the executed quantity
+func (this *OrdersQueue) execute(quantity float32, listener fillListener) float32 {
+	var executed float32 = 0
+	// execute logic
+	for quantity > 0 && this.ringbuffer.Len() > 0 {
+		order := this.ringbuffer.Front()
+		h := this.ringbuffer.FrontHandle()
+		q := order.Order.Quantity - order.ExecutedQuantity
+		if quantity >= q {
+			//fully filled
+			order.ExecutedQuantity = order.Order.Quantity
+			// move the read pointer by flushing
+			this.ringbuffer.PopFront()
+		} else {
+			// partial filled, write the updated quantity back to the buf
+			q = quantity
+			order.ExecutedQuantity += q
+			this.ringbuffer.SetFront(order)
+		}
+		quantity -= q
+		this.totalVolume -= q
+		executed += q
+
+		if listener != nil {
+			listener.onFill(this.price, &order, h, q)
+		}
+	}
+	return executed
+}
+
+func (this *OrdersQueue) clear() {
+	this.ringbuffer.Clear()
+	this.totalVolume = 0
+}
+
+// gives the memory of an empty queue back, keeping room for size orders
+func (this *OrdersQueue) shrink(size int) {
+	this.ringbuffer.Shrink(size)
+}
@@ -0,0 +1,128 @@
+package book
+
+import (
+	"fmt"
+	"sort"
+)
+
+// price levels kept in a sorted slice.
+// lookups are O(lgN) by binary search, insert and delete are O(N) memmove,
+// which is cheap for books with a few hundred levels around the touch.
+type priceLadder struct {
+	prices []float32
+	levels []*OrdersQueue
+}
+
+func newPriceLadder() priceLadder {
+	return priceLadder{}
+}
+
+func (l *priceLadder) Size() int {
+	return len(l.prices)
+}
+
+func (l *priceLadder) IsEmpty() bool {
+	return len(l.prices) == 0
+}
+
+func (l *priceLadder) panicIfEmpty() {
+	if l.IsEmpty() {
+		panic("price ladder is empty")
+	}
+}
+
+// index of the first price >= price
+func (l *priceLadder) search(price float32) int {
+	return sort.Search(len(l.prices), func(i int) bool {
+		return l.prices[i] >= price
+	})
+}
+
+func (l *priceLadder) Contains(price float32) bool {
+	i := l.search(price)
+	return i < len(l.prices) && l.prices[i] == price
+}
+
+func (l *priceLadder) Get(price float32) *OrdersQueue {
+	l.panicIfEmpty()
+
+	i := l.search(price)
+	if i == len(l.prices) || l.prices[i] != price {
+		panic(fmt.Sprintf("key %0.8f does not exist", price))
+	}
+	return l.levels[i]
+}
+
+func (l *priceLadder) Put(price float32, level *OrdersQueue) {
+	i := l.search(price)
+	if i < len(l.prices) && l.prices[i] == price {
+		// existing level, updating the value
+		l.levels[i] = level
+		return
+	}
+
+	l.prices = append(l.prices, 0)
+	l.levels = append(l.levels, nil)
+	copy(l.prices[i+1:], l.prices[i:])
+	copy(l.levels[i+1:], l.levels[i:])
+	l.prices[i] = price
+	l.levels[i] = level
+}
+
+func (l *priceLadder) Delete(price float32) {
+	i := l.search(price)
+	if i == len(l.prices) || l.prices[i] != price {
+		return
+	}
+
+	copy(l.prices[i:], l.prices[i+1:])
+	copy(l.levels[i:], l.levels[i+1:])
+	last := len(l.prices) - 1
+	l.levels[last] = nil
+	l.prices = l.prices[:last]
+	l.levels = l.levels[:last]
+}
+
+func (l *priceLadder) Min() float32 {
+	l.panicIfEmpty()
+	return l.prices[0]
+}
+
+func (l *priceLadder) Max() float32 {
+	l.panicIfEmpty()
+	return l.prices[len(l.prices)-1]
+}
+
+func (l *priceLadder) Higher(price float32) (float32, bool) {
+	i := sort.Search(len(l.prices), func(i int) bool {
+		return l.prices[i] > price
+	})
+	if i == len(l.prices) {
+		return 0, false
+	}
+	return l.prices[i], true
+}
+
+func (l *priceLadder) Lower(price float32) (float32, bool) {
+	i := l.search(price) - 1
+	if i < 0 {
+		return 0, false
+	}
+	return l.prices[i], true
+}
+
+func (l *priceLadder) Ascend(fn func(price float32, level *OrdersQueue) bool) {
+	for i := range l.prices {
+		if !fn(l.prices[i], l.levels[i]) {
+			return
+		}
+	}
+}
+
+func (l *priceLadder) Descend(fn func(price float32, level *OrdersQueue) bool) {
+	for i := len(l.prices) - 1; i >= 0; i-- {
+		if !fn(l.prices[i], l.levels[i]) {
+			return
+		}
+	}
+}
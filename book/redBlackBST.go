@@ -1,41 +1,46 @@
-package main
+package book
 
 import (
+	"cmp"
 	"fmt"
+	"io"
+	"strings"
 )
 
 // truncated from https://github.com/alexey-ernest/go-hft-orderbook/blob/master/redblackbst.go
 // A self-balancing Binary Search Tree with 2*lgN worst case garantees for
 // search, put, delete, min, max, select, rank, floor, ceiling operations.
 // Average runtine for search-based operations estimated as 1*lgN
+// It is an ordered map of any ordered key type, the book keys its levels by float32 price
+// but integer ticks work the same.
 
-type nodeRedBlack struct {
-	Key   float32
-	Value *OrdersQueue
-	Next  *nodeRedBlack
-	Prev  *nodeRedBlack
+type nodeRedBlack[K cmp.Ordered, V any] struct {
+	Key   K
+	Value V
+	Next  *nodeRedBlack[K, V]
+	Prev  *nodeRedBlack[K, V]
 
-	left  *nodeRedBlack
-	right *nodeRedBlack
+	left  *nodeRedBlack[K, V]
+	right *nodeRedBlack[K, V]
 	size  int
 	isRed bool
 }
 
-type redBlackBST struct {
-	root *nodeRedBlack
-	minC *nodeRedBlack // cached min/max keys for O(1) access
-	maxC *nodeRedBlack
+type redBlackBST[K cmp.Ordered, V any] struct {
+	root *nodeRedBlack[K, V]
+	minC *nodeRedBlack[K, V] // cached min/max keys for O(1) access
+	maxC *nodeRedBlack[K, V]
 }
 
-func NewRedBlackBST() redBlackBST {
-	return redBlackBST{}
+func newRedBlackBST[K cmp.Ordered, V any]() redBlackBST[K, V] {
+	return redBlackBST[K, V]{}
 }
 
-func (t *redBlackBST) Size() int {
+func (t *redBlackBST[K, V]) Size() int {
 	return t.size(t.root)
 }
 
-func (t *redBlackBST) size(n *nodeRedBlack) int {
+func (t *redBlackBST[K, V]) size(n *nodeRedBlack[K, V]) int {
 	if n == nil {
 		return 0
 	}
@@ -43,32 +48,32 @@ func (t *redBlackBST) size(n *nodeRedBlack) int {
 	return n.size
 }
 
-func (t *redBlackBST) IsEmpty() bool {
+func (t *redBlackBST[K, V]) IsEmpty() bool {
 	return t.size(t.root) == 0
 }
 
-func (t *redBlackBST) panicIfEmpty() {
+func (t *redBlackBST[K, V]) panicIfEmpty() {
 	if t.IsEmpty() {
 		panic("Red Black BST is empty")
 	}
 }
 
-func (t *redBlackBST) Contains(key float32) bool {
+func (t *redBlackBST[K, V]) Contains(key K) bool {
 	return t.get(t.root, key) != nil
 }
 
-func (t *redBlackBST) Get(key float32) *OrdersQueue {
+func (t *redBlackBST[K, V]) Get(key K) V {
 	t.panicIfEmpty()
 
 	x := t.get(t.root, key)
 	if x == nil {
-		panic(fmt.Sprintf("key %0.8f does not exist", key))
+		panic(fmt.Sprintf("key %s does not exist", formatKey(key)))
 	}
 
 	return x.Value
 }
 
-func (t *redBlackBST) get(n *nodeRedBlack, key float32) *nodeRedBlack {
+func (t *redBlackBST[K, V]) get(n *nodeRedBlack[K, V], key K) *nodeRedBlack[K, V] {
 	if n == nil {
 		return nil
 	}
@@ -84,7 +89,7 @@ func (t *redBlackBST) get(n *nodeRedBlack, key float32) *nodeRedBlack {
 	}
 }
 
-func (t *redBlackBST) isRed(n *nodeRedBlack) bool {
+func (t *redBlackBST[K, V]) isRed(n *nodeRedBlack[K, V]) bool {
 	if n == nil {
 		// nil nodes are black by default
 		return false
@@ -93,7 +98,7 @@ func (t *redBlackBST) isRed(n *nodeRedBlack) bool {
 	return n.isRed
 }
 
-func (t *redBlackBST) flipColors(n *nodeRedBlack) {
+func (t *redBlackBST[K, V]) flipColors(n *nodeRedBlack[K, V]) {
 	if n == nil {
 		return
 	}
@@ -110,7 +115,7 @@ func (t *redBlackBST) flipColors(n *nodeRedBlack) {
 	n.isRed = !n.isRed
 }
 
-func (t *redBlackBST) rotateLeft(n *nodeRedBlack) *nodeRedBlack {
+func (t *redBlackBST[K, V]) rotateLeft(n *nodeRedBlack[K, V]) *nodeRedBlack[K, V] {
 	x := n.right
 	n.right = x.left
 	x.left = n
@@ -125,7 +130,7 @@ func (t *redBlackBST) rotateLeft(n *nodeRedBlack) *nodeRedBlack {
 	return x
 }
 
-func (t *redBlackBST) rotateRight(n *nodeRedBlack) *nodeRedBlack {
+func (t *redBlackBST[K, V]) rotateRight(n *nodeRedBlack[K, V]) *nodeRedBlack[K, V] {
 	x := n.left
 	n.left = x.right
 	x.right = n
@@ -140,17 +145,17 @@ func (t *redBlackBST) rotateRight(n *nodeRedBlack) *nodeRedBlack {
 	return x
 }
 
-func (t *redBlackBST) Put(key float32, value *OrdersQueue) {
+func (t *redBlackBST[K, V]) Put(key K, value V) {
 	t.root = t.put(t.root, key, value)
 
 	// keeping root black
 	t.root.isRed = false
 }
 
-func (t *redBlackBST) put(n *nodeRedBlack, key float32, value *OrdersQueue) *nodeRedBlack {
+func (t *redBlackBST[K, V]) put(n *nodeRedBlack[K, V], key K, value V) *nodeRedBlack[K, V] {
 	if n == nil {
 		// search miss, creating a new node with a red link as a part of 3- or 4-node
-		n := &nodeRedBlack{
+		n := &nodeRedBlack[K, V]{
 			Value: value,
 			Key:   key,
 			size:  1,
@@ -223,7 +228,7 @@ func (t *redBlackBST) put(n *nodeRedBlack, key float32, value *OrdersQueue) *nod
 	return n
 }
 
-func (t *redBlackBST) Height() int {
+func (t *redBlackBST[K, V]) Height() int {
 	if t.IsEmpty() {
 		return 0
 	}
@@ -231,7 +236,7 @@ func (t *redBlackBST) Height() int {
 	return t.height(t.root)
 }
 
-func (t *redBlackBST) height(n *nodeRedBlack) int {
+func (t *redBlackBST[K, V]) height(n *nodeRedBlack[K, V]) int {
 	if n == nil {
 		return 0
 	}
@@ -247,12 +252,56 @@ func (t *redBlackBST) height(n *nodeRedBlack) int {
 	return height + 1
 }
 
-func (t *redBlackBST) IsRedBlack() bool {
+func (t *redBlackBST[K, V]) IsRedBlack() bool {
 	balanced, _ := t.isBalanced(t.root)
 	return balanced && t.is23(t.root)
 }
 
-func (t *redBlackBST) isBalanced(n *nodeRedBlack) (bool, int) {
+// IsLinked certifies the intrusive list of the nodes: following Next from the cached min
+// and Prev from the cached max must visit every node in key order, as an in-order walk does.
+func (t *redBlackBST[K, V]) IsLinked() bool {
+	nodes := make([]*nodeRedBlack[K, V], 0, t.Size())
+	t.inorder(t.root, func(n *nodeRedBlack[K, V]) {
+		nodes = append(nodes, n)
+	})
+
+	if len(nodes) == 0 {
+		return t.minC == nil && t.maxC == nil
+	}
+	if t.minC != nodes[0] || t.maxC != nodes[len(nodes)-1] {
+		// cached min/max must be the tree nodes holding the extreme keys
+		return false
+	}
+
+	for i, n := range nodes {
+		var prev, next *nodeRedBlack[K, V]
+		if i > 0 {
+			prev = nodes[i-1]
+		}
+		if i < len(nodes)-1 {
+			next = nodes[i+1]
+		}
+		if n.Prev != prev || n.Next != next {
+			return false
+		}
+		if next != nil && next.Key <= n.Key {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *redBlackBST[K, V]) inorder(n *nodeRedBlack[K, V], fn func(n *nodeRedBlack[K, V])) {
+	if n == nil {
+		return
+	}
+
+	t.inorder(n.left, fn)
+	fn(n)
+	t.inorder(n.right, fn)
+}
+
+func (t *redBlackBST[K, V]) isBalanced(n *nodeRedBlack[K, V]) (bool, int) {
 	if n == nil {
 		// nil node is black by default
 		return true, 1
@@ -273,7 +322,7 @@ func (t *redBlackBST) isBalanced(n *nodeRedBlack) (bool, int) {
 	return lb && rb && l == r, b
 }
 
-func (t *redBlackBST) is23(n *nodeRedBlack) bool {
+func (t *redBlackBST[K, V]) is23(n *nodeRedBlack[K, V]) bool {
 	if n == nil {
 		return true
 	}
@@ -291,22 +340,22 @@ func (t *redBlackBST) is23(n *nodeRedBlack) bool {
 	return t.is23(n.left) && t.is23(n.right)
 }
 
-func (t *redBlackBST) Min() float32 {
+func (t *redBlackBST[K, V]) Min() K {
 	t.panicIfEmpty()
 	return t.minC.Key
 }
 
-func (t *redBlackBST) MinValue() *OrdersQueue {
+func (t *redBlackBST[K, V]) MinValue() V {
 	t.panicIfEmpty()
 	return t.minC.Value
 }
 
-func (t *redBlackBST) MinPointer() *nodeRedBlack {
+func (t *redBlackBST[K, V]) MinPointer() *nodeRedBlack[K, V] {
 	t.panicIfEmpty()
 	return t.minC
 }
 
-func (t *redBlackBST) min(n *nodeRedBlack) *nodeRedBlack {
+func (t *redBlackBST[K, V]) min(n *nodeRedBlack[K, V]) *nodeRedBlack[K, V] {
 	if n.left == nil {
 		return n
 	}
@@ -314,22 +363,22 @@ func (t *redBlackBST) min(n *nodeRedBlack) *nodeRedBlack {
 	return t.min(n.left)
 }
 
-func (t *redBlackBST) Max() float32 {
+func (t *redBlackBST[K, V]) Max() K {
 	t.panicIfEmpty()
 	return t.maxC.Key
 }
 
-func (t *redBlackBST) MaxValue() *OrdersQueue {
+func (t *redBlackBST[K, V]) MaxValue() V {
 	t.panicIfEmpty()
 	return t.maxC.Value
 }
 
-func (t *redBlackBST) MaxPointer() *nodeRedBlack {
+func (t *redBlackBST[K, V]) MaxPointer() *nodeRedBlack[K, V] {
 	t.panicIfEmpty()
 	return t.maxC
 }
 
-func (t *redBlackBST) max(n *nodeRedBlack) *nodeRedBlack {
+func (t *redBlackBST[K, V]) max(n *nodeRedBlack[K, V]) *nodeRedBlack[K, V] {
 	if n.right == nil {
 		return n
 	}
@@ -337,18 +386,18 @@ func (t *redBlackBST) max(n *nodeRedBlack) *nodeRedBlack {
 	return t.max(n.right)
 }
 
-func (t *redBlackBST) Floor(key float32) float32 {
+func (t *redBlackBST[K, V]) Floor(key K) K {
 	t.panicIfEmpty()
 
 	floor := t.floor(t.root, key)
 	if floor == nil {
-		panic(fmt.Sprintf("there are no keys <= %0.8f", key))
+		panic(fmt.Sprintf("there are no keys <= %s", formatKey(key)))
 	}
 
 	return floor.Key
 }
 
-func (t *redBlackBST) floor(n *nodeRedBlack, key float32) *nodeRedBlack {
+func (t *redBlackBST[K, V]) floor(n *nodeRedBlack[K, V], key K) *nodeRedBlack[K, V] {
 	if n == nil {
 		// search miss
 		return nil
@@ -373,18 +422,18 @@ func (t *redBlackBST) floor(n *nodeRedBlack, key float32) *nodeRedBlack {
 	return n
 }
 
-func (t *redBlackBST) Ceiling(key float32) float32 {
+func (t *redBlackBST[K, V]) Ceiling(key K) K {
 	t.panicIfEmpty()
 
 	ceiling := t.ceiling(t.root, key)
 	if ceiling == nil {
-		panic(fmt.Sprintf("there are no keys >= %0.8f", key))
+		panic(fmt.Sprintf("there are no keys >= %s", formatKey(key)))
 	}
 
 	return ceiling.Key
 }
 
-func (t *redBlackBST) ceiling(n *nodeRedBlack, key float32) *nodeRedBlack {
+func (t *redBlackBST[K, V]) ceiling(n *nodeRedBlack[K, V], key K) *nodeRedBlack[K, V] {
 	if n == nil {
 		// search miss
 		return nil
@@ -409,7 +458,84 @@ func (t *redBlackBST) ceiling(n *nodeRedBlack, key float32) *nodeRedBlack {
 	return n
 }
 
-func (t *redBlackBST) Select(k int) float32 {
+// smallest key strictly greater than key
+func (t *redBlackBST[K, V]) Higher(key K) (K, bool) {
+	if n := t.get(t.root, key); n != nil {
+		// walking from an existing level, the neighbour is one link away
+		if n.Next == nil {
+			var zero K
+			return zero, false
+		}
+		return n.Next.Key, true
+	}
+
+	higher := t.higher(t.root, key)
+	if higher == nil {
+		var zero K
+		return zero, false
+	}
+
+	return higher.Key, true
+}
+
+func (t *redBlackBST[K, V]) higher(n *nodeRedBlack[K, V], key K) *nodeRedBlack[K, V] {
+	if n == nil {
+		return nil
+	}
+
+	if n.Key <= key {
+		// higher key must be in the right sub-tree
+		return t.higher(n.right, key)
+	}
+
+	// a closer key could be in the left sub-tree, if not, using current root
+	higher := t.higher(n.left, key)
+	if higher != nil {
+		return higher
+	}
+
+	return n
+}
+
+// largest key strictly less than key
+func (t *redBlackBST[K, V]) Lower(key K) (K, bool) {
+	if n := t.get(t.root, key); n != nil {
+		if n.Prev == nil {
+			var zero K
+			return zero, false
+		}
+		return n.Prev.Key, true
+	}
+
+	lower := t.lower(t.root, key)
+	if lower == nil {
+		var zero K
+		return zero, false
+	}
+
+	return lower.Key, true
+}
+
+func (t *redBlackBST[K, V]) lower(n *nodeRedBlack[K, V], key K) *nodeRedBlack[K, V] {
+	if n == nil {
+		return nil
+	}
+
+	if n.Key >= key {
+		// lower key must be in the left sub-tree
+		return t.lower(n.left, key)
+	}
+
+	// a closer key could be in the right sub-tree, if not, using current root
+	lower := t.lower(n.right, key)
+	if lower != nil {
+		return lower
+	}
+
+	return n
+}
+
+func (t *redBlackBST[K, V]) Select(k int) K {
 	if k < 0 || k >= t.Size() {
 		panic("index out of range")
 	}
@@ -417,7 +543,7 @@ func (t *redBlackBST) Select(k int) float32 {
 	return t.selectNode(t.root, k).Key
 }
 
-func (t *redBlackBST) selectNode(n *nodeRedBlack, k int) *nodeRedBlack {
+func (t *redBlackBST[K, V]) selectNode(n *nodeRedBlack[K, V], k int) *nodeRedBlack[K, V] {
 	if t.size(n.left) == k {
 		return n
 	}
@@ -430,12 +556,12 @@ func (t *redBlackBST) selectNode(n *nodeRedBlack, k int) *nodeRedBlack {
 	return t.selectNode(n.right, k)
 }
 
-func (t *redBlackBST) Rank(key float32) int {
+func (t *redBlackBST[K, V]) Rank(key K) int {
 	t.panicIfEmpty()
 	return t.rank(t.root, key)
 }
 
-func (t *redBlackBST) rank(n *nodeRedBlack, key float32) int {
+func (t *redBlackBST[K, V]) rank(n *nodeRedBlack[K, V], key K) int {
 	if n == nil {
 		return 0
 	}
@@ -451,7 +577,7 @@ func (t *redBlackBST) rank(n *nodeRedBlack, key float32) int {
 	return t.size(n.left) + 1 + t.rank(n.right, key)
 }
 
-func (t *redBlackBST) moveRedLeft(n *nodeRedBlack) *nodeRedBlack {
+func (t *redBlackBST[K, V]) moveRedLeft(n *nodeRedBlack[K, V]) *nodeRedBlack[K, V] {
 	// assuming that n.left and n.left.left are black and n is red,
 	// make h.left or one of its children red
 	t.flipColors(n)
@@ -471,7 +597,7 @@ func (t *redBlackBST) moveRedLeft(n *nodeRedBlack) *nodeRedBlack {
 	return n
 }
 
-func (t *redBlackBST) DeleteMin() {
+func (t *redBlackBST[K, V]) DeleteMin() {
 	t.panicIfEmpty()
 
 	if !t.isRed(t.root.left) && !t.isRed(t.root.right) {
@@ -484,7 +610,7 @@ func (t *redBlackBST) DeleteMin() {
 	}
 }
 
-func (t *redBlackBST) deleteMin(n *nodeRedBlack) *nodeRedBlack {
+func (t *redBlackBST[K, V]) deleteMin(n *nodeRedBlack[K, V]) *nodeRedBlack[K, V] {
 	if n.left == nil {
 		// we've reached the least leave of the tree
 		next := n.Next
@@ -498,10 +624,13 @@ func (t *redBlackBST) deleteMin(n *nodeRedBlack) *nodeRedBlack {
 		n.Next = nil
 		n.Prev = nil
 
-		// updating global min
+		// updating global min, and global max if n was the last node
 		if t.minC == n {
 			t.minC = next
 		}
+		if t.maxC == n {
+			t.maxC = prev
+		}
 
 		return n.right
 	}
@@ -528,7 +657,7 @@ func (t *redBlackBST) deleteMin(n *nodeRedBlack) *nodeRedBlack {
 	return n
 }
 
-func (t *redBlackBST) moveRedRight(n *nodeRedBlack) *nodeRedBlack {
+func (t *redBlackBST[K, V]) moveRedRight(n *nodeRedBlack[K, V]) *nodeRedBlack[K, V] {
 	// assuming n is red, n.right and n.right.left are black,
 	// make h.right or one of its children red
 	t.flipColors(n)
@@ -545,7 +674,7 @@ func (t *redBlackBST) moveRedRight(n *nodeRedBlack) *nodeRedBlack {
 	return n
 }
 
-func (t *redBlackBST) DeleteMax() {
+func (t *redBlackBST[K, V]) DeleteMax() {
 	t.panicIfEmpty()
 
 	if !t.isRed(t.root.left) && !t.isRed(t.root.right) {
@@ -557,7 +686,7 @@ func (t *redBlackBST) DeleteMax() {
 	}
 }
 
-func (t *redBlackBST) deleteMax(n *nodeRedBlack) *nodeRedBlack {
+func (t *redBlackBST[K, V]) deleteMax(n *nodeRedBlack[K, V]) *nodeRedBlack[K, V] {
 	if t.isRed(n.left) {
 		// making right red by rotating
 		n = t.rotateRight(n)
@@ -575,10 +704,13 @@ func (t *redBlackBST) deleteMax(n *nodeRedBlack) *nodeRedBlack {
 		n.Next = nil
 		n.Prev = nil
 
-		// updating global max
+		// updating global max, and global min if n was the last node
 		if t.maxC == n {
 			t.maxC = prev
 		}
+		if t.minC == n {
+			t.minC = next
+		}
 
 		return n.left
 	}
@@ -605,8 +737,11 @@ func (t *redBlackBST) deleteMax(n *nodeRedBlack) *nodeRedBlack {
 	return n
 }
 
-func (t *redBlackBST) Delete(key float32) {
-	t.panicIfEmpty()
+func (t *redBlackBST[K, V]) Delete(key K) {
+	if !t.Contains(key) {
+		// a search miss would otherwise drop the subtree it ends in
+		return
+	}
 
 	if !t.isRed(t.root.left) && !t.isRed(t.root.right) {
 		t.root.isRed = true
@@ -617,7 +752,7 @@ func (t *redBlackBST) Delete(key float32) {
 	}
 }
 
-func (t *redBlackBST) delete(n *nodeRedBlack, key float32) *nodeRedBlack {
+func (t *redBlackBST[K, V]) delete(n *nodeRedBlack[K, V], key K) *nodeRedBlack[K, V] {
 	if n.Key > key {
 		if n.left == nil {
 			// search miss
@@ -673,8 +808,8 @@ func (t *redBlackBST) delete(n *nodeRedBlack, key float32) *nodeRedBlack {
 			n.Value = rightMin.Value
 			n.right = t.deleteMin(n.right)
 
-			// global min will be updated automatically if requied,
-			// as we copy values from successor
+			// global min/max will be updated automatically if requied,
+			// as we copy values from successor and deleteMin hands its list position to n
 		} else {
 			if n.right == nil {
 				// search miss
@@ -700,7 +835,7 @@ func (t *redBlackBST) delete(n *nodeRedBlack, key float32) *nodeRedBlack {
 	return n
 }
 
-func (t *redBlackBST) Keys(lo, hi float32) []float32 {
+func (t *redBlackBST[K, V]) Keys(lo, hi K) []K {
 	if lo < t.Min() || hi > t.Max() {
 		panic("keys out of range")
 	}
@@ -708,7 +843,7 @@ func (t *redBlackBST) Keys(lo, hi float32) []float32 {
 	return t.keys(t.root, lo, hi)
 }
 
-func (t *redBlackBST) keys(n *nodeRedBlack, lo, hi float32) []float32 {
+func (t *redBlackBST[K, V]) keys(n *nodeRedBlack[K, V], lo, hi K) []K {
 	if n == nil {
 		return nil
 	}
@@ -722,7 +857,7 @@ func (t *redBlackBST) keys(n *nodeRedBlack, lo, hi float32) []float32 {
 	l := t.keys(n.left, lo, hi)
 	r := t.keys(n.right, lo, hi)
 
-	keys := make([]float32, 0)
+	keys := make([]K, 0)
 	if l != nil {
 		keys = append(keys, l...)
 	}
@@ -734,22 +869,52 @@ func (t *redBlackBST) keys(n *nodeRedBlack, lo, hi float32) []float32 {
 	return keys
 }
 
-func (t *redBlackBST) Print() {
-	fmt.Println()
-	t.print(t.root)
-	fmt.Println()
+// in-order traversal from the min key following the Next links, stops when fn returns false
+func (t *redBlackBST[K, V]) Ascend(fn func(key K, value V) bool) {
+	for n := t.minC; n != nil; n = n.Next {
+		if !fn(n.Key, n.Value) {
+			return
+		}
+	}
 }
 
-func (t *redBlackBST) print(n *nodeRedBlack) {
-	if n == nil {
-		return
+// reverse in-order traversal from the max key following the Prev links, stops when fn returns false
+func (t *redBlackBST[K, V]) Descend(fn func(key K, value V) bool) {
+	for n := t.maxC; n != nil; n = n.Prev {
+		if !fn(n.Key, n.Value) {
+			return
+		}
 	}
+}
 
+// writes the structure of the tree to w, one key per line indented by its depth. the right
+// subtree is printed above its parent, so keys go from highest to lowest. red keys are starred
+func (t *redBlackBST[K, V]) Fprint(w io.Writer) error {
+	return t.fprint(w, t.root, 0)
+}
+
+func (t *redBlackBST[K, V]) fprint(w io.Writer, n *nodeRedBlack[K, V], depth int) error {
+	if n == nil {
+		return nil
+	}
+	if err := t.fprint(w, n.right, depth+1); err != nil {
+		return err
+	}
+	mark := ""
 	if n.isRed {
-		fmt.Printf("*")
+		mark = "*"
+	}
+	if _, err := fmt.Fprintf(w, "%s%s%s\n", strings.Repeat("    ", depth), mark, formatKey(n.Key)); err != nil {
+		return err
 	}
-	fmt.Printf("%0.8f ", n.Key)
+	return t.fprint(w, n.left, depth+1)
+}
 
-	t.print(n.left)
-	t.print(n.right)
+// floats print with 8 decimals as prices always have, other keys as fmt does
+func formatKey[K cmp.Ordered](key K) string {
+	switch k := any(key).(type) {
+	case float32, float64:
+		return fmt.Sprintf("%0.8f", k)
+	}
+	return fmt.Sprint(key)
 }
@@ -1,19 +1,21 @@
-package main
+package book
 
 import (
+	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
 )
 
 func TestRedBlackEmpty(t *testing.T) {
-	rb := NewRedBlackBST()
+	rb := newRedBlackBST[float32, *OrdersQueue]()
 	if rb.Size() != 0 || !rb.IsEmpty() {
 		t.Errorf("Red Black BST should be empty")
 	}
 }
 
 func TestRedBlackBasic(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	keys := make([]float32, 0)
 	for i := 0; i < 10; i += 1 {
 		k := rand.Float32()
@@ -36,7 +38,7 @@ func TestRedBlackBasic(t *testing.T) {
 }
 
 func TestRedBlackHeight(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	n := 100000
 	for i := 0; i < n; i += 1 {
 
@@ -57,7 +59,7 @@ func TestRedBlackHeight(t *testing.T) {
 }
 
 func TestRedBlackMinMax(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	for i := 0; i < 10; i += 1 {
 		st.Put(float32(10-i), nil)
 	}
@@ -74,7 +76,7 @@ func TestRedBlackMinMax(t *testing.T) {
 }
 
 func TestRedBlackMinMaxCachedOnDelete(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	for i := 0; i < 100; i += 1 {
 		st.Put(float32(100-i), nil)
 	}
@@ -112,7 +114,7 @@ func TestRedBlackMinMaxCachedOnDelete(t *testing.T) {
 }
 
 func TestRedBlackFloor(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	for i := 0; i < 10; i += 1 {
 		k := float32(20 - 2*i)
 		st.Put(k, nil)
@@ -131,7 +133,7 @@ func TestRedBlackFloor(t *testing.T) {
 }
 
 func TestRedBlackCeiling(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	for i := 0; i < 10; i += 1 {
 		k := float32(20 - 2*i)
 		st.Put(k, nil)
@@ -150,7 +152,7 @@ func TestRedBlackCeiling(t *testing.T) {
 }
 
 func TestRedBlackSelect(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	for i := 0; i < 10; i += 1 {
 		k := float32(10 - i)
 		st.Put(k, nil)
@@ -168,7 +170,7 @@ func TestRedBlackSelect(t *testing.T) {
 }
 
 func TestRedBlackRank(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	keys := make([]float32, 0)
 	for i := 0; i < 10; i += 1 {
 		k := float32(10 - i)
@@ -193,7 +195,7 @@ func TestRedBlackRank(t *testing.T) {
 }
 
 func TestRedBlackKeys(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	for i := 0; i < 10; i += 1 {
 		k := float32(10 - i)
 		st.Put(k, nil)
@@ -222,7 +224,7 @@ func TestRedBlackKeys(t *testing.T) {
 }
 
 func TestRedBlackDeleteMin(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	for i := 0; i < 10; i += 1 {
 		k := float32(10 - i)
 		st.Put(k, nil)
@@ -243,7 +245,7 @@ func TestRedBlackDeleteMin(t *testing.T) {
 }
 
 func TestRedBlackDeleteMax(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	for i := 0; i < 10; i += 1 {
 		k := float32(i)
 		st.Put(k, nil)
@@ -264,7 +266,7 @@ func TestRedBlackDeleteMax(t *testing.T) {
 }
 
 func TestRedBlackDelete(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	for i := 0; i < 10; i += 1 {
 		k := float32(i)
 		st.Put(k, nil)
@@ -286,7 +288,7 @@ func TestRedBlackDelete(t *testing.T) {
 }
 
 func TestRedBlackPutLinkedListOrder(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	for i := 0; i < 100; i += 1 {
 		k := rand.Float32()
 		st.Put(k, nil)
@@ -302,7 +304,7 @@ func TestRedBlackPutLinkedListOrder(t *testing.T) {
 }
 
 func TestRedBlackPutDeleteLinkedListOrder(t *testing.T) {
-	st := NewRedBlackBST()
+	st := newRedBlackBST[float32, *OrdersQueue]()
 	n := 1000
 	for i := 0; i < n; i += 1 {
 		k := rand.Float32()
@@ -330,3 +332,138 @@ func TestRedBlackPutDeleteLinkedListOrder(t *testing.T) {
 		}
 	}
 }
+
+func TestRedBlackMaxCachedOnSuccessorDelete(t *testing.T) {
+	st := newRedBlackBST[float32, *OrdersQueue]()
+	st.Put(1, nil)
+	st.Put(2, nil)
+	st.Put(3, nil)
+
+	// 2 is replaced by its successor 3, which was the cached max
+	st.Delete(2)
+	st.Delete(3)
+	if st.Max() != 1 || st.Min() != 1 {
+		t.Errorf("min %0.8f max %0.8f should both be 1", st.Min(), st.Max())
+	}
+	if !st.IsLinked() {
+		t.Errorf("linked list certification failed")
+	}
+}
+
+func TestRedBlackMinMaxCachedOnLastDelete(t *testing.T) {
+	st := newRedBlackBST[float32, *OrdersQueue]()
+	st.Put(1, nil)
+	st.DeleteMin()
+	st.Put(2, nil)
+	if st.Min() != 2 || st.Max() != 2 {
+		t.Errorf("min %0.8f max %0.8f should both be 2", st.Min(), st.Max())
+	}
+
+	st.DeleteMax()
+	st.Put(3, nil)
+	if st.Min() != 3 || st.Max() != 3 {
+		t.Errorf("min %0.8f max %0.8f should both be 3", st.Min(), st.Max())
+	}
+}
+
+func TestRedBlackLinkedListRandomOps(t *testing.T) {
+	st := newRedBlackBST[float32, *OrdersQueue]()
+	for i := 0; i < 5000; i += 1 {
+		k := float32(rand.Intn(500))
+		switch rand.Intn(4) {
+		case 0, 1:
+			st.Put(k, nil)
+		case 2:
+			st.Delete(k)
+		case 3:
+			if !st.IsEmpty() {
+				if rand.Intn(2) == 0 {
+					st.DeleteMin()
+				} else {
+					st.DeleteMax()
+				}
+			}
+		}
+
+		if !st.IsRedBlack() {
+			t.Fatalf("certification failed after %d operations", i)
+		}
+		if !st.IsLinked() {
+			t.Fatalf("linked list certification failed after %d operations", i)
+		}
+	}
+}
+
+func TestRedBlackLinkedTraversal(t *testing.T) {
+	st := newRedBlackBST[float32, *OrdersQueue]()
+	for i := 0; i < 10; i += 1 {
+		st.Put(float32(2*i), nil)
+	}
+
+	n := 0
+	for p := st.MinPointer(); p != nil; p = p.Next {
+		if p.Key != float32(2*n) {
+			t.Errorf("key %0.8f should be %d", p.Key, 2*n)
+		}
+		n += 1
+	}
+	if n != 10 {
+		t.Errorf("walking the list should visit 10 nodes, visited %d", n)
+	}
+
+	for p := st.MaxPointer(); p != nil; p = p.Prev {
+		n -= 1
+		if p.Key != float32(2*n) {
+			t.Errorf("key %0.8f should be %d", p.Key, 2*n)
+		}
+	}
+}
+
+func TestRedBlackIntegerTicks(t *testing.T) {
+	st := newRedBlackBST[int64, string]()
+	for _, tick := range []int64{10050, 9990, 10000, 10025} {
+		st.Put(tick, fmt.Sprint(tick))
+	}
+	st.Put(10000, "replaced")
+
+	if st.Size() != 4 || st.Min() != 9990 || st.Max() != 10050 || st.Get(10000) != "replaced" {
+		t.Errorf("unexpected size %d min %d max %d", st.Size(), st.Min(), st.Max())
+	}
+	if higher, ok := st.Higher(10000); !ok || higher != 10025 {
+		t.Errorf("the tick above 10000 should be 10025, got %d", higher)
+	}
+	if _, ok := st.Lower(9990); ok {
+		t.Errorf("nothing should be below the min")
+	}
+	if keys := st.Keys(9990, 10025); len(keys) != 3 || keys[0] != 9990 || keys[2] != 10025 {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	st.Delete(9990)
+	if st.Min() != 10000 || !st.IsRedBlack() || !st.IsLinked() {
+		t.Errorf("the min should move up after a delete")
+	}
+
+	var out strings.Builder
+	st.Fprint(&out)
+	if lines := strings.Fields(strings.ReplaceAll(out.String(), "*", "")); len(lines) != 3 || lines[0] != "10050" {
+		t.Errorf("integer keys should print as integers\n%s", out.String())
+	}
+}
+
+func TestRedBlackStringKeys(t *testing.T) {
+	st := newRedBlackBST[string, int]()
+	for i, k := range []string{"ETH", "BTC", "SOL", "ADA"} {
+		st.Put(k, i)
+	}
+	keys := make([]string, 0)
+	st.Ascend(func(k string, _ int) bool {
+		keys = append(keys, k)
+		return true
+	})
+	if strings.Join(keys, " ") != "ADA BTC ETH SOL" {
+		t.Errorf("keys should ascend in string order, got %v", keys)
+	}
+	if st.Rank("ETH") != 2 || st.Select(1) != "BTC" || st.Floor("C") != "BTC" || st.Ceiling("C") != "ETH" {
+		t.Errorf("unexpected rank, select, floor or ceiling")
+	}
+}
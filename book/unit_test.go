@@ -0,0 +1,513 @@
+package book
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"matching-engine-go/orders"
+)
+
+func NewMockBuyOrder() orders.Order {
+	price := float32(10)
+	quantity := float32(100)
+	accountId := uint32(2)
+	sequenceId := uint32(1)
+	BidOrAsk := true // true = bid
+	orderType := orders.LIMIT
+	ni := orders.NewIncomingOrder(price, quantity, BidOrAsk, orderType, accountId)
+	no := orders.NewOrder(ni, sequenceId)
+	return no
+}
+
+func NewMockSellOrder() orders.Order {
+	price := float32(10)
+	quantity := float32(100)
+	accountId := uint32(2)
+	sequenceId := uint32(1)
+	BidOrAsk := false // true = bid
+	orderType := orders.LIMIT
+	ni := orders.NewIncomingOrder(price, quantity, BidOrAsk, orderType, accountId)
+	no := orders.NewOrder(ni, sequenceId)
+	return no
+}
+
+func NewCustomOrder(price float32, quantity float32, BidOrAsk bool, orderType orders.OrderType, accountId uint32, sequenceId uint32) orders.Order {
+	ni := orders.NewIncomingOrder(price, quantity, BidOrAsk, orderType, accountId)
+	no := orders.NewOrder(ni, sequenceId)
+	return no
+}
+
+// OrderBook
+func TestOrderbookEmpty(t *testing.T) {
+	b := NewOrderbook()
+	if b.BLength() != 0 || b.ALength() != 0 {
+		t.Errorf("orderbook should be empty")
+	}
+}
+
+func TestPlaceOrder(t *testing.T) {
+	b := NewOrderbook()
+	o := NewMockBuyOrder()
+	oo := &o
+	b.Execute(oo)
+}
+
+func TestGetBestBid(t *testing.T) {
+	b := NewOrderbook()
+	o := NewMockBuyOrder()
+	oo := &o
+	b.Execute(oo)
+	if b.GetBestBid() != o.Order.Price {
+		t.Errorf("best bid should be order price")
+	}
+
+}
+
+func TestGetVolumeAtBidLimit(t *testing.T) {
+	b := NewOrderbook()
+	o := NewMockBuyOrder()
+	oo := &o
+	b.Execute(oo)
+	if b.GetVolumeAtBidLimit(o.Order.Price) != o.Order.Quantity {
+		t.Errorf("best offer volume should be order quantity")
+	}
+}
+
+func TestGetBestOffer(t *testing.T) {
+	b := NewOrderbook()
+	o := NewMockSellOrder()
+	oo := &o
+	b.Execute(oo)
+	if b.GetBestOffer() != o.Order.Price {
+		t.Errorf("best offer should be order price")
+	}
+}
+
+func TestGetVolumeAtAskLimit(t *testing.T) {
+	b := NewOrderbook()
+	o := NewMockSellOrder()
+	oo := &o
+	b.Execute(oo)
+	if b.GetVolumeAtAskLimit(o.Order.Price) != o.Order.Quantity {
+		t.Errorf("best offer volume should be order quantity")
+	}
+}
+
+func TestMatchingEqualVolume(t *testing.T) {
+	b := NewOrderbook()
+	o := NewMockBuyOrder()
+	oo := &o
+	b.Execute(oo)
+	// fmt.Println("FIRST EXECUTION :  ", exc)
+	// fmt.Println("Best Bid :  ", b.GetBestBid())
+	// fmt.Println("Best Bid Volume :  ", b.GetVolumeAtBidLimit(o.Order.Price))
+	quantity := float32(o.Order.Quantity)
+	price := float32(o.Order.Price)
+	accountId := uint32(o.Order.AccountId)
+	sequenceId := uint32(o.SequenceId + 1)
+	BidOrAsk := false // true = bid
+	orderType := orders.LIMIT
+	sellOrder := NewCustomOrder(price, quantity, BidOrAsk, orderType, accountId, sequenceId)
+	s := &sellOrder
+	b.Execute(s)
+
+	if b.BLength() != 0 || b.ALength() != 0 {
+		fmt.Println(b.BLength(), b.ALength())
+		t.Errorf("orderbook should be empty")
+	}
+	// fmt.Println("SECOND EXECUTION : ", excc)
+
+}
+
+func TestMatchingHalfVolume(t *testing.T) {
+	b := NewOrderbook()
+	o := NewMockBuyOrder()
+	oo := &o
+	b.Execute(oo)
+	// fmt.Println("FIRST EXECUTION :  ", exc)
+	// fmt.Println("Best Bid :  ", b.GetBestBid())
+	// fmt.Println("Best Bid Volume :  ", b.GetVolumeAtBidLimit(o.Order.Price))
+	quantity := float32(o.Order.Quantity) / 2
+	price := float32(o.Order.Price)
+	accountId := uint32(o.Order.AccountId)
+	sequenceId := uint32(o.SequenceId + 1)
+	BidOrAsk := false // true = bid
+	orderType := orders.LIMIT
+	sellOrder := NewCustomOrder(price, quantity, BidOrAsk, orderType, accountId, sequenceId)
+	s := &sellOrder
+	b.Execute(s)
+
+	if b.GetVolumeAtBidLimit(o.Order.Price) != (o.Order.Quantity / 2) {
+		fmt.Println(b.GetVolumeAtBidLimit(o.Order.Price))
+		t.Errorf("best offer volume should be order quantity")
+	}
+	// fmt.Println("SECOND EXECUTION : ", excc)
+
+}
+
+func TestMatchingDoubleVolume(t *testing.T) {
+	b := NewOrderbook()
+	o := NewMockBuyOrder()
+	oo := &o
+	b.Execute(oo)
+	// fmt.Println("FIRST EXECUTION :  ", exc)
+	// fmt.Println("Best Bid :  ", b.GetBestBid())
+	// fmt.Println("Best Bid Volume :  ", b.GetVolumeAtBidLimit(o.Order.Price))
+	quantity := float32(o.Order.Quantity) * 2
+	price := float32(o.Order.Price)
+	accountId := uint32(o.Order.AccountId)
+	sequenceId := uint32(o.SequenceId + 1)
+	BidOrAsk := false // true = bid
+	orderType := orders.LIMIT
+	sellOrder := NewCustomOrder(price, quantity, BidOrAsk, orderType, accountId, sequenceId)
+	s := &sellOrder
+	b.Execute(s)
+
+	if b.GetVolumeAtAskLimit(o.Order.Price) != o.Order.Quantity-o.ExecutedQuantity {
+		fmt.Println(b.GetVolumeAtAskLimit(o.Order.Price))
+		t.Errorf("best offer volume should be order quantity")
+	}
+	// fmt.Println("SECOND EXECUTION : ", excc)
+
+}
+
+func TestOrderbookAddMultiple(t *testing.T) {
+	b := NewOrderbook()
+	for i := 0; i < 100; i += 1 {
+		bid := NewMockBuyOrder()
+		bid.Order.Price = float32(i)
+		b.Execute(&bid)
+	}
+
+	for i := 100; i < 200; i += 1 {
+		ask := NewMockSellOrder()
+		ask.Order.Price = float32(i)
+		b.Execute(&ask)
+	}
+
+	if b.BLength() != 100 {
+		t.Errorf("book should have 100 bids")
+	}
+	if b.ALength() != 100 {
+		t.Errorf("book should have 100 asks")
+	}
+
+}
+
+func TestOrderqueueResizing(t *testing.T) {
+	b := NewOrderbook()
+	var bid, ask orders.Order
+	no_Order := RINGBUF_INI_SIZE + 1
+	for i := 0; i < no_Order; i += 1 {
+		bid = NewMockBuyOrder()
+		b.Execute(&bid)
+	}
+
+	for i := 0; i < no_Order; i += 1 {
+		ask = NewMockSellOrder()
+		// to avoid matching
+		ask.Order.Price = bid.Order.Price + 1
+		b.Execute(&ask)
+	}
+
+	if b.BLength() != 1 {
+		t.Errorf("book should have 1 bids")
+	}
+
+	if b.bidLimitsCache[bid.Order.Price].ringbuffer.Len() != no_Order {
+		t.Errorf("ringbuffer has not resized")
+	}
+
+}
+
+func TestOrderbookCancel(t *testing.T) {
+	b := NewOrderbook()
+	first := NewCustomOrder(10, 100, true, orders.LIMIT, 2, 1)
+	second := NewCustomOrder(10, 50, true, orders.LIMIT, 2, 2)
+	b.Execute(&first)
+	b.Execute(&second)
+
+	o, ok := b.Cancel(first.SequenceId)
+	if !ok || o.SequenceId != first.SequenceId {
+		t.Errorf("cancel should return the resting order")
+	}
+	if b.GetVolumeAtBidLimit(10) != 50 {
+		t.Errorf("volume at limit should drop to 50, got %0.8f", b.GetVolumeAtBidLimit(10))
+	}
+	if _, ok := b.Cancel(first.SequenceId); ok {
+		t.Errorf("an order can only be cancelled once")
+	}
+
+	// the cancelled order is skipped by matching
+	sell := NewCustomOrder(10, 20, false, orders.LIMIT, 3, 3)
+	b.Execute(&sell)
+	if sell.ExecutedQuantity != 20 || b.GetVolumeAtBidLimit(10) != 30 {
+		t.Errorf("sell should match the second bid")
+	}
+
+	// cancelling the last order removes the level
+	if _, ok := b.Cancel(second.SequenceId); !ok {
+		t.Errorf("partially filled order should be cancellable")
+	}
+	if b.BLength() != 0 || b.Bids.Size() != 0 {
+		t.Errorf("empty level should be removed from the book")
+	}
+}
+
+func TestOrderbookReduceNotPositive(t *testing.T) {
+	b := NewOrderbook()
+	events := 0
+	b.Subscribe(func(e Event) { events++ })
+	bid := NewCustomOrder(10, 1, true, orders.LIMIT, 2, 1)
+	b.Execute(&bid)
+	events = 0
+
+	for _, quantity := range []float32{0, -5, float32(math.NaN())} {
+		if _, ok := b.Reduce(bid.SequenceId, quantity); ok {
+			t.Errorf("a reduce by %v should be refused", quantity)
+		}
+	}
+	if events != 0 || b.GetVolumeAtBidLimit(10) != 1 {
+		t.Errorf("the order should be left as it was, %d events volume %v", events, b.GetVolumeAtBidLimit(10))
+	}
+}
+
+func TestOrderbookCancelFilled(t *testing.T) {
+	b := NewOrderbook()
+	bid := NewCustomOrder(10, 100, true, orders.LIMIT, 2, 1)
+	b.Execute(&bid)
+	ask := NewCustomOrder(10, 100, false, orders.LIMIT, 3, 2)
+	b.Execute(&ask)
+
+	if _, ok := b.Cancel(bid.SequenceId); ok {
+		t.Errorf("filled order should not be cancellable")
+	}
+	if len(b.orders) != 0 {
+		t.Errorf("filled orders should leave the index")
+	}
+}
+
+func TestOrderbookDepth(t *testing.T) {
+	b := NewOrderbook()
+	for i := 0; i < 5; i += 1 {
+		bid := NewCustomOrder(float32(10+i), float32(10*(i+1)), true, orders.LIMIT, 2, uint32(i))
+		b.Execute(&bid)
+		ask := NewCustomOrder(float32(20+i), 10, false, orders.LIMIT, 2, uint32(10+i))
+		b.Execute(&ask)
+	}
+	extra := NewCustomOrder(14, 5, true, orders.LIMIT, 2, 20)
+	b.Execute(&extra)
+
+	bids := b.Depth(true, 3)
+	if len(bids) != 3 {
+		t.Fatalf("depth should return 3 levels, got %d", len(bids))
+	}
+	if bids[0] != (PriceLevel{14, 55, 2}) || bids[2].Price != 12 {
+		t.Errorf("bids should start at the best bid, got %+v", bids)
+	}
+
+	asks := b.Depth(false, 0)
+	if len(asks) != 5 || asks[0].Price != 20 || asks[4].Price != 24 {
+		t.Errorf("asks should list every level from the best offer, got %+v", asks)
+	}
+}
+
+func TestOrderbookSparseLevelsFootprint(t *testing.T) {
+	b := NewOrderbook()
+	for i := 0; i < MaxLimitsNum; i += 1 {
+		bid := NewCustomOrder(float32(i), 1, true, orders.LIMIT, 2, uint32(i))
+		b.Execute(&bid)
+	}
+	// 16 slots of an order with its two timestamps
+	perLevel := b.Footprint() / MaxLimitsNum
+	if perLevel > 1536 {
+		t.Errorf("a level holding one order should take less than 1.5KB, took %d bytes", perLevel)
+	}
+}
+
+func TestOrderbookMemoryBudget(t *testing.T) {
+	level := newOrdersQueue(0, orderByteSize)
+	levelBytes := level.Footprint()
+	b := NewOrderbookWithConfig(BookConfig{MemoryBudget: 3 * levelBytes})
+
+	for i := 0; i < 3; i += 1 {
+		bid := NewCustomOrder(float32(i), 1, true, orders.LIMIT, 2, uint32(i))
+		if _, err := b.Execute(&bid); err != nil {
+			t.Fatalf("level %d should fit the budget: %v", i, err)
+		}
+	}
+	if b.Footprint() != 3*levelBytes {
+		t.Errorf("footprint should be %d, got %d", 3*levelBytes, b.Footprint())
+	}
+
+	// a fourth level does not fit
+	bid := NewCustomOrder(3, 1, true, orders.LIMIT, 2, 3)
+	if _, err := b.Execute(&bid); err != ErrMemoryBudget {
+		t.Errorf("new level should be rejected, got %v", err)
+	}
+	// neither does growing a full level
+	for i := 1; i < RINGBUF_INI_SIZE; i += 1 {
+		bid := NewCustomOrder(0, 1, true, orders.LIMIT, 2, uint32(10+i))
+		b.Execute(&bid)
+	}
+	bid = NewCustomOrder(0, 1, true, orders.LIMIT, 2, 100)
+	if _, err := b.Execute(&bid); err != ErrMemoryBudget {
+		t.Errorf("level growth should be rejected, got %v", err)
+	}
+	if b.BLength() != 3 || b.GetVolumeAtBidLimit(0) != RINGBUF_INI_SIZE {
+		t.Errorf("rejected orders should not rest in the book")
+	}
+
+	// matching still works and frees the budget
+	ask := NewCustomOrder(2, 1, false, orders.LIMIT, 3, 200)
+	b.Execute(&ask)
+	if b.Footprint() != 2*levelBytes {
+		t.Errorf("swept level should give its bytes back")
+	}
+	if _, err := b.Execute(&bid); err != nil {
+		t.Errorf("order should rest once there is room: %v", err)
+	}
+}
+
+func TestOrderbookShrinkOnRelease(t *testing.T) {
+	b := NewOrderbookWithConfig(BookConfig{ShrinkOnRelease: true})
+	for i := 0; i < 10*RINGBUF_INI_SIZE; i += 1 {
+		bid := NewCustomOrder(10, 1, true, orders.LIMIT, 2, uint32(i))
+		b.Execute(&bid)
+	}
+	grown := b.bidLimitsCache[10]
+	ask := NewCustomOrder(10, 10*RINGBUF_INI_SIZE, false, orders.LIMIT, 3, 1000)
+	b.Execute(&ask)
+
+	if b.Footprint() != 0 {
+		t.Errorf("empty book should hold no level, footprint %d", b.Footprint())
+	}
+	if grown.ringbuffer.Cap() != RINGBUF_INI_SIZE {
+		t.Errorf("released level should shrink back to %d, cap %d", RINGBUF_INI_SIZE, grown.ringbuffer.Cap())
+	}
+}
+
+func TestOrderbookMassCancel(t *testing.T) {
+	b := NewOrderbook()
+	cancelled := make([]uint32, 0)
+	b.Subscribe(func(e Event) {
+		if e.Type == EventCancelled {
+			cancelled = append(cancelled, e.SequenceId)
+		}
+	})
+
+	// account 1 owns the even orders, alone at prices 10 and 12 and sharing 11 with account 2
+	for i, price := range []float32{10, 11, 12, 11, 11, 13} {
+		bid := NewCustomOrder(price, 10, true, orders.LIMIT, uint32(1+i%2), uint32(i))
+		b.Execute(&bid)
+	}
+	ask := NewCustomOrder(20, 10, false, orders.LIMIT, 1, 6)
+	b.Execute(&ask)
+
+	orders := b.MassCancel(1)
+	if len(orders) != 4 {
+		t.Fatalf("4 orders of account 1 should be cancelled, got %d", len(orders))
+	}
+	for i, id := range []uint32{0, 2, 4, 6} {
+		if cancelled[i] != id {
+			t.Errorf("cancel events should come oldest first, got %v", cancelled)
+			break
+		}
+	}
+	if len(b.AccountOrders(1)) != 0 || len(b.AccountOrders(2)) != 3 {
+		t.Errorf("only the orders of account 1 should leave the book")
+	}
+	if b.ALength() != 0 || b.Bids.Size() != 2 || b.GetVolumeAtBidLimit(10) != 0 ||
+		b.GetVolumeAtBidLimit(12) != 0 || b.GetVolumeAtBidLimit(11) != 20 {
+		t.Errorf("empty levels should be removed, the others kept")
+	}
+}
+
+// a bid that takes exactly the last ask level must not rest with nothing left
+func TestMatchingBidSweepsLastAsk(t *testing.T) {
+	b := NewOrderbook()
+	ask := NewCustomOrder(10, 5, false, orders.LIMIT, 1, 1)
+	b.Execute(&ask)
+	bid := NewCustomOrder(0, 5, true, orders.MARKET, 2, 2)
+	if executed, _ := b.Execute(&bid); executed != 5 {
+		t.Errorf("the bid should fill 5, got %v", executed)
+	}
+	if b.BLength() != 0 || b.ALength() != 0 {
+		t.Errorf("the book should be empty, got %d bids %d asks", b.BLength(), b.ALength())
+	}
+}
+
+// what a market order cannot fill is cancelled, it never rests at its price of 0
+func TestMatchingMarketRemainderCancelled(t *testing.T) {
+	b := NewOrderbook()
+	var events []Event
+	b.Subscribe(func(e Event) { events = append(events, e) })
+
+	ask := NewCustomOrder(0, 5, false, orders.MARKET, 1, 1)
+	if executed, err := b.Execute(&ask); executed != 0 || err != nil {
+		t.Errorf("a market ask in an empty book should fill nothing, got %v %v", executed, err)
+	}
+	if b.ALength() != 0 || len(events) != 1 || events[0].Type != EventCancelled || !events[0].Expired || events[0].Quantity != 5 {
+		t.Fatalf("the market ask should be cancelled without resting, got %+v", events)
+	}
+
+	resting := NewCustomOrder(10, 2, false, orders.LIMIT, 1, 2)
+	b.Execute(&resting)
+	bid := NewCustomOrder(0, 5, true, orders.MARKET, 2, 3)
+	if executed, _ := b.Execute(&bid); executed != 2 {
+		t.Errorf("the market bid should fill the 2 resting, got %v", executed)
+	}
+	last := events[len(events)-1]
+	if b.BLength() != 0 || last.Type != EventCancelled || !last.Expired || last.SequenceId != 3 || last.Quantity != 3 {
+		t.Errorf("the 3 left of the market bid should be cancelled, got %+v", last)
+	}
+
+	// a later limit order does not trade at 0
+	limit := NewCustomOrder(50, 1, true, orders.LIMIT, 2, 4)
+	if executed, _ := b.Execute(&limit); executed != 0 || b.GetBestBid() != 50 {
+		t.Errorf("the limit bid should rest at 50, executed %v", executed)
+	}
+	if v := b.CheckInvariants(); v != nil {
+		t.Errorf("the book should stay sound, got %v", v)
+	}
+}
+
+func TestBookStats(t *testing.T) {
+	// one more order than a level has room for
+	book := NewOrderbook()
+	for i := uint32(1); i <= RINGBUF_INI_SIZE+1; i++ {
+		o := orders.NewOrder(orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 1), i)
+		book.Execute(&o)
+	}
+	for i := uint32(1); i <= RINGBUF_INI_SIZE+1; i++ {
+		book.Cancel(i)
+	}
+	o := orders.NewOrder(orders.NewIncomingOrder(11, 1, true, orders.LIMIT, 1), 100)
+	book.Execute(&o)
+
+	stats := book.Stats()
+	if stats.PoolGets != 2 || stats.Resizes != 1 {
+		t.Errorf("expected 2 pool gets and a resize of the level at 10, got %+v", stats)
+	}
+	// sync.Pool may drop what it holds, the level released may or may not come back
+	if stats.PoolMisses < 1 || stats.PoolMisses > 2 {
+		t.Errorf("expected 1 or 2 pool misses, got %+v", stats)
+	}
+}
+
+func TestExecuteStampsAcceptedAt(t *testing.T) {
+	reads := 0
+	book := NewOrderbookWithConfig(BookConfig{Now: func() int64 { reads++; return int64(reads) * 100 }})
+	var events []Event
+	book.Subscribe(func(e Event) { events = append(events, e) })
+
+	o := orders.NewOrder(orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 1), 1)
+	book.Execute(&o)
+	if reads != 1 || o.AcceptedAt != 100 || events[0].Time != 100 {
+		t.Errorf("the clock should be read once for the order and its events, got %d reads %+v %+v", reads, o, events[0])
+	}
+	if resting, _ := book.Order(1); resting.AcceptedAt != 100 {
+		t.Errorf("the resting order should keep its accept time, got %+v", resting)
+	}
+}
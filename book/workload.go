@@ -0,0 +1,293 @@
+package book
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"matching-engine-go/ds"
+	"matching-engine-go/orders"
+)
+
+type WorkloadOpType uint8
+
+const (
+	WorkloadLimit WorkloadOpType = iota
+	WorkloadMarket
+	WorkloadCancel
+)
+
+func (t WorkloadOpType) String() string {
+	switch t {
+	case WorkloadLimit:
+		return "limit"
+	case WorkloadMarket:
+		return "market"
+	case WorkloadCancel:
+		return "cancel"
+	}
+	return "unknown"
+}
+
+// WorkloadProfile describes synthetic order flow. Arrivals are a Poisson process, limit
+// prices sit a geometric number of ticks away from a mid that random walks, and sizes
+// follow a Pareto power law.
+type WorkloadProfile struct {
+	Name string
+	// mean arrivals per second
+	Rate float64
+	// relative shares of the traffic
+	LimitWeight  float64
+	MarketWeight float64
+	CancelWeight float64
+
+	// starting mid price and tick size
+	Mid  float32
+	Tick float32
+	// mean distance of limit prices from the mid, in ticks
+	MeanDistance float64
+	// fraction of the limit orders priced through the mid, they trade on arrival
+	CrossRatio float64
+	// chance of the mid moving a tick either way on each arrival
+	Volatility float64
+
+	// sizes are MinSize * U^(-1/SizeAlpha) rounded down to whole units, capped at MaxSize.
+	// a smaller alpha gives a fatter tail
+	MinSize   float32
+	MaxSize   float32
+	SizeAlpha float64
+
+	Seed int64
+}
+
+// the built-in profiles of the load test
+func DefaultProfiles() []WorkloadProfile {
+	base := WorkloadProfile{
+		Rate: 100000, Mid: 100, Tick: 0.01, MeanDistance: 5, CrossRatio: 0.05,
+		Volatility: 0.05, MinSize: 1, MaxSize: 1000, SizeAlpha: 1.5, Seed: 1,
+	}
+	balanced := base
+	balanced.Name = "balanced"
+	balanced.LimitWeight, balanced.MarketWeight, balanced.CancelWeight = 0.6, 0.1, 0.3
+
+	cancels := base
+	cancels.Name = "cancel-heavy"
+	cancels.LimitWeight, cancels.MarketWeight, cancels.CancelWeight = 0.45, 0.02, 0.53
+	cancels.MeanDistance = 10
+
+	aggressive := base
+	aggressive.Name = "aggressive"
+	aggressive.LimitWeight, aggressive.MarketWeight, aggressive.CancelWeight = 0.5, 0.3, 0.2
+	aggressive.MeanDistance = 2
+	aggressive.CrossRatio = 0.2
+	aggressive.SizeAlpha = 1.1
+	return []WorkloadProfile{balanced, cancels, aggressive}
+}
+
+// one generated arrival
+type WorkloadOp struct {
+	Type WorkloadOpType
+	// arrival time since the start of the flow
+	At time.Duration
+	// order of a limit or market arrival, it carries the sequenceId to use
+	Order orders.Order
+	// order a cancel is for
+	CancelId uint32
+}
+
+// Workload generates the order flow of a profile, deterministically from its seed.
+type Workload struct {
+	profile WorkloadProfile
+	rng     *rand.Rand
+	mid     float32
+	at      time.Duration
+	next    uint32
+	// limit orders sent, cancels pick from them. some will have traded already
+	live []uint32
+}
+
+func NewWorkload(profile WorkloadProfile) *Workload {
+	if profile.Tick <= 0 {
+		profile.Tick = 1
+	}
+	if profile.MinSize <= 0 {
+		profile.MinSize = 1
+	}
+	if profile.SizeAlpha <= 0 {
+		profile.SizeAlpha = 1.5
+	}
+	return &Workload{profile: profile, rng: rand.New(rand.NewSource(profile.Seed)), mid: profile.Mid}
+}
+
+func (w *Workload) Profile() WorkloadProfile {
+	return w.profile
+}
+
+func (w *Workload) Next() WorkloadOp {
+	p := &w.profile
+	if p.Rate > 0 {
+		w.at += time.Duration(w.rng.ExpFloat64() / p.Rate * float64(time.Second))
+	}
+	if w.rng.Float64() < p.Volatility {
+		if w.rng.Intn(2) == 0 {
+			w.mid += p.Tick
+		} else if w.mid > 2*p.Tick {
+			w.mid -= p.Tick
+		}
+	}
+
+	op := WorkloadOp{At: w.at}
+	total := p.LimitWeight + p.MarketWeight + p.CancelWeight
+	pick := w.rng.Float64() * total
+	switch {
+	case pick < p.CancelWeight && len(w.live) > 0:
+		op.Type = WorkloadCancel
+		i := w.rng.Intn(len(w.live))
+		op.CancelId = w.live[i]
+		w.live[i] = w.live[len(w.live)-1]
+		w.live = w.live[:len(w.live)-1]
+		return op
+	case pick < p.CancelWeight+p.MarketWeight:
+		op.Type = WorkloadMarket
+		w.next++
+		op.Order = orders.NewOrder(orders.NewIncomingOrder(0, w.size(), w.rng.Intn(2) == 0, orders.MARKET, 0), w.next)
+		return op
+	}
+
+	op.Type = WorkloadLimit
+	bidOrAsk := w.rng.Intn(2) == 0
+	ticks := w.distance()
+	if w.rng.Float64() < p.CrossRatio {
+		ticks = -1 - ticks
+	}
+	price := w.mid - float32(ticks)*p.Tick
+	if !bidOrAsk {
+		price = w.mid + float32(ticks)*p.Tick
+	}
+	if price < p.Tick {
+		price = p.Tick
+	}
+	// keep prices on the tick grid, float32 drift would scatter them over distinct levels
+	price = float32(math.Round(float64(price/p.Tick))) * p.Tick
+	w.next++
+	op.Order = orders.NewOrder(orders.NewIncomingOrder(price, w.size(), bidOrAsk, orders.LIMIT, 0), w.next)
+	w.live = append(w.live, w.next)
+	return op
+}
+
+// geometric number of ticks with the profile mean
+func (w *Workload) distance() int {
+	if w.profile.MeanDistance <= 0 {
+		return 0
+	}
+	q := w.profile.MeanDistance / (1 + w.profile.MeanDistance)
+	return int(math.Log(1-w.rng.Float64()) / math.Log(q))
+}
+
+func (w *Workload) size() float32 {
+	p := &w.profile
+	s := float64(p.MinSize) * math.Pow(1-w.rng.Float64(), -1/p.SizeAlpha)
+	s = math.Floor(s)
+	if p.MaxSize > 0 && s > float64(p.MaxSize) {
+		s = float64(p.MaxSize)
+	}
+	if s < float64(p.MinSize) {
+		s = float64(p.MinSize)
+	}
+	return float32(s)
+}
+
+// outcome of a load test
+type LoadResult struct {
+	Profile string
+	Ops     int
+	Limits  int
+	Markets int
+	Cancels int
+	// cancels of orders that had traded away already
+	CancelMisses int
+	Fills        int
+	Volume       float32
+	Elapsed      time.Duration
+	// operations per second of wall time
+	Throughput float64
+	// time spent in Execute, limit and market orders
+	Execute ds.LatencyHistogram
+	// time spent in Cancel
+	Cancel ds.LatencyHistogram
+}
+
+// runs load tests against one book, its fills are counted by a single handler whatever
+// the number of runs
+type LoadRunner struct {
+	book *Orderbook
+	// result of the run in progress
+	result *LoadResult
+}
+
+func NewLoadRunner(book *Orderbook) *LoadRunner {
+	r := &LoadRunner{book: book}
+	book.Subscribe(r.onEvent)
+	return r
+}
+
+func (r *LoadRunner) onEvent(ev Event) {
+	if ev.Type == EventFill && r.result != nil {
+		r.result.Fills++
+		r.result.Volume += ev.Quantity
+	}
+}
+
+// runs n operations of the workload against the book and times each call. Unpaced, the
+// operations go as fast as they can and the latency is the time spent in the book. Paced,
+// each one waits for its arrival time and the latency runs from the arrival, so time spent
+// queueing behind a slow call is counted too
+func (r *LoadRunner) Run(w *Workload, n int, paced bool) *LoadResult {
+	book := r.book
+	result := &LoadResult{Profile: w.Profile().Name}
+	r.result = result
+	defer func() { r.result = nil }()
+
+	ops := make([]WorkloadOp, n)
+	for i := range ops {
+		ops[i] = w.Next()
+	}
+	start := time.Now()
+	for i := range ops {
+		op := &ops[i]
+		t := time.Now()
+		if paced {
+			// the workload may have run before, the first arrival is now
+			arrival := start.Add(op.At - ops[0].At)
+			if wait := time.Until(arrival); wait > time.Millisecond {
+				time.Sleep(wait - time.Millisecond)
+			}
+			for time.Now().Before(arrival) {
+			}
+			t = arrival
+		}
+		switch op.Type {
+		case WorkloadLimit, WorkloadMarket:
+			book.Execute(&op.Order)
+			result.Execute.Record(time.Since(t))
+			if op.Type == WorkloadLimit {
+				result.Limits++
+			} else {
+				result.Markets++
+			}
+		case WorkloadCancel:
+			_, ok := book.Cancel(op.CancelId)
+			result.Cancel.Record(time.Since(t))
+			result.Cancels++
+			if !ok {
+				result.CancelMisses++
+			}
+		}
+	}
+	result.Elapsed = time.Since(start)
+	result.Ops = n
+	if result.Elapsed > 0 {
+		result.Throughput = float64(n) / result.Elapsed.Seconds()
+	}
+	return result
+}
@@ -0,0 +1,101 @@
+package book
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestWorkloadMix(t *testing.T) {
+	profile := DefaultProfiles()[0]
+	w := NewWorkload(profile)
+	counts := make(map[WorkloadOpType]int)
+	var last time.Duration
+	var sizes []float32
+	n := 100000
+	for i := 0; i < n; i++ {
+		op := w.Next()
+		if op.At < last {
+			t.Fatalf("arrivals should not go back in time")
+		}
+		last = op.At
+		counts[op.Type]++
+		if op.Type != WorkloadCancel {
+			sizes = append(sizes, op.Order.Order.Quantity)
+			if q := op.Order.Order.Quantity; q < profile.MinSize || q > profile.MaxSize {
+				t.Fatalf("size %v out of bounds", q)
+			}
+		}
+	}
+	for typ, share := range map[WorkloadOpType]float64{WorkloadLimit: 0.6, WorkloadMarket: 0.1, WorkloadCancel: 0.3} {
+		if got := float64(counts[typ]) / float64(n); math.Abs(got-share) > 0.02 {
+			t.Errorf("%v should be %g of the flow, got %g", typ, share, got)
+		}
+	}
+	// mean gap of the Poisson arrivals
+	if mean := float64(last) / float64(n); math.Abs(mean-1e4) > 500 {
+		t.Errorf("arrivals should average 10us apart at 100k/s, got %gns", mean)
+	}
+	// power law: most orders are small, a few are very large
+	small, large := 0, 0
+	for _, s := range sizes {
+		if s <= 2 {
+			small++
+		}
+		if s >= 100 {
+			large++
+		}
+	}
+	if small < len(sizes)/2 || large == 0 {
+		t.Errorf("sizes should be heavy tailed, %d small and %d large of %d", small, large, len(sizes))
+	}
+
+	again := NewWorkload(profile)
+	first := NewWorkload(profile)
+	for i := 0; i < 1000; i++ {
+		if a, b := first.Next(), again.Next(); a != b {
+			t.Fatalf("the same seed should give the same flow, op %d differs", i)
+		}
+	}
+}
+
+func TestLoadRunner(t *testing.T) {
+	for _, profile := range DefaultProfiles() {
+		book := NewOrderbook()
+		result := NewLoadRunner(&book).Run(NewWorkload(profile), 20000, false)
+		if result.Ops != 20000 || result.Limits+result.Markets+result.Cancels != result.Ops {
+			t.Errorf("%s: the operations should add up, got %+v", profile.Name, result)
+		}
+		if result.Fills == 0 || result.Execute.Count() != uint64(result.Limits+result.Markets) || result.Throughput <= 0 {
+			t.Errorf("%s: the flow should trade and be timed, got %d fills", profile.Name, result.Fills)
+		}
+		if book.BLength() > 0 && book.ALength() > 0 && book.GetBestBid() >= book.GetBestOffer() {
+			t.Errorf("%s: the book should not be crossed", profile.Name)
+		}
+	}
+
+	// a warmup then a measured run, each counts its own fills
+	book := NewOrderbook()
+	fills := 0
+	book.Subscribe(func(ev Event) {
+		if ev.Type == EventFill {
+			fills++
+		}
+	})
+	runner := NewLoadRunner(&book)
+	w := NewWorkload(DefaultProfiles()[0])
+	warmup, measured := runner.Run(w, 5000, false), runner.Run(w, 5000, false)
+	if warmup.Fills+measured.Fills != fills || len(book.handlers) != 2 {
+		t.Errorf("each fill should be counted once, %d and %d of %d with %d handlers",
+			warmup.Fills, measured.Fills, fills, len(book.handlers))
+	}
+
+	// paced at 1M/s the 2000 arrivals take about 2ms
+	profile := DefaultProfiles()[0]
+	profile.Rate = 1e6
+	book = NewOrderbook()
+	result := NewLoadRunner(&book).Run(NewWorkload(profile), 2000, true)
+	if result.Elapsed < time.Millisecond {
+		t.Errorf("a paced run should follow the arrivals, took %v", result.Elapsed)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	lob "matching-engine-go/book"
+)
+
+func loadtest(args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	profileName := flags.String("profile", "all", "workload profile: balanced, cancel-heavy, aggressive or all")
+	n := flags.Int("n", 1000000, "operations per profile")
+	warmup := flags.Int("warmup", 100000, "operations run before measuring, to give the book depth")
+	paced := flags.Bool("paced", false, "follow the Poisson arrival times and measure latency from arrival")
+	rate := flags.Float64("rate", 0, "arrivals per second, overrides the profile")
+	seed := flags.Int64("seed", 1, "random seed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *n <= 0 {
+		return fmt.Errorf("-n must be positive, got %d", *n)
+	}
+
+	profiles := make([]lob.WorkloadProfile, 0)
+	for _, p := range lob.DefaultProfiles() {
+		if *profileName == "all" || *profileName == p.Name {
+			profiles = append(profiles, p)
+		}
+	}
+	if len(profiles) == 0 {
+		return fmt.Errorf("unknown profile %q", *profileName)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "profile\tops/s\tfills\tcancel miss\tp50\tp99\tp99.9\tmax\tcancel p99\t")
+	for _, p := range profiles {
+		p.Seed = *seed
+		if *rate > 0 {
+			p.Rate = *rate
+		}
+		book := lob.NewOrderbook()
+		runner := lob.NewLoadRunner(&book)
+		w := lob.NewWorkload(p)
+		if *warmup > 0 {
+			runner.Run(w, *warmup, false)
+		}
+		r := runner.Run(w, *n, *paced)
+		fmt.Fprintf(tw, "%s\t%.0f\t%d\t%d\t%v\t%v\t%v\t%v\t%v\t\n", p.Name, r.Throughput, r.Fills, r.CancelMisses,
+			r.Execute.Quantile(0.5), r.Execute.Quantile(0.99), r.Execute.Quantile(0.999), r.Execute.Max(),
+			r.Cancel.Quantile(0.99))
+	}
+	return tw.Flush()
+}
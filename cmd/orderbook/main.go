@@ -0,0 +1,361 @@
+// Command orderbook drives a single order book from the command line.
+//
+//	orderbook run [-format jsonl|csv] [-levels n] [-journal file] [file]
+//	orderbook replay [-levels n] journal
+//	orderbook snapshot [-format jsonl|csv] [file]
+//	orderbook tree [-format jsonl|csv] [-side bid|ask] [file]
+//	orderbook repl
+//	orderbook loadtest [-profile name] [-n ops] [-paced]
+//
+// Orders are read from the file, or stdin when there is none, either as JSON Lines in the
+// journal format or as CSV with a header naming the columns op, id, side, type, price,
+// quantity and account. Only side and quantity are required, orders without an id are
+// numbered after the highest one seen.
+//
+// run prints the fills and the final depth and can write the journal of what it did,
+// with the time of each entry.
+// replay runs a journal again, snapshot dumps every resting order as JSON and tree
+// prints the red black tree of one side of the book. repl takes orders typed as
+// "buy 100 @ 10.5" against a live book, or a script of them on stdin. loadtest runs
+// synthetic order flow and reports throughput and latency quantiles of Execute.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	lob "matching-engine-go/book"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "orderbook:", err)
+		os.Exit(1)
+	}
+}
+
+const usage = `usage: orderbook <command> [flags] [file]
+
+commands:
+  run       execute orders, print the fills and the final depth
+  replay    execute a journal again
+  snapshot  execute orders and dump the resting orders as JSON
+  tree      execute orders and print the red black tree of one side
+  repl      enter orders interactively
+  loadtest  run synthetic order flow and report throughput and latency
+`
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return errors.New("no command")
+	}
+	switch args[0] {
+	case "run":
+		return runOrders(args[1:], stdin, stdout, stderr)
+	case "replay":
+		return replay(args[1:], stdout, stderr)
+	case "snapshot":
+		return snapshot(args[1:], stdin, stdout, stderr)
+	case "tree":
+		return tree(args[1:], stdin, stdout, stderr)
+	case "repl":
+		return replCommand(args[1:], stdin, stdout, stderr)
+	case "loadtest":
+		return loadtest(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	}
+	fmt.Fprint(stderr, usage)
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+func runOrders(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "", "input format, jsonl or csv. defaults to the file extension")
+	levels := flags.Int("levels", 0, "depth levels to print, 0 for all")
+	journalPath := flags.String("journal", "", "write the journal of the run to this file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var journal *lob.JournalWriter
+	if *journalPath != "" {
+		f, err := os.Create(*journalPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		journal = lob.NewJournalWriter(f)
+	}
+
+	// stamped with the wall clock, the journal keeps the times for the replay
+	book := lob.NewOrderbookWithConfig(lob.BookConfig{Now: func() int64 { return time.Now().UnixNano() }})
+	printFills(&book, stdout)
+	err := readInput(flags.Arg(0), *format, stdin, executor(&book, journal, stderr))
+	if err != nil {
+		return err
+	}
+	if journal != nil {
+		if err := journal.Flush(); err != nil {
+			return err
+		}
+	}
+	return printDepth(stdout, &book, *levels)
+}
+
+func replay(args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	levels := flags.Int("levels", 0, "depth levels to print, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("replay takes the journal file")
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	book := lob.NewOrderbook()
+	printFills(&book, stdout)
+	// a journal is what the book accepted, any error means it does not belong to this book
+	err = lob.ReadJournal(f, func(e lob.JournalEntry) error { return e.Apply(&book) })
+	if err != nil {
+		return err
+	}
+	return printDepth(stdout, &book, *levels)
+}
+
+func snapshot(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "", "input format, jsonl or csv. defaults to the file extension")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	book := lob.NewOrderbook()
+	if err := readInput(flags.Arg(0), *format, stdin, executor(&book, nil, stderr)); err != nil {
+		return err
+	}
+
+	s := book.Snapshot()
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snapshotJSON{Bids: levelsJSON(s.Bids), Asks: levelsJSON(s.Asks)})
+}
+
+func tree(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("tree", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	format := flags.String("format", "", "input format, jsonl or csv. defaults to the file extension")
+	side := flags.String("side", "bid", "side of the book, bid or ask")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	book := lob.NewOrderbook()
+	if err := readInput(flags.Arg(0), *format, stdin, executor(&book, nil, stderr)); err != nil {
+		return err
+	}
+	switch *side {
+	case "bid":
+		return lob.FprintTree(stdout, book.Bids)
+	case "ask":
+		return lob.FprintTree(stdout, book.Asks)
+	}
+	return fmt.Errorf("side must be bid or ask, got %q", *side)
+}
+
+// applies the entries of an input file to the book. rejected entries are reported on
+// stderr and left out of the journal, the run goes on
+func executor(book *lob.Orderbook, journal *lob.JournalWriter, stderr io.Writer) func(line int, e lob.JournalEntry) error {
+	var last uint32
+	return func(line int, e lob.JournalEntry) error {
+		if e.Op == "" {
+			e.Op = lob.JournalOrder
+		}
+		if e.Op == lob.JournalOrder {
+			if e.Id == 0 {
+				e.Id = last + 1
+			}
+			if e.Id > last {
+				last = e.Id
+			}
+		}
+		if err := e.Apply(book); err != nil {
+			fmt.Fprintf(stderr, "line %d: %s %d rejected: %v\n", line, e.Op, e.Id, err)
+			return nil
+		}
+		if journal != nil {
+			return journal.Append(e.At(book))
+		}
+		return nil
+	}
+}
+
+func printFills(book *lob.Orderbook, w io.Writer) {
+	book.Subscribe(func(ev lob.Event) {
+		if ev.Type != lob.EventFill {
+			return
+		}
+		side := "sell"
+		if ev.BidOrAsk {
+			side = "buy"
+		}
+		fmt.Fprintf(w, "fill %s %g @ %g taker %d maker %d\n", side, ev.Quantity, ev.Price, ev.SequenceId, ev.MakerSequenceId)
+	})
+}
+
+// prints the asks above the bids, like a price ladder
+func printDepth(w io.Writer, book *lob.Orderbook, levels int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "side\tprice\tvolume\torders\t")
+	asks := book.Depth(false, levels)
+	for i := len(asks) - 1; i >= 0; i-- {
+		fmt.Fprintf(tw, "ask\t%g\t%g\t%d\t\n", asks[i].Price, asks[i].Volume, asks[i].Orders)
+	}
+	for _, l := range book.Depth(true, levels) {
+		fmt.Fprintf(tw, "bid\t%g\t%g\t%d\t\n", l.Price, l.Volume, l.Orders)
+	}
+	return tw.Flush()
+}
+
+// reads the entries of path, stdin if it is empty, in the given format
+func readInput(path string, format string, stdin io.Reader, fn func(line int, e lob.JournalEntry) error) error {
+	r := stdin
+	if path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if format == "" {
+		format = "jsonl"
+		if strings.HasSuffix(path, ".csv") {
+			format = "csv"
+		}
+	}
+	switch format {
+	case "jsonl":
+		line := 0
+		return lob.ReadJournal(r, func(e lob.JournalEntry) error {
+			line++
+			return fn(line, e)
+		})
+	case "csv":
+		return readCSV(r, fn)
+	}
+	return fmt.Errorf("format must be jsonl or csv, got %q", format)
+}
+
+func readCSV(r io.Reader, fn func(line int, e lob.JournalEntry) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		e := lob.JournalEntry{Op: field("op"), Side: field("side"), Type: field("type")}
+		if e.Id, err = parseUint(field("id")); err != nil {
+			return fmt.Errorf("csv line %d: id: %w", line, err)
+		}
+		if e.Account, err = parseUint(field("account")); err != nil {
+			return fmt.Errorf("csv line %d: account: %w", line, err)
+		}
+		if e.Price, err = parseFloat(field("price")); err != nil {
+			return fmt.Errorf("csv line %d: price: %w", line, err)
+		}
+		if e.Quantity, err = parseFloat(field("quantity")); err != nil {
+			return fmt.Errorf("csv line %d: quantity: %w", line, err)
+		}
+		if err := fn(line, e); err != nil {
+			return err
+		}
+	}
+}
+
+func parseUint(s string) (uint32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	return uint32(n), err
+}
+
+func parseFloat(s string) (float32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(s, 32)
+	return float32(f), err
+}
+
+type snapshotJSON struct {
+	Bids []levelJSON `json:"bids"`
+	Asks []levelJSON `json:"asks"`
+}
+
+type levelJSON struct {
+	Price  float32     `json:"price"`
+	Volume float32     `json:"volume"`
+	Orders []orderJSON `json:"orders"`
+}
+
+type orderJSON struct {
+	Id        uint32  `json:"id"`
+	Account   uint32  `json:"account"`
+	Quantity  float32 `json:"quantity"`
+	Remaining float32 `json:"remaining"`
+}
+
+func levelsJSON(levels []lob.LevelSnapshot) []levelJSON {
+	out := make([]levelJSON, len(levels))
+	for i, l := range levels {
+		orders := make([]orderJSON, len(l.Orders))
+		for j, o := range l.Orders {
+			orders[j] = orderJSON{
+				Id:        o.SequenceId,
+				Account:   o.Order.AccountId,
+				Quantity:  o.Order.Quantity,
+				Remaining: o.Order.Quantity - o.ExecutedQuantity,
+			}
+		}
+		out[i] = levelJSON{Price: l.Price, Volume: l.Volume, Orders: orders}
+	}
+	return out
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const ordersInput = `{"side":"sell","price":101,"quantity":3,"account":1}
+{"side":"sell","price":100,"quantity":2,"account":1}
+{"side":"buy","price":99,"quantity":5,"account":2}
+{"side":"buy","type":"market","quantity":4,"account":3}
+{"op":"cancel","id":3}
+{"op":"cancel","id":42}
+{"side":"up","quantity":1}
+`
+
+func runCommand(t *testing.T, stdin string, args ...string) (string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	if err := run(args, strings.NewReader(stdin), &stdout, &stderr); err != nil {
+		t.Fatalf("%v: %v\n%s", args, err, stderr.String())
+	}
+	return stdout.String(), stderr.String()
+}
+
+func TestRunAndReplay(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), "journal.jsonl")
+	out, errs := runCommand(t, ordersInput, "run", "-journal", journal)
+
+	for _, want := range []string{"fill buy 2 @ 100 taker 4 maker 2", "fill buy 2 @ 101 taker 4 maker 1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("the output should have %q\n%s", want, out)
+		}
+	}
+	// the bid was cancelled, one ask is left
+	if !strings.Contains(out, "ask    101       1       1") || strings.Contains(out, "bid") {
+		t.Errorf("unexpected depth\n%s", out)
+	}
+	if !strings.Contains(errs, "line 6: cancel 42 rejected") || !strings.Contains(errs, "line 7: order 5 rejected") {
+		t.Errorf("the bad lines should be reported\n%s", errs)
+	}
+
+	b, err := os.ReadFile(journal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(b), "\n"); lines != 5 {
+		t.Errorf("the journal should hold the 5 accepted entries, got %d\n%s", lines, b)
+	}
+	replayed, _ := runCommand(t, "", "replay", journal)
+	if replayed != out {
+		t.Errorf("the replay should print the same fills and depth\nwant %s\ngot  %s", out, replayed)
+	}
+}
+
+func TestRunRejects(t *testing.T) {
+	input := `{"id":1,"side":"sell","price":101,"quantity":3}
+{"id":1,"side":"sell","price":102,"quantity":3}
+{"side":"buy","price":99,"quantity":0}
+{"side":"buy","price":99,"quantity":-1}
+`
+	out, errs := runCommand(t, input, "run")
+	for _, want := range []string{"line 2: order 1 rejected", "line 3: order 2 rejected", "line 4: order 3 rejected"} {
+		if !strings.Contains(errs, want) {
+			t.Errorf("%q should be reported\n%s", want, errs)
+		}
+	}
+	if !strings.Contains(out, "ask    101       3       1") || strings.Contains(out, "102") || strings.Contains(out, "bid") {
+		t.Errorf("only the first ask should rest\n%s", out)
+	}
+}
+
+func TestCSVSnapshotAndTree(t *testing.T) {
+	input := "side,price,quantity,account\nbuy,10,1,1\nbuy,11,2,2\nbuy,11,3,3\nbuy,12,1,1\nsell,13,1,1\n"
+	path := filepath.Join(t.TempDir(), "orders.csv")
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, _ := runCommand(t, "", "snapshot", path)
+	var s snapshotJSON
+	if err := json.Unmarshal([]byte(out), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Bids) != 3 || len(s.Asks) != 1 || s.Bids[1].Price != 11 || len(s.Bids[1].Orders) != 2 ||
+		s.Bids[1].Orders[0].Id != 2 || s.Bids[1].Orders[1].Account != 3 {
+		t.Errorf("unexpected snapshot %+v", s)
+	}
+
+	out, _ = runCommand(t, input, "tree", "-format", "csv")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || strings.TrimLeft(lines[0], " *") != "12.00000000" || lines[1] != "11.00000000" {
+		t.Errorf("the tree should list the bid levels from the highest\n%s", out)
+	}
+}
+
+func TestReplScript(t *testing.T) {
+	dir := t.TempDir()
+	setup := filepath.Join(dir, "setup.txt")
+	if err := os.WriteFile(setup, []byte("# two asks\nsell 3 @ 101\nsell 2@100 acct 7\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	script := "load " + setup + "\nbuy 5 @ 99\nbuy 4\ncancel 1\ncancel 1\nbook\nquit\nbuy 1 @ 1\n"
+	out, _ := runCommand(t, script, "repl")
+
+	for _, want := range []string{
+		"order 2: 0 filled, 2 resting",
+		"fill buy 2 @ 100 taker 4 maker 2",
+		"order 4: 4 filled",
+		"cancelled 1, 1 left unfilled",
+		"error: line 5: orderbook: order is not resting in the book",
+		"top: 5 x 99 | -",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("the output should have %q\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "order 5") {
+		t.Errorf("nothing should run after quit\n%s", out)
+	}
+}
+
+func TestReplBadNumbers(t *testing.T) {
+	r := newRepl(&bytes.Buffer{})
+	for _, line := range []string{"buy 0", "buy NaN", "buy Inf", "sell 1 @ NaN", "sell 1 @ -1", "sell 1 @ +Inf"} {
+		if err := r.exec(line); err == nil {
+			t.Errorf("%q should be refused", line)
+		}
+	}
+	if r.book.BLength() != 0 || r.book.ALength() != 0 {
+		t.Errorf("nothing should reach the book")
+	}
+}
+
+func TestReplComplete(t *testing.T) {
+	r := newRepl(&bytes.Buffer{})
+	for _, line := range []string{"sell 1 @ 10", "sell 1 @ 11", "buy 1 @ 9"} {
+		if err := r.exec(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		line string
+		want string
+		ok   bool
+	}{
+		{"can", "cancel ", true},
+		{"b", "b", false},
+		{"bo", "book ", true},
+		{"cancel ", "", false},
+		{"cancel 2", "cancel 2", false},
+		{"cancel 4", "", false},
+	} {
+		line, _, ok := r.complete(c.line, len(c.line))
+		if ok != c.ok || (ok && line != c.want) {
+			t.Errorf("completing %q should give %q %v, got %q %v", c.line, c.want, c.ok, line, ok)
+		}
+	}
+}
+
+func TestLoadtest(t *testing.T) {
+	out, _ := runCommand(t, "", "loadtest", "-n", "5000", "-warmup", "1000")
+	for _, profile := range []string{"balanced", "cancel-heavy", "aggressive"} {
+		if !strings.Contains(out, profile) {
+			t.Errorf("the report should have a line for %s\n%s", profile, out)
+		}
+	}
+	for _, args := range [][]string{{"-profile", "nope"}, {"-n", "-1"}, {"-n", "0"}} {
+		if err := run(append([]string{"loadtest"}, args...), nil, &bytes.Buffer{}, &bytes.Buffer{}); err == nil {
+			t.Errorf("loadtest %v should fail", args)
+		}
+	}
+}
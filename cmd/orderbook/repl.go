@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+
+	lob "matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+const replHelp = `commands:
+  buy QTY [@ PRICE] [acct N]   limit order, a market order without a price
+  sell QTY [@ PRICE] [acct N]
+  cancel ID                    cancel a resting order
+  book [LEVELS]                depth ladder of both sides
+  top                          best bid and offer
+  orders                       resting orders
+  load FILE                    run the commands of a file
+  help
+  quit
+`
+
+var replCommands = []string{"buy", "sell", "cancel", "book", "top", "orders", "load", "help", "quit"}
+
+// repl drives one in-memory book from typed commands
+type repl struct {
+	book *lob.Orderbook
+	out  io.Writer
+	last uint32
+	// files being loaded, to refuse a file that loads itself
+	loading map[string]bool
+}
+
+func newRepl(out io.Writer) *repl {
+	book := lob.NewOrderbook()
+	r := &repl{book: &book, out: out, loading: make(map[string]bool)}
+	printFills(r.book, out)
+	return r
+}
+
+var errQuit = errors.New("quit")
+
+func replCommand(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("repl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if f, ok := stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		return replTerminal(f, stdout)
+	}
+
+	// piped input runs like a script
+	r := newRepl(stdout)
+	err := r.script(stdin, "")
+	if err == errQuit {
+		return nil
+	}
+	return err
+}
+
+// line editing with history and tab completion in raw mode
+func replTerminal(f *os.File, stdout io.Writer) error {
+	state, err := term.MakeRaw(int(f.Fd()))
+	if err != nil {
+		return err
+	}
+	defer term.Restore(int(f.Fd()), state)
+
+	screen := struct {
+		io.Reader
+		io.Writer
+	}{f, stdout}
+	t := term.NewTerminal(screen, "> ")
+	r := newRepl(t)
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return r.complete(line, pos)
+	}
+	fmt.Fprint(t, "type help for the commands\n")
+	for {
+		line, err := t.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := r.exec(line); err == errQuit {
+			return nil
+		} else if err != nil {
+			fmt.Fprintln(t, "error:", err)
+		}
+	}
+}
+
+// runs the commands of in one per line. a loaded file stops at its first error, the
+// script given on stdin reports errors and goes on like a terminal would
+func (r *repl) script(in io.Reader, name string) error {
+	scanner := bufio.NewScanner(in)
+	for line := 1; scanner.Scan(); line++ {
+		err := r.exec(scanner.Text())
+		switch {
+		case err == nil:
+		case err == errQuit:
+			return err
+		case name == "":
+			fmt.Fprintf(r.out, "error: line %d: %v\n", line, err)
+		default:
+			return fmt.Errorf("%s line %d: %w", name, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// runs one command, blank lines and # comments are skipped
+func (r *repl) exec(line string) error {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	switch fields[0] {
+	case "buy", "sell":
+		return r.order(fields[0] == "buy", fields[1:])
+	case "cancel":
+		if len(fields) != 2 {
+			return errors.New("usage: cancel ID")
+		}
+		id, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("bad order id %q", fields[1])
+		}
+		o, ok := r.book.Cancel(uint32(id))
+		if !ok {
+			return lob.ErrUnknownOrder
+		}
+		fmt.Fprintf(r.out, "cancelled %d, %g left unfilled\n", id, o.Order.Quantity-o.ExecutedQuantity)
+		r.top()
+	case "book":
+		levels := 10
+		if len(fields) > 1 {
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 0 {
+				return fmt.Errorf("bad level count %q", fields[1])
+			}
+			levels = n
+		}
+		return printLadder(r.out, r.book, levels)
+	case "top":
+		r.top()
+	case "orders":
+		return r.orders()
+	case "load":
+		if len(fields) != 2 {
+			return errors.New("usage: load FILE")
+		}
+		return r.load(fields[1])
+	case "help":
+		fmt.Fprint(r.out, replHelp)
+	case "quit", "exit":
+		return errQuit
+	default:
+		return fmt.Errorf("unknown command %q, type help", fields[0])
+	}
+	return nil
+}
+
+// QTY [@ PRICE] [acct N], the @ may touch the numbers around it
+func (r *repl) order(bidOrAsk bool, args []string) error {
+	args = strings.Fields(strings.ReplaceAll(strings.Join(args, " "), "@", " @ "))
+	if len(args) == 0 {
+		return errors.New("usage: buy|sell QTY [@ PRICE] [acct N]")
+	}
+	quantity, err := strconv.ParseFloat(args[0], 32)
+	if err != nil || !(quantity > 0) || math.IsInf(quantity, 0) {
+		return fmt.Errorf("bad quantity %q", args[0])
+	}
+	incoming := orders.NewIncomingOrder(0, float32(quantity), bidOrAsk, orders.MARKET, 0)
+	args = args[1:]
+	if len(args) >= 2 && args[0] == "@" {
+		price, err := strconv.ParseFloat(args[1], 32)
+		if err != nil || !(price > 0) || math.IsInf(price, 0) {
+			return fmt.Errorf("bad price %q", args[1])
+		}
+		incoming.Price = float32(price)
+		incoming.OrderType = orders.LIMIT
+		args = args[2:]
+	}
+	if len(args) == 2 && args[0] == "acct" {
+		account, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("bad account %q", args[1])
+		}
+		incoming.AccountId = uint32(account)
+		args = args[2:]
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unexpected %q", strings.Join(args, " "))
+	}
+
+	r.last++
+	o := orders.NewOrder(incoming, r.last)
+	executed, err := r.book.Execute(&o)
+	if err != nil {
+		return err
+	}
+	if _, resting := r.book.Order(o.SequenceId); resting {
+		fmt.Fprintf(r.out, "order %d: %g filled, %g resting\n", o.SequenceId, executed, incoming.Quantity-executed)
+	} else {
+		fmt.Fprintf(r.out, "order %d: %g filled\n", o.SequenceId, executed)
+	}
+	r.top()
+	return nil
+}
+
+func (r *repl) top() {
+	bid, ask := "-", "-"
+	if b := r.book.Depth(true, 1); len(b) > 0 {
+		bid = fmt.Sprintf("%g x %g", b[0].Volume, b[0].Price)
+	}
+	if a := r.book.Depth(false, 1); len(a) > 0 {
+		ask = fmt.Sprintf("%g x %g", a[0].Price, a[0].Volume)
+	}
+	fmt.Fprintf(r.out, "top: %s | %s\n", bid, ask)
+}
+
+func (r *repl) orders() error {
+	s := r.book.Snapshot()
+	tw := tabwriter.NewWriter(r.out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "id\tside\tprice\tremaining\tacct\t")
+	for _, side := range []struct {
+		name   string
+		levels []lob.LevelSnapshot
+	}{{"sell", s.Asks}, {"buy", s.Bids}} {
+		for _, l := range side.levels {
+			for _, o := range l.Orders {
+				fmt.Fprintf(tw, "%d\t%s\t%g\t%g\t%d\t\n", o.SequenceId, side.name, l.Price, o.Order.Quantity-o.ExecutedQuantity, o.Order.AccountId)
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+func (r *repl) load(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if r.loading[abs] {
+		return fmt.Errorf("%s is already being loaded", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r.loading[abs] = true
+	defer delete(r.loading, abs)
+	return r.script(f, path)
+}
+
+// completes the word before the cursor: commands first, then resting order ids for
+// cancel and file names for load
+func (r *repl) complete(line string, pos int) (string, int, bool) {
+	head, tail := line[:pos], line[pos:]
+	start := strings.LastIndexAny(head, " \t") + 1
+	word := head[start:]
+	fields := strings.Fields(head[:start])
+
+	var candidates []string
+	switch {
+	case len(fields) == 0:
+		candidates = replCommands
+	case len(fields) == 1 && fields[0] == "cancel":
+		for _, id := range r.restingIds() {
+			candidates = append(candidates, strconv.FormatUint(uint64(id), 10))
+		}
+	case len(fields) == 1 && fields[0] == "load":
+		matches, _ := filepath.Glob(word + "*")
+		candidates = matches
+	}
+
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	completed := commonPrefix(matches)
+	if len(matches) == 1 && len(fields) == 0 {
+		completed += " "
+	}
+	if completed == word {
+		return "", 0, false
+	}
+	return head[:start] + completed + tail, start + len(completed), true
+}
+
+func (r *repl) restingIds() []uint32 {
+	ids := make([]uint32, 0)
+	s := r.book.Snapshot()
+	for _, levels := range [][]lob.LevelSnapshot{s.Bids, s.Asks} {
+		for _, l := range levels {
+			for _, o := range l.Orders {
+				ids = append(ids, o.SequenceId)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// both sides around the spread, bid volumes left of the price and ask volumes right of it
+func printLadder(w io.Writer, book *lob.Orderbook, levels int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "bids\tprice\tasks\t")
+	asks := book.Depth(false, levels)
+	for i := len(asks) - 1; i >= 0; i-- {
+		fmt.Fprintf(tw, "\t%g\t%g (%d)\t\n", asks[i].Price, asks[i].Volume, asks[i].Orders)
+	}
+	bids := book.Depth(true, levels)
+	if len(asks) > 0 && len(bids) > 0 {
+		fmt.Fprintf(tw, "spread\t%g\t\t\n", asks[0].Price-bids[0].Price)
+	}
+	for _, l := range bids {
+		fmt.Fprintf(tw, "%g (%d)\t%g\t\t\n", l.Volume, l.Orders, l.Price)
+	}
+	return tw.Flush()
+}
@@ -1,4 +1,6 @@
-package main
+// Package ds holds the generic data structures under the book: the ring buffer deques
+// that queue the orders of a price level and the latency histogram.
+package ds
 
 // modified https://github.com/LdDl/deque/blob/v0.3.0/deque.go by removing shrinking
 // minCapacity is the smallest capacity that deque may have.
@@ -0,0 +1,24 @@
+package ds_test
+
+import (
+	"fmt"
+
+	"matching-engine-go/ds"
+)
+
+func ExampleTombstoneDeque() {
+	q := ds.NewTombstoneDeque[string](4)
+	q.PushBack("a")
+	h := q.PushBack("b")
+	q.PushBack("c")
+
+	// removes b from the middle without moving the others
+	q.Cancel(h)
+	q.Each(func(_ ds.Handle, s string) bool {
+		fmt.Println(s)
+		return true
+	})
+	// Output:
+	// a
+	// c
+}
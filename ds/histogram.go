@@ -0,0 +1,131 @@
+package ds
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// sub-buckets per power of two, the quantiles are within 1/histogramSubBuckets of the true value
+const (
+	histogramSubBits    = 4
+	histogramSubBuckets = 1 << histogramSubBits
+	histogramBuckets    = (64 - histogramSubBits + 1) * histogramSubBuckets
+)
+
+// LatencyHistogram counts durations in log-linear buckets: every power of two is split into
+// 16 equal buckets, so any nanosecond value is kept within 6.25% in a fixed array. Record
+// does not allocate, the zero value is ready to use. It is not safe for concurrent use.
+type LatencyHistogram struct {
+	counts [histogramBuckets]uint64
+	total  uint64
+	sum    uint64
+	max    uint64
+}
+
+func histogramIndex(v uint64) int {
+	if v < histogramSubBuckets {
+		return int(v)
+	}
+	// the top histogramSubBits+1 bits of v pick the bucket
+	shift := bits.Len64(v) - histogramSubBits - 1
+	return (shift+1)*histogramSubBuckets + int(v>>uint(shift)) - histogramSubBuckets
+}
+
+// highest value that falls in bucket i
+func histogramUpper(i int) uint64 {
+	if i < histogramSubBuckets {
+		return uint64(i)
+	}
+	shift := i/histogramSubBuckets - 1
+	mantissa := uint64(i%histogramSubBuckets + histogramSubBuckets)
+	return (mantissa+1)<<uint(shift) - 1
+}
+
+func (h *LatencyHistogram) Record(d time.Duration) {
+	v := uint64(0)
+	if d > 0 {
+		v = uint64(d)
+	}
+	h.counts[histogramIndex(v)]++
+	h.total++
+	h.sum += v
+	if v > h.max {
+		h.max = v
+	}
+}
+
+func (h *LatencyHistogram) Count() uint64 {
+	return h.total
+}
+
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / h.total)
+}
+
+func (h *LatencyHistogram) Max() time.Duration {
+	return time.Duration(h.max)
+}
+
+// the value under which a fraction q of the recorded durations fall, the upper bound of its
+// bucket capped by the max. 0 when empty
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			if upper := histogramUpper(i); upper < h.max {
+				return time.Duration(upper)
+			}
+			return time.Duration(h.max)
+		}
+	}
+	return time.Duration(h.max)
+}
+
+// sum of the recorded durations
+func (h *LatencyHistogram) Sum() time.Duration {
+	return time.Duration(h.sum)
+}
+
+// number of recorded durations at or below d. a bucket is counted once all of it is, so the
+// count is exact at bucket bounds and may fall short by the bucket straddling d otherwise
+func (h *LatencyHistogram) CountAtMost(d time.Duration) uint64 {
+	if d < 0 {
+		return 0
+	}
+	var count uint64
+	for i, c := range h.counts {
+		if histogramUpper(i) > uint64(d) {
+			break
+		}
+		count += c
+	}
+	return count
+}
+
+// adds the counts of other to h
+func (h *LatencyHistogram) Merge(other *LatencyHistogram) {
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.total += other.total
+	h.sum += other.sum
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+func (h *LatencyHistogram) Reset() {
+	*h = LatencyHistogram{}
+}
@@ -0,0 +1,36 @@
+package ds
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	var h LatencyHistogram
+	if h.Quantile(0.5) != 0 {
+		t.Errorf("an empty histogram should report 0")
+	}
+	for i := 1; i <= 100000; i++ {
+		h.Record(time.Duration(i))
+	}
+	for _, q := range []float64{0.5, 0.99, 0.999} {
+		want := q * 100000
+		got := float64(h.Quantile(q))
+		if got < want || got > want*(1+1.0/histogramSubBuckets) {
+			t.Errorf("p%g should be within a bucket of %g, got %g", q*100, want, got)
+		}
+	}
+	if h.Quantile(1) != 100000 || h.Max() != 100000 || h.Mean() != 50000 || h.Count() != 100000 {
+		t.Errorf("unexpected max %v mean %v count %d", h.Max(), h.Mean(), h.Count())
+	}
+
+	var other LatencyHistogram
+	other.Record(time.Second)
+	h.Merge(&other)
+	if h.Count() != 100001 || h.Max() != time.Second {
+		t.Errorf("merging should add the counts")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { h.Record(time.Microsecond) }); allocs != 0 {
+		t.Errorf("recording should not allocate, got %v", allocs)
+	}
+}
@@ -0,0 +1,374 @@
+package ds
+
+import (
+	"unsafe"
+)
+
+// smallest ring buffer of a TombstoneDeque, lower than Deque's so sparse price levels stay cheap
+const tombstoneMinCapacity = 1 << 2
+
+// TombstoneDeque is a FIFO ring buffer with O(1) cancellation of any element.
+// PushBack hands out a Handle recording the slot of the element, Cancel leaves a tombstone
+// in that slot instead of shifting the buffer like Deque.Remove does.
+// Tombstones at either end are trimmed eagerly so Front is always a live element,
+// the ones in the middle are dropped when the buffer is compacted on resize.
+type TombstoneDeque[T any] struct {
+	buf   []tombstoneSlot[T]
+	head  int
+	tail  int
+	count int // occupied slots, tombstones included
+	live  int
+
+	// handle id -> position in buf, handles are recycled through the free list.
+	// there are never more handles out than slots, so both are sized along with buf
+	refs   []handleRef
+	free   []int32
+	minCap int
+	// generation given to new handle ids, above every generation handed out before a Shrink
+	epoch uint32
+}
+
+type tombstoneSlot[T any] struct {
+	value  T
+	handle Handle
+	alive  bool
+}
+
+type handleRef struct {
+	pos int
+	gen uint32
+}
+
+// Handle identifies an element of a TombstoneDeque until it is popped or cancelled.
+// A stale handle is detected by its generation and ignored.
+type Handle struct {
+	id  int32
+	gen uint32
+}
+
+// NewTombstoneDeque returns a queue able to hold size[0] elements before resizing,
+// that will not shrink below size[1] elements. Both are rounded up to the nearest power of 2 like New.
+func NewTombstoneDeque[T any](size ...int) *TombstoneDeque[T] {
+	var capacity, minimum int
+	if len(size) >= 1 {
+		capacity = size[0]
+		if len(size) >= 2 {
+			minimum = size[1]
+		}
+	}
+
+	minCap := tombstoneMinCapacity
+	for minCap < minimum {
+		minCap <<= 1
+	}
+
+	q := &TombstoneDeque[T]{minCap: minCap}
+	if capacity != 0 {
+		q.allocate(roundCapacity(capacity, minCap))
+	}
+	return q
+}
+
+// smallest power of 2 multiple of minCap holding size elements
+func roundCapacity(size int, minCap int) int {
+	bufSize := minCap
+	for bufSize < size {
+		bufSize <<= 1
+	}
+	return bufSize
+}
+
+// Footprint returns the bytes held by the ring buffer and the handle table.
+func (q *TombstoneDeque[T]) Footprint() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.buf) * q.slotBytes()
+}
+
+// GrowthCost returns the bytes the next PushBack would allocate, 0 if it fits in place.
+func (q *TombstoneDeque[T]) GrowthCost() int {
+	if q.count != len(q.buf) {
+		return 0
+	}
+	return (q.growSize() - len(q.buf)) * q.slotBytes()
+}
+
+// Shrink reallocates an empty queue down to size elements, rounded up to a power of 2
+// and never below the minimum capacity. It is a no-op if the queue holds elements.
+func (q *TombstoneDeque[T]) Shrink(size int) {
+	if q.count != 0 {
+		return
+	}
+	size = roundCapacity(size, q.minCap)
+	if size >= len(q.buf) {
+		return
+	}
+	// every handle is released, fresh ids start above the generations handed out so far
+	q.refs = nil
+	q.free = nil
+	q.allocate(size)
+	q.head = 0
+	q.tail = 0
+}
+
+// bytes of one slot: the element, its handle table entry and its free list entry
+func (q *TombstoneDeque[T]) slotBytes() int {
+	return int(unsafe.Sizeof(tombstoneSlot[T]{})) + int(unsafe.Sizeof(handleRef{})) + int(unsafe.Sizeof(int32(0)))
+}
+
+// allocate sets up a ring buffer of size slots, growing the handle table and the free list with it
+func (q *TombstoneDeque[T]) allocate(size int) {
+	q.buf = make([]tombstoneSlot[T], size)
+	refs := make([]handleRef, len(q.refs), size)
+	copy(refs, q.refs)
+	q.refs = refs
+	free := make([]int32, len(q.free), size)
+	copy(free, q.free)
+	q.free = free
+}
+
+// Len returns the number of live elements.
+func (q *TombstoneDeque[T]) Len() int {
+	if q == nil {
+		return 0
+	}
+	return q.live
+}
+
+// Cap returns the number of slots of the ring buffer.
+func (q *TombstoneDeque[T]) Cap() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.buf)
+}
+
+// Tombstones returns the number of cancelled elements still occupying a slot.
+func (q *TombstoneDeque[T]) Tombstones() int {
+	if q == nil {
+		return 0
+	}
+	return q.count - q.live
+}
+
+// PushBack appends an element and returns its handle.
+func (q *TombstoneDeque[T]) PushBack(elem T) Handle {
+	q.growIfFull()
+
+	h := q.newHandle(q.tail)
+	q.buf[q.tail] = tombstoneSlot[T]{elem, h, true}
+	q.tail = q.next(q.tail)
+	q.count++
+	q.live++
+	return h
+}
+
+// Front returns the oldest live element, panics if the queue is empty.
+func (q *TombstoneDeque[T]) Front() T {
+	if q.live <= 0 {
+		panic("tombstoneDeque: Front() called when empty")
+	}
+	return q.buf[q.head].value
+}
+
+// FrontHandle returns the handle of the oldest live element, panics if the queue is empty.
+func (q *TombstoneDeque[T]) FrontHandle() Handle {
+	if q.live <= 0 {
+		panic("tombstoneDeque: FrontHandle() called when empty")
+	}
+	return q.buf[q.head].handle
+}
+
+// SetFront overwrites the oldest live element in place, panics if the queue is empty.
+func (q *TombstoneDeque[T]) SetFront(elem T) {
+	if q.live <= 0 {
+		panic("tombstoneDeque: SetFront() called when empty")
+	}
+	q.buf[q.head].value = elem
+}
+
+// PopFront removes and returns the oldest live element, panics if the queue is empty.
+func (q *TombstoneDeque[T]) PopFront() T {
+	if q.live <= 0 {
+		panic("tombstoneDeque: PopFront() called on empty queue")
+	}
+	ret := q.buf[q.head].value
+	q.kill(q.head)
+	q.trim()
+	return ret
+}
+
+// Get returns the element of a handle, false if it has left the queue.
+func (q *TombstoneDeque[T]) Get(h Handle) (T, bool) {
+	pos, ok := q.lookup(h)
+	if !ok {
+		return Zero[T](), false
+	}
+	return q.buf[pos].value, true
+}
+
+// Set overwrites the element of a handle, false if it has left the queue.
+func (q *TombstoneDeque[T]) Set(h Handle, elem T) bool {
+	pos, ok := q.lookup(h)
+	if !ok {
+		return false
+	}
+	q.buf[pos].value = elem
+	return true
+}
+
+// Cancel removes the element of a handle in O(1), leaving a tombstone in its slot.
+// It returns the removed element, false if the handle is stale.
+func (q *TombstoneDeque[T]) Cancel(h Handle) (T, bool) {
+	pos, ok := q.lookup(h)
+	if !ok {
+		return Zero[T](), false
+	}
+	ret := q.buf[pos].value
+	q.kill(pos)
+	q.trim()
+	return ret, true
+}
+
+// Each visits the live elements from front to back, stops when fn returns false.
+func (q *TombstoneDeque[T]) Each(fn func(h Handle, elem T) bool) {
+	for i, pos := 0, q.head; i < q.count; i, pos = i+1, q.next(pos) {
+		s := &q.buf[pos]
+		if s.alive && !fn(s.handle, s.value) {
+			return
+		}
+	}
+}
+
+// Clear removes all elements but retains the current capacity.
+func (q *TombstoneDeque[T]) Clear() {
+	for i, pos := 0, q.head; i < q.count; i, pos = i+1, q.next(pos) {
+		if q.buf[pos].alive {
+			q.release(q.buf[pos].handle)
+		}
+		q.buf[pos] = tombstoneSlot[T]{}
+	}
+	q.head = 0
+	q.tail = 0
+	q.count = 0
+	q.live = 0
+}
+
+// lookup returns the buffer position of a live handle
+func (q *TombstoneDeque[T]) lookup(h Handle) (int, bool) {
+	if q == nil || h.id < 0 || int(h.id) >= len(q.refs) {
+		return 0, false
+	}
+	ref := q.refs[h.id]
+	if ref.gen != h.gen || ref.pos < 0 {
+		return 0, false
+	}
+	return ref.pos, true
+}
+
+// kill turns a live slot into a tombstone
+func (q *TombstoneDeque[T]) kill(pos int) {
+	s := &q.buf[pos]
+	q.release(s.handle)
+	s.value = Zero[T]()
+	s.alive = false
+	q.live--
+}
+
+// trim drops the tombstones at both ends of the buffer, each tombstone is trimmed once
+func (q *TombstoneDeque[T]) trim() {
+	for q.count > 0 && !q.buf[q.head].alive {
+		q.buf[q.head] = tombstoneSlot[T]{}
+		q.head = q.next(q.head)
+		q.count--
+	}
+	for q.count > 0 && !q.buf[q.prev(q.tail)].alive {
+		q.tail = q.prev(q.tail)
+		q.buf[q.tail] = tombstoneSlot[T]{}
+		q.count--
+	}
+}
+
+func (q *TombstoneDeque[T]) newHandle(pos int) Handle {
+	var id int32
+	if n := len(q.free); n > 0 {
+		id = q.free[n-1]
+		q.free = q.free[:n-1]
+	} else {
+		id = int32(len(q.refs))
+		q.refs = append(q.refs, handleRef{gen: q.epoch})
+	}
+	q.refs[id].pos = pos
+	return Handle{id, q.refs[id].gen}
+}
+
+func (q *TombstoneDeque[T]) release(h Handle) {
+	// bumping the generation invalidates every copy of the handle
+	q.refs[h.id] = handleRef{pos: -1, gen: h.gen + 1}
+	q.free = append(q.free, h.id)
+	if h.gen >= q.epoch {
+		q.epoch = h.gen + 1
+	}
+}
+
+// prev returns the previous buffer position wrapping around buffer.
+func (q *TombstoneDeque[T]) prev(i int) int {
+	return (i - 1) & (len(q.buf) - 1) // bitwise modulus
+}
+
+// next returns the next buffer position wrapping around buffer.
+func (q *TombstoneDeque[T]) next(i int) int {
+	return (i + 1) & (len(q.buf) - 1) // bitwise modulus
+}
+
+// growIfFull makes room for one more element, compacting the tombstones away
+// and doubling the buffer only if it is still more than half full after that.
+func (q *TombstoneDeque[T]) growIfFull() {
+	if q.count != len(q.buf) {
+		return
+	}
+	if len(q.buf) == 0 {
+		q.allocate(q.minCap)
+		return
+	}
+	q.resize(q.growSize())
+}
+
+// size of the buffer after the next growth
+func (q *TombstoneDeque[T]) growSize() int {
+	if len(q.buf) == 0 {
+		return q.minCap
+	}
+	if q.live<<1 >= len(q.buf) {
+		return len(q.buf) << 1
+	}
+	return len(q.buf)
+}
+
+// resize copies the live elements to a new buffer of the given size, updating their handles.
+func (q *TombstoneDeque[T]) resize(size int) {
+	oldBuf := q.buf
+	if size == len(oldBuf) {
+		// compaction only, the handle table is already big enough
+		q.buf = make([]tombstoneSlot[T], size)
+	} else {
+		q.allocate(size)
+	}
+	newBuf := q.buf
+	n := 0
+	mask := len(oldBuf) - 1
+	for i, pos := 0, q.head; i < q.count; i, pos = i+1, (pos+1)&mask {
+		s := oldBuf[pos]
+		if !s.alive {
+			continue
+		}
+		newBuf[n] = s
+		q.refs[s.handle.id].pos = n
+		n++
+	}
+
+	q.head = 0
+	q.tail = n & (size - 1)
+	q.count = n
+}
@@ -0,0 +1,319 @@
+package ds
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestTombstoneEmpty(t *testing.T) {
+	q := NewTombstoneDeque[int]()
+	if q.Len() != 0 || q.Cap() != 0 || q.Tombstones() != 0 {
+		t.Error("new queue should be empty without allocating")
+	}
+	var n *TombstoneDeque[int]
+	if n.Len() != 0 || n.Cap() != 0 {
+		t.Error("nil queue should be empty")
+	}
+	if _, ok := n.Get(Handle{}); ok {
+		t.Error("nil queue should not resolve handles")
+	}
+}
+
+func TestTombstoneFIFO(t *testing.T) {
+	q := NewTombstoneDeque[int]()
+	for i := 0; i < 1000; i++ {
+		q.PushBack(i)
+	}
+	if q.Len() != 1000 {
+		t.Error("q.Len() =", q.Len(), "expect 1000")
+	}
+	for i := 0; i < 1000; i++ {
+		if q.Front() != i {
+			t.Fatal("q.Front() =", q.Front(), "expect", i)
+		}
+		if q.PopFront() != i {
+			t.Fatal("wrong value popped at", i)
+		}
+	}
+	if q.Len() != 0 {
+		t.Error("queue should be empty")
+	}
+}
+
+func TestTombstoneCancel(t *testing.T) {
+	q := NewTombstoneDeque[int]()
+	handles := make([]Handle, 10)
+	for i := range handles {
+		handles[i] = q.PushBack(i)
+	}
+
+	// cancelling in the middle leaves a tombstone
+	if v, ok := q.Cancel(handles[5]); !ok || v != 5 {
+		t.Error("cancel should return the removed element")
+	}
+	if q.Len() != 9 || q.Tombstones() != 1 {
+		t.Error("expect 9 live elements and 1 tombstone, got", q.Len(), q.Tombstones())
+	}
+	// a handle can only be cancelled once
+	if _, ok := q.Cancel(handles[5]); ok {
+		t.Error("stale handle should not cancel")
+	}
+
+	// cancelling the front trims the tombstones behind it
+	for i := 0; i < 5; i++ {
+		q.Cancel(handles[i])
+	}
+	if q.Front() != 6 || q.Tombstones() != 0 {
+		t.Error("front should skip the tombstones, got", q.Front(), q.Tombstones())
+	}
+
+	// cancelling the back
+	q.Cancel(handles[9])
+	q.Cancel(handles[8])
+	if q.Len() != 2 {
+		t.Error("q.Len() =", q.Len(), "expect 2")
+	}
+	if q.PopFront() != 6 || q.PopFront() != 7 {
+		t.Error("popping should skip cancelled elements")
+	}
+	if q.Len() != 0 || q.Tombstones() != 0 {
+		t.Error("queue should be empty")
+	}
+}
+
+func TestTombstoneGetSet(t *testing.T) {
+	q := NewTombstoneDeque[string]()
+	a := q.PushBack("a")
+	b := q.PushBack("b")
+
+	if v, ok := q.Get(b); !ok || v != "b" {
+		t.Error("get should return the element of the handle")
+	}
+	if !q.Set(b, "c") {
+		t.Error("set should succeed on a live handle")
+	}
+	q.SetFront("z")
+	if v, _ := q.Get(a); v != "z" {
+		t.Error("set front should overwrite the first element")
+	}
+	if q.FrontHandle() != a {
+		t.Error("front handle should be the first pushed handle")
+	}
+
+	q.PopFront()
+	if _, ok := q.Get(a); ok {
+		t.Error("popped handle should be stale")
+	}
+	if q.Set(a, "x") {
+		t.Error("set on a stale handle should fail")
+	}
+
+	// recycled handle ids must not resolve the old handle
+	c := q.PushBack("d")
+	if c == a {
+		t.Error("recycled handle should have a new generation")
+	}
+	if _, ok := q.Get(a); ok {
+		t.Error("stale handle should not resolve after recycling")
+	}
+}
+
+func TestTombstoneCompactionOnResize(t *testing.T) {
+	q := NewTombstoneDeque[int](minCapacity)
+	handles := make([]Handle, minCapacity)
+	for i := range handles {
+		handles[i] = q.PushBack(i)
+	}
+	// cancel 3 out of 4 elements, leaving tombstones in the middle
+	for i := 1; i < minCapacity-1; i++ {
+		if i%4 == 0 {
+			continue
+		}
+		q.Cancel(handles[i])
+	}
+	live := q.Len()
+
+	// the buffer is full of tombstones, pushing compacts instead of growing
+	h := q.PushBack(-1)
+	if q.Cap() != minCapacity {
+		t.Error("expect compaction without growth, cap", q.Cap())
+	}
+	if q.Tombstones() != 0 || q.Len() != live+1 {
+		t.Error("compaction should drop the tombstones")
+	}
+
+	// handles survive the compaction
+	for i := 0; i < minCapacity-1; i += 4 {
+		if v, ok := q.Get(handles[i]); !ok || v != i {
+			t.Fatal("handle", i, "should survive compaction")
+		}
+	}
+	if v, ok := q.Cancel(h); !ok || v != -1 {
+		t.Error("handle pushed during compaction should be valid")
+	}
+
+	// order is kept
+	prev := -1
+	q.Each(func(h Handle, v int) bool {
+		if v <= prev {
+			t.Error("compaction should keep FIFO order")
+		}
+		prev = v
+		return true
+	})
+}
+
+func TestTombstoneGrowth(t *testing.T) {
+	q := NewTombstoneDeque[int]()
+	handles := make([]Handle, 0)
+	for i := 0; i < 10*minCapacity; i++ {
+		handles = append(handles, q.PushBack(i))
+		if i%3 == 0 {
+			q.Cancel(handles[i/2])
+		}
+	}
+	n := 0
+	q.Each(func(h Handle, v int) bool {
+		if got, ok := q.Get(h); !ok || got != v {
+			t.Fatal("handle should resolve to its element after growth")
+		}
+		n++
+		return true
+	})
+	if n != q.Len() {
+		t.Error("each should visit every live element")
+	}
+}
+
+func TestTombstoneClear(t *testing.T) {
+	q := NewTombstoneDeque[int]()
+	h := q.PushBack(1)
+	q.PushBack(2)
+	q.Clear()
+	if q.Len() != 0 || q.Tombstones() != 0 {
+		t.Error("queue should be empty after clear")
+	}
+	if _, ok := q.Get(h); ok {
+		t.Error("handles should be stale after clear")
+	}
+	q.PushBack(3)
+	if q.Front() != 3 {
+		t.Error("queue should be reusable after clear")
+	}
+}
+
+// an element the size of a book order, the payload of the benchmarks
+type benchOrder struct {
+	payload    [24]byte
+	SequenceId uint32
+}
+
+// the initial size of a book level
+const benchQueueSize = 16
+
+func BenchmarkDequePushPop(b *testing.B) {
+	q := New[benchOrder](benchQueueSize)
+	for i := 0; i < b.N; i++ {
+		q.PushBack(benchOrder{})
+		if q.Len() == benchQueueSize/2 {
+			q.PopFront()
+		}
+	}
+}
+
+func BenchmarkTombstoneDequePushPop(b *testing.B) {
+	q := NewTombstoneDeque[benchOrder](benchQueueSize)
+	for i := 0; i < b.N; i++ {
+		q.PushBack(benchOrder{})
+		if q.Len() == benchQueueSize/2 {
+			q.PopFront()
+		}
+	}
+}
+
+// cancelling a random resting order out of a queue of 1024
+func BenchmarkDequeCancel(b *testing.B) {
+	q := New[benchOrder](benchQueueSize)
+	for i := 0; i < 1024; i++ {
+		q.PushBack(benchOrder{SequenceId: uint32(i)})
+	}
+	ids := make([]int, 1<<16)
+	for i := range ids {
+		ids[i] = rand.Intn(1024)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := uint32(ids[i&(1<<16-1)])
+		at := q.Index(func(o benchOrder) bool { return o.SequenceId == id })
+		o := q.Remove(at)
+		q.PushBack(o)
+	}
+}
+
+func BenchmarkTombstoneDequeCancel(b *testing.B) {
+	q := NewTombstoneDeque[benchOrder](benchQueueSize)
+	handles := make([]Handle, 1024)
+	for i := range handles {
+		handles[i] = q.PushBack(benchOrder{SequenceId: uint32(i)})
+	}
+	ids := make([]int, 1<<16)
+	for i := range ids {
+		ids[i] = rand.Intn(1024)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := ids[i&(1<<16-1)]
+		o, _ := q.Cancel(handles[id])
+		handles[id] = q.PushBack(o)
+	}
+}
+
+func TestTombstoneFootprint(t *testing.T) {
+	q := NewTombstoneDeque[benchOrder](4)
+	if q.Cap() != 4 {
+		t.Error("small queues should not be rounded up to Deque's minimum, cap", q.Cap())
+	}
+	small := q.Footprint()
+	for i := 0; i < 4; i++ {
+		if q.GrowthCost() != 0 {
+			t.Error("pushing into free slots should not allocate")
+		}
+		q.PushBack(benchOrder{})
+	}
+	cost := q.GrowthCost()
+	if cost != small {
+		t.Error("full queue should double, growth cost", cost, "expect", small)
+	}
+	q.PushBack(benchOrder{})
+	if q.Footprint() != small+cost {
+		t.Error("footprint should grow by the growth cost")
+	}
+}
+
+func TestTombstoneShrink(t *testing.T) {
+	q := NewTombstoneDeque[int](4)
+	handles := make([]Handle, 0)
+	for i := 0; i < 100; i++ {
+		handles = append(handles, q.PushBack(i))
+	}
+	q.Shrink(4)
+	if q.Cap() != 128 {
+		t.Error("a queue holding elements should not shrink")
+	}
+
+	q.Clear()
+	q.Shrink(4)
+	if q.Cap() != 4 {
+		t.Error("empty queue should shrink to 4, cap", q.Cap())
+	}
+
+	// old handles stay stale after their ids are handed out again
+	for i := 0; i < 4; i++ {
+		q.PushBack(-i)
+	}
+	for _, h := range handles {
+		if _, ok := q.Get(h); ok {
+			t.Fatal("handle from before the shrink should be stale")
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// source of time for the engine timers, injectable so tests and replays are deterministic
+type Clock interface {
+	Now() time.Time
+	// calls f once d has elapsed on this clock
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+type Timer interface {
+	// false if the timer has already fired or been stopped
+	Stop() bool
+}
+
+// wall clock backed by the time package
+type realClock struct{}
+
+func NewRealClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// wall clock read once at the start then moved by the monotonic clock, so it never goes
+// back when the system time is stepped
+type monotonicClock struct {
+	start time.Time
+	wall  time.Time
+}
+
+func NewMonotonicClock() Clock {
+	now := time.Now()
+	return monotonicClock{start: now, wall: now.Round(0)}
+}
+
+func (c monotonicClock) Now() time.Time {
+	return c.wall.Add(time.Since(c.start))
+}
+
+func (monotonicClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// ManualClock only moves when told to, for tests and for replays that set it to the
+// recorded times. Timers fire synchronously from Advance and Set, in deadline order, on
+// the goroutine moving the clock.
+type ManualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+type manualTimer struct {
+	clock    *ManualClock
+	deadline time.Time
+	f        func()
+	done     bool
+}
+
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &manualTimer{clock: c, deadline: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *ManualClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// moves the clock to now, firing the timers due by then. the clock never goes back
+func (c *ManualClock) Set(now time.Time) {
+	for {
+		c.mu.Lock()
+		if now.Before(c.now) {
+			now = c.now
+		}
+		t := c.nextDue(now)
+		if t == nil {
+			c.now = now
+			c.mu.Unlock()
+			return
+		}
+		// time moves to each deadline, callbacks see the time they were due at
+		c.now = t.deadline
+		t.done = true
+		c.mu.Unlock()
+
+		// fired unlocked, the callback may schedule or stop timers
+		t.f()
+	}
+}
+
+// removes and returns the earliest timer due by now
+func (c *ManualClock) nextDue(now time.Time) *manualTimer {
+	live := c.timers[:0]
+	for _, t := range c.timers {
+		if !t.done {
+			live = append(live, t)
+		}
+	}
+	c.timers = live
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].deadline.Before(c.timers[j].deadline)
+	})
+
+	if len(c.timers) == 0 || c.timers[0].deadline.After(now) {
+		return nil
+	}
+	t := c.timers[0]
+	c.timers = c.timers[1:]
+	return t
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	if t.done {
+		return false
+	}
+	t.done = true
+	return true
+}
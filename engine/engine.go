@@ -0,0 +1,410 @@
+// Package engine runs a book per instrument behind one lock, with pre-trade risk checks,
+// sessions, the invariant audit, metrics, fees, the trade tape and bars, and the gateways
+// that expose it: FIX, OUCH, ITCH market data, JSON over HTTP and gRPC.
+package engine
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+var (
+	ErrUnknownSymbol = errors.New("engine: unknown symbol")
+	ErrAccountKilled = errors.New("engine: account kill switch is on")
+	// the book errors, so callers of the engine need not import the book
+	ErrUnknownOrder    = book.ErrUnknownOrder
+	ErrInvalidQuantity = book.ErrInvalidQuantity
+	ErrInvalidPrice    = book.ErrInvalidPrice
+)
+
+// engine settings, zero value gives an engine without pre-trade risk checks
+type EngineConfig struct {
+	// settings of every book, Symbol is set per instrument
+	Book book.BookConfig
+	// consulted before an order reaches the book and fed every event, optional
+	Risk RiskChecker
+	// drives the session grace timers, the audit and the bars, and stamps the orders and
+	// the book events. defaults to the wall clock
+	Clock Clock
+	// how often every book is checked with CheckInvariants, 0 turns the audit off.
+	// OnViolation gets the books that fail, it is called without the engine locked
+	// so it may halt trading through the engine
+	AuditInterval time.Duration
+	OnViolation   func(symbol string, violations []book.Violation)
+	// trade tape and bars of every instrument
+	Tape TapeConfig
+	// prices the fills before their events go out, optional
+	Fees FeeModel
+}
+
+// Engine routes orders to the book of their instrument, numbering them and running
+// the pre-trade checks on the way. It is safe for concurrent use, event handlers are
+// called with the engine locked and must not call back into it.
+type Engine struct {
+	mu         sync.Mutex
+	config     EngineConfig
+	books      map[string]*book.Orderbook
+	sequenceId uint32
+	handlers   []book.EventHandler
+	// accounts whose kill switch is on
+	killed   map[uint32]bool
+	sessions map[uint32]*session
+	// last id given by NewSessionId
+	lastSessionId uint32
+	// pending audit, nil once the engine is closed
+	audit   Timer
+	metrics engineMetrics
+	// trade tape and bars by symbol
+	tapes         map[string]*symbolTape
+	tradeHandlers []TradeHandler
+	barHandlers   []BarHandler
+}
+
+func NewEngine(config EngineConfig) *Engine {
+	if config.Clock == nil {
+		config.Clock = NewRealClock()
+	}
+	config.Tape = config.Tape.withDefaults()
+	e := &Engine{
+		config:   config,
+		books:    make(map[string]*book.Orderbook),
+		killed:   make(map[uint32]bool),
+		sessions: make(map[uint32]*session),
+		tapes:    make(map[string]*symbolTape),
+	}
+	if config.AuditInterval > 0 && config.OnViolation != nil {
+		e.audit = config.Clock.AfterFunc(config.AuditInterval, e.runAudit)
+	}
+	return e
+}
+
+// stops the periodic audit and the bar timers
+func (e *Engine) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.audit != nil {
+		e.audit.Stop()
+		e.audit = nil
+	}
+	e.stopBars()
+}
+
+// creates the book of an instrument, returns the existing one if already listed
+func (e *Engine) AddInstrument(symbol string) *book.Orderbook {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if book, ok := e.books[symbol]; ok {
+		return book
+	}
+	config := e.config.Book
+	config.Symbol = symbol
+	if config.Now == nil {
+		config.Now = e.now
+	}
+	book := book.NewOrderbookWithConfig(config)
+	book.Subscribe(e.dispatch)
+	e.books[symbol] = &book
+	e.tapes[symbol] = newSymbolTape(e.config.Tape)
+	return &book
+}
+
+// the book of an instrument. reading it while orders are submitted is not safe
+func (e *Engine) Book(symbol string) (*book.Orderbook, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	book, ok := e.books[symbol]
+	return book, ok
+}
+
+// runs fn on the book of an instrument with the engine locked, so the book can be read
+// safely. fn must not call back into the engine
+func (e *Engine) View(symbol string, fn func(book *book.Orderbook)) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	book, ok := e.books[symbol]
+	if !ok {
+		return ErrUnknownSymbol
+	}
+	fn(book)
+	return nil
+}
+
+// listed instruments in symbol order
+func (e *Engine) Symbols() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.symbols()
+}
+
+func (e *Engine) symbols() []string {
+	symbols := make([]string, 0, len(e.books))
+	for symbol := range e.books {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// unix nanoseconds on the engine clock
+func (e *Engine) now() int64 {
+	return e.config.Clock.Now().UnixNano()
+}
+
+// registers a handler for the events of every book
+func (e *Engine) Subscribe(h book.EventHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.handlers = append(e.handlers, h)
+}
+
+// numbers the order and runs it through the book of its instrument.
+// returns the order as it stands after matching
+func (e *Engine) Submit(symbol string, incoming orders.IncomingOrder) (orders.Order, error) {
+	// received before waiting for the lock
+	received := e.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.metrics.ordersIn++
+	book, err := e.check(symbol, incoming, nil)
+	if err != nil {
+		e.metrics.reject(err)
+		return orders.Order{}, err
+	}
+	return e.execute(book, incoming, received)
+}
+
+// cancels a resting order and submits its replacement, which loses the time priority.
+// the replacement is checked before the cancel, as if the order replaced was already
+// gone, so a rejected replace leaves the order in place. ErrUnknownOrder if the order
+// is not resting or belongs to another account
+func (e *Engine) Replace(symbol string, sequenceId uint32, incoming orders.IncomingOrder) (orders.Order, error) {
+	return e.replace(symbol, sequenceId, func(old orders.Order) orders.IncomingOrder {
+		return incoming
+	})
+}
+
+// Replace for a new total quantity, as in FIX: incoming.Quantity counts what the order
+// has executed and the replacement is for the rest. ErrInvalidQuantity if nothing is left
+func (e *Engine) Amend(symbol string, sequenceId uint32, incoming orders.IncomingOrder) (orders.Order, error) {
+	return e.replace(symbol, sequenceId, func(old orders.Order) orders.IncomingOrder {
+		incoming.Quantity -= old.ExecutedQuantity
+		return incoming
+	})
+}
+
+// looks the order up and builds its replacement with next, all under the lock
+func (e *Engine) replace(symbol string, sequenceId uint32, next func(old orders.Order) orders.IncomingOrder) (orders.Order, error) {
+	received := e.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.metrics.ordersIn++
+	book, incoming, err := e.checkReplace(symbol, sequenceId, next)
+	if err != nil {
+		e.metrics.reject(err)
+		return orders.Order{}, err
+	}
+	book.Cancel(sequenceId)
+	return e.execute(book, incoming, received)
+}
+
+func (e *Engine) checkReplace(symbol string, sequenceId uint32, next func(old orders.Order) orders.IncomingOrder) (*book.Orderbook, orders.IncomingOrder, error) {
+	orderbook, ok := e.books[symbol]
+	if !ok {
+		return nil, orders.IncomingOrder{}, ErrUnknownSymbol
+	}
+	old, ok := orderbook.Order(sequenceId)
+	if !ok {
+		return nil, orders.IncomingOrder{}, ErrUnknownOrder
+	}
+	incoming := next(old)
+	if incoming.AccountId != old.Order.AccountId {
+		return nil, orders.IncomingOrder{}, ErrUnknownOrder
+	}
+	_, err := e.check(symbol, incoming, &old)
+	return orderbook, incoming, err
+}
+
+// pre-trade checks, returns the book the order goes to. replaced is the order it
+// replaces, if any
+func (e *Engine) check(symbol string, incoming orders.IncomingOrder, replaced *orders.Order) (*book.Orderbook, error) {
+	orderbook, ok := e.books[symbol]
+	if !ok {
+		return nil, ErrUnknownSymbol
+	}
+	if err := book.ValidateOrder(incoming); err != nil {
+		return nil, err
+	}
+	if e.killed[incoming.AccountId] {
+		return nil, ErrAccountKilled
+	}
+	if incoming.SessionId != 0 && !e.sessions[incoming.SessionId].isConnected() {
+		return nil, ErrSessionNotConnected
+	}
+	if e.config.Risk != nil {
+		if incoming.OrderType == orders.MARKET {
+			// checked at the worst level it would reach, what it could cost rather than its price of 0
+			incoming.Price = orderbook.SweepCost(!incoming.BidOrAsk, incoming.Quantity).WorstPrice
+		}
+		var err error
+		if replaced != nil {
+			err = e.config.Risk.CheckReplace(symbol, *replaced, incoming)
+		} else {
+			err = e.config.Risk.Check(symbol, incoming)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return orderbook, nil
+}
+
+func (e *Engine) execute(book *book.Orderbook, incoming orders.IncomingOrder, received int64) (orders.Order, error) {
+	e.sequenceId++
+	o := orders.NewOrder(incoming, e.sequenceId)
+	// accepted by the book, at the time of its events
+	o.ReceivedAt = received
+	start := time.Now()
+	_, err := book.Execute(&o)
+	e.metrics.matching.Record(time.Since(start))
+	e.metrics.reject(err)
+	return o, err
+}
+
+// removes a resting order from the book of its instrument
+func (e *Engine) Cancel(symbol string, sequenceId uint32) (orders.Order, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	book, ok := e.books[symbol]
+	if !ok {
+		e.metrics.reject(ErrUnknownSymbol)
+		return orders.Order{}, ErrUnknownSymbol
+	}
+	o, ok := book.Cancel(sequenceId)
+	if !ok {
+		e.metrics.reject(ErrUnknownOrder)
+		return orders.Order{}, ErrUnknownOrder
+	}
+	return o, nil
+}
+
+// cancels every resting order of an account across all instruments
+func (e *Engine) MassCancel(accountId uint32) []orders.Order {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.massCancel(accountId)
+}
+
+func (e *Engine) massCancel(accountId uint32) []orders.Order {
+	cancelled := make([]orders.Order, 0)
+	e.eachBook(func(book *book.Orderbook) {
+		cancelled = append(cancelled, book.MassCancel(accountId)...)
+	})
+	return cancelled
+}
+
+// visits the books in symbol order, for deterministic event order
+func (e *Engine) eachBook(fn func(book *book.Orderbook)) {
+	for _, symbol := range e.symbols() {
+		fn(e.books[symbol])
+	}
+}
+
+// turns the kill switch of an account on: its resting orders are cancelled and new ones
+// are rejected with ErrAccountKilled until ResetKillSwitch
+func (e *Engine) Kill(accountId uint32) []orders.Order {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.killed[accountId] = true
+	return e.massCancel(accountId)
+}
+
+func (e *Engine) ResetKillSwitch(accountId uint32) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.killed, accountId)
+}
+
+func (e *Engine) IsKilled(accountId uint32) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.killed[accountId]
+}
+
+// audits every book, returns the violations by symbol, nil when all books are sound
+func (e *Engine) CheckInvariants() map[string][]book.Violation {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.checkInvariants()
+}
+
+func (e *Engine) checkInvariants() map[string][]book.Violation {
+	var found map[string][]book.Violation
+	for symbol := range e.books {
+		if violations := e.books[symbol].CheckInvariants(); len(violations) > 0 {
+			if found == nil {
+				found = make(map[string][]book.Violation)
+			}
+			found[symbol] = violations
+		}
+	}
+	return found
+}
+
+// audit timer callback, schedules the next audit then reports in symbol order
+func (e *Engine) runAudit() {
+	e.mu.Lock()
+	if e.audit == nil {
+		// closed in the meantime
+		e.mu.Unlock()
+		return
+	}
+	found := e.checkInvariants()
+	e.audit = e.config.Clock.AfterFunc(e.config.AuditInterval, e.runAudit)
+	e.mu.Unlock()
+
+	symbols := make([]string, 0, len(found))
+	for symbol := range found {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		e.config.OnViolation(symbol, found[symbol])
+	}
+}
+
+// fills are priced first. book events go to the risk module before the handlers, so
+// handlers see the updated exposure, fills then go on the tape
+func (e *Engine) dispatch(ev book.Event) {
+	if ev.Type == book.EventFill && e.config.Fees != nil {
+		ev.MakerFee, ev.TakerFee = e.config.Fees.OnFill(ev)
+	}
+	e.metrics.onEvent(ev)
+	if e.config.Risk != nil {
+		e.config.Risk.OnEvent(ev)
+	}
+	for _, h := range e.handlers {
+		h(ev)
+	}
+	if ev.Type == book.EventFill {
+		e.recordTrade(ev)
+	}
+}
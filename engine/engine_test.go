@@ -0,0 +1,329 @@
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+// engine on a manual clock set at start, with symbols listed
+func newTestEngine(config EngineConfig, start time.Time, symbols ...string) (*Engine, *ManualClock) {
+	clock := NewManualClock(start)
+	config.Clock = clock
+	e := NewEngine(config)
+	for _, symbol := range symbols {
+		e.AddInstrument(symbol)
+	}
+	return e, clock
+}
+
+func TestEngineUnknownSymbol(t *testing.T) {
+	e := NewEngine(EngineConfig{})
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 1)); err != ErrUnknownSymbol {
+		t.Errorf("submit on an unlisted symbol should fail, got %v", err)
+	}
+	if _, err := e.Cancel("BTC", 1); err != ErrUnknownSymbol {
+		t.Errorf("cancel on an unlisted symbol should fail, got %v", err)
+	}
+}
+
+func TestEngineSubmitCancel(t *testing.T) {
+	// events are stamped 0
+	e := NewEngine(EngineConfig{Clock: NewManualClock(time.Unix(0, 0))})
+	e.AddInstrument("BTC")
+	e.AddInstrument("ETH")
+
+	events := make([]book.Event, 0)
+	e.Subscribe(func(ev book.Event) {
+		events = append(events, ev)
+	})
+
+	bid, err := e.Submit("BTC", orders.NewIncomingOrder(10, 100, true, orders.LIMIT, 1))
+	if err != nil || bid.SequenceId != 1 {
+		t.Fatalf("first order should get sequenceId 1, got %d %v", bid.SequenceId, err)
+	}
+	ask, _ := e.Submit("BTC", orders.NewIncomingOrder(10, 40, false, orders.LIMIT, 2))
+	if ask.SequenceId != 2 || ask.ExecutedQuantity != 40 {
+		t.Errorf("ask should be fully filled, executed %0.8f", ask.ExecutedQuantity)
+	}
+	// books are independent
+	other, _ := e.Submit("ETH", orders.NewIncomingOrder(10, 5, false, orders.LIMIT, 2))
+	if other.ExecutedQuantity != 0 {
+		t.Errorf("ETH ask should not match the BTC bid")
+	}
+
+	if _, err := e.Cancel("BTC", bid.SequenceId); err != nil {
+		t.Errorf("resting bid should be cancelled: %v", err)
+	}
+	if _, err := e.Cancel("BTC", bid.SequenceId); err != ErrUnknownOrder {
+		t.Errorf("second cancel should fail, got %v", err)
+	}
+
+	expected := []book.Event{
+		{Type: book.EventAdded, Symbol: "BTC", SequenceId: 1, AccountId: 1, BidOrAsk: true, Price: 10, Quantity: 100, Remaining: 100},
+		{Type: book.EventFill, Symbol: "BTC", SequenceId: 2, AccountId: 2, Price: 10, Quantity: 40,
+			MakerSequenceId: 1, MakerAccountId: 1, MakerRemaining: 60},
+		{Type: book.EventAdded, Symbol: "ETH", SequenceId: 3, AccountId: 2, Price: 10, Quantity: 5, Remaining: 5},
+		{Type: book.EventCancelled, Symbol: "BTC", SequenceId: 1, AccountId: 1, BidOrAsk: true, Price: 10, Quantity: 60},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expect %d events, got %d: %+v", len(expected), len(events), events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("event %d should be %+v, got %+v", i, expected[i], events[i])
+		}
+	}
+}
+
+func TestEngineInvalidQuantity(t *testing.T) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(10, 0, true, orders.LIMIT, 1)); err != ErrInvalidQuantity {
+		t.Errorf("empty order should be rejected, got %v", err)
+	}
+}
+
+func TestEngineInvalidOrders(t *testing.T) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	nan, inf := float32(math.NaN()), float32(math.Inf(1))
+	cases := []struct {
+		name     string
+		incoming orders.IncomingOrder
+		err      error
+	}{
+		{"NaN quantity", orders.NewIncomingOrder(10, nan, true, orders.LIMIT, 1), ErrInvalidQuantity},
+		{"infinite quantity", orders.NewIncomingOrder(10, inf, true, orders.LIMIT, 1), ErrInvalidQuantity},
+		{"negative quantity", orders.NewIncomingOrder(10, -1, true, orders.LIMIT, 1), ErrInvalidQuantity},
+		{"NaN price", orders.NewIncomingOrder(nan, 1, true, orders.LIMIT, 1), ErrInvalidPrice},
+		{"infinite price", orders.NewIncomingOrder(-inf, 1, false, orders.MARKET, 1), ErrInvalidPrice},
+		{"limit at 0", orders.NewIncomingOrder(0, 1, true, orders.LIMIT, 1), ErrInvalidPrice},
+		{"negative limit", orders.NewIncomingOrder(-5, 1, false, orders.LIMIT, 1), ErrInvalidPrice},
+	}
+	for _, c := range cases {
+		if _, err := e.Submit("BTC", c.incoming); err != c.err {
+			t.Errorf("%s should be rejected with %v, got %v", c.name, c.err, err)
+		}
+	}
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(0, 1, true, orders.MARKET, 1)); err != nil {
+		t.Errorf("a market order needs no price: %v", err)
+	}
+	if v := e.CheckInvariants(); len(v) != 0 {
+		t.Errorf("rejected orders should leave the book sound, got %v", v)
+	}
+}
+
+func TestEngineMarketRemainderCancelled(t *testing.T) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	var cancels []book.Event
+	e.Subscribe(func(ev book.Event) {
+		if ev.Type == book.EventCancelled {
+			cancels = append(cancels, ev)
+		}
+	})
+
+	// nothing to take, the whole market ask is cancelled
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(0, 5, false, orders.MARKET, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(cancels) != 1 || !cancels[0].Expired || cancels[0].Quantity != 5 {
+		t.Fatalf("the market ask should be cancelled, got %+v", cancels)
+	}
+	// and no later bid trades against it at 0
+	bid, _ := e.Submit("BTC", orders.NewIncomingOrder(50, 5, true, orders.LIMIT, 2))
+	if bid.ExecutedQuantity != 0 {
+		t.Errorf("the bid should rest, executed %0.8f", bid.ExecutedQuantity)
+	}
+	e.View("BTC", func(b *book.Orderbook) {
+		if b.ALength() != 0 || b.GetBestBid() != 50 {
+			t.Errorf("only the bid should rest at 50")
+		}
+	})
+}
+
+func TestEngineReplaceOtherAccount(t *testing.T) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	o, _ := e.Submit("BTC", orders.NewIncomingOrder(10, 5, true, orders.LIMIT, 1))
+
+	if _, err := e.Replace("BTC", o.SequenceId, orders.NewIncomingOrder(11, 5, true, orders.LIMIT, 2)); err != ErrUnknownOrder {
+		t.Errorf("an account should not replace the order of another, got %v", err)
+	}
+	replaced, err := e.Replace("BTC", o.SequenceId, orders.NewIncomingOrder(11, 5, true, orders.LIMIT, 1))
+	if err != nil || replaced.Order.Price != 11 {
+		t.Errorf("the owner should replace its order, got %+v %v", replaced, err)
+	}
+}
+
+func TestEngineAmend(t *testing.T) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	o, _ := e.Submit("BTC", orders.NewIncomingOrder(10, 10, false, orders.LIMIT, 1))
+	e.Submit("BTC", orders.NewIncomingOrder(10, 4, true, orders.LIMIT, 2))
+
+	// 4 of the 10 executed
+	if _, err := e.Amend("BTC", o.SequenceId, orders.NewIncomingOrder(11, 4, false, orders.LIMIT, 1)); err != ErrInvalidQuantity {
+		t.Errorf("nothing left to amend to should be rejected, got %v", err)
+	}
+	amended, err := e.Amend("BTC", o.SequenceId, orders.NewIncomingOrder(11, 8, false, orders.LIMIT, 1))
+	if err != nil || amended.Order.Quantity != 4 {
+		t.Errorf("the amended order should be for the 4 left, got %+v %v", amended, err)
+	}
+}
+
+func TestEngineMassCancelAcrossInstruments(t *testing.T) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	e.AddInstrument("ETH")
+	e.Submit("BTC", orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 1))
+	e.Submit("ETH", orders.NewIncomingOrder(20, 1, false, orders.LIMIT, 1))
+	e.Submit("ETH", orders.NewIncomingOrder(21, 1, false, orders.LIMIT, 2))
+
+	cancelled := e.MassCancel(1)
+	if len(cancelled) != 2 {
+		t.Errorf("both orders of account 1 should be cancelled, got %d", len(cancelled))
+	}
+	btc, _ := e.Book("BTC")
+	eth, _ := e.Book("ETH")
+	if btc.BLength() != 0 || eth.ALength() != 1 {
+		t.Errorf("only the orders of account 1 should leave the books")
+	}
+	// mass cancel does not block new orders
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 1)); err != nil {
+		t.Errorf("account should still trade after a mass cancel: %v", err)
+	}
+}
+
+func TestEngineKillSwitch(t *testing.T) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	e.Submit("BTC", orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 1))
+	e.Submit("BTC", orders.NewIncomingOrder(11, 1, true, orders.LIMIT, 1))
+
+	if cancelled := e.Kill(1); len(cancelled) != 2 {
+		t.Errorf("kill should cancel the resting orders, got %d", len(cancelled))
+	}
+	if !e.IsKilled(1) {
+		t.Errorf("kill switch should be on")
+	}
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 1)); err != ErrAccountKilled {
+		t.Errorf("killed account should be rejected, got %v", err)
+	}
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 2)); err != nil {
+		t.Errorf("other accounts should not be affected: %v", err)
+	}
+
+	e.ResetKillSwitch(1)
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 1)); err != nil {
+		t.Errorf("account should trade after the reset: %v", err)
+	}
+}
+
+// a side whose max goes wrong once broken is set
+type brokenSide struct {
+	book.BookSide
+	broken *bool
+}
+
+func (s *brokenSide) Max() float32 {
+	if *s.broken {
+		return s.BookSide.Max() + 1
+	}
+	return s.BookSide.Max()
+}
+
+func TestEngineAudit(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var reported []string
+	var broken bool
+	var e *Engine
+	e = NewEngine(EngineConfig{
+		Book: book.BookConfig{NewSide: func() book.BookSide {
+			return &brokenSide{book.NewPriceLadderSide(), &broken}
+		}},
+		Clock:         clock,
+		AuditInterval: time.Second,
+		OnViolation: func(symbol string, violations []book.Violation) {
+			reported = append(reported, symbol)
+			// the engine is not locked, trading can be halted from here
+			e.Kill(2)
+		},
+	})
+	e.AddInstrument("BTC")
+	e.AddInstrument("ETH")
+	e.Submit("BTC", orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 1))
+	// ETH stays empty, it has no max to get wrong
+	e.Submit("ETH", orders.NewIncomingOrder(10, 1, false, orders.LIMIT, 1))
+	e.Cancel("ETH", 2)
+
+	clock.Advance(time.Second)
+	if len(reported) != 0 {
+		t.Fatalf("sound books should not be reported, got %v", reported)
+	}
+
+	broken = true
+	clock.Advance(time.Second)
+	if len(reported) != 1 || reported[0] != "BTC" || !e.IsKilled(2) {
+		t.Fatalf("the broken book should be reported once, got %v", reported)
+	}
+	if found := e.CheckInvariants(); len(found) != 1 || len(found["BTC"]) != 1 {
+		t.Errorf("expected one violation on BTC, got %v", found)
+	}
+
+	e.Close()
+	clock.Advance(time.Minute)
+	if len(reported) != 1 {
+		t.Errorf("no audit should run once closed, got %v", reported)
+	}
+}
+
+func TestEngineTimestamps(t *testing.T) {
+	clock := NewManualClock(time.Unix(100, 0))
+	e := NewEngine(EngineConfig{Clock: clock})
+	e.AddInstrument("BTC")
+	var events []book.Event
+	e.Subscribe(func(ev book.Event) { events = append(events, ev) })
+
+	bid, _ := e.Submit("BTC", orders.NewIncomingOrder(10, 2, true, orders.LIMIT, 1))
+	if bid.ReceivedAt != 100e9 || bid.AcceptedAt != 100e9 {
+		t.Errorf("the bid should be received and accepted at 100s, got %d %d", bid.ReceivedAt, bid.AcceptedAt)
+	}
+	clock.Advance(time.Microsecond)
+	e.Submit("BTC", orders.NewIncomingOrder(10, 1, false, orders.LIMIT, 2))
+	clock.Advance(time.Microsecond)
+	e.Cancel("BTC", bid.SequenceId)
+
+	times := []int64{100e9, 100e9 + 1e3, 100e9 + 2e3}
+	if len(events) != len(times) {
+		t.Fatalf("expect added, fill and cancelled, got %+v", events)
+	}
+	for i, ev := range events {
+		if ev.Time != times[i] {
+			t.Errorf("%s should be stamped %d, got %d", ev.Type, times[i], ev.Time)
+		}
+	}
+	if trade, _ := e.LastTrade("BTC"); trade.Time != time.Unix(100, 1e3) {
+		t.Errorf("the trade should be printed at the match time, got %v", trade.Time)
+	}
+}
+
+func TestMonotonicClock(t *testing.T) {
+	clock := NewMonotonicClock()
+	before := time.Now()
+	last := clock.Now()
+	for i := 0; i < 1000; i++ {
+		now := clock.Now()
+		if now.Before(last) {
+			t.Fatalf("the monotonic clock went back from %v to %v", last, now)
+		}
+		last = now
+	}
+	if d := last.Sub(before); d < -time.Second || d > time.Second {
+		t.Errorf("the monotonic clock should follow the wall clock, %v apart", d)
+	}
+}
@@ -0,0 +1,29 @@
+package engine_test
+
+import (
+	"fmt"
+
+	"matching-engine-go/book"
+	"matching-engine-go/engine"
+	"matching-engine-go/orders"
+)
+
+func ExampleEngine() {
+	e := engine.NewEngine(engine.EngineConfig{})
+	e.AddInstrument("BTC")
+	e.Subscribe(func(ev book.Event) {
+		fmt.Println(ev.Symbol, ev.Type, ev.Price, ev.Quantity)
+	})
+
+	bid, _ := e.Submit("BTC", orders.NewIncomingOrder(100, 2, true, orders.LIMIT, 1))
+	e.Submit("BTC", orders.NewIncomingOrder(100, 1, false, orders.LIMIT, 2))
+	e.Cancel("BTC", bid.SequenceId)
+	if _, err := e.Submit("ETH", orders.NewIncomingOrder(100, 1, true, orders.LIMIT, 1)); err != nil {
+		fmt.Println(err)
+	}
+	// Output:
+	// BTC added 100 2
+	// BTC fill 100 1
+	// BTC cancelled 100 1
+	// engine: unknown symbol
+}
@@ -0,0 +1,314 @@
+package engine
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"matching-engine-go/book"
+)
+
+// fees charged on the fills, consulted by the Engine before the fill events reach the
+// risk module and the handlers. implement it to bill from an external schedule
+type FeeModel interface {
+	// fees of the resting and the incoming order of a fill, negative for a rebate
+	OnFill(e book.Event) (makerFee float64, takerFee float64)
+}
+
+// fractions of the fill notional, a negative maker rate pays a rebate
+type FeeRates struct {
+	Maker float64
+	Taker float64
+}
+
+// rates that apply once the rolling volume of an account reaches MinVolume
+type FeeTier struct {
+	MinVolume float64
+	Rates     FeeRates
+}
+
+// volume tiers of an instrument by increasing MinVolume. volume below the first tier
+// still gets the first tier
+type FeeSchedule []FeeTier
+
+// the tier for a rolling volume, at least floor. -1 if there is no tier at all
+func (s FeeSchedule) tier(volume float64, floor int) int {
+	tier := 0
+	for i := range s {
+		if volume >= s[i].MinVolume {
+			tier = i
+		}
+	}
+	if floor > tier {
+		tier = floor
+	}
+	if tier >= len(s) {
+		tier = len(s) - 1
+	}
+	return tier
+}
+
+// fee settings, the zero value charges nothing
+type FeeConfig struct {
+	// schedule of every instrument without its own
+	Default     FeeSchedule
+	Instruments map[string]FeeSchedule
+	// span of the rolling volume that picks the tier, counted across instruments,
+	// made of Buckets equal slices. defaults to 30 days of one day each
+	Window  time.Duration
+	Buckets int
+}
+
+func (config FeeConfig) withDefaults() FeeConfig {
+	if config.Window <= 0 {
+		config.Window = 30 * 24 * time.Hour
+	}
+	if config.Buckets <= 0 {
+		config.Buckets = 30
+	}
+	return config
+}
+
+// fees and notional of one account in one instrument since the last reset
+type FeeTotals struct {
+	AccountId     uint32
+	Symbol        string
+	Fills         int
+	MakerNotional float64
+	TakerNotional float64
+	MakerFees     float64
+	TakerFees     float64
+}
+
+// fees less rebates
+func (t FeeTotals) Net() float64 {
+	return t.MakerFees + t.TakerFees
+}
+
+// in-memory FeeModel keeping the rolling volume and the fee totals of every account.
+// fills are placed in the window by the time of their event, so replays bill the same
+type AccountFees struct {
+	mu       sync.Mutex
+	config   FeeConfig
+	slot     time.Duration
+	accounts map[uint32]*feeAccount
+}
+
+type feeAccount struct {
+	volume rollingVolume
+	// lowest tier the account gets whatever its volume, 0 for none
+	floor  int
+	totals map[string]*FeeTotals
+}
+
+// notional traded by slot of the window, a ring indexed by slot number
+type rollingVolume struct {
+	buckets []float64
+	// latest slot traded in, slots since the epoch, negative before 1970
+	last    int64
+	started bool
+}
+
+func NewAccountFees(config FeeConfig) *AccountFees {
+	config = config.withDefaults()
+	slot := config.Window / time.Duration(config.Buckets)
+	if slot <= 0 {
+		slot = 1
+	}
+	return &AccountFees{
+		config:   config,
+		slot:     slot,
+		accounts: make(map[uint32]*feeAccount),
+	}
+}
+
+func (f *AccountFees) account(accountId uint32) *feeAccount {
+	a, ok := f.accounts[accountId]
+	if !ok {
+		a = &feeAccount{
+			volume: rollingVolume{buckets: make([]float64, f.config.Buckets)},
+			totals: make(map[string]*FeeTotals),
+		}
+		f.accounts[accountId] = a
+	}
+	return a
+}
+
+func (a *feeAccount) symbol(accountId uint32, symbol string) *FeeTotals {
+	t, ok := a.totals[symbol]
+	if !ok {
+		t = &FeeTotals{AccountId: accountId, Symbol: symbol}
+		a.totals[symbol] = t
+	}
+	return t
+}
+
+// moves the window to slot, the slots it leaves behind are cleared
+func (r *rollingVolume) advance(slot int64) {
+	if r.started && slot <= r.last {
+		return
+	}
+	size := int64(len(r.buckets))
+	if !r.started || slot-r.last >= size {
+		clear(r.buckets)
+	} else {
+		for s := r.last + 1; s <= slot; s++ {
+			r.buckets[r.index(s)] = 0
+		}
+	}
+	r.last, r.started = slot, true
+}
+
+// adds notional to slot, dropped if the slot has already left the window
+func (r *rollingVolume) add(slot int64, notional float64) {
+	r.advance(slot)
+	if slot <= r.last-int64(len(r.buckets)) {
+		return
+	}
+	r.buckets[r.index(slot)] += notional
+}
+
+// position of a slot in the ring, slots before 1970 wrap around too
+func (r *rollingVolume) index(slot int64) int64 {
+	size := int64(len(r.buckets))
+	return ((slot % size) + size) % size
+}
+
+func (r *rollingVolume) total(slot int64) float64 {
+	r.advance(slot)
+	var total float64
+	for _, v := range r.buckets {
+		total += v
+	}
+	return total
+}
+
+// keeps the account at tier or above, as for a market maker program. 0 goes back
+// to the volume tiers alone
+func (f *AccountFees) SetTier(accountId uint32, tier int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.account(accountId).floor = tier
+}
+
+// rolling volume of an account at a time, the one that picks its tier
+func (f *AccountFees) Volume(accountId uint32, at time.Time) float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.account(accountId).volume.total(f.slotOf(at.UnixNano()))
+}
+
+// index in the schedule of an instrument of the tier an account trades at now
+func (f *AccountFees) Tier(accountId uint32, symbol string, at time.Time) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	a := f.account(accountId)
+	return f.schedule(symbol).tier(a.volume.total(f.slotOf(at.UnixNano())), a.floor)
+}
+
+func (f *AccountFees) schedule(symbol string) FeeSchedule {
+	if s, ok := f.config.Instruments[symbol]; ok {
+		return s
+	}
+	return f.config.Default
+}
+
+// rounded down, so the slot before 1970 is -1 and not 0
+func (f *AccountFees) slotOf(nanos int64) int64 {
+	slot := nanos / int64(f.slot)
+	if nanos%int64(f.slot) < 0 {
+		slot--
+	}
+	return slot
+}
+
+// both sides are priced at the tier their volume gave them before this fill,
+// then the fill counts toward their volume
+func (f *AccountFees) OnFill(e book.Event) (float64, float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	notional := float64(e.Price) * float64(e.Quantity)
+	slot := f.slotOf(e.Time)
+	schedule := f.schedule(e.Symbol)
+
+	maker, taker := f.account(e.MakerAccountId), f.account(e.AccountId)
+	var makerFee, takerFee float64
+	if len(schedule) > 0 {
+		makerFee = notional * schedule[schedule.tier(maker.volume.total(slot), maker.floor)].Rates.Maker
+		takerFee = notional * schedule[schedule.tier(taker.volume.total(slot), taker.floor)].Rates.Taker
+	}
+	maker.volume.add(slot, notional)
+	taker.volume.add(slot, notional)
+
+	m := maker.symbol(e.MakerAccountId, e.Symbol)
+	m.Fills++
+	m.MakerNotional += notional
+	m.MakerFees += makerFee
+	t := taker.symbol(e.AccountId, e.Symbol)
+	t.Fills++
+	t.TakerNotional += notional
+	t.TakerFees += takerFee
+	return makerFee, takerFee
+}
+
+// fee totals of every account and instrument traded since the last reset,
+// by account then symbol
+func (f *AccountFees) Totals() []FeeTotals {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	totals := make([]FeeTotals, 0)
+	for _, a := range f.accounts {
+		for _, t := range a.totals {
+			totals = append(totals, *t)
+		}
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].AccountId != totals[j].AccountId {
+			return totals[i].AccountId < totals[j].AccountId
+		}
+		return totals[i].Symbol < totals[j].Symbol
+	})
+	return totals
+}
+
+// starts new totals, as at the end of the day. the rolling volumes are kept
+func (f *AccountFees) ResetTotals() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, a := range f.accounts {
+		a.totals = make(map[string]*FeeTotals)
+	}
+}
+
+// writes the totals as CSV with a header, one row per account and instrument
+func (f *AccountFees) WriteReport(w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"account", "symbol", "fills", "maker_notional", "taker_notional", "maker_fees", "taker_fees", "net_fees"})
+	for _, t := range f.Totals() {
+		out.Write([]string{
+			strconv.FormatUint(uint64(t.AccountId), 10),
+			t.Symbol,
+			strconv.Itoa(t.Fills),
+			formatAmount(t.MakerNotional),
+			formatAmount(t.TakerNotional),
+			formatAmount(t.MakerFees),
+			formatAmount(t.TakerFees),
+			formatAmount(t.Net()),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"matching-engine-go/book"
+)
+
+var testFeeConfig = FeeConfig{
+	Default: FeeSchedule{
+		{MinVolume: 0, Rates: FeeRates{Maker: -0.0001, Taker: 0.0005}},
+		{MinVolume: 1000, Rates: FeeRates{Maker: -0.0002, Taker: 0.0003}},
+	},
+	Instruments: map[string]FeeSchedule{
+		"ETH": {{Rates: FeeRates{Maker: 0, Taker: 0.001}}},
+	},
+	Window:  3 * 24 * time.Hour,
+	Buckets: 3,
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestFeesOnFills(t *testing.T) {
+	fees := NewAccountFees(testFeeConfig)
+	e, _ := newTestEngine(EngineConfig{Fees: fees}, time.Unix(0, 0), "BTC", "ETH")
+	var fills []book.Event
+	e.Subscribe(func(ev book.Event) {
+		if ev.Type == book.EventFill {
+			fills = append(fills, ev)
+		}
+	})
+
+	trade(e, "BTC", 10, 10)
+	trade(e, "ETH", 10, 10)
+	if len(fills) != 2 {
+		t.Fatalf("expect 2 fills, got %d", len(fills))
+	}
+	if !closeTo(fills[0].MakerFee, -0.01) || !closeTo(fills[0].TakerFee, 0.05) {
+		t.Errorf("BTC maker should get a 0.01 rebate and the taker pay 0.05, got %v %v", fills[0].MakerFee, fills[0].TakerFee)
+	}
+	if fills[1].MakerFee != 0 || !closeTo(fills[1].TakerFee, 0.1) {
+		t.Errorf("ETH has its own schedule, got %v %v", fills[1].MakerFee, fills[1].TakerFee)
+	}
+
+	totals := fees.Totals()
+	if len(totals) != 4 || totals[0].AccountId != 1 || totals[0].Symbol != "BTC" || totals[3].Symbol != "ETH" {
+		t.Fatalf("totals should be by account then symbol, got %+v", totals)
+	}
+	if totals[0].Fills != 1 || totals[0].MakerNotional != 100 || !closeTo(totals[0].Net(), -0.01) {
+		t.Errorf("account 1 on BTC should have made 100 for a 0.01 rebate, got %+v", totals[0])
+	}
+	if totals[2].TakerNotional != 100 || !closeTo(totals[2].TakerFees, 0.05) {
+		t.Errorf("account 2 on BTC should have taken 100 for 0.05, got %+v", totals[2])
+	}
+}
+
+func TestFeesRollingVolumeTiers(t *testing.T) {
+	fees := NewAccountFees(testFeeConfig)
+	e, clock := newTestEngine(EngineConfig{Fees: fees}, time.Unix(0, 0), "BTC", "ETH")
+	var fills []book.Event
+	e.Subscribe(func(ev book.Event) {
+		if ev.Type == book.EventFill {
+			fills = append(fills, ev)
+		}
+	})
+
+	// 1000 of volume reaches the second tier, priced from the next fill on
+	trade(e, "BTC", 100, 10)
+	if tier := fees.Tier(2, "BTC", clock.Now()); tier != 1 {
+		t.Errorf("account 2 should be in the second tier, got %d", tier)
+	}
+	trade(e, "BTC", 100, 10)
+	if !closeTo(fills[0].TakerFee, 0.5) || !closeTo(fills[1].TakerFee, 0.3) || !closeTo(fills[1].MakerFee, -0.2) {
+		t.Errorf("the second fill should be at the second tier, got %+v", fills)
+	}
+
+	// two days later the volume is still in the window, on the third it is out
+	clock.Advance(2 * 24 * time.Hour)
+	if v := fees.Volume(2, clock.Now()); v != 2000 {
+		t.Errorf("the window should still hold 2000, got %v", v)
+	}
+	clock.Advance(24 * time.Hour)
+	if v := fees.Volume(2, clock.Now()); v != 0 {
+		t.Errorf("the volume should have left the window, got %v", v)
+	}
+	trade(e, "BTC", 10, 10)
+	if !closeTo(fills[2].TakerFee, 0.05) {
+		t.Errorf("the account should be back in the first tier, got %v", fills[2].TakerFee)
+	}
+
+	// a tier floor holds whatever the volume
+	fees.SetTier(2, 1)
+	trade(e, "BTC", 1, 10)
+	if !closeTo(fills[3].TakerFee, 0.003) || !closeTo(fills[3].MakerFee, -0.001) {
+		t.Errorf("account 2 should take at its floor tier and account 1 make at the first, got %+v", fills[3])
+	}
+}
+
+func TestFeesRollingVolumeBefore1970(t *testing.T) {
+	fees := NewAccountFees(FeeConfig{Window: 3 * time.Hour, Buckets: 3})
+	at := time.Unix(-7200, 0)
+	fees.OnFill(book.Event{Symbol: "BTC", Time: at.UnixNano(), Price: 10, Quantity: 10, AccountId: 2, MakerAccountId: 1})
+
+	if v := fees.Volume(2, at); v != 100 {
+		t.Errorf("the fill should count in its own slot, got %v", v)
+	}
+	// the window of 3 slots at 0 still holds -2
+	if v := fees.Volume(2, time.Unix(0, 0)); v != 100 {
+		t.Errorf("the window should hold the fill across the epoch, got %v", v)
+	}
+	if v := fees.Volume(2, time.Unix(3600, 0)); v != 0 {
+		t.Errorf("the fill should have left the window, got %v", v)
+	}
+}
+
+func TestFeesReport(t *testing.T) {
+	fees := NewAccountFees(testFeeConfig)
+	e, _ := newTestEngine(EngineConfig{Fees: fees}, time.Unix(0, 0), "BTC", "ETH")
+	trade(e, "BTC", 10, 10)
+
+	var buf bytes.Buffer
+	if err := fees.WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "account,symbol,fills,maker_notional,taker_notional,maker_fees,taker_fees,net_fees\n" +
+		"1,BTC,1,100,0,-0.01,0,-0.01\n" +
+		"2,BTC,1,0,100,0,0.05,0.05\n"
+	if buf.String() != expected {
+		t.Errorf("report should be\n%s\ngot\n%s", expected, buf.String())
+	}
+
+	fees.ResetTotals()
+	if totals := fees.Totals(); len(totals) != 0 {
+		t.Errorf("totals should start again after a reset, got %+v", totals)
+	}
+	if v := fees.Volume(2, time.Unix(0, 0)); v != 100 {
+		t.Errorf("a reset should keep the rolling volume, got %v", v)
+	}
+}
@@ -0,0 +1,954 @@
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+// FIX 4.4 ExecType / OrdStatus values
+const (
+	fixExecNew      = "0"
+	fixExecCanceled = "4"
+	fixExecReplaced = "5"
+	fixExecRejected = "8"
+	fixExecTrade    = "F"
+
+	fixStatusNew             = "0"
+	fixStatusPartiallyFilled = "1"
+	fixStatusFilled          = "2"
+	fixStatusCanceled        = "4"
+	fixStatusRejected        = "8"
+)
+
+// outgoing messages buffered per connection before it is dropped as a slow consumer
+const fixOutboundBuffer = 4096
+
+// settings of the FIX acceptor
+type FixConfig struct {
+	// our CompID, logons addressed to another TargetCompID are refused
+	SenderCompID string
+	// flag the orders entered through FIX as cancel-on-disconnect, cancelled DisconnectGrace
+	// after their session drops
+	CancelOnDisconnect bool
+	DisconnectGrace    time.Duration
+	// drives the heartbeats, defaults to the wall clock
+	Clock Clock
+}
+
+// FixGateway is a FIX 4.4 acceptor in front of the Engine. It handles logon, heartbeats and
+// sequence number recovery, translates NewOrderSingle, OrderCancelRequest and
+// OrderCancelReplaceRequest into engine calls and reports back with ExecutionReports.
+//
+// Sequence numbers and sent reports are kept per counterparty CompID across reconnects,
+// so reports produced while a counterparty is away can be recovered with a ResendRequest.
+// The gateway never calls the engine while holding its own lock, as engine events
+// are delivered with the engine locked.
+type FixGateway struct {
+	engine *Engine
+	config FixConfig
+
+	mu       sync.Mutex
+	listener net.Listener
+	sessions map[string]*fixSession
+	// live orders entered through the gateway by engine sequenceId
+	orders map[uint32]*fixOrder
+	// events of orders still being submitted, claimed once Submit returns their sequenceId
+	unclaimed map[uint32][]book.Event
+	inflight  int
+	execId    uint64
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// sequence state of a counterparty
+type fixSession struct {
+	compID string
+	// engine session of the counterparty, stable across reconnects
+	sessionId uint32
+	nextIn    int
+	nextOut   int
+	// sent application messages by MsgSeqNum, for resends
+	store map[int]*fixMessage
+	// live orders by ClOrdID
+	live map[string]*fixOrder
+	conn *fixConn
+}
+
+// one TCP connection
+type fixConn struct {
+	conn    net.Conn
+	out     chan []byte
+	done    chan struct{}
+	once    sync.Once
+	session *fixSession
+
+	heartBtInt     time.Duration
+	lastIn         time.Time
+	lastOut        time.Time
+	testReqPending bool
+	testReqId      int
+	resendPending  bool
+	timer          Timer
+}
+
+// order entered through the gateway
+type fixOrder struct {
+	session     *fixSession
+	clOrdID     string
+	symbol      string
+	account     uint32
+	bidOrAsk    bool
+	orderType   orders.OrderType
+	quantity    float32
+	price       float32
+	cumQty      float32
+	cumNotional float32
+	// CumQty carried over from the orders it replaced, the engine order only knows its own fills
+	carriedQty float32
+	sequenceId uint32
+	// ClOrdID of the pending OrderCancelRequest
+	cancelClOrdID string
+	// a replace is in flight, the cancel of this order is part of it
+	replacing bool
+	// cancelled by someone else while the replace was in flight
+	cancelledWhileReplacing bool
+}
+
+func NewFixGateway(engine *Engine, config FixConfig) *FixGateway {
+	if config.Clock == nil {
+		config.Clock = NewRealClock()
+	}
+	g := &FixGateway{
+		engine:    engine,
+		config:    config,
+		sessions:  make(map[string]*fixSession),
+		orders:    make(map[uint32]*fixOrder),
+		unclaimed: make(map[uint32][]book.Event),
+	}
+	engine.Subscribe(g.onEvent)
+	return g
+}
+
+// listens on addr and serves connections in the background
+func (g *FixGateway) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.listener = l
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.Serve(l)
+	}()
+	return nil
+}
+
+func (g *FixGateway) Addr() net.Addr {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.listener == nil {
+		return nil
+	}
+	return g.listener.Addr()
+}
+
+// accepts connections until the listener is closed
+func (g *FixGateway) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		c := &fixConn{
+			conn: conn,
+			out:  make(chan []byte, fixOutboundBuffer),
+			done: make(chan struct{}),
+		}
+
+		g.mu.Lock()
+		if g.closed {
+			g.mu.Unlock()
+			conn.Close()
+			return net.ErrClosed
+		}
+		g.wg.Add(1)
+		g.mu.Unlock()
+
+		go g.serve(c)
+	}
+}
+
+// stops listening and drops every connection
+func (g *FixGateway) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	var err error
+	if g.listener != nil {
+		err = g.listener.Close()
+	}
+	for _, s := range g.sessions {
+		if s.conn != nil {
+			s.conn.close()
+		}
+	}
+	g.mu.Unlock()
+
+	g.wg.Wait()
+	return err
+}
+
+func (g *FixGateway) serve(c *fixConn) {
+	defer g.wg.Done()
+	// a Logout queued on the way out still gets written
+	defer c.closeAfterFlush()
+	go c.writeLoop()
+
+	r := bufio.NewReader(c.conn)
+	m, err := readFixMessage(r)
+	if err != nil || m.MsgType() != msgLogon || !g.logon(c, m) {
+		return
+	}
+	defer g.logout(c)
+
+	for {
+		m, err := readFixMessage(r)
+		if err != nil {
+			return
+		}
+		if !g.receive(c, m) {
+			return
+		}
+	}
+}
+
+// writes the outgoing messages in order, a nil message closes the connection once flushed
+func (c *fixConn) writeLoop() {
+	for {
+		select {
+		case b := <-c.out:
+			if b == nil {
+				c.close()
+				return
+			}
+			if _, err := c.conn.Write(b); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *fixConn) write(b []byte) {
+	select {
+	case c.out <- b:
+	default:
+		// slow consumer, the counterparty can recover the reports after reconnecting
+		c.close()
+	}
+}
+
+// closes the connection once the messages queued so far are written
+func (c *fixConn) closeAfterFlush() {
+	c.write(nil)
+}
+
+func (c *fixConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// validates the logon and attaches the connection to the session of the counterparty
+func (g *FixGateway) logon(c *fixConn, m *fixMessage) bool {
+	sender, _ := m.Get(tagSenderCompID)
+	target, _ := m.Get(tagTargetCompID)
+	heartBtInt, ok := m.GetInt(tagHeartBtInt)
+	seq, hasSeq := m.GetInt(tagMsgSeqNum)
+	if sender == "" || target != g.config.SenderCompID || !ok || heartBtInt <= 0 || !hasSeq {
+		return false
+	}
+
+	g.mu.Lock()
+	_, known := g.sessions[sender]
+	g.mu.Unlock()
+	var sessionId uint32
+	if !known {
+		// allocated outside the gateway lock, the engine calls back into the gateway
+		sessionId = g.engine.NewSessionId()
+	}
+
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return false
+	}
+	s, ok := g.sessions[sender]
+	if !ok {
+		s = &fixSession{
+			compID:    sender,
+			sessionId: sessionId,
+			nextIn:    1,
+			nextOut:   1,
+			store:     make(map[int]*fixMessage),
+			live:      make(map[string]*fixOrder),
+		}
+		g.sessions[sender] = s
+	}
+	if s.conn != nil {
+		// already logged on through another connection
+		g.mu.Unlock()
+		return false
+	}
+	reset := m.GetBool(tagResetSeqNumFlag)
+	if reset {
+		s.nextIn = 1
+		s.nextOut = 1
+		s.store = make(map[int]*fixMessage)
+	}
+
+	now := g.config.Clock.Now()
+	s.conn = c
+	c.session = s
+	c.heartBtInt = time.Duration(heartBtInt) * time.Second
+	c.lastIn = now
+	c.lastOut = now
+
+	if seq < s.nextIn {
+		g.sendLogout(s, fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", s.nextIn, seq))
+		s.conn = nil
+		g.mu.Unlock()
+		return false
+	}
+
+	reply := newFixMessage(msgLogon).SetInt(tagEncryptMethod, 0).SetInt(tagHeartBtInt, heartBtInt)
+	if reset {
+		reply.Set(tagResetSeqNumFlag, "Y")
+	}
+	g.send(s, reply, false)
+	if seq == s.nextIn {
+		s.nextIn++
+	} else {
+		g.requestResend(s)
+	}
+	c.timer = g.config.Clock.AfterFunc(c.heartBtInt, func() { g.heartbeat(c) })
+	g.mu.Unlock()
+
+	g.engine.Connect(s.sessionId, g.config.DisconnectGrace)
+	return true
+}
+
+// detaches a dropped connection from its session
+func (g *FixGateway) logout(c *fixConn) {
+	g.mu.Lock()
+	s := c.session
+	if s.conn == c {
+		s.conn = nil
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	g.mu.Unlock()
+
+	g.engine.Disconnect(s.sessionId)
+}
+
+// heartbeat timer: keeps the line alive and probes a silent counterparty
+func (g *FixGateway) heartbeat(c *fixConn) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s := c.session
+	if s.conn != c {
+		return
+	}
+	now := g.config.Clock.Now()
+	silence := now.Sub(c.lastIn)
+	if c.testReqPending && silence >= 2*c.heartBtInt {
+		// TestRequest went unanswered
+		c.close()
+		return
+	}
+	if now.Sub(c.lastOut) >= c.heartBtInt {
+		g.send(s, newFixMessage(msgHeartbeat), false)
+	}
+	if !c.testReqPending && silence >= c.heartBtInt {
+		c.testReqId++
+		g.send(s, newFixMessage(msgTestRequest).Set(tagTestReqID, "TEST"+strconv.Itoa(c.testReqId)), false)
+		c.testReqPending = true
+	}
+	c.timer = g.config.Clock.AfterFunc(c.heartBtInt, func() { g.heartbeat(c) })
+}
+
+// sequence checks of an incoming message, then dispatch. false drops the connection
+func (g *FixGateway) receive(c *fixConn, m *fixMessage) bool {
+	g.mu.Lock()
+	s := c.session
+	c.lastIn = g.config.Clock.Now()
+	c.testReqPending = false
+
+	seq, ok := m.GetInt(tagMsgSeqNum)
+	if !ok {
+		g.sendLogout(s, "MsgSeqNum missing")
+		g.mu.Unlock()
+		return false
+	}
+	msgType := m.MsgType()
+
+	if msgType == msgSequenceReset && !m.GetBool(tagGapFillFlag) {
+		// reset mode ignores the MsgSeqNum of the message itself
+		if newSeq, ok := m.GetInt(tagNewSeqNo); ok && newSeq > s.nextIn {
+			s.nextIn = newSeq
+		}
+		g.mu.Unlock()
+		return true
+	}
+	if seq < s.nextIn {
+		if m.GetBool(tagPossDupFlag) {
+			// already processed
+			g.mu.Unlock()
+			return true
+		}
+		g.sendLogout(s, fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", s.nextIn, seq))
+		g.mu.Unlock()
+		return false
+	}
+	if seq > s.nextIn {
+		// gap, the messages in between have to come first
+		if !c.resendPending {
+			g.requestResend(s)
+		}
+		process := msgType == msgResendRequest || msgType == msgLogout
+		g.mu.Unlock()
+		if process {
+			return g.dispatch(c, m)
+		}
+		return true
+	}
+	s.nextIn++
+	c.resendPending = false
+	if msgType == msgSequenceReset {
+		if newSeq, ok := m.GetInt(tagNewSeqNo); ok && newSeq > s.nextIn {
+			s.nextIn = newSeq
+		}
+	}
+	g.mu.Unlock()
+
+	return g.dispatch(c, m)
+}
+
+func (g *FixGateway) dispatch(c *fixConn, m *fixMessage) bool {
+	switch m.MsgType() {
+	case msgHeartbeat, msgSequenceReset:
+	case msgTestRequest:
+		id, _ := m.Get(tagTestReqID)
+		g.mu.Lock()
+		g.send(c.session, newFixMessage(msgHeartbeat).Set(tagTestReqID, id), false)
+		g.mu.Unlock()
+	case msgResendRequest:
+		begin, _ := m.GetInt(tagBeginSeqNo)
+		end, _ := m.GetInt(tagEndSeqNo)
+		g.mu.Lock()
+		g.resend(c.session, begin, end)
+		g.mu.Unlock()
+	case msgLogout:
+		g.mu.Lock()
+		g.sendLogout(c.session, "")
+		g.mu.Unlock()
+		return false
+	case msgNewOrderSingle:
+		g.newOrderSingle(c.session, m)
+	case msgOrderCancelRequest:
+		g.cancelRequest(c.session, m)
+	case msgOrderCancelReplace:
+		g.replaceRequest(c.session, m)
+	default:
+		seq, _ := m.Get(tagMsgSeqNum)
+		g.mu.Lock()
+		// 11 = invalid MsgType
+		g.send(c.session, newFixMessage(msgReject).Set(tagRefSeqNum, seq).Set(tagRefMsgType, m.MsgType()).
+			SetInt(tagSessionRejectReason, 11).Set(tagText, "unsupported MsgType"), false)
+		g.mu.Unlock()
+	}
+	return true
+}
+
+// numbers and stamps a message, stores application messages for resends and writes it
+// if the counterparty is connected. called with the gateway locked
+func (g *FixGateway) send(s *fixSession, m *fixMessage, app bool) {
+	seq := s.nextOut
+	s.nextOut++
+	stamped := g.stamp(s, m, seq)
+	if app {
+		s.store[seq] = stamped
+	}
+	if s.conn != nil {
+		s.conn.lastOut = g.config.Clock.Now()
+		s.conn.write(stamped.encode())
+	}
+}
+
+// MsgType followed by the standard header, then the body of m
+func (g *FixGateway) stamp(s *fixSession, m *fixMessage, seq int) *fixMessage {
+	out := &fixMessage{fields: make([]fixField, 0, len(m.fields)+4)}
+	out.fields = append(out.fields, m.fields[0])
+	out.Set(tagSenderCompID, g.config.SenderCompID)
+	out.Set(tagTargetCompID, s.compID)
+	out.SetInt(tagMsgSeqNum, seq)
+	out.SetTime(tagSendingTime, g.config.Clock.Now())
+	for _, f := range m.fields[1:] {
+		switch f.tag {
+		case tagSenderCompID, tagTargetCompID, tagMsgSeqNum, tagSendingTime:
+		default:
+			out.fields = append(out.fields, f)
+		}
+	}
+	return out
+}
+
+func (g *FixGateway) sendLogout(s *fixSession, text string) {
+	m := newFixMessage(msgLogout)
+	if text != "" {
+		m.Set(tagText, text)
+	}
+	g.send(s, m, false)
+	if s.conn != nil {
+		s.conn.closeAfterFlush()
+	}
+}
+
+func (g *FixGateway) requestResend(s *fixSession) {
+	g.send(s, newFixMessage(msgResendRequest).SetInt(tagBeginSeqNo, s.nextIn).SetInt(tagEndSeqNo, 0), false)
+	if s.conn != nil {
+		s.conn.resendPending = true
+	}
+}
+
+// resends the stored application messages in [begin, end] as possible duplicates,
+// admin messages are skipped with a SequenceReset-GapFill
+func (g *FixGateway) resend(s *fixSession, begin int, end int) {
+	if s.conn == nil {
+		return
+	}
+	if begin < 1 {
+		begin = 1
+	}
+	if end == 0 || end >= s.nextOut {
+		end = s.nextOut - 1
+	}
+	now := g.config.Clock.Now()
+	for seq := begin; seq <= end; {
+		if stored, ok := s.store[seq]; ok {
+			m := stored.clone()
+			orig, _ := m.Get(tagSendingTime)
+			m.SetTime(tagSendingTime, now)
+			m.Set(tagPossDupFlag, "Y")
+			m.Set(tagOrigSendingTime, orig)
+			s.conn.write(m.encode())
+			seq++
+			continue
+		}
+
+		gapEnd := seq + 1
+		for gapEnd <= end && s.store[gapEnd] == nil {
+			gapEnd++
+		}
+		gap := newFixMessage(msgSequenceReset).Set(tagGapFillFlag, "Y").SetInt(tagNewSeqNo, gapEnd)
+		m := g.stamp(s, gap, seq)
+		m.Set(tagPossDupFlag, "Y")
+		s.conn.write(m.encode())
+		seq = gapEnd
+	}
+	s.conn.lastOut = now
+}
+
+// NewOrderSingle
+func (g *FixGateway) newOrderSingle(s *fixSession, m *fixMessage) {
+	o, incoming, err := g.parseOrder(s, m)
+	g.mu.Lock()
+	if err == nil && s.live[o.clOrdID] != nil {
+		err = errors.New("duplicate ClOrdID")
+	}
+	if err != nil {
+		g.reject(s, o, err)
+		g.mu.Unlock()
+		return
+	}
+	g.inflight++
+	g.mu.Unlock()
+
+	order, err := g.engine.Submit(o.symbol, incoming)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.accepted(s, o, order, err, fixExecNew, "")
+}
+
+// OrderCancelRequest
+func (g *FixGateway) cancelRequest(s *fixSession, m *fixMessage) {
+	orig, _ := m.Get(tagOrigClOrdID)
+	clOrdID, _ := m.Get(tagClOrdID)
+
+	g.mu.Lock()
+	o := s.live[orig]
+	if o == nil {
+		g.cancelReject(s, clOrdID, orig, "1", "unknown order")
+		g.mu.Unlock()
+		return
+	}
+	o.cancelClOrdID = clOrdID
+	symbol, sequenceId := o.symbol, o.sequenceId
+	g.mu.Unlock()
+
+	// the cancel report comes from the engine event
+	if _, err := g.engine.Cancel(symbol, sequenceId); err != nil {
+		g.mu.Lock()
+		o.cancelClOrdID = ""
+		g.cancelReject(s, clOrdID, orig, "1", "too late to cancel")
+		g.mu.Unlock()
+	}
+}
+
+// OrderCancelReplaceRequest, the replacement is a new order in the engine for OrderQty
+// less CumQty. the fills of the original order are carried over
+func (g *FixGateway) replaceRequest(s *fixSession, m *fixMessage) {
+	orig, _ := m.Get(tagOrigClOrdID)
+	clOrdID, _ := m.Get(tagClOrdID)
+
+	g.mu.Lock()
+	old := s.live[orig]
+	if old == nil {
+		g.replaceReject(s, clOrdID, orig, "unknown order")
+		g.mu.Unlock()
+		return
+	}
+	o, incoming, err := g.parseReplace(s, m, old)
+	if err == nil && s.live[clOrdID] != nil {
+		err = errors.New("duplicate ClOrdID")
+	}
+	if err != nil {
+		g.replaceReject(s, clOrdID, orig, err.Error())
+		g.mu.Unlock()
+		return
+	}
+	old.replacing = true
+	g.inflight++
+	g.mu.Unlock()
+
+	// the engine takes off what the original order executes up to the replace
+	order, err := g.engine.Amend(o.symbol, old.sequenceId, incoming)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	old.replacing = false
+	if order.SequenceId == 0 {
+		g.inflight--
+		g.replaceReject(s, clOrdID, orig, err.Error())
+		if old.cancelledWhileReplacing {
+			g.cancelled(old)
+		}
+		g.clearUnclaimed()
+		return
+	}
+	g.remove(old)
+	o.cumQty, o.cumNotional, o.carriedQty = old.cumQty, old.cumNotional, old.cumQty
+	g.accepted(s, o, order, err, fixExecReplaced, orig)
+}
+
+// registers an order returned by the engine, acknowledges it and reports the fills it
+// got while being submitted. called with the gateway locked
+func (g *FixGateway) accepted(s *fixSession, o *fixOrder, order orders.Order, err error, execType string, origClOrdID string) {
+	defer g.clearUnclaimed()
+	g.inflight--
+	if order.SequenceId == 0 {
+		g.reject(s, o, err)
+		return
+	}
+
+	o.sequenceId = order.SequenceId
+	g.orders[o.sequenceId] = o
+	s.live[o.clOrdID] = o
+	status := fixStatusNew
+	if o.cumQty > 0 {
+		status = fixStatusPartiallyFilled
+	}
+	ack := g.executionReport(o, execType, status, order.Order.Quantity)
+	if origClOrdID != "" {
+		ack.Set(tagOrigClOrdID, origClOrdID)
+	}
+	g.send(s, ack, true)
+
+	for _, ev := range g.unclaimed[o.sequenceId] {
+		if ev.Type == book.EventCancelled {
+			g.cancelled(o)
+			continue
+		}
+		g.fill(o, ev.Price, ev.Quantity, ev.Remaining)
+	}
+	delete(g.unclaimed, o.sequenceId)
+
+	if err != nil && g.orders[o.sequenceId] == o {
+		// matched but the remainder could not rest
+		g.remove(o)
+		report := g.executionReport(o, fixExecCanceled, fixStatusCanceled, 0)
+		report.Set(tagText, err.Error())
+		g.send(s, report, true)
+	}
+}
+
+// events of orders nobody is waiting for any more
+func (g *FixGateway) clearUnclaimed() {
+	if g.inflight == 0 && len(g.unclaimed) > 0 {
+		g.unclaimed = make(map[uint32][]book.Event)
+	}
+}
+
+// engine events, delivered with the engine locked
+func (g *FixGateway) onEvent(ev book.Event) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch ev.Type {
+	case book.EventFill:
+		if maker := g.orders[ev.MakerSequenceId]; maker != nil {
+			g.fill(maker, ev.Price, ev.Quantity, ev.MakerRemaining)
+		}
+		if taker := g.orders[ev.SequenceId]; taker != nil {
+			g.fill(taker, ev.Price, ev.Quantity, ev.Remaining)
+		} else if g.inflight > 0 {
+			// the taker may be one of ours being submitted
+			g.unclaimed[ev.SequenceId] = append(g.unclaimed[ev.SequenceId], ev)
+		}
+	case book.EventCancelled:
+		if o := g.orders[ev.SequenceId]; o != nil {
+			if o.replacing {
+				o.cancelledWhileReplacing = true
+				return
+			}
+			g.cancelled(o)
+		} else if ev.Expired && g.inflight > 0 {
+			// the market remainder of an order being submitted
+			g.unclaimed[ev.SequenceId] = append(g.unclaimed[ev.SequenceId], ev)
+		}
+	}
+}
+
+func (g *FixGateway) fill(o *fixOrder, price float32, quantity float32, remaining float32) {
+	o.cumQty += quantity
+	o.cumNotional += price * quantity
+	status := fixStatusPartiallyFilled
+	if remaining <= 0 {
+		status = fixStatusFilled
+		g.remove(o)
+	}
+	report := g.executionReport(o, fixExecTrade, status, remaining)
+	report.SetFloat(tagLastQty, quantity)
+	report.SetFloat(tagLastPx, price)
+	g.send(o.session, report, true)
+}
+
+func (g *FixGateway) cancelled(o *fixOrder) {
+	g.remove(o)
+	report := g.executionReport(o, fixExecCanceled, fixStatusCanceled, 0)
+	if o.cancelClOrdID != "" {
+		report.Set(tagClOrdID, o.cancelClOrdID)
+		report.Set(tagOrigClOrdID, o.clOrdID)
+	}
+	g.send(o.session, report, true)
+}
+
+func (g *FixGateway) remove(o *fixOrder) {
+	if g.orders[o.sequenceId] == o {
+		delete(g.orders, o.sequenceId)
+	}
+	if o.session.live[o.clOrdID] == o {
+		delete(o.session.live, o.clOrdID)
+	}
+}
+
+func (g *FixGateway) reject(s *fixSession, o *fixOrder, err error) {
+	report := g.executionReport(o, fixExecRejected, fixStatusRejected, 0)
+	report.Set(tagOrderID, "NONE")
+	// 0 = broker option, 1 = unknown symbol, 3 = order exceeds limit
+	reason := 0
+	switch {
+	case errors.Is(err, ErrUnknownSymbol):
+		reason = 1
+	case errors.Is(err, ErrRiskOrderSize), errors.Is(err, ErrRiskPosition),
+		errors.Is(err, ErrRiskExposure), errors.Is(err, ErrRiskBalance):
+		reason = 3
+	}
+	report.SetInt(tagOrdRejReason, reason)
+	report.Set(tagText, err.Error())
+	g.send(s, report, true)
+}
+
+func (g *FixGateway) cancelReject(s *fixSession, clOrdID string, orig string, reason string, text string) {
+	m := newFixMessage(msgOrderCancelReject).Set(tagOrderID, "NONE").Set(tagClOrdID, clOrdID).
+		Set(tagOrigClOrdID, orig).Set(tagOrdStatus, fixStatusRejected).Set(tagCxlRejResponseTo, "1").
+		Set(tagCxlRejReason, reason).Set(tagText, text)
+	g.send(s, m, true)
+}
+
+func (g *FixGateway) replaceReject(s *fixSession, clOrdID string, orig string, text string) {
+	m := newFixMessage(msgOrderCancelReject).Set(tagOrderID, "NONE").Set(tagClOrdID, clOrdID).
+		Set(tagOrigClOrdID, orig).Set(tagOrdStatus, fixStatusRejected).Set(tagCxlRejResponseTo, "2").
+		Set(tagCxlRejReason, "99").Set(tagText, text)
+	g.send(s, m, true)
+}
+
+func (g *FixGateway) executionReport(o *fixOrder, execType string, status string, leaves float32) *fixMessage {
+	g.execId++
+	m := newFixMessage(msgExecutionReport).
+		Set(tagOrderID, strconv.FormatUint(uint64(o.sequenceId), 10)).
+		Set(tagClOrdID, o.clOrdID).
+		Set(tagExecID, strconv.FormatUint(g.execId, 10)).
+		Set(tagExecType, execType).
+		Set(tagOrdStatus, status).
+		Set(tagSymbol, o.symbol).
+		Set(tagSide, fixSide(o.bidOrAsk)).
+		SetFloat(tagOrderQty, o.quantity).
+		SetFloat(tagLeavesQty, leaves).
+		SetFloat(tagCumQty, o.cumQty)
+	if o.orderType == orders.LIMIT {
+		m.SetFloat(tagPrice, o.price)
+	}
+	avg := float32(0)
+	if o.cumQty > 0 {
+		avg = o.cumNotional / o.cumQty
+	}
+	m.SetFloat(tagAvgPx, avg)
+	m.SetTime(tagTransactTime, g.config.Clock.Now())
+	return m
+}
+
+func fixSide(bidOrAsk bool) string {
+	if bidOrAsk {
+		return "1"
+	}
+	return "2"
+}
+
+// fields of a NewOrderSingle. the order is returned even on error so it can be rejected
+func (g *FixGateway) parseOrder(s *fixSession, m *fixMessage) (*fixOrder, orders.IncomingOrder, error) {
+	o := &fixOrder{session: s}
+	o.clOrdID, _ = m.Get(tagClOrdID)
+	o.symbol, _ = m.Get(tagSymbol)
+	if o.clOrdID == "" || o.symbol == "" {
+		return o, orders.IncomingOrder{}, errors.New("ClOrdID and Symbol are required")
+	}
+	// only a replace may leave it out to keep the type of the order
+	if _, ok := m.Get(tagOrdType); !ok {
+		return o, orders.IncomingOrder{}, errors.New("OrdType is required")
+	}
+	if err := g.parseOrderFields(o, m); err != nil {
+		return o, orders.IncomingOrder{}, err
+	}
+	if v, ok := m.Get(tagAccount); ok {
+		a, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return o, orders.IncomingOrder{}, errors.New("Account must be numeric")
+		}
+		o.account = uint32(a)
+	}
+	return o, g.incoming(s, o), nil
+}
+
+// fields of an OrderCancelReplaceRequest, missing ones are taken from the order replaced
+func (g *FixGateway) parseReplace(s *fixSession, m *fixMessage, old *fixOrder) (*fixOrder, orders.IncomingOrder, error) {
+	o := &fixOrder{
+		session:   s,
+		symbol:    old.symbol,
+		account:   old.account,
+		bidOrAsk:  old.bidOrAsk,
+		orderType: old.orderType,
+		quantity:  old.quantity,
+		price:     old.price,
+	}
+	o.clOrdID, _ = m.Get(tagClOrdID)
+	if o.clOrdID == "" {
+		return o, orders.IncomingOrder{}, errors.New("ClOrdID is required")
+	}
+	if symbol, ok := m.Get(tagSymbol); ok && symbol != old.symbol {
+		return o, orders.IncomingOrder{}, errors.New("Symbol cannot be replaced")
+	}
+	if side, ok := m.Get(tagSide); ok && side != fixSide(old.bidOrAsk) {
+		return o, orders.IncomingOrder{}, errors.New("Side cannot be replaced")
+	}
+	if err := g.parseOrderFields(o, m); err != nil {
+		return o, orders.IncomingOrder{}, err
+	}
+	if o.quantity <= old.cumQty {
+		return o, orders.IncomingOrder{}, errors.New("OrderQty must be greater than CumQty")
+	}
+	incoming := g.incoming(s, o)
+	incoming.Quantity -= old.carriedQty
+	return o, incoming, nil
+}
+
+// Side, OrderQty, OrdType and Price, keeping the values already in o when absent
+func (g *FixGateway) parseOrderFields(o *fixOrder, m *fixMessage) error {
+	if side, ok := m.Get(tagSide); ok {
+		switch side {
+		case "1":
+			o.bidOrAsk = true
+		case "2":
+			o.bidOrAsk = false
+		default:
+			return errors.New("unsupported Side")
+		}
+	} else if o.quantity == 0 {
+		return errors.New("Side is required")
+	}
+	if v, ok := m.Get(tagOrderQty); ok {
+		q, err := strconv.ParseFloat(v, 32)
+		if err != nil || q <= 0 {
+			return errors.New("OrderQty must be positive")
+		}
+		o.quantity = float32(q)
+	} else if o.quantity == 0 {
+		return errors.New("OrderQty is required")
+	}
+	if v, ok := m.Get(tagOrdType); ok {
+		switch v {
+		case "1":
+			o.orderType = orders.MARKET
+		case "2":
+			o.orderType = orders.LIMIT
+		default:
+			return errors.New("unsupported OrdType")
+		}
+	}
+	if v, ok := m.Get(tagPrice); ok {
+		p, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return errors.New("invalid Price")
+		}
+		o.price = float32(p)
+	} else if o.orderType == orders.LIMIT && o.price == 0 {
+		return errors.New("Price is required for limit orders")
+	}
+	return nil
+}
+
+func (g *FixGateway) incoming(s *fixSession, o *fixOrder) orders.IncomingOrder {
+	incoming := orders.NewIncomingOrder(o.price, o.quantity, o.bidOrAsk, o.orderType, o.account)
+	incoming.SessionId = s.sessionId
+	incoming.CancelOnDisconnect = g.config.CancelOnDisconnect
+	return incoming
+}
@@ -0,0 +1,249 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	fixBeginString = "FIX.4.4"
+	fixSOH         = '\x01'
+	// SendingTime / TransactTime format, UTC with milliseconds
+	fixTimeFormat = "20060102-15:04:05.000"
+)
+
+// FIX 4.4 tags used by the gateway
+const (
+	tagAccount             = 1
+	tagAvgPx               = 6
+	tagBeginSeqNo          = 7
+	tagBeginString         = 8
+	tagBodyLength          = 9
+	tagCheckSum            = 10
+	tagClOrdID             = 11
+	tagCumQty              = 14
+	tagEndSeqNo            = 16
+	tagExecID              = 17
+	tagLastPx              = 31
+	tagLastQty             = 32
+	tagMsgSeqNum           = 34
+	tagMsgType             = 35
+	tagNewSeqNo            = 36
+	tagOrderID             = 37
+	tagOrderQty            = 38
+	tagOrdStatus           = 39
+	tagOrdType             = 40
+	tagOrigClOrdID         = 41
+	tagPossDupFlag         = 43
+	tagPrice               = 44
+	tagRefSeqNum           = 45
+	tagSenderCompID        = 49
+	tagSendingTime         = 52
+	tagSide                = 54
+	tagSymbol              = 55
+	tagTargetCompID        = 56
+	tagText                = 58
+	tagTransactTime        = 60
+	tagEncryptMethod       = 98
+	tagCxlRejReason        = 102
+	tagOrdRejReason        = 103
+	tagHeartBtInt          = 108
+	tagTestReqID           = 112
+	tagOrigSendingTime     = 122
+	tagGapFillFlag         = 123
+	tagResetSeqNumFlag     = 141
+	tagExecType            = 150
+	tagLeavesQty           = 151
+	tagRefMsgType          = 372
+	tagSessionRejectReason = 373
+	tagCxlRejResponseTo    = 434
+)
+
+// FIX 4.4 message types used by the gateway
+const (
+	msgHeartbeat          = "0"
+	msgTestRequest        = "1"
+	msgResendRequest      = "2"
+	msgReject             = "3"
+	msgSequenceReset      = "4"
+	msgLogout             = "5"
+	msgExecutionReport    = "8"
+	msgOrderCancelReject  = "9"
+	msgLogon              = "A"
+	msgNewOrderSingle     = "D"
+	msgOrderCancelRequest = "F"
+	msgOrderCancelReplace = "G"
+)
+
+var errFixGarbled = errors.New("fix: garbled message")
+
+type fixField struct {
+	tag   int
+	value string
+}
+
+// tag=value fields in wire order, without BeginString, BodyLength and CheckSum
+type fixMessage struct {
+	fields []fixField
+}
+
+func newFixMessage(msgType string) *fixMessage {
+	return &fixMessage{fields: []fixField{{tagMsgType, msgType}}}
+}
+
+func (m *fixMessage) MsgType() string {
+	v, _ := m.Get(tagMsgType)
+	return v
+}
+
+func (m *fixMessage) Get(tag int) (string, bool) {
+	for _, f := range m.fields {
+		if f.tag == tag {
+			return f.value, true
+		}
+	}
+	return "", false
+}
+
+func (m *fixMessage) GetInt(tag int) (int, bool) {
+	v, ok := m.Get(tag)
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(v)
+	return i, err == nil
+}
+
+func (m *fixMessage) GetFloat(tag int) (float32, bool) {
+	v, ok := m.Get(tag)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 32)
+	return float32(f), err == nil
+}
+
+func (m *fixMessage) GetBool(tag int) bool {
+	v, _ := m.Get(tag)
+	return v == "Y"
+}
+
+// replaces the field if present, appends it otherwise
+func (m *fixMessage) Set(tag int, value string) *fixMessage {
+	for i := range m.fields {
+		if m.fields[i].tag == tag {
+			m.fields[i].value = value
+			return m
+		}
+	}
+	m.fields = append(m.fields, fixField{tag, value})
+	return m
+}
+
+func (m *fixMessage) SetInt(tag int, value int) *fixMessage {
+	return m.Set(tag, strconv.Itoa(value))
+}
+
+func (m *fixMessage) SetFloat(tag int, value float32) *fixMessage {
+	return m.Set(tag, strconv.FormatFloat(float64(value), 'f', -1, 32))
+}
+
+func (m *fixMessage) SetTime(tag int, t time.Time) *fixMessage {
+	return m.Set(tag, t.UTC().Format(fixTimeFormat))
+}
+
+// copy with its own field slice, so resends can rewrite the header
+func (m *fixMessage) clone() *fixMessage {
+	fields := make([]fixField, len(m.fields))
+	copy(fields, m.fields)
+	return &fixMessage{fields}
+}
+
+// wire format: BeginString, BodyLength, the fields, CheckSum
+func (m *fixMessage) encode() []byte {
+	var body bytes.Buffer
+	for _, f := range m.fields {
+		body.WriteString(strconv.Itoa(f.tag))
+		body.WriteByte('=')
+		body.WriteString(f.value)
+		body.WriteByte(fixSOH)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "%d=%s%c%d=%d%c", tagBeginString, fixBeginString, fixSOH, tagBodyLength, body.Len(), fixSOH)
+	out.Write(body.Bytes())
+	fmt.Fprintf(&out, "%d=%03d%c", tagCheckSum, fixChecksum(out.Bytes()), fixSOH)
+	return out.Bytes()
+}
+
+func fixChecksum(b []byte) int {
+	sum := 0
+	for _, c := range b {
+		sum += int(c)
+	}
+	return sum % 256
+}
+
+// reads one framed message, checking BeginString, BodyLength and CheckSum
+func readFixMessage(r *bufio.Reader) (*fixMessage, error) {
+	begin, err := r.ReadBytes(fixSOH)
+	if err != nil {
+		return nil, err
+	}
+	if string(begin) != fmt.Sprintf("%d=%s%c", tagBeginString, fixBeginString, fixSOH) {
+		return nil, errFixGarbled
+	}
+	length, err := r.ReadBytes(fixSOH)
+	if err != nil {
+		return nil, err
+	}
+	tag, value, ok := bytes.Cut(length[:len(length)-1], []byte("="))
+	if !ok || string(tag) != strconv.Itoa(tagBodyLength) {
+		return nil, errFixGarbled
+	}
+	n, err := strconv.Atoi(string(value))
+	if err != nil || n <= 0 || n > 1<<16 {
+		return nil, errFixGarbled
+	}
+
+	// body and the 7 bytes of the CheckSum field
+	rest := make([]byte, n+7)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+	body, trailer := rest[:n], rest[n:]
+	sum := fixChecksum(begin) + fixChecksum(length) + fixChecksum(body)
+	if string(trailer) != fmt.Sprintf("%d=%03d%c", tagCheckSum, sum%256, fixSOH) {
+		return nil, errFixGarbled
+	}
+	return parseFixBody(body)
+}
+
+func parseFixBody(body []byte) (*fixMessage, error) {
+	m := &fixMessage{}
+	for len(body) > 0 {
+		field, rest, ok := bytes.Cut(body, []byte{fixSOH})
+		if !ok {
+			return nil, errFixGarbled
+		}
+		tag, value, ok := bytes.Cut(field, []byte("="))
+		if !ok {
+			return nil, errFixGarbled
+		}
+		t, err := strconv.Atoi(string(tag))
+		if err != nil {
+			return nil, errFixGarbled
+		}
+		m.fields = append(m.fields, fixField{t, string(value)})
+		body = rest
+	}
+	if len(m.fields) == 0 || m.fields[0].tag != tagMsgType {
+		return nil, errFixGarbled
+	}
+	return m, nil
+}
@@ -0,0 +1,340 @@
+package engine
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"matching-engine-go/orders"
+)
+
+// in-process FIX initiator
+type fixClient struct {
+	t      *testing.T
+	compID string
+	conn   net.Conn
+	r      *bufio.Reader
+	seq    int
+}
+
+func newFixGateway(t *testing.T, config FixConfig) (*FixGateway, *Engine, *ManualClock) {
+	clock := NewManualClock(time.Unix(0, 0))
+	e := NewEngine(EngineConfig{Clock: clock})
+	e.AddInstrument("BTC")
+	config.SenderCompID = "ENGINE"
+	config.Clock = clock
+	g := NewFixGateway(e, config)
+	if err := g.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { g.Close() })
+	return g, e, clock
+}
+
+func dialFix(t *testing.T, g *FixGateway, compID string) *fixClient {
+	conn, err := net.Dial("tcp", g.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &fixClient{t: t, compID: compID, conn: conn, r: bufio.NewReader(conn), seq: 1}
+}
+
+// logs on as compID and waits for the Logon reply
+func logonFix(t *testing.T, g *FixGateway, compID string) *fixClient {
+	c := dialFix(t, g, compID)
+	c.send(newFixMessage(msgLogon).SetInt(tagEncryptMethod, 0).SetInt(tagHeartBtInt, 30))
+	c.expect(msgLogon)
+	return c
+}
+
+func (c *fixClient) send(m *fixMessage) {
+	c.sendSeq(m, c.seq)
+	c.seq++
+}
+
+func (c *fixClient) sendSeq(m *fixMessage, seq int) {
+	m.Set(tagSenderCompID, c.compID).Set(tagTargetCompID, "ENGINE").SetInt(tagMsgSeqNum, seq).
+		SetTime(tagSendingTime, time.Now())
+	if _, err := c.conn.Write(m.encode()); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *fixClient) read() *fixMessage {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	m, err := readFixMessage(c.r)
+	if err != nil {
+		c.t.Fatalf("reading from the gateway: %v", err)
+	}
+	return m
+}
+
+func (c *fixClient) expect(msgType string) *fixMessage {
+	c.t.Helper()
+	m := c.read()
+	if m.MsgType() != msgType {
+		c.t.Fatalf("expected MsgType %s, got %s", msgType, m.MsgType())
+	}
+	return m
+}
+
+// reads an ExecutionReport and checks its ExecType, OrdStatus and LeavesQty
+func (c *fixClient) expectReport(execType string, status string, leaves float32) *fixMessage {
+	c.t.Helper()
+	m := c.expect(msgExecutionReport)
+	gotType, _ := m.Get(tagExecType)
+	gotStatus, _ := m.Get(tagOrdStatus)
+	gotLeaves, _ := m.GetFloat(tagLeavesQty)
+	if gotType != execType || gotStatus != status || gotLeaves != leaves {
+		c.t.Fatalf("expected ExecType %s OrdStatus %s LeavesQty %v, got %s %s %v",
+			execType, status, leaves, gotType, gotStatus, gotLeaves)
+	}
+	return m
+}
+
+func (c *fixClient) expectClosed() {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		m, err := readFixMessage(c.r)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				c.t.Fatal("the gateway should have dropped the connection")
+			}
+			return
+		}
+		if m.MsgType() != msgHeartbeat && m.MsgType() != msgTestRequest && m.MsgType() != msgLogout {
+			c.t.Fatalf("unexpected MsgType %s before disconnect", m.MsgType())
+		}
+	}
+}
+
+func newOrderSingle(clOrdID string, side string, quantity string, price string) *fixMessage {
+	m := newFixMessage(msgNewOrderSingle).Set(tagClOrdID, clOrdID).Set(tagSymbol, "BTC").
+		Set(tagSide, side).Set(tagOrderQty, quantity)
+	if price == "" {
+		return m.Set(tagOrdType, "1")
+	}
+	return m.Set(tagOrdType, "2").Set(tagPrice, price)
+}
+
+func TestFixLogonRefused(t *testing.T) {
+	g, _, _ := newFixGateway(t, FixConfig{})
+	c := dialFix(t, g, "CLIENT")
+	m := newFixMessage(msgLogon).SetInt(tagEncryptMethod, 0).SetInt(tagHeartBtInt, 30)
+	m.Set(tagSenderCompID, "CLIENT").Set(tagTargetCompID, "SOMEONE").SetInt(tagMsgSeqNum, 1)
+	c.conn.Write(m.encode())
+	c.expectClosed()
+}
+
+func TestFixHeartbeat(t *testing.T) {
+	g, _, clock := newFixGateway(t, FixConfig{})
+	c := logonFix(t, g, "CLIENT")
+
+	c.send(newFixMessage(msgTestRequest).Set(tagTestReqID, "ping"))
+	if id, _ := c.expect(msgHeartbeat).Get(tagTestReqID); id != "ping" {
+		t.Errorf("the Heartbeat should answer the TestRequest, got TestReqID %q", id)
+	}
+
+	clock.Advance(30 * time.Second)
+	c.expect(msgHeartbeat)
+	id, _ := c.expect(msgTestRequest).Get(tagTestReqID)
+	c.send(newFixMessage(msgHeartbeat).Set(tagTestReqID, id))
+
+	// silent for two intervals with a TestRequest pending
+	clock.Advance(30 * time.Second)
+	clock.Advance(30 * time.Second)
+	clock.Advance(30 * time.Second)
+	c.expectClosed()
+}
+
+func TestFixOrderLifecycle(t *testing.T) {
+	g, _, _ := newFixGateway(t, FixConfig{})
+	maker := logonFix(t, g, "MAKER")
+	taker := logonFix(t, g, "TAKER")
+
+	maker.send(newOrderSingle("m1", "2", "10", "100"))
+	ack := maker.expectReport(fixExecNew, fixStatusNew, 10)
+	if id, _ := ack.Get(tagClOrdID); id != "m1" {
+		t.Errorf("the ack should carry the ClOrdID, got %q", id)
+	}
+
+	taker.send(newOrderSingle("t1", "1", "4", "101"))
+	taker.expectReport(fixExecNew, fixStatusNew, 4)
+	fill := taker.expectReport(fixExecTrade, fixStatusFilled, 0)
+	if px, _ := fill.GetFloat(tagLastPx); px != 100 {
+		t.Errorf("the fill should be at the resting price, got %v", px)
+	}
+	fill = maker.expectReport(fixExecTrade, fixStatusPartiallyFilled, 6)
+	if qty, _ := fill.GetFloat(tagCumQty); qty != 4 {
+		t.Errorf("CumQty of the maker should be 4, got %v", qty)
+	}
+
+	// OrderQty counts the 4 already filled
+	maker.send(newFixMessage(msgOrderCancelReplace).Set(tagClOrdID, "m2").Set(tagOrigClOrdID, "m1").
+		Set(tagOrderQty, "4").Set(tagPrice, "102"))
+	maker.expect(msgOrderCancelReject)
+	maker.send(newFixMessage(msgOrderCancelReplace).Set(tagClOrdID, "m2").Set(tagOrigClOrdID, "m1").
+		Set(tagOrderQty, "8").Set(tagPrice, "102"))
+	replaced := maker.expectReport(fixExecReplaced, fixStatusPartiallyFilled, 4)
+	if orig, _ := replaced.Get(tagOrigClOrdID); orig != "m1" {
+		t.Errorf("the replace report should refer to m1, got %q", orig)
+	}
+	if qty, _ := replaced.GetFloat(tagCumQty); qty != 4 {
+		t.Errorf("the replacement should keep CumQty 4, got %v", qty)
+	}
+	if px, _ := replaced.GetFloat(tagPrice); px != 102 {
+		t.Errorf("the replacement should rest at 102, got %v", px)
+	}
+
+	maker.send(newFixMessage(msgOrderCancelRequest).Set(tagClOrdID, "c1").Set(tagOrigClOrdID, "m1").
+		Set(tagSymbol, "BTC").Set(tagSide, "2"))
+	reject := maker.expect(msgOrderCancelReject)
+	if to, _ := reject.Get(tagCxlRejResponseTo); to != "1" {
+		t.Errorf("cancelling the replaced order should be rejected, got CxlRejResponseTo %q", to)
+	}
+
+	maker.send(newFixMessage(msgOrderCancelRequest).Set(tagClOrdID, "c2").Set(tagOrigClOrdID, "m2").
+		Set(tagSymbol, "BTC").Set(tagSide, "2"))
+	cancelled := maker.expectReport(fixExecCanceled, fixStatusCanceled, 0)
+	id, _ := cancelled.Get(tagClOrdID)
+	orig, _ := cancelled.Get(tagOrigClOrdID)
+	if id != "c2" || orig != "m2" {
+		t.Errorf("the cancel report should carry ClOrdID c2 and OrigClOrdID m2, got %q %q", id, orig)
+	}
+}
+
+func TestFixRejects(t *testing.T) {
+	g, _, _ := newFixGateway(t, FixConfig{})
+	c := logonFix(t, g, "CLIENT")
+
+	c.send(newOrderSingle("o1", "1", "1", "10").Set(tagSymbol, "XYZ"))
+	report := c.expectReport(fixExecRejected, fixStatusRejected, 0)
+	if reason, _ := report.GetInt(tagOrdRejReason); reason != 1 {
+		t.Errorf("an unknown symbol should be rejected with OrdRejReason 1, got %d", reason)
+	}
+
+	c.send(newOrderSingle("o2", "1", "-1", "10"))
+	c.expectReport(fixExecRejected, fixStatusRejected, 0)
+	for i, fields := range [][2]string{{"NaN", "10"}, {"1", "NaN"}, {"Inf", "10"}, {"1", "-Inf"}, {"1", "0"}} {
+		c.send(newOrderSingle("bad"+strconv.Itoa(i), "1", fields[0], fields[1]))
+		c.expectReport(fixExecRejected, fixStatusRejected, 0)
+	}
+
+	c.send(newFixMessage(msgNewOrderSingle).Set(tagClOrdID, "o5").Set(tagSymbol, "BTC").
+		Set(tagSide, "1").Set(tagOrderQty, "1").Set(tagPrice, "10"))
+	if text, _ := c.expectReport(fixExecRejected, fixStatusRejected, 0).Get(tagText); text != "OrdType is required" {
+		t.Errorf("an order without OrdType should be rejected, got %q", text)
+	}
+
+	c.send(newOrderSingle("o3", "1", "1", "10"))
+	c.expectReport(fixExecNew, fixStatusNew, 1)
+	c.send(newOrderSingle("o3", "1", "1", "10"))
+	c.expectReport(fixExecRejected, fixStatusRejected, 0)
+
+	// the maker side of a fill is reported as it happens, before the taker is acknowledged
+	c.send(newOrderSingle("o4", "2", "1", ""))
+	if id, _ := c.expectReport(fixExecTrade, fixStatusFilled, 0).Get(tagClOrdID); id != "o3" {
+		t.Errorf("the resting order should fill first, got %q", id)
+	}
+	c.expectReport(fixExecNew, fixStatusNew, 1)
+	c.expectReport(fixExecTrade, fixStatusFilled, 0)
+
+	c.send(newFixMessage("Z"))
+	c.expect(msgReject)
+}
+
+func TestFixSequenceRecovery(t *testing.T) {
+	g, e, _ := newFixGateway(t, FixConfig{})
+	maker := logonFix(t, g, "MAKER")
+	maker.send(newOrderSingle("m1", "2", "10", "100"))
+	maker.expectReport(fixExecNew, fixStatusNew, 10)
+
+	maker.send(newFixMessage(msgLogout))
+	maker.expect(msgLogout)
+	maker.expectClosed()
+
+	// fills while the maker is away
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(100, 3, true, orders.LIMIT, 9)); err != nil {
+		t.Fatal(err)
+	}
+
+	c := dialFix(t, g, "MAKER")
+	c.seq = maker.seq
+	c.send(newFixMessage(msgLogon).SetInt(tagEncryptMethod, 0).SetInt(tagHeartBtInt, 30))
+	logon := c.expect(msgLogon)
+	// Logon 1, ack 2, Logout 3, fill 4, Logon 5
+	if seq, _ := logon.GetInt(tagMsgSeqNum); seq != 5 {
+		t.Fatalf("sequence numbers should carry over the reconnect, got %d", seq)
+	}
+
+	c.send(newFixMessage(msgResendRequest).SetInt(tagBeginSeqNo, 1).SetInt(tagEndSeqNo, 0))
+	gap := c.expect(msgSequenceReset)
+	if next, _ := gap.GetInt(tagNewSeqNo); next != 2 || !gap.GetBool(tagGapFillFlag) {
+		t.Errorf("the Logon should be skipped with a GapFill to 2, got %d", next)
+	}
+	ack := c.expectReport(fixExecNew, fixStatusNew, 10)
+	if !ack.GetBool(tagPossDupFlag) {
+		t.Errorf("resent reports should be flagged PossDup")
+	}
+	c.expect(msgSequenceReset)
+	fill := c.expectReport(fixExecTrade, fixStatusPartiallyFilled, 7)
+	if seq, _ := fill.GetInt(tagMsgSeqNum); seq != 4 || !fill.GetBool(tagPossDupFlag) {
+		t.Errorf("the missed fill should be resent as 4, got %d", seq)
+	}
+	if next, _ := c.expect(msgSequenceReset).GetInt(tagNewSeqNo); next != 6 {
+		t.Errorf("the resend should end with a GapFill to 6, got %d", next)
+	}
+
+	// a gap from the client side is asked back
+	c.sendSeq(newFixMessage(msgHeartbeat), c.seq+2)
+	resend := c.expect(msgResendRequest)
+	if begin, _ := resend.GetInt(tagBeginSeqNo); begin != c.seq {
+		t.Errorf("the gateway should ask from %d, got %d", c.seq, begin)
+	}
+	c.sendSeq(newFixMessage(msgSequenceReset).Set(tagGapFillFlag, "Y").SetInt(tagNewSeqNo, c.seq+3).
+		Set(tagPossDupFlag, "Y"), c.seq)
+	c.seq += 3
+	c.send(newFixMessage(msgTestRequest).Set(tagTestReqID, "after gap"))
+	if id, _ := c.expect(msgHeartbeat).Get(tagTestReqID); id != "after gap" {
+		t.Errorf("messages after the gap fill should be processed, got TestReqID %q", id)
+	}
+}
+
+func TestFixCancelOnDisconnect(t *testing.T) {
+	g, e, clock := newFixGateway(t, FixConfig{CancelOnDisconnect: true, DisconnectGrace: time.Second})
+	c := logonFix(t, g, "CLIENT")
+	c.send(newOrderSingle("o1", "1", "5", "10"))
+	c.expectReport(fixExecNew, fixStatusNew, 5)
+
+	c.conn.Close()
+	book, _ := e.Book("BTC")
+	deadline := time.Now().Add(5 * time.Second)
+	for e.IsConnected(1) {
+		if time.Now().After(deadline) {
+			t.Fatal("the engine session should drop with the connection")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if book.BLength() != 1 {
+		t.Fatalf("the order should survive the grace period")
+	}
+	clock.Advance(time.Second)
+	if book.BLength() != 0 {
+		t.Errorf("the order should be cancelled once the grace period expires")
+	}
+
+	c = dialFix(t, g, "CLIENT")
+	c.seq = 3
+	c.send(newFixMessage(msgLogon).SetInt(tagEncryptMethod, 0).SetInt(tagHeartBtInt, 30))
+	c.expect(msgLogon)
+	c.send(newFixMessage(msgResendRequest).SetInt(tagBeginSeqNo, 3).SetInt(tagEndSeqNo, 0))
+	report := c.expectReport(fixExecCanceled, fixStatusCanceled, 0)
+	if id, _ := report.Get(tagClOrdID); id != "o1" {
+		t.Errorf("the cancel-on-disconnect report should be recoverable, got ClOrdID %q", id)
+	}
+}
@@ -0,0 +1,399 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"matching-engine-go/book"
+	"matching-engine-go/enginepb"
+	"matching-engine-go/orders"
+)
+
+// updates buffered per stream before it is dropped as a slow consumer
+const grpcStreamBuffer = 1024
+
+var errGrpcSlowConsumer = status.Error(codes.ResourceExhausted, "stream fell behind the engine")
+
+// GrpcServer implements the MatchingEngine service of enginepb on top of the Engine.
+// Streams are fed from the engine events, a stream that falls behind is ended with
+// ResourceExhausted rather than holding up the matching. The response headers of a
+// stream are sent once it is subscribed, clients can wait on them before trading.
+type GrpcServer struct {
+	enginepb.UnimplementedMatchingEngineServer
+	engine *Engine
+
+	mu sync.Mutex
+	// market data and book event streams by symbol, execution reports by account
+	market  map[string]map[*grpcStream]struct{}
+	events  map[string]map[*grpcStream]struct{}
+	reports map[uint32]map[*grpcStream]struct{}
+}
+
+// one server stream
+type grpcStream struct {
+	out chan interface{}
+	// depth changed, coalesced until the sender gets to it
+	notify chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func NewGrpcServer(engine *Engine) *GrpcServer {
+	s := &GrpcServer{
+		engine:  engine,
+		market:  make(map[string]map[*grpcStream]struct{}),
+		events:  make(map[string]map[*grpcStream]struct{}),
+		reports: make(map[uint32]map[*grpcStream]struct{}),
+	}
+	engine.Subscribe(s.onEvent)
+	return s
+}
+
+// registers the service on a grpc.Server
+func (s *GrpcServer) Register(server *grpc.Server) {
+	enginepb.RegisterMatchingEngineServer(server, s)
+}
+
+func (s *GrpcServer) SubmitOrder(ctx context.Context, req *enginepb.SubmitOrderRequest) (*enginepb.OrderResponse, error) {
+	incoming, err := grpcIncoming(req)
+	if err != nil {
+		return nil, err
+	}
+	o, err := s.engine.Submit(req.Symbol, incoming)
+	if o.SequenceId == 0 {
+		return nil, grpcError(err)
+	}
+	return s.orderResponse(req.Symbol, o), nil
+}
+
+func (s *GrpcServer) CancelOrder(ctx context.Context, req *enginepb.CancelOrderRequest) (*enginepb.OrderResponse, error) {
+	o, err := s.engine.Cancel(req.Symbol, req.OrderId)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &enginepb.OrderResponse{Order: grpcOrder(req.Symbol, o, false)}, nil
+}
+
+func (s *GrpcServer) ReplaceOrder(ctx context.Context, req *enginepb.ReplaceOrderRequest) (*enginepb.OrderResponse, error) {
+	// the quantity counts what the order has executed, as in FIX
+	o, err := s.engine.replace(req.Symbol, req.OrderId, func(old orders.Order) orders.IncomingOrder {
+		incoming := old.Order
+		incoming.Price = req.Price
+		incoming.Quantity = req.Quantity - old.ExecutedQuantity
+		return incoming
+	})
+	if o.SequenceId == 0 {
+		return nil, grpcError(err)
+	}
+	return s.orderResponse(req.Symbol, o), nil
+}
+
+func (s *GrpcServer) GetDepth(ctx context.Context, req *enginepb.GetDepthRequest) (*enginepb.DepthSnapshot, error) {
+	depth, err := s.depth(req.Symbol, int(req.Levels))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return depth, nil
+}
+
+func (s *GrpcServer) StreamMarketData(req *enginepb.StreamMarketDataRequest, stream enginepb.MatchingEngine_StreamMarketDataServer) error {
+	if _, err := s.depth(req.Symbol, 1); err != nil {
+		return grpcError(err)
+	}
+	c := newGrpcStream()
+	s.register(func() { addStream(s.market, req.Symbol, c) })
+	defer s.unregister(func() { removeStream(s.market, req.Symbol, c) })
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	// the first snapshot goes out straight away
+	c.notify <- struct{}{}
+	return c.run(stream.Context(), func(update interface{}) error {
+		if update == nil {
+			depth, err := s.depth(req.Symbol, int(req.Levels))
+			if err != nil {
+				return grpcError(err)
+			}
+			update = &enginepb.MarketDataUpdate{Update: &enginepb.MarketDataUpdate_Depth{Depth: depth}}
+		}
+		return stream.Send(update.(*enginepb.MarketDataUpdate))
+	})
+}
+
+func (s *GrpcServer) StreamBookEvents(req *enginepb.StreamBookEventsRequest, stream enginepb.MatchingEngine_StreamBookEventsServer) error {
+	if _, err := s.depth(req.Symbol, 1); err != nil {
+		return grpcError(err)
+	}
+	c := newGrpcStream()
+	s.register(func() { addStream(s.events, req.Symbol, c) })
+	defer s.unregister(func() { removeStream(s.events, req.Symbol, c) })
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	return c.run(stream.Context(), func(update interface{}) error {
+		return stream.Send(update.(*enginepb.BookEvent))
+	})
+}
+
+func (s *GrpcServer) StreamExecutionReports(req *enginepb.StreamExecutionReportsRequest, stream enginepb.MatchingEngine_StreamExecutionReportsServer) error {
+	c := newGrpcStream()
+	s.register(func() { addStream(s.reports, req.AccountId, c) })
+	defer s.unregister(func() { removeStream(s.reports, req.AccountId, c) })
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+
+	return c.run(stream.Context(), func(update interface{}) error {
+		return stream.Send(update.(*enginepb.ExecutionReport))
+	})
+}
+
+func (s *GrpcServer) orderResponse(symbol string, o orders.Order) *enginepb.OrderResponse {
+	resting := false
+	s.engine.View(symbol, func(book *book.Orderbook) {
+		_, resting = book.Order(o.SequenceId)
+	})
+	return &enginepb.OrderResponse{Order: grpcOrder(symbol, o, resting)}
+}
+
+func (s *GrpcServer) depth(symbol string, levels int) (*enginepb.DepthSnapshot, error) {
+	depth := &enginepb.DepthSnapshot{Symbol: symbol}
+	err := s.engine.View(symbol, func(book *book.Orderbook) {
+		depth.Bids = grpcLevels(book.Depth(true, levels))
+		depth.Asks = grpcLevels(book.Depth(false, levels))
+	})
+	return depth, err
+}
+
+func (s *GrpcServer) register(add func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	add()
+}
+
+func (s *GrpcServer) unregister(remove func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remove()
+}
+
+func addStream[K comparable](streams map[K]map[*grpcStream]struct{}, key K, c *grpcStream) {
+	set, ok := streams[key]
+	if !ok {
+		set = make(map[*grpcStream]struct{})
+		streams[key] = set
+	}
+	set[c] = struct{}{}
+}
+
+func removeStream[K comparable](streams map[K]map[*grpcStream]struct{}, key K, c *grpcStream) {
+	delete(streams[key], c)
+	if len(streams[key]) == 0 {
+		delete(streams, key)
+	}
+}
+
+// engine events, delivered with the engine locked
+func (s *GrpcServer) onEvent(ev book.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.market[ev.Symbol] {
+		select {
+		case c.notify <- struct{}{}:
+		default:
+			// a snapshot is due already
+		}
+	}
+	if ev.Type == book.EventFill && len(s.market[ev.Symbol]) > 0 {
+		trade := &enginepb.MarketDataUpdate{Update: &enginepb.MarketDataUpdate_Trade{Trade: &enginepb.Fill{
+			Symbol:        ev.Symbol,
+			Price:         ev.Price,
+			Quantity:      ev.Quantity,
+			AggressorSide: grpcSide(ev.BidOrAsk),
+			TakerOrderId:  ev.SequenceId,
+			MakerOrderId:  ev.MakerSequenceId,
+		}}}
+		for c := range s.market[ev.Symbol] {
+			c.send(trade)
+		}
+	}
+
+	if len(s.events[ev.Symbol]) > 0 {
+		event := &enginepb.BookEvent{
+			Type:           grpcEventType(ev.Type),
+			Symbol:         ev.Symbol,
+			OrderId:        ev.SequenceId,
+			AccountId:      ev.AccountId,
+			Side:           grpcSide(ev.BidOrAsk),
+			Price:          ev.Price,
+			Quantity:       ev.Quantity,
+			Remaining:      ev.Remaining,
+			MakerOrderId:   ev.MakerSequenceId,
+			MakerAccountId: ev.MakerAccountId,
+			MakerRemaining: ev.MakerRemaining,
+		}
+		for c := range s.events[ev.Symbol] {
+			c.send(event)
+		}
+	}
+
+	if streams := s.reports[ev.AccountId]; len(streams) > 0 {
+		report := &enginepb.ExecutionReport{
+			Type:      grpcEventType(ev.Type),
+			Symbol:    ev.Symbol,
+			OrderId:   ev.SequenceId,
+			Side:      grpcSide(ev.BidOrAsk),
+			Price:     ev.Price,
+			Quantity:  ev.Quantity,
+			Remaining: ev.Remaining,
+		}
+		if ev.Type == book.EventFill {
+			report.Liquidity = enginepb.Liquidity_LIQUIDITY_TAKER
+		}
+		for c := range streams {
+			c.send(report)
+		}
+	}
+	if ev.Type != book.EventFill {
+		return
+	}
+	if streams := s.reports[ev.MakerAccountId]; len(streams) > 0 {
+		report := &enginepb.ExecutionReport{
+			Type:      enginepb.EventType_EVENT_TYPE_FILL,
+			Symbol:    ev.Symbol,
+			OrderId:   ev.MakerSequenceId,
+			Side:      grpcSide(!ev.BidOrAsk),
+			Price:     ev.Price,
+			Quantity:  ev.Quantity,
+			Remaining: ev.MakerRemaining,
+			Liquidity: enginepb.Liquidity_LIQUIDITY_MAKER,
+		}
+		for c := range streams {
+			c.send(report)
+		}
+	}
+}
+
+func newGrpcStream() *grpcStream {
+	return &grpcStream{
+		out:    make(chan interface{}, grpcStreamBuffer),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (c *grpcStream) send(update interface{}) {
+	select {
+	case c.out <- update:
+	default:
+		// slow consumer
+		c.once.Do(func() { close(c.done) })
+	}
+}
+
+// sends the queued updates until the client goes away, send gets nil when a fresh
+// depth snapshot is due
+func (c *grpcStream) run(ctx context.Context, send func(update interface{}) error) error {
+	for {
+		var update interface{}
+		select {
+		case update = <-c.out:
+		case <-c.notify:
+		case <-c.done:
+			return errGrpcSlowConsumer
+		case <-ctx.Done():
+			return nil
+		}
+		if err := send(update); err != nil {
+			return err
+		}
+	}
+}
+
+func grpcIncoming(req *enginepb.SubmitOrderRequest) (orders.IncomingOrder, error) {
+	var bidOrAsk bool
+	switch req.Side {
+	case enginepb.Side_SIDE_BUY:
+		bidOrAsk = true
+	case enginepb.Side_SIDE_SELL:
+	default:
+		return orders.IncomingOrder{}, status.Error(codes.InvalidArgument, "side is required")
+	}
+	orderType := orders.LIMIT
+	if req.Type == enginepb.OrderType_ORDER_TYPE_MARKET {
+		orderType = orders.MARKET
+	}
+	return orders.NewIncomingOrder(req.Price, req.Quantity, bidOrAsk, orderType, req.AccountId), nil
+}
+
+// status of an engine error
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, ErrUnknownSymbol), errors.Is(err, ErrUnknownOrder):
+		code = codes.NotFound
+	case errors.Is(err, ErrInvalidQuantity), errors.Is(err, ErrInvalidPrice):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrAccountKilled):
+		code = codes.PermissionDenied
+	case errors.Is(err, ErrRiskOrderSize), errors.Is(err, ErrRiskPosition), errors.Is(err, ErrRiskExposure),
+		errors.Is(err, ErrRiskBalance), errors.Is(err, ErrSessionNotConnected), errors.Is(err, book.ErrMemoryBudget):
+		code = codes.FailedPrecondition
+	}
+	return status.Error(code, err.Error())
+}
+
+func grpcOrder(symbol string, o orders.Order, resting bool) *enginepb.Order {
+	orderType := enginepb.OrderType_ORDER_TYPE_LIMIT
+	if o.Order.OrderType == orders.MARKET {
+		orderType = enginepb.OrderType_ORDER_TYPE_MARKET
+	}
+	return &enginepb.Order{
+		Id:               o.SequenceId,
+		Symbol:           symbol,
+		Side:             grpcSide(o.Order.BidOrAsk),
+		Type:             orderType,
+		Price:            o.Order.Price,
+		Quantity:         o.Order.Quantity,
+		ExecutedQuantity: o.ExecutedQuantity,
+		AccountId:        o.Order.AccountId,
+		Resting:          resting,
+	}
+}
+
+func grpcSide(bidOrAsk bool) enginepb.Side {
+	if bidOrAsk {
+		return enginepb.Side_SIDE_BUY
+	}
+	return enginepb.Side_SIDE_SELL
+}
+
+func grpcEventType(t book.EventType) enginepb.EventType {
+	switch t {
+	case book.EventAdded:
+		return enginepb.EventType_EVENT_TYPE_ADDED
+	case book.EventFill:
+		return enginepb.EventType_EVENT_TYPE_FILL
+	case book.EventCancelled:
+		return enginepb.EventType_EVENT_TYPE_CANCELLED
+	}
+	return enginepb.EventType_EVENT_TYPE_UNSPECIFIED
+}
+
+func grpcLevels(levels []book.PriceLevel) []*enginepb.PriceLevel {
+	out := make([]*enginepb.PriceLevel, len(levels))
+	for i, l := range levels {
+		out[i] = &enginepb.PriceLevel{Price: l.Price, Volume: l.Volume, Orders: int32(l.Orders)}
+	}
+	return out
+}
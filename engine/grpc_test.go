@@ -0,0 +1,221 @@
+package engine
+
+import (
+	"context"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"matching-engine-go/enginepb"
+)
+
+func newGrpcClient(t *testing.T) (enginepb.MatchingEngineClient, *Engine) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	NewGrpcServer(e).Register(server)
+	go server.Serve(listener)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+	})
+	return enginepb.NewMatchingEngineClient(conn), e
+}
+
+func grpcContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestGrpcOrders(t *testing.T) {
+	client, _ := newGrpcClient(t)
+	ctx := grpcContext(t)
+
+	resting, err := client.SubmitOrder(ctx, &enginepb.SubmitOrderRequest{
+		Symbol: "BTC", Side: enginepb.Side_SIDE_SELL, Type: enginepb.OrderType_ORDER_TYPE_LIMIT,
+		Price: 100, Quantity: 5, AccountId: 1,
+	})
+	if err != nil || resting.Order.Id == 0 || !resting.Order.Resting {
+		t.Fatalf("the order should rest, got %v %v", resting, err)
+	}
+
+	taker, err := client.SubmitOrder(ctx, &enginepb.SubmitOrderRequest{
+		Symbol: "BTC", Side: enginepb.Side_SIDE_BUY, Type: enginepb.OrderType_ORDER_TYPE_MARKET,
+		Quantity: 2, AccountId: 2,
+	})
+	if err != nil || taker.Order.ExecutedQuantity != 2 || taker.Order.Resting {
+		t.Errorf("the market order should fill, got %v %v", taker, err)
+	}
+
+	// 2 of the 5 executed, 3 are left
+	_, err = client.ReplaceOrder(ctx, &enginepb.ReplaceOrderRequest{
+		Symbol: "BTC", OrderId: resting.Order.Id, Price: 101, Quantity: 2,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("a replace for no more than was executed should fail, got %v", err)
+	}
+	replaced, err := client.ReplaceOrder(ctx, &enginepb.ReplaceOrderRequest{
+		Symbol: "BTC", OrderId: resting.Order.Id, Price: 101, Quantity: 5,
+	})
+	if err != nil || replaced.Order.Id == resting.Order.Id || replaced.Order.Side != enginepb.Side_SIDE_SELL ||
+		replaced.Order.AccountId != 1 || !replaced.Order.Resting {
+		t.Fatalf("the replacement should keep side and account, got %v %v", replaced, err)
+	}
+
+	depth, err := client.GetDepth(ctx, &enginepb.GetDepthRequest{Symbol: "BTC", Levels: 5})
+	if err != nil || len(depth.Bids) != 0 || len(depth.Asks) != 1 ||
+		depth.Asks[0].Price != 101 || depth.Asks[0].Volume != 3 || depth.Asks[0].Orders != 1 {
+		t.Errorf("unexpected depth %v %v", depth, err)
+	}
+
+	cancelled, err := client.CancelOrder(ctx, &enginepb.CancelOrderRequest{Symbol: "BTC", OrderId: replaced.Order.Id})
+	if err != nil || cancelled.Order.Id != replaced.Order.Id || cancelled.Order.Resting {
+		t.Errorf("the order should be cancelled, got %v %v", cancelled, err)
+	}
+}
+
+func TestGrpcErrors(t *testing.T) {
+	client, e := newGrpcClient(t)
+	ctx := grpcContext(t)
+	e.Kill(9)
+
+	limit := func(symbol string, quantity float32, account uint32) *enginepb.SubmitOrderRequest {
+		return &enginepb.SubmitOrderRequest{Symbol: symbol, Side: enginepb.Side_SIDE_BUY, Price: 1, Quantity: quantity, AccountId: account}
+	}
+	cases := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"unknown symbol", func() error { _, err := client.SubmitOrder(ctx, limit("XYZ", 1, 1)); return err }, codes.NotFound},
+		{"no quantity", func() error { _, err := client.SubmitOrder(ctx, limit("BTC", 0, 1)); return err }, codes.InvalidArgument},
+		{"NaN quantity", func() error {
+			_, err := client.SubmitOrder(ctx, limit("BTC", float32(math.NaN()), 1))
+			return err
+		}, codes.InvalidArgument},
+		{"infinite price", func() error {
+			_, err := client.SubmitOrder(ctx, &enginepb.SubmitOrderRequest{Symbol: "BTC", Side: enginepb.Side_SIDE_BUY,
+				Price: float32(math.Inf(1)), Quantity: 1})
+			return err
+		}, codes.InvalidArgument},
+		{"limit at 0", func() error {
+			_, err := client.SubmitOrder(ctx, &enginepb.SubmitOrderRequest{Symbol: "BTC", Side: enginepb.Side_SIDE_BUY, Quantity: 1})
+			return err
+		}, codes.InvalidArgument},
+		{"no side", func() error {
+			_, err := client.SubmitOrder(ctx, &enginepb.SubmitOrderRequest{Symbol: "BTC", Price: 1, Quantity: 1})
+			return err
+		}, codes.InvalidArgument},
+		{"killed account", func() error { _, err := client.SubmitOrder(ctx, limit("BTC", 1, 9)); return err }, codes.PermissionDenied},
+		{"unknown order", func() error {
+			_, err := client.CancelOrder(ctx, &enginepb.CancelOrderRequest{Symbol: "BTC", OrderId: 12345})
+			return err
+		}, codes.NotFound},
+		{"replace unknown order", func() error {
+			_, err := client.ReplaceOrder(ctx, &enginepb.ReplaceOrderRequest{Symbol: "BTC", OrderId: 12345, Price: 1, Quantity: 1})
+			return err
+		}, codes.NotFound},
+		{"depth of unknown symbol", func() error {
+			_, err := client.GetDepth(ctx, &enginepb.GetDepthRequest{Symbol: "XYZ"})
+			return err
+		}, codes.NotFound},
+	}
+	for _, c := range cases {
+		if code := status.Code(c.call()); code != c.code {
+			t.Errorf("%s should fail with %v, got %v", c.name, c.code, code)
+		}
+	}
+}
+
+func TestGrpcStreams(t *testing.T) {
+	client, _ := newGrpcClient(t)
+	ctx := grpcContext(t)
+
+	market, err := client.StreamMarketData(ctx, &enginepb.StreamMarketDataRequest{Symbol: "BTC", Levels: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update, err := market.Recv()
+	if err != nil || update.GetDepth() == nil || len(update.GetDepth().Bids) != 0 {
+		t.Fatalf("the first update should be an empty snapshot, got %v %v", update, err)
+	}
+	events, err := client.StreamBookEvents(ctx, &enginepb.StreamBookEventsRequest{Symbol: "BTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, err := client.StreamExecutionReports(ctx, &enginepb.StreamExecutionReportsRequest{AccountId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stream := range []grpc.ClientStream{events, reports} {
+		if _, err := stream.Header(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resting, err := client.SubmitOrder(ctx, &enginepb.SubmitOrderRequest{
+		Symbol: "BTC", Side: enginepb.Side_SIDE_BUY, Price: 99, Quantity: 4, AccountId: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := events.Recv()
+	if err != nil || event.Type != enginepb.EventType_EVENT_TYPE_ADDED || event.OrderId != resting.Order.Id || event.AccountId != 1 {
+		t.Errorf("the book should see the order added, got %v %v", event, err)
+	}
+	report, err := reports.Recv()
+	if err != nil || report.Type != enginepb.EventType_EVENT_TYPE_ADDED || report.OrderId != resting.Order.Id || report.Remaining != 4 {
+		t.Fatalf("the owner should see the order added, got %v %v", report, err)
+	}
+
+	if _, err := client.SubmitOrder(ctx, &enginepb.SubmitOrderRequest{
+		Symbol: "BTC", Side: enginepb.Side_SIDE_SELL, Price: 99, Quantity: 1, AccountId: 2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	report, err = reports.Recv()
+	if err != nil || report.Type != enginepb.EventType_EVENT_TYPE_FILL || report.Liquidity != enginepb.Liquidity_LIQUIDITY_MAKER ||
+		report.OrderId != resting.Order.Id || report.Remaining != 3 || report.Side != enginepb.Side_SIDE_BUY {
+		t.Errorf("the owner should see the fill as maker, got %v %v", report, err)
+	}
+
+	var trade *enginepb.Fill
+	var depth *enginepb.DepthSnapshot
+	for trade == nil || depth == nil || depth.Bids[0].Volume != 3 {
+		update, err := market.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if update.GetTrade() != nil {
+			trade = update.GetTrade()
+		}
+		if d := update.GetDepth(); d != nil && len(d.Bids) > 0 {
+			depth = d
+		}
+	}
+	if trade.Price != 99 || trade.Quantity != 1 || trade.AggressorSide != enginepb.Side_SIDE_SELL || trade.MakerOrderId != resting.Order.Id {
+		t.Errorf("unexpected trade %v", trade)
+	}
+	if depth.Bids[0].Price != 99 || len(depth.Bids) != 1 {
+		t.Errorf("the depth should show the remaining bid, got %v", depth)
+	}
+}
@@ -0,0 +1,634 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+const (
+	// levels per side in depth responses when the request does not say
+	httpDefaultLevels = 10
+	// outgoing messages buffered per WebSocket before it is dropped as a slow consumer
+	httpOutboundBuffer = 256
+	httpWriteTimeout   = 5 * time.Second
+)
+
+// HttpApi is a JSON over HTTP front end to the Engine, simpler to script than the binary
+// protocols. REST endpoints:
+//
+//	GET    /books                          listed symbols
+//	GET    /books/{symbol}/depth?levels=n  L2 depth
+//	POST   /books/{symbol}/orders          submit an order
+//	GET    /books/{symbol}/orders?account  resting orders of an account
+//	GET    /books/{symbol}/orders/{id}     a resting order
+//	DELETE /books/{symbol}/orders/{id}     cancel a resting order
+//	GET    /metrics                        engine metrics in the Prometheus text format
+//
+// WebSocket channels:
+//
+//	/ws/depth/{symbol}?levels=n  L2 depth, a snapshot on connect and after every change
+//	/ws/trades/{symbol}          every fill
+//	/ws/orders/{account}         updates of the orders of one account
+//
+// The trades and orders channels confirm the subscription with a subscribed message.
+type HttpApi struct {
+	engine   *Engine
+	upgrader websocket.Upgrader
+
+	mu     sync.Mutex
+	depth  map[string]map[*wsClient]struct{}
+	trades map[string]map[*wsClient]struct{}
+	orders map[uint32]map[*wsClient]struct{}
+	closed bool
+}
+
+type httpOrderRequest struct {
+	// buy or sell
+	Side string `json:"side"`
+	// limit or market, limit when empty
+	Type     string  `json:"type"`
+	Price    float32 `json:"price"`
+	Quantity float32 `json:"quantity"`
+	Account  uint32  `json:"account"`
+}
+
+type httpOrder struct {
+	Id        uint32  `json:"id"`
+	Symbol    string  `json:"symbol"`
+	Side      string  `json:"side"`
+	Type      string  `json:"type"`
+	Price     float32 `json:"price"`
+	Quantity  float32 `json:"quantity"`
+	Executed  float32 `json:"executed"`
+	Remaining float32 `json:"remaining"`
+	Account   uint32  `json:"account"`
+	// still in the book
+	Resting bool `json:"resting"`
+}
+
+type httpLevel struct {
+	Price  float32 `json:"price"`
+	Volume float32 `json:"volume"`
+	Orders int     `json:"orders"`
+}
+
+type httpDepth struct {
+	Type   string      `json:"type"`
+	Symbol string      `json:"symbol"`
+	Bids   []httpLevel `json:"bids"`
+	Asks   []httpLevel `json:"asks"`
+}
+
+type httpTrade struct {
+	Type     string  `json:"type"`
+	Symbol   string  `json:"symbol"`
+	Price    float32 `json:"price"`
+	Quantity float32 `json:"quantity"`
+	// side of the incoming order
+	Side    string `json:"side"`
+	TakerId uint32 `json:"takerId"`
+	MakerId uint32 `json:"makerId"`
+}
+
+type httpOrderUpdate struct {
+	// added, fill or cancelled
+	Type     string  `json:"type"`
+	Symbol   string  `json:"symbol"`
+	Id       uint32  `json:"id"`
+	Side     string  `json:"side"`
+	Price    float32 `json:"price"`
+	Quantity float32 `json:"quantity"`
+	// left open after the update
+	Remaining float32 `json:"remaining"`
+	// on fills, taker or maker
+	Role string `json:"role,omitempty"`
+}
+
+// first message of the trades and orders channels, updates follow from there on
+type httpSubscribed struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+}
+
+type httpError struct {
+	Error string `json:"error"`
+}
+
+// one WebSocket subscriber
+type wsClient struct {
+	conn *websocket.Conn
+	out  chan []byte
+	// depth changed, coalesced until the writer gets to it
+	notify chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+func NewHttpApi(engine *Engine) *HttpApi {
+	api := &HttpApi{
+		engine: engine,
+		depth:  make(map[string]map[*wsClient]struct{}),
+		trades: make(map[string]map[*wsClient]struct{}),
+		orders: make(map[uint32]map[*wsClient]struct{}),
+	}
+	engine.Subscribe(api.onEvent)
+	return api
+}
+
+func (api *HttpApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "metrics":
+		api.allow(w, r, http.MethodGet, func() { api.engine.MetricsHandler().ServeHTTP(w, r) })
+	case len(parts) == 1 && parts[0] == "books":
+		api.allow(w, r, http.MethodGet, func() { writeJSON(w, http.StatusOK, api.engine.Symbols()) })
+	case len(parts) == 3 && parts[0] == "books" && parts[2] == "depth":
+		api.allow(w, r, http.MethodGet, func() { api.getDepth(w, r, parts[1]) })
+	case len(parts) == 3 && parts[0] == "books" && parts[2] == "orders":
+		switch r.Method {
+		case http.MethodPost:
+			api.submit(w, r, parts[1])
+		case http.MethodGet:
+			api.accountOrders(w, r, parts[1])
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case len(parts) == 4 && parts[0] == "books" && parts[2] == "orders":
+		id, err := strconv.ParseUint(parts[3], 10, 32)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid order id")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			api.getOrder(w, parts[1], uint32(id))
+		case http.MethodDelete:
+			api.cancel(w, parts[1], uint32(id))
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case len(parts) == 3 && parts[0] == "ws" && parts[1] == "depth":
+		api.streamDepth(w, r, parts[2])
+	case len(parts) == 3 && parts[0] == "ws" && parts[1] == "trades":
+		api.streamTrades(w, r, parts[2])
+	case len(parts) == 3 && parts[0] == "ws" && parts[1] == "orders":
+		api.streamOrders(w, r, parts[2])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// drops every WebSocket subscriber
+func (api *HttpApi) Close() {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	api.closed = true
+	for _, subscribers := range []map[string]map[*wsClient]struct{}{api.depth, api.trades} {
+		for _, clients := range subscribers {
+			for c := range clients {
+				c.close()
+			}
+		}
+	}
+	for _, clients := range api.orders {
+		for c := range clients {
+			c.close()
+		}
+	}
+}
+
+func (api *HttpApi) allow(w http.ResponseWriter, r *http.Request, method string, fn func()) {
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	fn()
+}
+
+func (api *HttpApi) getDepth(w http.ResponseWriter, r *http.Request, symbol string) {
+	levels, err := levelsParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	depth, err := api.snapshot(symbol, levels)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, depth)
+}
+
+func (api *HttpApi) snapshot(symbol string, levels int) (httpDepth, error) {
+	depth := httpDepth{Type: "depth", Symbol: symbol}
+	err := api.engine.View(symbol, func(book *book.Orderbook) {
+		depth.Bids = httpLevels(book.Depth(true, levels))
+		depth.Asks = httpLevels(book.Depth(false, levels))
+	})
+	return depth, err
+}
+
+func (api *HttpApi) submit(w http.ResponseWriter, r *http.Request, symbol string) {
+	var req httpOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	incoming, err := req.incoming()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	o, err := api.engine.Submit(symbol, incoming)
+	if o.SequenceId == 0 {
+		writeEngineError(w, err)
+		return
+	}
+	resting := false
+	api.engine.View(symbol, func(book *book.Orderbook) {
+		_, resting = book.Order(o.SequenceId)
+	})
+	writeJSON(w, http.StatusCreated, newHttpOrder(symbol, o, resting))
+}
+
+func (api *HttpApi) accountOrders(w http.ResponseWriter, r *http.Request, symbol string) {
+	account, err := strconv.ParseUint(r.URL.Query().Get("account"), 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "account is required")
+		return
+	}
+	orders := make([]httpOrder, 0)
+	err = api.engine.View(symbol, func(book *book.Orderbook) {
+		for _, id := range book.AccountOrders(uint32(account)) {
+			if o, ok := book.Order(id); ok {
+				orders = append(orders, newHttpOrder(symbol, o, true))
+			}
+		}
+	})
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+func (api *HttpApi) getOrder(w http.ResponseWriter, symbol string, id uint32) {
+	var o orders.Order
+	found := false
+	if err := api.engine.View(symbol, func(book *book.Orderbook) { o, found = book.Order(id) }); err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	if !found {
+		writeEngineError(w, ErrUnknownOrder)
+		return
+	}
+	writeJSON(w, http.StatusOK, newHttpOrder(symbol, o, true))
+}
+
+func (api *HttpApi) cancel(w http.ResponseWriter, symbol string, id uint32) {
+	o, err := api.engine.Cancel(symbol, id)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newHttpOrder(symbol, o, false))
+}
+
+func (req *httpOrderRequest) incoming() (orders.IncomingOrder, error) {
+	var bidOrAsk bool
+	switch req.Side {
+	case "buy":
+		bidOrAsk = true
+	case "sell":
+	default:
+		return orders.IncomingOrder{}, errors.New("side must be buy or sell")
+	}
+	orderType := orders.LIMIT
+	switch req.Type {
+	case "", "limit":
+	case "market":
+		orderType = orders.MARKET
+	default:
+		return orders.IncomingOrder{}, errors.New("type must be limit or market")
+	}
+	return orders.NewIncomingOrder(req.Price, req.Quantity, bidOrAsk, orderType, req.Account), nil
+}
+
+func newHttpOrder(symbol string, o orders.Order, resting bool) httpOrder {
+	orderType := "limit"
+	if o.Order.OrderType == orders.MARKET {
+		orderType = "market"
+	}
+	return httpOrder{
+		Id:        o.SequenceId,
+		Symbol:    symbol,
+		Side:      httpSide(o.Order.BidOrAsk),
+		Type:      orderType,
+		Price:     o.Order.Price,
+		Quantity:  o.Order.Quantity,
+		Executed:  o.ExecutedQuantity,
+		Remaining: o.Order.Quantity - o.ExecutedQuantity,
+		Account:   o.Order.AccountId,
+		Resting:   resting,
+	}
+}
+
+func httpSide(bidOrAsk bool) string {
+	if bidOrAsk {
+		return "buy"
+	}
+	return "sell"
+}
+
+func httpLevels(levels []book.PriceLevel) []httpLevel {
+	out := make([]httpLevel, len(levels))
+	for i, l := range levels {
+		out[i] = httpLevel{Price: l.Price, Volume: l.Volume, Orders: l.Orders}
+	}
+	return out
+}
+
+func levelsParam(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("levels")
+	if v == "" {
+		return httpDefaultLevels, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, errors.New("levels must be a positive number")
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, httpError{Error: message})
+}
+
+// status code of an engine error
+func writeEngineError(w http.ResponseWriter, err error) {
+	status := http.StatusUnprocessableEntity
+	switch {
+	case errors.Is(err, ErrUnknownSymbol), errors.Is(err, ErrUnknownOrder):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidQuantity), errors.Is(err, ErrInvalidPrice):
+		status = http.StatusBadRequest
+	}
+	writeError(w, status, err.Error())
+}
+
+func (api *HttpApi) streamDepth(w http.ResponseWriter, r *http.Request, symbol string) {
+	levels, err := levelsParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := api.snapshot(symbol, 1); err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	c := api.upgrade(w, r)
+	if c == nil {
+		return
+	}
+	if !api.register(c, func() { addClient(api.depth, symbol, c) }) {
+		return
+	}
+	defer api.unregister(func() { removeClient(api.depth, symbol, c) })
+
+	// the first snapshot goes out straight away
+	c.notify <- struct{}{}
+	go c.writeLoop(func() ([]byte, error) {
+		depth, err := api.snapshot(symbol, levels)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(depth)
+	})
+	c.readLoop()
+}
+
+func (api *HttpApi) streamTrades(w http.ResponseWriter, r *http.Request, symbol string) {
+	if _, err := api.snapshot(symbol, 1); err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	c := api.upgrade(w, r)
+	if c == nil {
+		return
+	}
+	if !api.register(c, func() { addClient(api.trades, symbol, c) }) {
+		return
+	}
+	defer api.unregister(func() { removeClient(api.trades, symbol, c) })
+
+	c.subscribed("trades")
+	go c.writeLoop(nil)
+	c.readLoop()
+}
+
+func (api *HttpApi) streamOrders(w http.ResponseWriter, r *http.Request, account string) {
+	id, err := strconv.ParseUint(account, 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid account")
+		return
+	}
+	c := api.upgrade(w, r)
+	if c == nil {
+		return
+	}
+	if !api.register(c, func() { addClient(api.orders, uint32(id), c) }) {
+		return
+	}
+	defer api.unregister(func() { removeClient(api.orders, uint32(id), c) })
+
+	c.subscribed("orders")
+	go c.writeLoop(nil)
+	c.readLoop()
+}
+
+func (api *HttpApi) upgrade(w http.ResponseWriter, r *http.Request) *wsClient {
+	conn, err := api.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has answered already
+		return nil
+	}
+	return &wsClient{
+		conn:   conn,
+		out:    make(chan []byte, httpOutboundBuffer),
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (api *HttpApi) register(c *wsClient, add func()) bool {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if api.closed {
+		c.close()
+		return false
+	}
+	add()
+	return true
+}
+
+func (api *HttpApi) unregister(remove func()) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	remove()
+}
+
+func addClient[K comparable](subscribers map[K]map[*wsClient]struct{}, key K, c *wsClient) {
+	clients, ok := subscribers[key]
+	if !ok {
+		clients = make(map[*wsClient]struct{})
+		subscribers[key] = clients
+	}
+	clients[c] = struct{}{}
+}
+
+func removeClient[K comparable](subscribers map[K]map[*wsClient]struct{}, key K, c *wsClient) {
+	delete(subscribers[key], c)
+	if len(subscribers[key]) == 0 {
+		delete(subscribers, key)
+	}
+}
+
+// engine events, delivered with the engine locked
+func (api *HttpApi) onEvent(ev book.Event) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	for c := range api.depth[ev.Symbol] {
+		select {
+		case c.notify <- struct{}{}:
+		default:
+			// a snapshot is due already
+		}
+	}
+
+	if ev.Type == book.EventFill && len(api.trades[ev.Symbol]) > 0 {
+		trade, _ := json.Marshal(httpTrade{
+			Type:     "trade",
+			Symbol:   ev.Symbol,
+			Price:    ev.Price,
+			Quantity: ev.Quantity,
+			Side:     httpSide(ev.BidOrAsk),
+			TakerId:  ev.SequenceId,
+			MakerId:  ev.MakerSequenceId,
+		})
+		for c := range api.trades[ev.Symbol] {
+			c.send(trade)
+		}
+	}
+
+	if clients := api.orders[ev.AccountId]; len(clients) > 0 {
+		update := httpOrderUpdate{
+			Type:      ev.Type.String(),
+			Symbol:    ev.Symbol,
+			Id:        ev.SequenceId,
+			Side:      httpSide(ev.BidOrAsk),
+			Price:     ev.Price,
+			Quantity:  ev.Quantity,
+			Remaining: ev.Remaining,
+		}
+		if ev.Type == book.EventFill {
+			update.Role = "taker"
+		}
+		b, _ := json.Marshal(update)
+		for c := range clients {
+			c.send(b)
+		}
+	}
+	if ev.Type != book.EventFill {
+		return
+	}
+	if clients := api.orders[ev.MakerAccountId]; len(clients) > 0 {
+		b, _ := json.Marshal(httpOrderUpdate{
+			Type:      ev.Type.String(),
+			Symbol:    ev.Symbol,
+			Id:        ev.MakerSequenceId,
+			Side:      httpSide(!ev.BidOrAsk),
+			Price:     ev.Price,
+			Quantity:  ev.Quantity,
+			Remaining: ev.MakerRemaining,
+			Role:      "maker",
+		})
+		for c := range clients {
+			c.send(b)
+		}
+	}
+}
+
+func (c *wsClient) subscribed(channel string) {
+	b, _ := json.Marshal(httpSubscribed{Type: "subscribed", Channel: channel})
+	c.send(b)
+}
+
+func (c *wsClient) send(b []byte) {
+	select {
+	case c.out <- b:
+	default:
+		// slow consumer
+		c.close()
+	}
+}
+
+// writes the queued messages, and a fresh snapshot from the given function whenever notified
+func (c *wsClient) writeLoop(snapshot func() ([]byte, error)) {
+	for {
+		var b []byte
+		select {
+		case b = <-c.out:
+		case <-c.notify:
+			var err error
+			if b, err = snapshot(); err != nil {
+				c.close()
+				return
+			}
+		case <-c.done:
+			return
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(httpWriteTimeout))
+		if err := c.conn.WriteMessage(websocket.TextMessage, b); err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+// the channels are one way, reading only notices the client going away
+func (c *wsClient) readLoop() {
+	defer c.close()
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (c *wsClient) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
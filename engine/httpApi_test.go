@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newHttpApi(t *testing.T) (*httptest.Server, *Engine) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	e.AddInstrument("ETH")
+	api := NewHttpApi(e)
+	server := httptest.NewServer(api)
+	t.Cleanup(func() {
+		api.Close()
+		server.Close()
+	})
+	return server, e
+}
+
+func doJSON(t *testing.T, method string, url string, body interface{}, out interface{}) int {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, _ := http.NewRequest(method, url, reader)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decoding %s %s: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func dialWs(t *testing.T, server *httptest.Server, path string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func readWs(t *testing.T, conn *websocket.Conn, out interface{}) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHttpOrders(t *testing.T) {
+	server, _ := newHttpApi(t)
+
+	var symbols []string
+	doJSON(t, http.MethodGet, server.URL+"/books", nil, &symbols)
+	if len(symbols) != 2 || symbols[0] != "BTC" {
+		t.Errorf("both instruments should be listed, got %v", symbols)
+	}
+
+	var resting httpOrder
+	status := doJSON(t, http.MethodPost, server.URL+"/books/BTC/orders",
+		httpOrderRequest{Side: "sell", Price: 100, Quantity: 5, Account: 1}, &resting)
+	if status != http.StatusCreated || resting.Id == 0 || !resting.Resting || resting.Remaining != 5 {
+		t.Fatalf("the order should rest, got %d %+v", status, resting)
+	}
+
+	var taker httpOrder
+	doJSON(t, http.MethodPost, server.URL+"/books/BTC/orders",
+		httpOrderRequest{Side: "buy", Type: "market", Quantity: 2, Account: 2}, &taker)
+	if taker.Executed != 2 || taker.Resting {
+		t.Errorf("the market order should fill, got %+v", taker)
+	}
+
+	var got httpOrder
+	doJSON(t, http.MethodGet, server.URL+"/books/BTC/orders/"+itoa(resting.Id), nil, &got)
+	if got.Remaining != 3 || got.Executed != 2 {
+		t.Errorf("the resting order should have 3 left, got %+v", got)
+	}
+	var orders []httpOrder
+	doJSON(t, http.MethodGet, server.URL+"/books/BTC/orders?account=1", nil, &orders)
+	if len(orders) != 1 || orders[0].Id != resting.Id {
+		t.Errorf("account 1 should have one resting order, got %+v", orders)
+	}
+
+	var depth httpDepth
+	doJSON(t, http.MethodGet, server.URL+"/books/BTC/depth?levels=5", nil, &depth)
+	if len(depth.Bids) != 0 || len(depth.Asks) != 1 || depth.Asks[0].Volume != 3 {
+		t.Errorf("unexpected depth %+v", depth)
+	}
+
+	var cancelled httpOrder
+	status = doJSON(t, http.MethodDelete, server.URL+"/books/BTC/orders/"+itoa(resting.Id), nil, &cancelled)
+	if status != http.StatusOK || cancelled.Id != resting.Id {
+		t.Errorf("the order should be cancelled, got %d %+v", status, cancelled)
+	}
+	var e httpError
+	if status := doJSON(t, http.MethodDelete, server.URL+"/books/BTC/orders/"+itoa(resting.Id), nil, &e); status != http.StatusNotFound {
+		t.Errorf("a second cancel should be not found, got %d", status)
+	}
+}
+
+func TestHttpErrors(t *testing.T) {
+	server, e := newHttpApi(t)
+	var body httpError
+	cases := []struct {
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{http.MethodPost, "/books/XYZ/orders", httpOrderRequest{Side: "buy", Price: 1, Quantity: 1}, http.StatusNotFound},
+		{http.MethodPost, "/books/BTC/orders", httpOrderRequest{Side: "up", Price: 1, Quantity: 1}, http.StatusBadRequest},
+		{http.MethodPost, "/books/BTC/orders", httpOrderRequest{Side: "buy", Price: 1}, http.StatusBadRequest},
+		{http.MethodPost, "/books/BTC/orders", httpOrderRequest{Side: "buy", Quantity: 1}, http.StatusBadRequest},
+		{http.MethodPost, "/books/BTC/orders", httpOrderRequest{Side: "sell", Price: -2, Quantity: 1}, http.StatusBadRequest},
+		{http.MethodPost, "/books/BTC/orders", httpOrderRequest{Side: "buy", Price: 1, Quantity: 1, Account: 9}, http.StatusUnprocessableEntity},
+		{http.MethodGet, "/books/BTC/orders/12345", nil, http.StatusNotFound},
+		{http.MethodGet, "/books/BTC/orders", nil, http.StatusBadRequest},
+		{http.MethodPut, "/books/BTC/orders/1", nil, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/books/BTC/depth?levels=x", nil, http.StatusBadRequest},
+		{http.MethodGet, "/nowhere", nil, http.StatusNotFound},
+	}
+	e.Kill(9)
+	for _, c := range cases {
+		if status := doJSON(t, c.method, server.URL+c.path, c.body, &body); status != c.status || body.Error == "" {
+			t.Errorf("%s %s should fail with %d, got %d %q", c.method, c.path, c.status, status, body.Error)
+		}
+	}
+}
+
+func TestHttpStreams(t *testing.T) {
+	server, _ := newHttpApi(t)
+
+	depthConn := dialWs(t, server, "/ws/depth/BTC?levels=2")
+	var depth httpDepth
+	readWs(t, depthConn, &depth)
+	if depth.Type != "depth" || len(depth.Bids) != 0 {
+		t.Fatalf("the first message should be an empty snapshot, got %+v", depth)
+	}
+	tradeConn := dialWs(t, server, "/ws/trades/BTC")
+	makerConn := dialWs(t, server, "/ws/orders/1")
+	for _, conn := range []*websocket.Conn{tradeConn, makerConn} {
+		var subscribed httpSubscribed
+		if readWs(t, conn, &subscribed); subscribed.Type != "subscribed" {
+			t.Fatalf("the subscription should be confirmed, got %+v", subscribed)
+		}
+	}
+
+	var resting httpOrder
+	doJSON(t, http.MethodPost, server.URL+"/books/BTC/orders",
+		httpOrderRequest{Side: "buy", Price: 99, Quantity: 4, Account: 1}, &resting)
+	var update httpOrderUpdate
+	readWs(t, makerConn, &update)
+	if update.Type != "added" || update.Id != resting.Id || update.Remaining != 4 {
+		t.Errorf("the owner should see the order added, got %+v", update)
+	}
+	for len(depth.Bids) == 0 {
+		readWs(t, depthConn, &depth)
+	}
+	if depth.Bids[0].Price != 99 || depth.Bids[0].Volume != 4 {
+		t.Errorf("the depth should show the new bid, got %+v", depth)
+	}
+
+	doJSON(t, http.MethodPost, server.URL+"/books/BTC/orders",
+		httpOrderRequest{Side: "sell", Price: 99, Quantity: 1, Account: 2}, nil)
+	var trade httpTrade
+	readWs(t, tradeConn, &trade)
+	if trade.Price != 99 || trade.Quantity != 1 || trade.Side != "sell" || trade.MakerId != resting.Id {
+		t.Errorf("unexpected trade %+v", trade)
+	}
+	readWs(t, makerConn, &update)
+	if update.Type != "fill" || update.Role != "maker" || update.Remaining != 3 {
+		t.Errorf("the owner should see the fill as maker, got %+v", update)
+	}
+	for depth.Bids[0].Volume != 3 {
+		readWs(t, depthConn, &depth)
+	}
+}
+
+func itoa(id uint32) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
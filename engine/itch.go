@@ -0,0 +1,228 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ITCH-style market data: fixed big endian layouts starting with a type byte, carried in
+// MoldUDP64-style packets that number every message so receivers can detect gaps and
+// ask the retransmission server for them.
+
+// message types
+const (
+	ItchSystemEvent   byte = 'S'
+	ItchAddOrder      byte = 'A'
+	ItchOrderExecuted byte = 'E'
+	ItchOrderCancel   byte = 'X'
+	ItchOrderDelete   byte = 'D'
+	ItchTrade         byte = 'P'
+)
+
+// system event codes
+const (
+	ItchStartOfMessages byte = 'O'
+	ItchEndOfMessages   byte = 'C'
+)
+
+// message lengths, type byte included
+const (
+	itchSystemEventLen   = 10
+	itchAddOrderLen      = 30
+	itchOrderExecutedLen = 25
+	itchOrderCancelLen   = 17
+	itchOrderDeleteLen   = 13
+	itchTradeLen         = 34
+)
+
+// packet header: session, sequence number of the first message, message count
+const (
+	itchSessionLen = 10
+	itchHeaderLen  = itchSessionLen + 8 + 2
+	// keeps packets under the usual ethernet MTU
+	itchMaxPacket = 1400
+)
+
+var (
+	errItchShortPacket = errors.New("itch: short packet")
+	errItchUnknownType = errors.New("itch: unknown message type")
+)
+
+// one market data message, Type tells which fields are meaningful:
+//
+//	SystemEvent    Code
+//	AddOrder       OrderRef Side Quantity Symbol Price
+//	OrderExecuted  OrderRef Quantity MatchNumber
+//	OrderCancel    OrderRef Quantity (cancelled part, the order stays)
+//	OrderDelete    OrderRef
+//	Trade          Side Quantity Symbol Price MatchNumber
+//
+// OrderExecuted reports the resting side of a match and is what rebuilds the book,
+// Trade is the print of the same match for tape readers, Side being the aggressor's.
+type ItchMessage struct {
+	Type        byte
+	Timestamp   uint64
+	Code        byte
+	OrderRef    uint32
+	Side        byte
+	Quantity    float32
+	Price       float32
+	Symbol      string
+	MatchNumber uint64
+}
+
+func itchMessageLen(t byte) int {
+	switch t {
+	case ItchSystemEvent:
+		return itchSystemEventLen
+	case ItchAddOrder:
+		return itchAddOrderLen
+	case ItchOrderExecuted:
+		return itchOrderExecutedLen
+	case ItchOrderCancel:
+		return itchOrderCancelLen
+	case ItchOrderDelete:
+		return itchOrderDeleteLen
+	case ItchTrade:
+		return itchTradeLen
+	}
+	return 0
+}
+
+// appends the encoded message to b
+func (m *ItchMessage) encode(b []byte) []byte {
+	n := itchMessageLen(m.Type)
+	start := len(b)
+	for i := 0; i < n; i++ {
+		b = append(b, 0)
+	}
+	out := b[start:]
+	out[0] = m.Type
+	binary.BigEndian.PutUint64(out[1:], m.Timestamp)
+	switch m.Type {
+	case ItchSystemEvent:
+		out[9] = m.Code
+	case ItchAddOrder:
+		binary.BigEndian.PutUint32(out[9:], m.OrderRef)
+		out[13] = m.Side
+		putFloat(out[14:], m.Quantity)
+		putSymbol(out[18:], m.Symbol)
+		putFloat(out[26:], m.Price)
+	case ItchOrderExecuted:
+		binary.BigEndian.PutUint32(out[9:], m.OrderRef)
+		putFloat(out[13:], m.Quantity)
+		binary.BigEndian.PutUint64(out[17:], m.MatchNumber)
+	case ItchOrderCancel:
+		binary.BigEndian.PutUint32(out[9:], m.OrderRef)
+		putFloat(out[13:], m.Quantity)
+	case ItchOrderDelete:
+		binary.BigEndian.PutUint32(out[9:], m.OrderRef)
+	case ItchTrade:
+		out[9] = m.Side
+		putFloat(out[10:], m.Quantity)
+		putSymbol(out[14:], m.Symbol)
+		putFloat(out[22:], m.Price)
+		binary.BigEndian.PutUint64(out[26:], m.MatchNumber)
+	}
+	return b
+}
+
+func (m *ItchMessage) decode(b []byte) error {
+	if len(b) == 0 {
+		return errItchShortPacket
+	}
+	n := itchMessageLen(b[0])
+	if n == 0 {
+		return errItchUnknownType
+	}
+	if len(b) < n {
+		return errItchShortPacket
+	}
+	*m = ItchMessage{Type: b[0], Timestamp: binary.BigEndian.Uint64(b[1:])}
+	switch m.Type {
+	case ItchSystemEvent:
+		m.Code = b[9]
+	case ItchAddOrder:
+		m.OrderRef = binary.BigEndian.Uint32(b[9:])
+		m.Side = b[13]
+		m.Quantity = getFloat(b[14:])
+		m.Symbol = getSymbol(b[18:])
+		m.Price = getFloat(b[26:])
+	case ItchOrderExecuted:
+		m.OrderRef = binary.BigEndian.Uint32(b[9:])
+		m.Quantity = getFloat(b[13:])
+		m.MatchNumber = binary.BigEndian.Uint64(b[17:])
+	case ItchOrderCancel:
+		m.OrderRef = binary.BigEndian.Uint32(b[9:])
+		m.Quantity = getFloat(b[13:])
+	case ItchOrderDelete:
+		m.OrderRef = binary.BigEndian.Uint32(b[9:])
+	case ItchTrade:
+		m.Side = b[9]
+		m.Quantity = getFloat(b[10:])
+		m.Symbol = getSymbol(b[14:])
+		m.Price = getFloat(b[22:])
+		m.MatchNumber = binary.BigEndian.Uint64(b[26:])
+	}
+	return nil
+}
+
+// header of a packet carrying count messages from sequence on, 0 messages is a heartbeat
+func appendItchHeader(b []byte, session string, sequence uint64, count uint16) []byte {
+	var h [itchHeaderLen]byte
+	copy(h[:itchSessionLen], session)
+	for i := len(session); i < itchSessionLen; i++ {
+		h[i] = ' '
+	}
+	binary.BigEndian.PutUint64(h[itchSessionLen:], sequence)
+	binary.BigEndian.PutUint16(h[itchSessionLen+8:], count)
+	return append(b, h[:]...)
+}
+
+// appends a length prefixed message to a packet
+func appendItchBlock(b []byte, message []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(message)))
+	return append(b, message...)
+}
+
+// header of a packet, also the whole of a retransmission request
+func parseItchHeader(b []byte) (session string, sequence uint64, count uint16, err error) {
+	if len(b) < itchHeaderLen {
+		return "", 0, 0, errItchShortPacket
+	}
+	end := itchSessionLen
+	for end > 0 && b[end-1] == ' ' {
+		end--
+	}
+	return string(b[:end]), binary.BigEndian.Uint64(b[itchSessionLen:]),
+		binary.BigEndian.Uint16(b[itchSessionLen+8:]), nil
+}
+
+// splits a packet into its header and messages
+func parseItchPacket(b []byte) (session string, sequence uint64, messages [][]byte, err error) {
+	session, sequence, count, err := parseItchHeader(b)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	b = b[itchHeaderLen:]
+	messages = make([][]byte, 0, count)
+	for i := 0; i < int(count); i++ {
+		if len(b) < 2 {
+			return "", 0, nil, errItchShortPacket
+		}
+		n := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+n {
+			return "", 0, nil, errItchShortPacket
+		}
+		messages = append(messages, b[2:2+n])
+		b = b[2+n:]
+	}
+	return session, sequence, messages, nil
+}
+
+func itchSide(bidOrAsk bool) byte {
+	if bidOrAsk {
+		return 'B'
+	}
+	return 'S'
+}
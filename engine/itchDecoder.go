@@ -0,0 +1,338 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"matching-engine-go/book"
+)
+
+var errItchUnknownOrder = errors.New("itch: unknown order reference")
+
+// ItchReceiver puts the packets of a feed back in sequence order. Messages are handed to
+// the handler exactly once and without gaps, missing ones are asked for from the
+// retransmission server and the packets after them held back until they arrive. A message
+// that does not decode is skipped, Receive reports it once the others are delivered.
+type ItchReceiver struct {
+	session string
+	handler func(m ItchMessage)
+	// held while the handler runs, without mu, so packets read on the feed and the
+	// retransmission sockets are delivered one after the other
+	handling sync.Mutex
+
+	mu sync.Mutex
+	// sequence number of the next message for the handler
+	next    uint64
+	pending map[uint64][]byte
+	// one past the highest sequence number seen or announced
+	latest uint64
+	// next when the last retransmission was requested
+	requested  uint64
+	retransmit net.Conn
+	conn       net.PacketConn
+	wg         sync.WaitGroup
+}
+
+func NewItchReceiver(session string, handler func(m ItchMessage)) *ItchReceiver {
+	return &ItchReceiver{session: session, handler: handler, next: 1, pending: make(map[uint64][]byte)}
+}
+
+// joins the feed on addr, a multicast group or a local unicast address. Packets are
+// processed in the background
+func (r *ItchReceiver) Listen(addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	var conn *net.UDPConn
+	if udpAddr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp", nil, udpAddr)
+	} else {
+		conn, err = net.ListenUDP("udp", udpAddr)
+	}
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.conn = conn
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go r.readLoop(conn)
+	return nil
+}
+
+// recovers gaps from the retransmission server at addr
+func (r *ItchReceiver) RecoverFrom(addr string) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.retransmit = conn
+	r.mu.Unlock()
+
+	// retransmissions come back on the socket the request was sent from
+	r.wg.Add(1)
+	go r.readLoop(conn.(net.PacketConn))
+	return nil
+}
+
+func (r *ItchReceiver) Addr() net.Addr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.LocalAddr()
+}
+
+func (r *ItchReceiver) readLoop(conn net.PacketConn) {
+	defer r.wg.Done()
+	buf := make([]byte, 1<<16)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		r.Receive(buf[:n])
+	}
+}
+
+func (r *ItchReceiver) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.conn != nil {
+		err = r.conn.Close()
+	}
+	if r.retransmit != nil {
+		r.retransmit.Close()
+	}
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	return err
+}
+
+// sequence number of the next message the handler will see
+func (r *ItchReceiver) Next() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.next
+}
+
+// processes one packet of the feed, the packet may be reused once it returns. the
+// handler is called after the receiver is unlocked, so it may call Next
+func (r *ItchReceiver) Receive(packet []byte) error {
+	session, sequence, messages, err := parseItchPacket(packet)
+	if err != nil {
+		return err
+	}
+	if session != r.session {
+		return nil
+	}
+
+	r.handling.Lock()
+	defer r.handling.Unlock()
+	ready, err := r.sequence(sequence, messages)
+	for _, m := range ready {
+		r.handler(m)
+	}
+	return err
+}
+
+// the messages of a packet now in order, with the ones held back they let through.
+// the first message that does not decode is returned as the error
+func (r *ItchReceiver) sequence(sequence uint64, messages [][]byte) ([]ItchMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ready []ItchMessage
+	var err error
+	take := func(b []byte) {
+		var m ItchMessage
+		if decodeErr := m.decode(b); decodeErr != nil {
+			if err == nil {
+				err = fmt.Errorf("itch: message %d: %w", r.next, decodeErr)
+			}
+		} else {
+			ready = append(ready, m)
+		}
+		r.next++
+	}
+	for i, b := range messages {
+		s := sequence + uint64(i)
+		if s < r.next {
+			continue
+		}
+		if s == r.next {
+			take(b)
+			continue
+		}
+		if _, ok := r.pending[s]; !ok {
+			r.pending[s] = append([]byte(nil), b...)
+		}
+	}
+	for {
+		b, ok := r.pending[r.next]
+		if !ok {
+			break
+		}
+		delete(r.pending, r.next)
+		take(b)
+	}
+
+	// a heartbeat announces the next sequence number, anything before it is missing
+	if end := sequence + uint64(len(messages)); end > r.latest {
+		r.latest = end
+	}
+	// asks once per position, heartbeats repeat requests that went unanswered
+	if r.latest > r.next && (r.requested != r.next || len(messages) == 0) {
+		r.request()
+	}
+	return ready, err
+}
+
+// asks for the messages from next up to the first one held back. called with the
+// receiver locked
+func (r *ItchReceiver) request() {
+	end := r.latest
+	for s := range r.pending {
+		if s < end {
+			end = s
+		}
+	}
+	count := end - r.next
+	if count > 0xffff {
+		count = 0xffff
+	}
+	r.requested = r.next
+	if r.retransmit != nil {
+		r.retransmit.Write(appendItchHeader(nil, r.session, r.next, uint16(count)))
+	}
+}
+
+// ItchBook is the reference decoder: it rebuilds the resting orders and price levels of
+// every instrument from the feed alone.
+type ItchBook struct {
+	orders map[uint32]*itchOrder
+	books  map[string]*itchSides
+	// the last Trade message
+	LastTrade ItchMessage
+}
+
+type itchOrder struct {
+	symbol   string
+	bidOrAsk bool
+	price    float32
+	quantity float32
+}
+
+type itchSides struct {
+	bids map[float32]*book.PriceLevel
+	asks map[float32]*book.PriceLevel
+}
+
+func NewItchBook() *ItchBook {
+	return &ItchBook{orders: make(map[uint32]*itchOrder), books: make(map[string]*itchSides)}
+}
+
+func (b *ItchBook) Apply(m ItchMessage) error {
+	switch m.Type {
+	case ItchAddOrder:
+		o := &itchOrder{symbol: m.Symbol, bidOrAsk: m.Side == 'B', price: m.Price, quantity: m.Quantity}
+		b.orders[m.OrderRef] = o
+		level := b.level(o, true)
+		level.Volume += o.quantity
+		level.Orders++
+	case ItchOrderExecuted, ItchOrderCancel:
+		o, ok := b.orders[m.OrderRef]
+		if !ok {
+			return errItchUnknownOrder
+		}
+		b.reduce(m.OrderRef, o, m.Quantity)
+	case ItchOrderDelete:
+		o, ok := b.orders[m.OrderRef]
+		if !ok {
+			return errItchUnknownOrder
+		}
+		b.reduce(m.OrderRef, o, o.quantity)
+	case ItchTrade:
+		b.LastTrade = m
+	}
+	return nil
+}
+
+func (b *ItchBook) reduce(ref uint32, o *itchOrder, quantity float32) {
+	level := b.level(o, false)
+	o.quantity -= quantity
+	level.Volume -= quantity
+	if o.quantity > 0 {
+		return
+	}
+	delete(b.orders, ref)
+	level.Orders--
+	if level.Orders == 0 {
+		sides := b.books[o.symbol]
+		if o.bidOrAsk {
+			delete(sides.bids, o.price)
+		} else {
+			delete(sides.asks, o.price)
+		}
+	}
+}
+
+// price level of an order, created if asked for
+func (b *ItchBook) level(o *itchOrder, create bool) *book.PriceLevel {
+	sides, ok := b.books[o.symbol]
+	if !ok {
+		sides = &itchSides{bids: make(map[float32]*book.PriceLevel), asks: make(map[float32]*book.PriceLevel)}
+		b.books[o.symbol] = sides
+	}
+	levels := sides.asks
+	if o.bidOrAsk {
+		levels = sides.bids
+	}
+	level, ok := levels[o.price]
+	if !ok && create {
+		level = &book.PriceLevel{Price: o.price}
+		levels[o.price] = level
+	}
+	return level
+}
+
+// number of resting orders across instruments
+func (b *ItchBook) Len() int {
+	return len(b.orders)
+}
+
+// the n best levels of one side of symbol, best first, like Orderbook.Depth
+func (b *ItchBook) Depth(symbol string, bidOrAsk bool, n int) []book.PriceLevel {
+	depth := make([]book.PriceLevel, 0)
+	sides, ok := b.books[symbol]
+	if !ok {
+		return depth
+	}
+	levels := sides.asks
+	if bidOrAsk {
+		levels = sides.bids
+	}
+	for _, level := range levels {
+		depth = append(depth, *level)
+	}
+	sort.Slice(depth, func(i, j int) bool {
+		if bidOrAsk {
+			return depth[i].Price > depth[j].Price
+		}
+		return depth[i].Price < depth[j].Price
+	})
+	if n > 0 && len(depth) > n {
+		depth = depth[:n]
+	}
+	return depth
+}
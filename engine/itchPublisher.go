@@ -0,0 +1,246 @@
+package engine
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"matching-engine-go/book"
+)
+
+// settings of the market data publisher
+type ItchConfig struct {
+	// session name in every packet header, up to 10 characters
+	Session string
+	// a heartbeat carrying the next sequence number goes out after this much silence,
+	// so receivers notice lost trailing messages. 0 disables heartbeats
+	Heartbeat time.Duration
+	// messages kept for retransmission, the oldest are dropped first
+	RetransmitWindow int
+	// stamps the messages and drives the heartbeats, defaults to the wall clock
+	Clock Clock
+}
+
+const defaultItchRetransmitWindow = 1 << 20
+
+// ItchPublisher turns the events of an Engine into sequenced ITCH-style messages sent over
+// UDP, to a multicast group or a unicast address, and answers retransmission requests
+// for the messages still in its window.
+type ItchPublisher struct {
+	config ItchConfig
+
+	mu   sync.Mutex
+	conn net.Conn
+	// sequence number of the next message, the first one is 1
+	next uint64
+	// sent messages in a ring, sequence number s at (s-1) % RetransmitWindow
+	store [][]byte
+	// oldest sequence number still stored
+	first       uint64
+	matchNumber uint64
+	lastSent    time.Time
+	timer       Timer
+	retransmit  net.PacketConn
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+// publishes the events of engine to dest, a host:port that may be a multicast group
+func NewItchPublisher(engine *Engine, dest string, config ItchConfig) (*ItchPublisher, error) {
+	if config.Clock == nil {
+		config.Clock = NewRealClock()
+	}
+	if config.RetransmitWindow <= 0 {
+		config.RetransmitWindow = defaultItchRetransmitWindow
+	}
+	conn, err := net.Dial("udp", dest)
+	if err != nil {
+		return nil, err
+	}
+	p := &ItchPublisher{config: config, conn: conn, next: 1, first: 1}
+
+	p.mu.Lock()
+	p.publish(ItchMessage{Type: ItchSystemEvent, Code: ItchStartOfMessages})
+	p.schedule()
+	p.mu.Unlock()
+
+	engine.Subscribe(p.onEvent)
+	return p, nil
+}
+
+// sequence number the next message will get
+func (p *ItchPublisher) Next() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.next
+}
+
+// serves retransmission requests on addr in the background. A request is a packet header
+// naming the first sequence number wanted and how many, the reply is a regular packet
+// sent back to the requester
+func (p *ItchPublisher) ServeRetransmit(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.retransmit = conn
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		buf := make([]byte, itchMaxPacket)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			session, sequence, count, err := parseItchHeader(buf[:n])
+			if err != nil || session != p.config.Session {
+				continue
+			}
+			if packet := p.replay(sequence, int(count)); packet != nil {
+				conn.WriteTo(packet, from)
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *ItchPublisher) RetransmitAddr() net.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.retransmit == nil {
+		return nil
+	}
+	return p.retransmit.LocalAddr()
+}
+
+// sends the end of messages event and stops publishing
+func (p *ItchPublisher) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.publish(ItchMessage{Type: ItchSystemEvent, Code: ItchEndOfMessages})
+	p.closed = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	if p.retransmit != nil {
+		p.retransmit.Close()
+	}
+	err := p.conn.Close()
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	return err
+}
+
+// engine events, delivered with the engine locked
+func (p *ItchPublisher) onEvent(ev book.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	switch ev.Type {
+	case book.EventAdded:
+		p.publish(ItchMessage{Type: ItchAddOrder, OrderRef: ev.SequenceId, Side: itchSide(ev.BidOrAsk),
+			Quantity: ev.Quantity, Symbol: ev.Symbol, Price: ev.Price})
+	case book.EventFill:
+		p.matchNumber++
+		p.publish(ItchMessage{Type: ItchOrderExecuted, OrderRef: ev.MakerSequenceId, Quantity: ev.Quantity,
+			MatchNumber: p.matchNumber})
+		p.publish(ItchMessage{Type: ItchTrade, Side: itchSide(ev.BidOrAsk), Quantity: ev.Quantity,
+			Symbol: ev.Symbol, Price: ev.Price, MatchNumber: p.matchNumber})
+	case book.EventCancelled:
+		if ev.Expired {
+			// a market remainder, never added to the feed
+			break
+		}
+		if ev.Remaining > 0 {
+			// reduced, the order keeps its place in the queue
+			p.publish(ItchMessage{Type: ItchOrderCancel, OrderRef: ev.SequenceId, Quantity: ev.Quantity})
+			break
+		}
+		p.publish(ItchMessage{Type: ItchOrderDelete, OrderRef: ev.SequenceId})
+	}
+}
+
+// numbers, stores and sends one message. called with the publisher locked
+func (p *ItchPublisher) publish(m ItchMessage) {
+	now := p.config.Clock.Now()
+	m.Timestamp = uint64(now.UnixNano())
+	encoded := m.encode(nil)
+
+	sequence := p.next
+	p.next++
+	if len(p.store) < p.config.RetransmitWindow {
+		p.store = append(p.store, encoded)
+	} else {
+		p.store[p.slot(sequence)] = encoded
+		p.first = sequence - uint64(p.config.RetransmitWindow) + 1
+	}
+
+	packet := appendItchHeader(make([]byte, 0, itchHeaderLen+2+len(encoded)), p.config.Session, sequence, 1)
+	packet = appendItchBlock(packet, encoded)
+	// UDP gives no delivery guarantee anyway, lost packets are recovered by retransmission
+	p.conn.Write(packet)
+	p.lastSent = now
+}
+
+// packet with the stored messages from sequence on, as many as fit and at most count
+func (p *ItchPublisher) replay(sequence uint64, count int) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if sequence < p.first || sequence >= p.next || count <= 0 {
+		return nil
+	}
+	packet := appendItchHeader(make([]byte, 0, itchMaxPacket), p.config.Session, sequence, 0)
+	sent := 0
+	for s := sequence; s < p.next && sent < count && sent < 0xffff; s++ {
+		m := p.store[p.slot(s)]
+		if len(packet)+2+len(m) > itchMaxPacket {
+			break
+		}
+		packet = appendItchBlock(packet, m)
+		sent++
+	}
+	binary.BigEndian.PutUint16(packet[itchSessionLen+8:], uint16(sent))
+	return packet
+}
+
+func (p *ItchPublisher) slot(sequence uint64) int {
+	return int((sequence - 1) % uint64(p.config.RetransmitWindow))
+}
+
+// heartbeat timer. called with the publisher locked
+func (p *ItchPublisher) schedule() {
+	if p.config.Heartbeat <= 0 {
+		return
+	}
+	p.timer = p.config.Clock.AfterFunc(p.config.Heartbeat, p.heartbeat)
+}
+
+func (p *ItchPublisher) heartbeat() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	now := p.config.Clock.Now()
+	if now.Sub(p.lastSent) >= p.config.Heartbeat {
+		p.conn.Write(appendItchHeader(nil, p.config.Session, p.next, 0))
+		p.lastSent = now
+	}
+	p.schedule()
+}
@@ -0,0 +1,226 @@
+package engine
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"matching-engine-go/orders"
+)
+
+func TestItchCodec(t *testing.T) {
+	messages := []ItchMessage{
+		{Type: ItchSystemEvent, Timestamp: 1, Code: ItchStartOfMessages},
+		{Type: ItchAddOrder, Timestamp: 2, OrderRef: 3, Side: 'B', Quantity: 4.5, Symbol: "BTC", Price: 100.25},
+		{Type: ItchOrderExecuted, Timestamp: 5, OrderRef: 3, Quantity: 1, MatchNumber: 6},
+		{Type: ItchOrderCancel, Timestamp: 7, OrderRef: 3, Quantity: 0.5},
+		{Type: ItchOrderDelete, Timestamp: 8, OrderRef: 3},
+		{Type: ItchTrade, Timestamp: 9, Side: 'S', Quantity: 1, Symbol: "ETH", Price: 2, MatchNumber: 6},
+	}
+	packet := appendItchHeader(nil, "TEST", 42, uint16(len(messages)))
+	for _, m := range messages {
+		packet = appendItchBlock(packet, m.encode(nil))
+	}
+
+	session, sequence, blocks, err := parseItchPacket(packet)
+	if err != nil || session != "TEST" || sequence != 42 || len(blocks) != len(messages) {
+		t.Fatalf("unexpected packet header %q %d %d %v", session, sequence, len(blocks), err)
+	}
+	for i, b := range blocks {
+		var m ItchMessage
+		if err := m.decode(b); err != nil || m != messages[i] {
+			t.Errorf("message %d should survive a round trip, got %+v %v", i, m, err)
+		}
+	}
+	if _, _, _, err := parseItchPacket(packet[:len(packet)-1]); err == nil {
+		t.Errorf("a truncated packet should not parse")
+	}
+}
+
+// reference book fed from a receiver, safe to inspect while packets arrive
+type itchMirror struct {
+	mu   sync.Mutex
+	book *ItchBook
+	err  error
+}
+
+func (m *itchMirror) apply(msg ItchMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.book.Apply(msg); err != nil && m.err == nil {
+		m.err = err
+	}
+}
+
+func randomItchFlow(t *testing.T, e *Engine, n int) {
+	r := rand.New(rand.NewSource(7))
+	live := make([]uint32, 0)
+	for i := 0; i < n; i++ {
+		if len(live) > 0 && r.Intn(4) == 0 {
+			k := r.Intn(len(live))
+			e.Cancel("BTC", live[k])
+			live = append(live[:k], live[k+1:]...)
+			continue
+		}
+		price := float32(95 + r.Intn(10))
+		o, err := e.Submit("BTC", orders.NewIncomingOrder(price, float32(1+r.Intn(5)), r.Intn(2) == 0, orders.LIMIT, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		live = append(live, o.SequenceId)
+	}
+}
+
+func waitItch(t *testing.T, r *ItchReceiver, p *ItchPublisher) {
+	deadline := time.Now().Add(10 * time.Second)
+	for r.Next() != p.Next() {
+		if time.Now().After(deadline) {
+			t.Fatalf("the receiver should catch up, at %d of %d", r.Next(), p.Next())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func checkItchMirror(t *testing.T, e *Engine, mirror *itchMirror) {
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+
+	if mirror.err != nil {
+		t.Fatalf("the feed should decode cleanly: %v", mirror.err)
+	}
+	book, _ := e.Book("BTC")
+	for _, side := range []bool{true, false} {
+		if want, got := book.Depth(side, 0), mirror.book.Depth("BTC", side, 0); !reflect.DeepEqual(want, got) {
+			t.Errorf("the rebuilt book should match the engine\nwant %v\ngot  %v", want, got)
+		}
+	}
+}
+
+func TestItchFeedRebuildsBook(t *testing.T) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	mirror := &itchMirror{book: NewItchBook()}
+	r := NewItchReceiver("FEED", mirror.apply)
+	if err := r.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	p, err := NewItchPublisher(e, r.Addr().String(), ItchConfig{Session: "FEED", Heartbeat: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.ServeRetransmit("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RecoverFrom(p.RetransmitAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	randomItchFlow(t, e, 2000)
+	waitItch(t, r, p)
+	checkItchMirror(t, e, mirror)
+	if mirror.book.LastTrade.Type != ItchTrade {
+		t.Errorf("the flow should have traded")
+	}
+}
+
+func TestItchGapFill(t *testing.T) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	// nobody listens to the live feed
+	sink := NewItchReceiver("FEED", func(ItchMessage) {})
+	if err := sink.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	p, err := NewItchPublisher(e, sink.Addr().String(), ItchConfig{Session: "FEED", RetransmitWindow: 1 << 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.ServeRetransmit("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	randomItchFlow(t, e, 500)
+
+	// a late joiner learns the sequence number from a heartbeat and recovers everything
+	mirror := &itchMirror{book: NewItchBook()}
+	r := NewItchReceiver("FEED", mirror.apply)
+	if err := r.RecoverFrom(p.RetransmitAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	deadline := time.Now().Add(10 * time.Second)
+	for r.Next() != p.Next() && time.Now().Before(deadline) {
+		r.Receive(appendItchHeader(nil, "FEED", p.Next(), 0))
+		time.Sleep(5 * time.Millisecond)
+	}
+	waitItch(t, r, p)
+	checkItchMirror(t, e, mirror)
+}
+
+func TestItchRetransmitWindow(t *testing.T) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	sink := NewItchReceiver("FEED", func(ItchMessage) {})
+	if err := sink.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	p, err := NewItchPublisher(e, sink.Addr().String(), ItchConfig{Session: "FEED", RetransmitWindow: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	for i := 0; i < 10; i++ {
+		e.Submit("BTC", orders.NewIncomingOrder(float32(10+i), 1, true, orders.LIMIT, 1))
+	}
+
+	if p.replay(1, 10) != nil {
+		t.Errorf("messages out of the window should not be replayed")
+	}
+	_, sequence, messages, err := parseItchPacket(p.replay(8, 10))
+	if err != nil || sequence != 8 || len(messages) != 4 {
+		t.Fatalf("the last 4 messages should be replayed, got %d from %d %v", len(messages), sequence, err)
+	}
+	var m ItchMessage
+	m.decode(messages[3])
+	if m.Type != ItchAddOrder || m.Price != 19 {
+		t.Errorf("the newest message should be the last add, got %+v", m)
+	}
+}
+
+func TestItchReceiverSkipsBadMessage(t *testing.T) {
+	var r *ItchReceiver
+	var got []uint64
+	r = NewItchReceiver("FEED", func(m ItchMessage) {
+		// the handler may look at the receiver
+		got = append(got, m.Timestamp, r.Next())
+	})
+	packet := appendItchHeader(nil, "FEED", 1, 3)
+	packet = appendItchBlock(packet, (&ItchMessage{Type: ItchOrderDelete, Timestamp: 1}).encode(nil))
+	packet = appendItchBlock(packet, []byte{'?', 0})
+	packet = appendItchBlock(packet, (&ItchMessage{Type: ItchOrderDelete, Timestamp: 3}).encode(nil))
+
+	done := make(chan error)
+	go func() { done <- r.Receive(packet) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("the bad message should be reported")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler should not deadlock on Next")
+	}
+	if want := []uint64{1, 4, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("the messages around the bad one should be delivered, got %v want %v", got, want)
+	}
+	if r.Next() != 4 {
+		t.Errorf("the bad message should be skipped, next is %d", r.Next())
+	}
+}
@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"matching-engine-go/book"
+	"matching-engine-go/ds"
+)
+
+// why the engine turned a request down, the reason label of the rejects counter
+type rejectReason uint8
+
+const (
+	rejectUnknownSymbol rejectReason = iota
+	rejectUnknownOrder
+	rejectInvalidQuantity
+	rejectInvalidPrice
+	rejectAccountKilled
+	rejectSession
+	rejectRisk
+	rejectMemoryBudget
+	rejectOther
+	rejectReasons
+)
+
+var rejectLabels = [rejectReasons]string{
+	"unknown_symbol", "unknown_order", "invalid_quantity", "invalid_price", "account_killed",
+	"session", "risk", "memory_budget", "other",
+}
+
+func reasonOf(err error) rejectReason {
+	switch {
+	case errors.Is(err, ErrUnknownSymbol):
+		return rejectUnknownSymbol
+	case errors.Is(err, ErrUnknownOrder):
+		return rejectUnknownOrder
+	case errors.Is(err, ErrInvalidQuantity):
+		return rejectInvalidQuantity
+	case errors.Is(err, ErrInvalidPrice):
+		return rejectInvalidPrice
+	case errors.Is(err, ErrAccountKilled):
+		return rejectAccountKilled
+	case errors.Is(err, ErrSessionNotConnected):
+		return rejectSession
+	case errors.Is(err, ErrRiskOrderSize), errors.Is(err, ErrRiskPosition), errors.Is(err, ErrRiskExposure),
+		errors.Is(err, ErrRiskBalance):
+		return rejectRisk
+	case errors.Is(err, book.ErrMemoryBudget):
+		return rejectMemoryBudget
+	}
+	return rejectOther
+}
+
+// engine counters. they are only touched with the engine locked, so recording is a plain
+// increment and never allocates
+type engineMetrics struct {
+	ordersIn uint64
+	fills    uint64
+	cancels  uint64
+	rejects  [rejectReasons]uint64
+	// time spent in Orderbook.Execute
+	matching ds.LatencyHistogram
+}
+
+func (m *engineMetrics) reject(err error) {
+	if err != nil {
+		m.rejects[reasonOf(err)]++
+	}
+}
+
+func (m *engineMetrics) onEvent(ev book.Event) {
+	switch ev.Type {
+	case book.EventFill:
+		m.fills++
+	case book.EventCancelled:
+		m.cancels++
+	}
+}
+
+// bucket bounds of the exposed histograms
+var (
+	metricsLatencyBounds = []time.Duration{
+		100 * time.Nanosecond, 250 * time.Nanosecond, 500 * time.Nanosecond,
+		time.Microsecond, 2500 * time.Nanosecond, 5 * time.Microsecond,
+		10 * time.Microsecond, 25 * time.Microsecond, 50 * time.Microsecond,
+		100 * time.Microsecond, 250 * time.Microsecond, time.Millisecond,
+	}
+	// powers of two, the buckets of book.LevelHistogram
+	metricsLevelBounds = []int{1, 2, 4, 8, 16, 32, 64, 128, 256}
+)
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// writes the metrics of the engine in the Prometheus text format. The engine counters and
+// the book gauges are copied in one go with the engine locked, the text is written after.
+func (e *Engine) WriteMetrics(w io.Writer) error {
+	e.mu.Lock()
+	s := e.metricsSnapshot()
+	e.mu.Unlock()
+
+	var b bytes.Buffer
+	s.write(&b)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// serves WriteMetrics, to be scraped by Prometheus
+func (e *Engine) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metricsContentType)
+		e.WriteMetrics(w)
+	})
+}
+
+// what a scrape reports, copied with the engine locked
+type metricsSnapshot struct {
+	engineMetrics
+	books []bookMetrics
+}
+
+type bookMetrics struct {
+	symbol    string
+	bidLevels book.LevelHistogram
+	askLevels book.LevelHistogram
+	stats     book.BookStats
+}
+
+// the books keep their level histograms as they go, nothing is walked here
+func (e *Engine) metricsSnapshot() *metricsSnapshot {
+	s := &metricsSnapshot{engineMetrics: e.metrics}
+	for _, symbol := range e.symbols() {
+		book := e.books[symbol]
+		s.books = append(s.books, bookMetrics{
+			symbol:    symbol,
+			bidLevels: book.LevelHistogram(true),
+			askLevels: book.LevelHistogram(false),
+			stats:     book.Stats(),
+		})
+	}
+	return s
+}
+
+func (s *metricsSnapshot) write(b *bytes.Buffer) {
+	metricHeader(b, "matching_engine_orders_in_total", "counter", "Orders submitted, replacements included.")
+	fmt.Fprintf(b, "matching_engine_orders_in_total %d\n", s.ordersIn)
+	metricHeader(b, "matching_engine_fills_total", "counter", "Fills between an incoming and a resting order.")
+	fmt.Fprintf(b, "matching_engine_fills_total %d\n", s.fills)
+	metricHeader(b, "matching_engine_cancels_total", "counter", "Resting orders cancelled or reduced.")
+	fmt.Fprintf(b, "matching_engine_cancels_total %d\n", s.cancels)
+	metricHeader(b, "matching_engine_rejects_total", "counter", "Requests turned down, by reason.")
+	for reason, count := range s.rejects {
+		fmt.Fprintf(b, "matching_engine_rejects_total{reason=%q} %d\n", rejectLabels[reason], count)
+	}
+
+	metricHeader(b, "matching_engine_matching_seconds", "histogram", "Time spent matching an incoming order.")
+	for _, bound := range metricsLatencyBounds {
+		fmt.Fprintf(b, "matching_engine_matching_seconds_bucket{le=\"%g\"} %d\n", bound.Seconds(), s.matching.CountAtMost(bound))
+	}
+	fmt.Fprintf(b, "matching_engine_matching_seconds_bucket{le=\"+Inf\"} %d\n", s.matching.Count())
+	fmt.Fprintf(b, "matching_engine_matching_seconds_sum %g\n", s.matching.Sum().Seconds())
+	fmt.Fprintf(b, "matching_engine_matching_seconds_count %d\n", s.matching.Count())
+
+	metricHeader(b, "matching_engine_levels", "gauge", "Price levels per side.")
+	for _, m := range s.books {
+		fmt.Fprintf(b, "matching_engine_levels{symbol=%q,side=\"bid\"} %d\n", m.symbol, m.bidLevels.Levels)
+		fmt.Fprintf(b, "matching_engine_levels{symbol=%q,side=\"ask\"} %d\n", m.symbol, m.askLevels.Levels)
+	}
+	metricHeader(b, "matching_engine_level_orders", "histogram", "Resting orders per price level.")
+	for _, m := range s.books {
+		writeLevelOrders(b, m.symbol, "bid", &m.bidLevels)
+		writeLevelOrders(b, m.symbol, "ask", &m.askLevels)
+	}
+
+	metricHeader(b, "matching_engine_pool_gets_total", "counter", "Price levels taken from the level pool.")
+	for _, m := range s.books {
+		fmt.Fprintf(b, "matching_engine_pool_gets_total{symbol=%q} %d\n", m.symbol, m.stats.PoolGets)
+	}
+	metricHeader(b, "matching_engine_pool_misses_total", "counter", "Price levels the level pool had to allocate.")
+	for _, m := range s.books {
+		fmt.Fprintf(b, "matching_engine_pool_misses_total{symbol=%q} %d\n", m.symbol, m.stats.PoolMisses)
+	}
+	metricHeader(b, "matching_engine_pool_hit_ratio", "gauge", "Share of the level pool gets served without allocating.")
+	for _, m := range s.books {
+		ratio := 1.0
+		if m.stats.PoolGets > 0 {
+			ratio = 1 - float64(m.stats.PoolMisses)/float64(m.stats.PoolGets)
+		}
+		fmt.Fprintf(b, "matching_engine_pool_hit_ratio{symbol=%q} %g\n", m.symbol, ratio)
+	}
+	metricHeader(b, "matching_engine_deque_resizes_total", "counter", "Orders that made the deque of their level grow.")
+	for _, m := range s.books {
+		fmt.Fprintf(b, "matching_engine_deque_resizes_total{symbol=%q} %d\n", m.symbol, m.stats.Resizes)
+	}
+}
+
+func metricHeader(b *bytes.Buffer, name string, kind string, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// the orders per level of one side as a histogram
+func writeLevelOrders(b *bytes.Buffer, symbol string, side string, levels *book.LevelHistogram) {
+	for _, bound := range metricsLevelBounds {
+		fmt.Fprintf(b, "matching_engine_level_orders_bucket{symbol=%q,side=%q,le=\"%d\"} %d\n", symbol, side, bound, levels.AtMost(bound))
+	}
+	fmt.Fprintf(b, "matching_engine_level_orders_bucket{symbol=%q,side=%q,le=\"+Inf\"} %d\n", symbol, side, levels.Levels)
+	fmt.Fprintf(b, "matching_engine_level_orders_sum{symbol=%q,side=%q} %d\n", symbol, side, levels.Orders)
+	fmt.Fprintf(b, "matching_engine_level_orders_count{symbol=%q,side=%q} %d\n", symbol, side, levels.Levels)
+}
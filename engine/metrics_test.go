@@ -0,0 +1,73 @@
+package engine
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+func TestEngineMetrics(t *testing.T) {
+	server, e := newHttpApi(t)
+	e.Submit("BTC", orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 1))
+	e.Submit("BTC", orders.NewIncomingOrder(10, 2, true, orders.LIMIT, 1))
+	e.Submit("BTC", orders.NewIncomingOrder(11, 1, true, orders.LIMIT, 1))
+	e.Submit("BTC", orders.NewIncomingOrder(12, 1, false, orders.LIMIT, 2))
+	// fills the bid at 11 and one at 10
+	e.Submit("BTC", orders.NewIncomingOrder(10, 2, false, orders.LIMIT, 2))
+	e.Cancel("BTC", 2)
+	e.Submit("BTC", orders.NewIncomingOrder(10, 0, true, orders.LIMIT, 1))
+	e.Submit("XRP", orders.NewIncomingOrder(10, 1, true, orders.LIMIT, 1))
+	e.Cancel("BTC", 99)
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	for _, line := range []string{
+		"# TYPE matching_engine_orders_in_total counter",
+		"matching_engine_orders_in_total 7",
+		"matching_engine_fills_total 2",
+		"matching_engine_cancels_total 1",
+		`matching_engine_rejects_total{reason="unknown_symbol"} 1`,
+		`matching_engine_rejects_total{reason="unknown_order"} 1`,
+		`matching_engine_rejects_total{reason="invalid_quantity"} 1`,
+		`matching_engine_matching_seconds_bucket{le="+Inf"} 5`,
+		"matching_engine_matching_seconds_count 5",
+		`matching_engine_levels{symbol="BTC",side="bid"} 0`,
+		`matching_engine_levels{symbol="BTC",side="ask"} 1`,
+		`matching_engine_level_orders_bucket{symbol="BTC",side="ask",le="1"} 1`,
+		`matching_engine_level_orders_count{symbol="ETH",side="bid"} 0`,
+		`matching_engine_pool_gets_total{symbol="BTC"} 3`,
+		`matching_engine_pool_misses_total{symbol="BTC"} 3`,
+		`matching_engine_pool_hit_ratio{symbol="ETH"} 1`,
+		`matching_engine_deque_resizes_total{symbol="BTC"} 0`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("missing %q in\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsRecordingDoesNotAllocate(t *testing.T) {
+	var m engineMetrics
+	fill := book.Event{Type: book.EventFill}
+	allocs := testing.AllocsPerRun(1000, func() {
+		m.ordersIn++
+		m.reject(ErrRiskBalance)
+		m.onEvent(fill)
+		m.matching.Record(300 * time.Nanosecond)
+	})
+	if allocs != 0 {
+		t.Errorf("recording should not allocate, got %v allocations", allocs)
+	}
+}
@@ -0,0 +1,436 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+// OUCH-style order entry: every message is a type byte followed by a fixed big endian layout,
+// so both sides know the length of a message from its first byte. Prices and quantities
+// travel as raw float32 bits, the same as IncomingOrder holds them.
+
+// inbound message types
+const (
+	ouchEnterOrder   byte = 'O'
+	ouchReplaceOrder byte = 'U'
+	ouchCancelOrder  byte = 'X'
+)
+
+// outbound message types
+const (
+	ouchAccepted byte = 'A'
+	ouchReplaced byte = 'U'
+	ouchExecuted byte = 'E'
+	ouchCanceled byte = 'C'
+	ouchRejected byte = 'J'
+)
+
+// message lengths, type byte included
+const (
+	ouchEnterOrderLen   = 28
+	ouchReplaceOrderLen = 17
+	ouchCancelOrderLen  = 5
+
+	ouchAcceptedLen = 35
+	ouchReplacedLen = 29
+	ouchExecutedLen = 33
+	ouchCanceledLen = 18
+	ouchRejectedLen = 14
+)
+
+// symbols are space padded to a fixed width
+const ouchSymbolLen = 8
+
+const (
+	OuchBuy  byte = 'B'
+	OuchSell byte = 'S'
+
+	OuchLimit  byte = 'L'
+	OuchMarket byte = 'M'
+)
+
+// reasons of Rejected messages
+const (
+	OuchRejectUnknownSymbol   byte = 'S'
+	OuchRejectInvalidQuantity byte = 'Q'
+	OuchRejectInvalidPrice    byte = 'X'
+	OuchRejectRisk            byte = 'R'
+	OuchRejectKilled          byte = 'K'
+	OuchRejectDuplicateToken  byte = 'D'
+	OuchRejectUnknownOrder    byte = 'U'
+	OuchRejectInvalidMessage  byte = 'I'
+	OuchRejectNotConnected    byte = 'N'
+	OuchRejectMemoryBudget    byte = 'M'
+	OuchRejectOther           byte = 'O'
+)
+
+// reasons of Canceled messages
+const (
+	// asked for by the client
+	OuchCancelUser byte = 'U'
+	// the remainder could not rest in the book
+	OuchCancelImmediate byte = 'I'
+	// mass cancel, kill switch or session expiry
+	OuchCancelSupervisory byte = 'S'
+)
+
+// EnterOrder flags
+const (
+	OuchFlagCancelOnDisconnect byte = 1 << 0
+)
+
+var errOuchUnknownType = errors.New("ouch: unknown message type")
+
+// client order entry, Token is chosen by the client and unique per connection
+type OuchEnterOrder struct {
+	Token     uint32
+	Side      byte
+	OrderType byte
+	Quantity  float32
+	Price     float32
+	AccountId uint32
+	Flags     byte
+	Symbol    string
+}
+
+// replaces the live order Token by a new one for Quantity at Price
+type OuchReplaceOrder struct {
+	Token    uint32
+	NewToken uint32
+	Quantity float32
+	Price    float32
+}
+
+type OuchCancelOrder struct {
+	Token uint32
+}
+
+// the order rests in the book or traded on entry, OrderRef is the engine sequenceId
+type OuchAccepted struct {
+	Timestamp uint64
+	Token     uint32
+	OrderRef  uint32
+	Side      byte
+	OrderType byte
+	Quantity  float32
+	Price     float32
+	Symbol    string
+}
+
+type OuchReplaced struct {
+	Timestamp     uint64
+	Token         uint32
+	OrderRef      uint32
+	PreviousToken uint32
+	Quantity      float32
+	Price         float32
+}
+
+type OuchExecuted struct {
+	Timestamp   uint64
+	Token       uint32
+	Quantity    float32
+	Price       float32
+	Leaves      float32
+	MatchNumber uint64
+}
+
+type OuchCanceled struct {
+	Timestamp uint64
+	Token     uint32
+	// quantity taken out of the book
+	Quantity float32
+	Reason   byte
+}
+
+type OuchRejected struct {
+	Timestamp uint64
+	Token     uint32
+	Reason    byte
+}
+
+// length of the message starting with type t, 0 if unknown
+func ouchInboundLen(t byte) int {
+	switch t {
+	case ouchEnterOrder:
+		return ouchEnterOrderLen
+	case ouchReplaceOrder:
+		return ouchReplaceOrderLen
+	case ouchCancelOrder:
+		return ouchCancelOrderLen
+	}
+	return 0
+}
+
+func ouchOutboundLen(t byte) int {
+	switch t {
+	case ouchAccepted:
+		return ouchAcceptedLen
+	case ouchReplaced:
+		return ouchReplacedLen
+	case ouchExecuted:
+		return ouchExecutedLen
+	case ouchCanceled:
+		return ouchCanceledLen
+	case ouchRejected:
+		return ouchRejectedLen
+	}
+	return 0
+}
+
+// reads one message into buf, which must hold the longest message, and returns it
+func readOuchMessage(r io.Reader, buf []byte, length func(t byte) int) ([]byte, error) {
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return nil, err
+	}
+	n := length(buf[0])
+	if n == 0 {
+		return nil, errOuchUnknownType
+	}
+	if _, err := io.ReadFull(r, buf[1:n]); err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func putFloat(b []byte, f float32) {
+	binary.BigEndian.PutUint32(b, math.Float32bits(f))
+}
+
+func getFloat(b []byte) float32 {
+	return math.Float32frombits(binary.BigEndian.Uint32(b))
+}
+
+func putSymbol(b []byte, symbol string) {
+	n := copy(b[:ouchSymbolLen], symbol)
+	for i := n; i < ouchSymbolLen; i++ {
+		b[i] = ' '
+	}
+}
+
+func getSymbol(b []byte) string {
+	end := ouchSymbolLen
+	for end > 0 && b[end-1] == ' ' {
+		end--
+	}
+	return string(b[:end])
+}
+
+func (m *OuchEnterOrder) encode(b []byte) []byte {
+	b = b[:ouchEnterOrderLen]
+	b[0] = ouchEnterOrder
+	binary.BigEndian.PutUint32(b[1:], m.Token)
+	b[5] = m.Side
+	b[6] = m.OrderType
+	putFloat(b[7:], m.Quantity)
+	putFloat(b[11:], m.Price)
+	binary.BigEndian.PutUint32(b[15:], m.AccountId)
+	b[19] = m.Flags
+	putSymbol(b[20:], m.Symbol)
+	return b
+}
+
+func (m *OuchEnterOrder) decode(b []byte) {
+	m.Token = binary.BigEndian.Uint32(b[1:])
+	m.Side = b[5]
+	m.OrderType = b[6]
+	m.Quantity = getFloat(b[7:])
+	m.Price = getFloat(b[11:])
+	m.AccountId = binary.BigEndian.Uint32(b[15:])
+	m.Flags = b[19]
+	m.Symbol = getSymbol(b[20:])
+}
+
+func (m *OuchReplaceOrder) encode(b []byte) []byte {
+	b = b[:ouchReplaceOrderLen]
+	b[0] = ouchReplaceOrder
+	binary.BigEndian.PutUint32(b[1:], m.Token)
+	binary.BigEndian.PutUint32(b[5:], m.NewToken)
+	putFloat(b[9:], m.Quantity)
+	putFloat(b[13:], m.Price)
+	return b
+}
+
+func (m *OuchReplaceOrder) decode(b []byte) {
+	m.Token = binary.BigEndian.Uint32(b[1:])
+	m.NewToken = binary.BigEndian.Uint32(b[5:])
+	m.Quantity = getFloat(b[9:])
+	m.Price = getFloat(b[13:])
+}
+
+func (m *OuchCancelOrder) encode(b []byte) []byte {
+	b = b[:ouchCancelOrderLen]
+	b[0] = ouchCancelOrder
+	binary.BigEndian.PutUint32(b[1:], m.Token)
+	return b
+}
+
+func (m *OuchCancelOrder) decode(b []byte) {
+	m.Token = binary.BigEndian.Uint32(b[1:])
+}
+
+func (m *OuchAccepted) encode(b []byte) []byte {
+	b = b[:ouchAcceptedLen]
+	b[0] = ouchAccepted
+	binary.BigEndian.PutUint64(b[1:], m.Timestamp)
+	binary.BigEndian.PutUint32(b[9:], m.Token)
+	binary.BigEndian.PutUint32(b[13:], m.OrderRef)
+	b[17] = m.Side
+	b[18] = m.OrderType
+	putFloat(b[19:], m.Quantity)
+	putFloat(b[23:], m.Price)
+	putSymbol(b[27:], m.Symbol)
+	return b
+}
+
+func (m *OuchAccepted) decode(b []byte) {
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.Token = binary.BigEndian.Uint32(b[9:])
+	m.OrderRef = binary.BigEndian.Uint32(b[13:])
+	m.Side = b[17]
+	m.OrderType = b[18]
+	m.Quantity = getFloat(b[19:])
+	m.Price = getFloat(b[23:])
+	m.Symbol = getSymbol(b[27:])
+}
+
+func (m *OuchReplaced) encode(b []byte) []byte {
+	b = b[:ouchReplacedLen]
+	b[0] = ouchReplaced
+	binary.BigEndian.PutUint64(b[1:], m.Timestamp)
+	binary.BigEndian.PutUint32(b[9:], m.Token)
+	binary.BigEndian.PutUint32(b[13:], m.OrderRef)
+	binary.BigEndian.PutUint32(b[17:], m.PreviousToken)
+	putFloat(b[21:], m.Quantity)
+	putFloat(b[25:], m.Price)
+	return b
+}
+
+func (m *OuchReplaced) decode(b []byte) {
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.Token = binary.BigEndian.Uint32(b[9:])
+	m.OrderRef = binary.BigEndian.Uint32(b[13:])
+	m.PreviousToken = binary.BigEndian.Uint32(b[17:])
+	m.Quantity = getFloat(b[21:])
+	m.Price = getFloat(b[25:])
+}
+
+func (m *OuchExecuted) encode(b []byte) []byte {
+	b = b[:ouchExecutedLen]
+	b[0] = ouchExecuted
+	binary.BigEndian.PutUint64(b[1:], m.Timestamp)
+	binary.BigEndian.PutUint32(b[9:], m.Token)
+	putFloat(b[13:], m.Quantity)
+	putFloat(b[17:], m.Price)
+	putFloat(b[21:], m.Leaves)
+	binary.BigEndian.PutUint64(b[25:], m.MatchNumber)
+	return b
+}
+
+func (m *OuchExecuted) decode(b []byte) {
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.Token = binary.BigEndian.Uint32(b[9:])
+	m.Quantity = getFloat(b[13:])
+	m.Price = getFloat(b[17:])
+	m.Leaves = getFloat(b[21:])
+	m.MatchNumber = binary.BigEndian.Uint64(b[25:])
+}
+
+func (m *OuchCanceled) encode(b []byte) []byte {
+	b = b[:ouchCanceledLen]
+	b[0] = ouchCanceled
+	binary.BigEndian.PutUint64(b[1:], m.Timestamp)
+	binary.BigEndian.PutUint32(b[9:], m.Token)
+	putFloat(b[13:], m.Quantity)
+	b[17] = m.Reason
+	return b
+}
+
+func (m *OuchCanceled) decode(b []byte) {
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.Token = binary.BigEndian.Uint32(b[9:])
+	m.Quantity = getFloat(b[13:])
+	m.Reason = b[17]
+}
+
+func (m *OuchRejected) encode(b []byte) []byte {
+	b = b[:ouchRejectedLen]
+	b[0] = ouchRejected
+	binary.BigEndian.PutUint64(b[1:], m.Timestamp)
+	binary.BigEndian.PutUint32(b[9:], m.Token)
+	b[13] = m.Reason
+	return b
+}
+
+func (m *OuchRejected) decode(b []byte) {
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.Token = binary.BigEndian.Uint32(b[9:])
+	m.Reason = b[13]
+}
+
+// maps an EnterOrder straight onto the engine order
+func (m *OuchEnterOrder) incoming() (orders.IncomingOrder, bool) {
+	var bidOrAsk bool
+	switch m.Side {
+	case OuchBuy:
+		bidOrAsk = true
+	case OuchSell:
+	default:
+		return orders.IncomingOrder{}, false
+	}
+	var orderType orders.OrderType
+	switch m.OrderType {
+	case OuchLimit:
+		orderType = orders.LIMIT
+	case OuchMarket:
+		orderType = orders.MARKET
+	default:
+		return orders.IncomingOrder{}, false
+	}
+	incoming := orders.NewIncomingOrder(m.Price, m.Quantity, bidOrAsk, orderType, m.AccountId)
+	incoming.CancelOnDisconnect = m.Flags&OuchFlagCancelOnDisconnect != 0
+	return incoming, true
+}
+
+func ouchSide(bidOrAsk bool) byte {
+	if bidOrAsk {
+		return OuchBuy
+	}
+	return OuchSell
+}
+
+func ouchOrderType(t orders.OrderType) byte {
+	if t == orders.MARKET {
+		return OuchMarket
+	}
+	return OuchLimit
+}
+
+// reject reason of an engine error
+func ouchRejectReason(err error) byte {
+	switch {
+	case errors.Is(err, ErrUnknownSymbol):
+		return OuchRejectUnknownSymbol
+	case errors.Is(err, ErrInvalidQuantity):
+		return OuchRejectInvalidQuantity
+	case errors.Is(err, ErrInvalidPrice):
+		return OuchRejectInvalidPrice
+	case errors.Is(err, ErrAccountKilled):
+		return OuchRejectKilled
+	case errors.Is(err, ErrUnknownOrder):
+		return OuchRejectUnknownOrder
+	case errors.Is(err, ErrSessionNotConnected):
+		return OuchRejectNotConnected
+	case errors.Is(err, book.ErrMemoryBudget):
+		return OuchRejectMemoryBudget
+	case errors.Is(err, ErrRiskOrderSize), errors.Is(err, ErrRiskPosition),
+		errors.Is(err, ErrRiskExposure), errors.Is(err, ErrRiskBalance):
+		return OuchRejectRisk
+	}
+	return OuchRejectOther
+}
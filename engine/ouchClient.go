@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"bufio"
+	"net"
+	"sync"
+)
+
+// one message from the gateway, Type tells which of the fields is set
+type OuchResponse struct {
+	Type     byte
+	Accepted OuchAccepted
+	Replaced OuchReplaced
+	Executed OuchExecuted
+	Canceled OuchCanceled
+	Rejected OuchRejected
+}
+
+// OuchClient is the client side of the binary order entry protocol. Orders can be sent
+// from several goroutines, responses are read by a single one with Read.
+type OuchClient struct {
+	conn net.Conn
+
+	mu     sync.Mutex
+	w      *bufio.Writer
+	wbuf   [ouchEnterOrderLen]byte
+	nextId uint32
+
+	r    *bufio.Reader
+	rbuf [ouchAcceptedLen]byte
+}
+
+func DialOuch(addr string) (*OuchClient, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(true)
+	}
+	return &OuchClient{conn: conn, w: bufio.NewWriter(conn), r: bufio.NewReader(conn)}, nil
+}
+
+// a token not handed out by this client before
+func (c *OuchClient) NextToken() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextId++
+	return c.nextId
+}
+
+func (c *OuchClient) Enter(m OuchEnterOrder) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.send(m.encode(c.wbuf[:]))
+}
+
+func (c *OuchClient) Replace(m OuchReplaceOrder) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.send(m.encode(c.wbuf[:]))
+}
+
+func (c *OuchClient) Cancel(token uint32) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := OuchCancelOrder{Token: token}
+	return c.send(m.encode(c.wbuf[:]))
+}
+
+func (c *OuchClient) send(b []byte) error {
+	if _, err := c.w.Write(b); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// blocks for the next message from the gateway and decodes it into resp
+func (c *OuchClient) Read(resp *OuchResponse) error {
+	b, err := readOuchMessage(c.r, c.rbuf[:], ouchOutboundLen)
+	if err != nil {
+		return err
+	}
+	resp.Type = b[0]
+	switch b[0] {
+	case ouchAccepted:
+		resp.Accepted.decode(b)
+	case ouchReplaced:
+		resp.Replaced.decode(b)
+	case ouchExecuted:
+		resp.Executed.decode(b)
+	case ouchCanceled:
+		resp.Canceled.decode(b)
+	case ouchRejected:
+		resp.Rejected.decode(b)
+	}
+	return nil
+}
+
+func (c *OuchClient) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,485 @@
+package engine
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	"matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+// outgoing messages buffered per connection before it is dropped as a slow consumer
+const ouchOutboundBuffer = 4096
+
+// settings of the binary order entry gateway
+type OuchConfig struct {
+	// orders flagged cancel-on-disconnect are cancelled DisconnectGrace after their
+	// connection drops
+	DisconnectGrace time.Duration
+	// stamps the outgoing messages, defaults to the wall clock
+	Clock Clock
+}
+
+// OuchGateway serves the OUCH-style binary order entry protocol in front of the Engine.
+// Every connection is an engine session, tokens are chosen by the client and only
+// have to be unique within their connection. As with the FIX gateway, the engine is never
+// called with the gateway locked.
+type OuchGateway struct {
+	engine *Engine
+	config OuchConfig
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*ouchConn]struct{}
+	// live orders entered through the gateway by engine sequenceId
+	orders map[uint32]*ouchOrder
+	// events of orders still being submitted, claimed once Submit returns their sequenceId
+	unclaimed   map[uint32][]book.Event
+	inflight    int
+	matchNumber uint64
+	closed      bool
+	wg          sync.WaitGroup
+}
+
+type ouchConn struct {
+	conn      net.Conn
+	out       chan ouchFrame
+	done      chan struct{}
+	once      sync.Once
+	sessionId uint32
+	// live orders by token
+	live map[uint32]*ouchOrder
+}
+
+// one outgoing message, sent by value so the hot path does not allocate
+type ouchFrame struct {
+	n int
+	b [ouchAcceptedLen]byte
+}
+
+type ouchOrder struct {
+	conn       *ouchConn
+	token      uint32
+	symbol     string
+	incoming   orders.IncomingOrder
+	sequenceId uint32
+	// cancel requested by the client
+	cancelPending bool
+	// a replace is in flight, the cancel of this order is part of it
+	replacing bool
+	// cancelled by someone else while the replace was in flight
+	cancelledWhileReplacing bool
+}
+
+func NewOuchGateway(engine *Engine, config OuchConfig) *OuchGateway {
+	if config.Clock == nil {
+		config.Clock = NewRealClock()
+	}
+	g := &OuchGateway{
+		engine:    engine,
+		config:    config,
+		conns:     make(map[*ouchConn]struct{}),
+		orders:    make(map[uint32]*ouchOrder),
+		unclaimed: make(map[uint32][]book.Event),
+	}
+	engine.Subscribe(g.onEvent)
+	return g
+}
+
+// listens on addr and serves connections in the background
+func (g *OuchGateway) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.listener = l
+	g.mu.Unlock()
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.Serve(l)
+	}()
+	return nil
+}
+
+func (g *OuchGateway) Addr() net.Addr {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.listener == nil {
+		return nil
+	}
+	return g.listener.Addr()
+}
+
+// accepts connections until the listener is closed
+func (g *OuchGateway) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetNoDelay(true)
+		}
+		c := &ouchConn{
+			conn: conn,
+			out:  make(chan ouchFrame, ouchOutboundBuffer),
+			done: make(chan struct{}),
+			live: make(map[uint32]*ouchOrder),
+		}
+
+		g.mu.Lock()
+		if g.closed {
+			g.mu.Unlock()
+			conn.Close()
+			return net.ErrClosed
+		}
+		g.conns[c] = struct{}{}
+		g.wg.Add(1)
+		g.mu.Unlock()
+
+		go g.serve(c)
+	}
+}
+
+// stops listening and drops every connection
+func (g *OuchGateway) Close() error {
+	g.mu.Lock()
+	g.closed = true
+	var err error
+	if g.listener != nil {
+		err = g.listener.Close()
+	}
+	for c := range g.conns {
+		c.close()
+	}
+	g.mu.Unlock()
+
+	g.wg.Wait()
+	return err
+}
+
+func (g *OuchGateway) serve(c *ouchConn) {
+	defer g.wg.Done()
+	defer c.close()
+
+	c.sessionId = g.engine.NewSessionId()
+	g.engine.Connect(c.sessionId, g.config.DisconnectGrace)
+	defer g.disconnect(c)
+	go c.writeLoop()
+
+	r := bufio.NewReader(c.conn)
+	var buf [ouchEnterOrderLen]byte
+	for {
+		b, err := readOuchMessage(r, buf[:], ouchInboundLen)
+		if err != nil {
+			return
+		}
+		switch b[0] {
+		case ouchEnterOrder:
+			var m OuchEnterOrder
+			m.decode(b)
+			g.enter(c, &m)
+		case ouchReplaceOrder:
+			var m OuchReplaceOrder
+			m.decode(b)
+			g.replace(c, &m)
+		case ouchCancelOrder:
+			var m OuchCancelOrder
+			m.decode(b)
+			g.cancel(c, &m)
+		}
+	}
+}
+
+// batches the outgoing messages, flushing whenever nothing else is queued
+func (c *ouchConn) writeLoop() {
+	w := bufio.NewWriter(c.conn)
+	for {
+		select {
+		case f := <-c.out:
+			w.Write(f.b[:f.n])
+			if len(c.out) == 0 {
+				if err := w.Flush(); err != nil {
+					c.close()
+					return
+				}
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *ouchConn) write(f *ouchFrame) {
+	select {
+	case <-c.done:
+	case c.out <- *f:
+	default:
+		// slow consumer
+		c.close()
+	}
+}
+
+func (c *ouchConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (g *OuchGateway) disconnect(c *ouchConn) {
+	g.mu.Lock()
+	delete(g.conns, c)
+	g.mu.Unlock()
+
+	g.engine.Disconnect(c.sessionId)
+}
+
+func (g *OuchGateway) timestamp() uint64 {
+	return uint64(g.config.Clock.Now().UnixNano())
+}
+
+// EnterOrder
+func (g *OuchGateway) enter(c *ouchConn, m *OuchEnterOrder) {
+	incoming, ok := m.incoming()
+	g.mu.Lock()
+	if !ok {
+		g.reject(c, m.Token, OuchRejectInvalidMessage)
+		g.mu.Unlock()
+		return
+	}
+	if c.live[m.Token] != nil {
+		g.reject(c, m.Token, OuchRejectDuplicateToken)
+		g.mu.Unlock()
+		return
+	}
+	incoming.SessionId = c.sessionId
+	o := &ouchOrder{conn: c, token: m.Token, symbol: m.Symbol, incoming: incoming}
+	// reserved so a second EnterOrder with the same token is refused while this one is submitted
+	c.live[m.Token] = o
+	g.inflight++
+	g.mu.Unlock()
+
+	order, err := g.engine.Submit(o.symbol, incoming)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.accepted(o, order, err, nil)
+}
+
+// CancelOrder
+func (g *OuchGateway) cancel(c *ouchConn, m *OuchCancelOrder) {
+	g.mu.Lock()
+	o := c.live[m.Token]
+	if o == nil || o.sequenceId == 0 {
+		g.reject(c, m.Token, OuchRejectUnknownOrder)
+		g.mu.Unlock()
+		return
+	}
+	o.cancelPending = true
+	symbol, sequenceId := o.symbol, o.sequenceId
+	g.mu.Unlock()
+
+	// the Canceled message comes from the engine event
+	if _, err := g.engine.Cancel(symbol, sequenceId); err != nil {
+		g.mu.Lock()
+		o.cancelPending = false
+		g.reject(c, m.Token, OuchRejectUnknownOrder)
+		g.mu.Unlock()
+	}
+}
+
+// ReplaceOrder, the replacement is a new order in the engine under NewToken
+func (g *OuchGateway) replace(c *ouchConn, m *OuchReplaceOrder) {
+	g.mu.Lock()
+	old := c.live[m.Token]
+	if old == nil || old.sequenceId == 0 || old.replacing {
+		g.reject(c, m.NewToken, OuchRejectUnknownOrder)
+		g.mu.Unlock()
+		return
+	}
+	if m.NewToken != m.Token && c.live[m.NewToken] != nil {
+		g.reject(c, m.NewToken, OuchRejectDuplicateToken)
+		g.mu.Unlock()
+		return
+	}
+	incoming := old.incoming
+	incoming.Quantity = m.Quantity
+	incoming.Price = m.Price
+	o := &ouchOrder{conn: c, token: m.NewToken, symbol: old.symbol, incoming: incoming}
+	if m.NewToken != m.Token {
+		c.live[m.NewToken] = o
+	}
+	old.replacing = true
+	g.inflight++
+	g.mu.Unlock()
+
+	order, err := g.engine.Replace(o.symbol, old.sequenceId, incoming)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	cancelled := old.cancelledWhileReplacing
+	old.replacing, old.cancelledWhileReplacing = false, false
+	if order.SequenceId == 0 {
+		g.inflight--
+		if c.live[m.NewToken] == o {
+			delete(c.live, m.NewToken)
+		}
+		g.reject(c, m.NewToken, ouchRejectReason(err))
+		if cancelled {
+			g.cancelled(old, old.incoming.Quantity, OuchCancelSupervisory)
+		}
+		g.clearUnclaimed()
+		return
+	}
+	g.remove(old)
+	c.live[m.NewToken] = o
+	g.accepted(o, order, err, old)
+}
+
+// registers an order returned by the engine, acknowledges it and reports the fills it
+// got while being submitted. replaced is the order it replaces, if any. called with the
+// gateway locked
+func (g *OuchGateway) accepted(o *ouchOrder, order orders.Order, err error, replaced *ouchOrder) {
+	defer g.clearUnclaimed()
+	g.inflight--
+	c := o.conn
+	if order.SequenceId == 0 {
+		if c.live[o.token] == o {
+			delete(c.live, o.token)
+		}
+		g.reject(c, o.token, ouchRejectReason(err))
+		return
+	}
+
+	o.sequenceId = order.SequenceId
+	g.orders[o.sequenceId] = o
+	var f ouchFrame
+	if replaced == nil {
+		m := OuchAccepted{
+			Timestamp: g.timestamp(),
+			Token:     o.token,
+			OrderRef:  o.sequenceId,
+			Side:      ouchSide(o.incoming.BidOrAsk),
+			OrderType: ouchOrderType(o.incoming.OrderType),
+			Quantity:  o.incoming.Quantity,
+			Price:     o.incoming.Price,
+			Symbol:    o.symbol,
+		}
+		f.n = len(m.encode(f.b[:]))
+	} else {
+		m := OuchReplaced{
+			Timestamp:     g.timestamp(),
+			Token:         o.token,
+			OrderRef:      o.sequenceId,
+			PreviousToken: replaced.token,
+			Quantity:      o.incoming.Quantity,
+			Price:         o.incoming.Price,
+		}
+		f.n = len(m.encode(f.b[:]))
+	}
+	c.write(&f)
+
+	for _, ev := range g.unclaimed[o.sequenceId] {
+		if ev.Type == book.EventCancelled {
+			g.cancelled(o, ev.Quantity, OuchCancelImmediate)
+			continue
+		}
+		g.executed(o, ev.Price, ev.Quantity, ev.Remaining)
+	}
+	delete(g.unclaimed, o.sequenceId)
+
+	if err != nil && g.orders[o.sequenceId] == o {
+		// matched but the remainder could not rest
+		g.cancelled(o, order.Order.Quantity-order.ExecutedQuantity, OuchCancelImmediate)
+	}
+}
+
+// events of orders nobody is waiting for any more
+func (g *OuchGateway) clearUnclaimed() {
+	if g.inflight == 0 && len(g.unclaimed) > 0 {
+		g.unclaimed = make(map[uint32][]book.Event)
+	}
+}
+
+// engine events, delivered with the engine locked
+func (g *OuchGateway) onEvent(ev book.Event) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch ev.Type {
+	case book.EventFill:
+		g.matchNumber++
+		if maker := g.orders[ev.MakerSequenceId]; maker != nil {
+			g.executed(maker, ev.Price, ev.Quantity, ev.MakerRemaining)
+		}
+		if taker := g.orders[ev.SequenceId]; taker != nil {
+			g.executed(taker, ev.Price, ev.Quantity, ev.Remaining)
+		} else if g.inflight > 0 {
+			// the taker may be one of ours being submitted
+			g.unclaimed[ev.SequenceId] = append(g.unclaimed[ev.SequenceId], ev)
+		}
+	case book.EventCancelled:
+		if o := g.orders[ev.SequenceId]; o != nil {
+			if o.replacing {
+				o.cancelledWhileReplacing = true
+				return
+			}
+			reason := OuchCancelSupervisory
+			if o.cancelPending {
+				reason = OuchCancelUser
+			}
+			g.cancelled(o, ev.Quantity, reason)
+		} else if ev.Expired && g.inflight > 0 {
+			// the market remainder of an order being submitted
+			g.unclaimed[ev.SequenceId] = append(g.unclaimed[ev.SequenceId], ev)
+		}
+	}
+}
+
+func (g *OuchGateway) executed(o *ouchOrder, price float32, quantity float32, leaves float32) {
+	if leaves <= 0 {
+		g.remove(o)
+	}
+	m := OuchExecuted{
+		Timestamp:   g.timestamp(),
+		Token:       o.token,
+		Quantity:    quantity,
+		Price:       price,
+		Leaves:      leaves,
+		MatchNumber: g.matchNumber,
+	}
+	var f ouchFrame
+	f.n = len(m.encode(f.b[:]))
+	o.conn.write(&f)
+}
+
+func (g *OuchGateway) cancelled(o *ouchOrder, quantity float32, reason byte) {
+	g.remove(o)
+	m := OuchCanceled{Timestamp: g.timestamp(), Token: o.token, Quantity: quantity, Reason: reason}
+	var f ouchFrame
+	f.n = len(m.encode(f.b[:]))
+	o.conn.write(&f)
+}
+
+func (g *OuchGateway) remove(o *ouchOrder) {
+	if g.orders[o.sequenceId] == o {
+		delete(g.orders, o.sequenceId)
+	}
+	if o.conn.live[o.token] == o {
+		delete(o.conn.live, o.token)
+	}
+}
+
+func (g *OuchGateway) reject(c *ouchConn, token uint32, reason byte) {
+	m := OuchRejected{Timestamp: g.timestamp(), Token: token, Reason: reason}
+	var f ouchFrame
+	f.n = len(m.encode(f.b[:]))
+	c.write(&f)
+}
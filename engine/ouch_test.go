@@ -0,0 +1,251 @@
+package engine
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+func newOuchGateway(t testing.TB, config OuchConfig) (*OuchGateway, *Engine) {
+	e := NewEngine(EngineConfig{})
+	e.AddInstrument("BTC")
+	g := NewOuchGateway(e, config)
+	if err := g.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { g.Close() })
+	return g, e
+}
+
+func dialOuch(t testing.TB, g *OuchGateway) *OuchClient {
+	c, err := DialOuch(g.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func expectOuch(t testing.TB, c *OuchClient, msgType byte) *OuchResponse {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var resp OuchResponse
+	if err := c.Read(&resp); err != nil {
+		t.Fatalf("reading from the gateway: %v", err)
+	}
+	if resp.Type != msgType {
+		t.Fatalf("expected message %c, got %c", msgType, resp.Type)
+	}
+	return &resp
+}
+
+func ouchLimit(token uint32, side byte, quantity float32, price float32) OuchEnterOrder {
+	return OuchEnterOrder{Token: token, Side: side, OrderType: OuchLimit, Quantity: quantity, Price: price,
+		AccountId: 1, Symbol: "BTC"}
+}
+
+func TestOuchCodec(t *testing.T) {
+	var buf [ouchAcceptedLen]byte
+	enter := OuchEnterOrder{Token: 7, Side: OuchSell, OrderType: OuchMarket, Quantity: 1.5, Price: 10.25,
+		AccountId: 3, Flags: OuchFlagCancelOnDisconnect, Symbol: "ETHUSD"}
+	b := enter.encode(buf[:])
+	if len(b) != ouchInboundLen(b[0]) {
+		t.Fatalf("EnterOrder should be %d bytes, got %d", ouchInboundLen(b[0]), len(b))
+	}
+	var decoded OuchEnterOrder
+	decoded.decode(b)
+	if decoded != enter {
+		t.Errorf("EnterOrder should survive a round trip, got %+v", decoded)
+	}
+	incoming, ok := decoded.incoming()
+	if !ok || incoming.BidOrAsk || incoming.OrderType != orders.MARKET || incoming.Quantity != 1.5 ||
+		incoming.AccountId != 3 || !incoming.CancelOnDisconnect {
+		t.Errorf("EnterOrder should map onto IncomingOrder, got %+v", incoming)
+	}
+
+	executed := OuchExecuted{Timestamp: 1 << 40, Token: 9, Quantity: 2, Price: 3, Leaves: 4, MatchNumber: 5}
+	b = executed.encode(buf[:])
+	var decodedExecuted OuchExecuted
+	decodedExecuted.decode(b)
+	if len(b) != ouchOutboundLen(b[0]) || decodedExecuted != executed {
+		t.Errorf("Executed should survive a round trip, got %+v", decodedExecuted)
+	}
+}
+
+func TestOuchOrderLifecycle(t *testing.T) {
+	g, _ := newOuchGateway(t, OuchConfig{})
+	maker := dialOuch(t, g)
+	taker := dialOuch(t, g)
+
+	maker.Enter(ouchLimit(1, OuchSell, 10, 100))
+	accepted := expectOuch(t, maker, ouchAccepted).Accepted
+	if accepted.Token != 1 || accepted.OrderRef == 0 || accepted.Symbol != "BTC" || accepted.Quantity != 10 {
+		t.Errorf("unexpected Accepted %+v", accepted)
+	}
+
+	taker.Enter(ouchLimit(1, OuchBuy, 4, 101))
+	expectOuch(t, taker, ouchAccepted)
+	fill := expectOuch(t, taker, ouchExecuted).Executed
+	if fill.Quantity != 4 || fill.Price != 100 || fill.Leaves != 0 {
+		t.Errorf("the taker should fill 4 at 100, got %+v", fill)
+	}
+	makerFill := expectOuch(t, maker, ouchExecuted).Executed
+	if makerFill.Leaves != 6 || makerFill.MatchNumber != fill.MatchNumber {
+		t.Errorf("the maker should have 6 left under the same match number, got %+v", makerFill)
+	}
+
+	maker.Replace(OuchReplaceOrder{Token: 1, NewToken: 2, Quantity: 8, Price: 102})
+	replaced := expectOuch(t, maker, ouchReplaced).Replaced
+	if replaced.Token != 2 || replaced.PreviousToken != 1 || replaced.Price != 102 {
+		t.Errorf("unexpected Replaced %+v", replaced)
+	}
+
+	maker.Cancel(1)
+	if reason := expectOuch(t, maker, ouchRejected).Rejected.Reason; reason != OuchRejectUnknownOrder {
+		t.Errorf("the replaced token should be unknown, got %c", reason)
+	}
+	maker.Cancel(2)
+	cancelled := expectOuch(t, maker, ouchCanceled).Canceled
+	if cancelled.Token != 2 || cancelled.Quantity != 8 || cancelled.Reason != OuchCancelUser {
+		t.Errorf("unexpected Canceled %+v", cancelled)
+	}
+}
+
+func TestOuchMarketRemainder(t *testing.T) {
+	g, _ := newOuchGateway(t, OuchConfig{})
+	maker := dialOuch(t, g)
+	taker := dialOuch(t, g)
+
+	maker.Enter(ouchLimit(1, OuchSell, 4, 100))
+	expectOuch(t, maker, ouchAccepted)
+
+	taker.Enter(OuchEnterOrder{Token: 1, Side: OuchBuy, OrderType: OuchMarket, Quantity: 10, AccountId: 2, Symbol: "BTC"})
+	expectOuch(t, taker, ouchAccepted)
+	if fill := expectOuch(t, taker, ouchExecuted).Executed; fill.Quantity != 4 || fill.Leaves != 6 {
+		t.Errorf("the taker should fill 4 with 6 left, got %+v", fill)
+	}
+	cancelled := expectOuch(t, taker, ouchCanceled).Canceled
+	if cancelled.Quantity != 6 || cancelled.Reason != OuchCancelImmediate {
+		t.Errorf("the 6 left should be cancelled at once, got %+v", cancelled)
+	}
+}
+
+func TestOuchRejects(t *testing.T) {
+	g, e := newOuchGateway(t, OuchConfig{})
+	c := dialOuch(t, g)
+
+	o := ouchLimit(1, OuchBuy, 1, 10)
+	o.Symbol = "XYZ"
+	c.Enter(o)
+	if reason := expectOuch(t, c, ouchRejected).Rejected.Reason; reason != OuchRejectUnknownSymbol {
+		t.Errorf("expected an unknown symbol reject, got %c", reason)
+	}
+	c.Enter(ouchLimit(1, OuchBuy, 0, 10))
+	if reason := expectOuch(t, c, ouchRejected).Rejected.Reason; reason != OuchRejectInvalidQuantity {
+		t.Errorf("expected an invalid quantity reject, got %c", reason)
+	}
+	nan, inf := float32(math.NaN()), float32(math.Inf(1))
+	for _, bad := range []struct {
+		o      OuchEnterOrder
+		reason byte
+	}{
+		{ouchLimit(1, OuchBuy, nan, 10), OuchRejectInvalidQuantity},
+		{ouchLimit(1, OuchBuy, inf, 10), OuchRejectInvalidQuantity},
+		{ouchLimit(1, OuchBuy, 1, nan), OuchRejectInvalidPrice},
+		{ouchLimit(1, OuchSell, 1, -inf), OuchRejectInvalidPrice},
+		{ouchLimit(1, OuchBuy, 1, 0), OuchRejectInvalidPrice},
+	} {
+		c.Enter(bad.o)
+		if reason := expectOuch(t, c, ouchRejected).Rejected.Reason; reason != bad.reason {
+			t.Errorf("%+v should be rejected with %c, got %c", bad.o, bad.reason, reason)
+		}
+	}
+	c.Enter(ouchLimit(1, 'Z', 1, 10))
+	if reason := expectOuch(t, c, ouchRejected).Rejected.Reason; reason != OuchRejectInvalidMessage {
+		t.Errorf("expected an invalid message reject, got %c", reason)
+	}
+
+	c.Enter(ouchLimit(1, OuchBuy, 1, 10))
+	expectOuch(t, c, ouchAccepted)
+	c.Enter(ouchLimit(1, OuchBuy, 1, 10))
+	if reason := expectOuch(t, c, ouchRejected).Rejected.Reason; reason != OuchRejectDuplicateToken {
+		t.Errorf("expected a duplicate token reject, got %c", reason)
+	}
+
+	e.Kill(1)
+	if cancelled := expectOuch(t, c, ouchCanceled).Canceled; cancelled.Reason != OuchCancelSupervisory {
+		t.Errorf("the kill switch should cancel with a supervisory reason, got %c", cancelled.Reason)
+	}
+	c.Enter(ouchLimit(2, OuchBuy, 1, 10))
+	if reason := expectOuch(t, c, ouchRejected).Rejected.Reason; reason != OuchRejectKilled {
+		t.Errorf("expected a kill switch reject, got %c", reason)
+	}
+
+	// a dropped session and a full book, mapped without provoking them
+	for err, reason := range map[error]byte{ErrSessionNotConnected: OuchRejectNotConnected, book.ErrMemoryBudget: OuchRejectMemoryBudget} {
+		if got := ouchRejectReason(err); got != reason {
+			t.Errorf("%v should be rejected with %c, got %c", err, reason, got)
+		}
+	}
+}
+
+func TestOuchCancelOnDisconnect(t *testing.T) {
+	g, e := newOuchGateway(t, OuchConfig{})
+	c := dialOuch(t, g)
+	flagged := ouchLimit(1, OuchBuy, 1, 10)
+	flagged.Flags = OuchFlagCancelOnDisconnect
+	c.Enter(flagged)
+	expectOuch(t, c, ouchAccepted)
+	c.Enter(ouchLimit(2, OuchBuy, 1, 11))
+	expectOuch(t, c, ouchAccepted)
+
+	c.Close()
+	book, _ := e.Book("BTC")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e.mu.Lock()
+		left := book.BLength()
+		e.mu.Unlock()
+		if left == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("only the flagged order should be cancelled when the connection drops")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// round trip of an order through the gateway over loopback: EnterOrder to Accepted,
+// then CancelOrder to Canceled
+func BenchmarkOuchLoopbackLatency(b *testing.B) {
+	g, _ := newOuchGateway(b, OuchConfig{})
+	c := dialOuch(b, g)
+
+	samples := make([]time.Duration, 0, b.N)
+	var resp OuchResponse
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		token := c.NextToken()
+		start := time.Now()
+		c.Enter(ouchLimit(token, OuchBuy, 1, 10))
+		if err := c.Read(&resp); err != nil || resp.Type != ouchAccepted {
+			b.Fatalf("expected Accepted, got %c %v", resp.Type, err)
+		}
+		samples = append(samples, time.Since(start))
+
+		c.Cancel(token)
+		if err := c.Read(&resp); err != nil || resp.Type != ouchCanceled {
+			b.Fatalf("expected Canceled, got %c %v", resp.Type, err)
+		}
+	}
+	b.StopTimer()
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	b.ReportMetric(float64(samples[len(samples)/2].Nanoseconds()), "p50-enter-ns")
+	b.ReportMetric(float64(samples[len(samples)*99/100].Nanoseconds()), "p99-enter-ns")
+}
@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"errors"
+	"sync"
+
+	"matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+var (
+	ErrRiskOrderSize = errors.New("risk: order quantity over the account limit")
+	ErrRiskPosition  = errors.New("risk: order could breach the account position limit")
+	ErrRiskExposure  = errors.New("risk: order would breach the account open exposure limit")
+	ErrRiskBalance   = errors.New("risk: insufficient available balance")
+)
+
+// pre-trade risk check consulted by the Engine before an order reaches the book.
+// implement it to back the checks with an external ledger
+type RiskChecker interface {
+	// returns an error if the order would breach the limits of its account
+	Check(symbol string, o orders.IncomingOrder) error
+	// Check for the replacement of a resting order of the same account, with the open
+	// quantity of old released as the replace cancels it
+	CheckReplace(symbol string, old orders.Order, o orders.IncomingOrder) error
+	// every event of the books, to keep positions and exposure up to date
+	OnEvent(e book.Event)
+}
+
+// per account limits, zero means no limit
+type RiskLimits struct {
+	// largest quantity of a single order
+	MaxOrderQuantity float32
+	// largest net position per instrument, counting the resting orders as if they were filled
+	MaxPosition float32
+	// largest notional of the resting orders across instruments
+	MaxOpenExposure float32
+}
+
+// in-memory RiskChecker keeping cash balance, positions and resting orders per account.
+// bids reserve their notional from the balance, asks may go short within MaxPosition.
+// the Engine hands market orders over priced at the worst level they would reach
+type AccountRisk struct {
+	mu       sync.Mutex
+	limits   RiskLimits
+	accounts map[uint32]*riskAccount
+}
+
+type riskAccount struct {
+	limits    RiskLimits
+	balance   float32
+	positions map[string]*riskPosition
+	// notional of the resting bids and asks
+	openBuyNotional  float32
+	openSellNotional float32
+}
+
+type riskPosition struct {
+	net float32
+	// resting quantity on each side
+	openBuy  float32
+	openSell float32
+}
+
+// limits applies to every account unless overridden with SetLimits
+func NewAccountRisk(limits RiskLimits) *AccountRisk {
+	return &AccountRisk{
+		limits:   limits,
+		accounts: make(map[uint32]*riskAccount),
+	}
+}
+
+func (r *AccountRisk) account(accountId uint32) *riskAccount {
+	a, ok := r.accounts[accountId]
+	if !ok {
+		a = &riskAccount{
+			limits:    r.limits,
+			positions: make(map[string]*riskPosition),
+		}
+		r.accounts[accountId] = a
+	}
+	return a
+}
+
+func (a *riskAccount) position(symbol string) *riskPosition {
+	p, ok := a.positions[symbol]
+	if !ok {
+		p = &riskPosition{}
+		a.positions[symbol] = p
+	}
+	return p
+}
+
+func (r *AccountRisk) SetLimits(accountId uint32, limits RiskLimits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.account(accountId).limits = limits
+}
+
+// adds cash to the account, negative amounts withdraw
+func (r *AccountRisk) Deposit(accountId uint32, amount float32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.account(accountId).balance += amount
+}
+
+// cash balance, including the part reserved by resting bids
+func (r *AccountRisk) Balance(accountId uint32) float32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.account(accountId).balance
+}
+
+// cash not reserved by resting bids
+func (r *AccountRisk) AvailableBalance(accountId uint32) float32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a := r.account(accountId)
+	return a.balance - a.openBuyNotional
+}
+
+// net filled position of the account in an instrument
+func (r *AccountRisk) Position(accountId uint32, symbol string) float32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.account(accountId).position(symbol).net
+}
+
+// notional of the resting orders of the account
+func (r *AccountRisk) OpenExposure(accountId uint32) float32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a := r.account(accountId)
+	return a.openBuyNotional + a.openSellNotional
+}
+
+func (r *AccountRisk) Check(symbol string, o orders.IncomingOrder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.check(symbol, o, nil)
+}
+
+func (r *AccountRisk) CheckReplace(symbol string, old orders.Order, o orders.IncomingOrder) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.check(symbol, o, &old)
+}
+
+func (r *AccountRisk) check(symbol string, o orders.IncomingOrder, replaced *orders.Order) error {
+	a := r.account(o.AccountId)
+	p := a.position(symbol)
+	notional := o.Price * o.Quantity
+	openBuy, openSell := p.openBuy, p.openSell
+	openBuyNotional, openSellNotional := a.openBuyNotional, a.openSellNotional
+	if replaced != nil {
+		open := replaced.Order.Quantity - replaced.ExecutedQuantity
+		if replaced.Order.BidOrAsk {
+			openBuy -= open
+			openBuyNotional -= replaced.Order.Price * open
+		} else {
+			openSell -= open
+			openSellNotional -= replaced.Order.Price * open
+		}
+	}
+
+	if a.limits.MaxOrderQuantity > 0 && o.Quantity > a.limits.MaxOrderQuantity {
+		return ErrRiskOrderSize
+	}
+	if a.limits.MaxPosition > 0 {
+		// worst case, every resting order on the same side gets filled too
+		if o.BidOrAsk && p.net+openBuy+o.Quantity > a.limits.MaxPosition {
+			return ErrRiskPosition
+		}
+		if !o.BidOrAsk && -(p.net-openSell-o.Quantity) > a.limits.MaxPosition {
+			return ErrRiskPosition
+		}
+	}
+	if a.limits.MaxOpenExposure > 0 && openBuyNotional+openSellNotional+notional > a.limits.MaxOpenExposure {
+		return ErrRiskExposure
+	}
+	if o.BidOrAsk && openBuyNotional+notional > a.balance {
+		return ErrRiskBalance
+	}
+	return nil
+}
+
+func (r *AccountRisk) OnEvent(e book.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch e.Type {
+	case book.EventAdded:
+		r.open(e.AccountId, e.Symbol, e.BidOrAsk, e.Price, e.Quantity)
+	case book.EventCancelled:
+		// an expired market remainder was never open
+		if !e.Expired {
+			r.open(e.AccountId, e.Symbol, e.BidOrAsk, e.Price, -e.Quantity)
+		}
+	case book.EventFill:
+		// the maker was resting on the other side at the fill price
+		r.open(e.MakerAccountId, e.Symbol, !e.BidOrAsk, e.Price, -e.Quantity)
+		r.fill(e.MakerAccountId, e.Symbol, !e.BidOrAsk, e.Price, e.Quantity)
+		r.fill(e.AccountId, e.Symbol, e.BidOrAsk, e.Price, e.Quantity)
+	}
+}
+
+// moves quantity in or out of the resting orders of an account
+func (r *AccountRisk) open(accountId uint32, symbol string, bidOrAsk bool, price float32, quantity float32) {
+	a := r.account(accountId)
+	p := a.position(symbol)
+	if bidOrAsk {
+		p.openBuy += quantity
+		a.openBuyNotional += price * quantity
+	} else {
+		p.openSell += quantity
+		a.openSellNotional += price * quantity
+	}
+}
+
+func (r *AccountRisk) fill(accountId uint32, symbol string, bidOrAsk bool, price float32, quantity float32) {
+	a := r.account(accountId)
+	p := a.position(symbol)
+	if bidOrAsk {
+		p.net += quantity
+		a.balance -= price * quantity
+	} else {
+		p.net -= quantity
+		a.balance += price * quantity
+	}
+}
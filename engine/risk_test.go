@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"testing"
+
+	"matching-engine-go/orders"
+)
+
+func newRiskEngine(limits RiskLimits) (*Engine, *AccountRisk) {
+	risk := NewAccountRisk(limits)
+	e := NewEngine(EngineConfig{Risk: risk})
+	e.AddInstrument("BTC")
+	return e, risk
+}
+
+func TestRiskOrderSize(t *testing.T) {
+	e, risk := newRiskEngine(RiskLimits{MaxOrderQuantity: 10})
+	risk.Deposit(1, 1000)
+
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(1, 11, true, orders.LIMIT, 1)); err != ErrRiskOrderSize {
+		t.Errorf("order over the size limit should be rejected, got %v", err)
+	}
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(1, 10, true, orders.LIMIT, 1)); err != nil {
+		t.Errorf("order at the size limit should pass: %v", err)
+	}
+
+	// per account override
+	risk.SetLimits(2, RiskLimits{})
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(1, 100, false, orders.LIMIT, 2)); err != nil {
+		t.Errorf("account without limits should pass: %v", err)
+	}
+}
+
+func TestRiskBalance(t *testing.T) {
+	e, risk := newRiskEngine(RiskLimits{})
+	risk.Deposit(1, 100)
+
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(10, 6, true, orders.LIMIT, 1)); err != nil {
+		t.Fatalf("bid within the balance should pass: %v", err)
+	}
+	if risk.AvailableBalance(1) != 40 {
+		t.Errorf("resting bid should reserve 60, available %0.8f", risk.AvailableBalance(1))
+	}
+	// the resting bid counts against the balance
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(10, 5, true, orders.LIMIT, 1)); err != ErrRiskBalance {
+		t.Errorf("bid over the available balance should be rejected, got %v", err)
+	}
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(10, 5, true, orders.LIMIT, 3)); err != ErrRiskBalance {
+		t.Errorf("account without cash should not buy, got %v", err)
+	}
+}
+
+func TestRiskMarketOrders(t *testing.T) {
+	e, risk := newRiskEngine(RiskLimits{})
+	e.Submit("BTC", orders.NewIncomingOrder(90, 5, false, orders.LIMIT, 2))
+	e.Submit("BTC", orders.NewIncomingOrder(100, 5, false, orders.LIMIT, 2))
+
+	// 10 would sweep up to 100, 1000 at most
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(0, 10, true, orders.MARKET, 1)); err != ErrRiskBalance {
+		t.Errorf("market bid without cash should be rejected, got %v", err)
+	}
+	risk.Deposit(1, 450)
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(0, 5, true, orders.MARKET, 1)); err != nil {
+		t.Errorf("market bid within the balance should pass: %v", err)
+	}
+	if risk.Balance(1) != 0 || risk.Position(1, "BTC") != 5 {
+		t.Errorf("account 1 should have spent its 450 on 5, balance %0.8f", risk.Balance(1))
+	}
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(0, 5, true, orders.MARKET, 1)); err != ErrRiskBalance {
+		t.Errorf("market bid over the balance should be rejected, got %v", err)
+	}
+}
+
+func TestRiskPosition(t *testing.T) {
+	e, risk := newRiskEngine(RiskLimits{MaxPosition: 10})
+	risk.Deposit(1, 1000)
+
+	e.Submit("BTC", orders.NewIncomingOrder(10, 6, true, orders.LIMIT, 1))
+	// resting bids count as if filled
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(9, 5, true, orders.LIMIT, 1)); err != ErrRiskPosition {
+		t.Errorf("bid that could breach the position should be rejected, got %v", err)
+	}
+
+	// shorts are bounded too
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(20, 11, false, orders.LIMIT, 2)); err != ErrRiskPosition {
+		t.Errorf("ask that could breach the short position should be rejected, got %v", err)
+	}
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(20, 10, false, orders.LIMIT, 2)); err != nil {
+		t.Errorf("ask at the position limit should pass: %v", err)
+	}
+}
+
+func TestRiskExposure(t *testing.T) {
+	e, risk := newRiskEngine(RiskLimits{MaxOpenExposure: 100})
+	risk.Deposit(1, 1000)
+
+	o, _ := e.Submit("BTC", orders.NewIncomingOrder(10, 8, true, orders.LIMIT, 1))
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(20, 2, false, orders.LIMIT, 1)); err != ErrRiskExposure {
+		t.Errorf("order over the open exposure should be rejected, got %v", err)
+	}
+
+	// cancelling frees the exposure
+	e.Cancel("BTC", o.SequenceId)
+	if risk.OpenExposure(1) != 0 {
+		t.Errorf("cancel should release the exposure, got %0.8f", risk.OpenExposure(1))
+	}
+	if _, err := e.Submit("BTC", orders.NewIncomingOrder(20, 2, false, orders.LIMIT, 1)); err != nil {
+		t.Errorf("order should pass once exposure is released: %v", err)
+	}
+}
+
+func TestRiskReplace(t *testing.T) {
+	e, risk := newRiskEngine(RiskLimits{MaxOpenExposure: 100, MaxPosition: 10})
+	risk.Deposit(1, 100)
+
+	o, _ := e.Submit("BTC", orders.NewIncomingOrder(10, 8, true, orders.LIMIT, 1))
+	// the bid it replaces does not count twice
+	replaced, err := e.Replace("BTC", o.SequenceId, orders.NewIncomingOrder(10, 10, true, orders.LIMIT, 1))
+	if err != nil {
+		t.Fatalf("replace within the limits should pass: %v", err)
+	}
+	if risk.OpenExposure(1) != 100 || risk.AvailableBalance(1) != 0 {
+		t.Errorf("only the replacement should be open, exposure %0.8f", risk.OpenExposure(1))
+	}
+	if _, err := e.Replace("BTC", replaced.SequenceId, orders.NewIncomingOrder(10, 11, true, orders.LIMIT, 1)); err != ErrRiskPosition {
+		t.Errorf("replace over the limits should be rejected, got %v", err)
+	}
+	if risk.OpenExposure(1) != 100 {
+		t.Errorf("a rejected replace should leave the order open, exposure %0.8f", risk.OpenExposure(1))
+	}
+}
+
+func TestRiskFills(t *testing.T) {
+	e, risk := newRiskEngine(RiskLimits{})
+	risk.Deposit(1, 1000)
+
+	e.Submit("BTC", orders.NewIncomingOrder(10, 5, false, orders.LIMIT, 2))
+	e.Submit("BTC", orders.NewIncomingOrder(11, 5, false, orders.LIMIT, 2))
+	if risk.OpenExposure(2) != 105 {
+		t.Errorf("resting asks should count as exposure, got %0.8f", risk.OpenExposure(2))
+	}
+
+	// sweeps both asks and rests 2 at 11
+	e.Submit("BTC", orders.NewIncomingOrder(11, 12, true, orders.LIMIT, 1))
+
+	if risk.Position(1, "BTC") != 10 || risk.Position(2, "BTC") != -10 {
+		t.Errorf("positions should be 10 and -10, got %0.8f %0.8f", risk.Position(1, "BTC"), risk.Position(2, "BTC"))
+	}
+	if risk.Balance(1) != 1000-105 || risk.Balance(2) != 105 {
+		t.Errorf("balances should move by the traded notional, got %0.8f %0.8f", risk.Balance(1), risk.Balance(2))
+	}
+	if risk.OpenExposure(2) != 0 {
+		t.Errorf("filled asks should release their exposure, got %0.8f", risk.OpenExposure(2))
+	}
+	if risk.OpenExposure(1) != 22 || risk.AvailableBalance(1) != 1000-105-22 {
+		t.Errorf("resting remainder should be reserved, exposure %0.8f", risk.OpenExposure(1))
+	}
+}
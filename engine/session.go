@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"errors"
+	"time"
+
+	"matching-engine-go/book"
+	"matching-engine-go/orders"
+)
+
+var ErrSessionNotConnected = errors.New("engine: session is not connected")
+
+// gateway session as seen by the engine
+type session struct {
+	connected bool
+	// delay between a disconnect and the cancel of the session orders
+	grace time.Duration
+	timer Timer
+	// bumped on every connect, so a timer firing late for a previous disconnect is ignored
+	epoch uint64
+}
+
+func (s *session) isConnected() bool {
+	return s != nil && s.connected
+}
+
+// hands out an unused session id, for gateways numbering their sessions
+func (e *Engine) NewSessionId() uint32 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastSessionId++
+	for e.sessions[e.lastSessionId] != nil || e.lastSessionId == 0 {
+		e.lastSessionId++
+	}
+	return e.lastSessionId
+}
+
+// registers a session, or brings a disconnected one back before its grace timer fires.
+// on disconnect its cancel-on-disconnect orders are cancelled once grace has elapsed
+func (e *Engine) Connect(sessionId uint32, grace time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, ok := e.sessions[sessionId]
+	if !ok {
+		s = &session{}
+		e.sessions[sessionId] = s
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.connected = true
+	s.grace = grace
+	s.epoch++
+}
+
+// marks a session as dropped. its cancel-on-disconnect orders are cancelled right away
+// without grace, or by a timer on the engine clock otherwise. returns the orders cancelled now
+func (e *Engine) Disconnect(sessionId uint32) []orders.Order {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, ok := e.sessions[sessionId]
+	if !ok || !s.connected {
+		return nil
+	}
+	s.connected = false
+	if s.grace <= 0 {
+		return e.cancelSession(sessionId)
+	}
+
+	epoch := s.epoch
+	s.timer = e.config.Clock.AfterFunc(s.grace, func() {
+		e.expireSession(sessionId, epoch)
+	})
+	return nil
+}
+
+func (e *Engine) IsConnected(sessionId uint32) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.sessions[sessionId].isConnected()
+}
+
+// grace timer callback
+func (e *Engine) expireSession(sessionId uint32, epoch uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, ok := e.sessions[sessionId]
+	if !ok || s.connected || s.epoch != epoch {
+		// reconnected in the meantime
+		return
+	}
+	s.timer = nil
+	e.cancelSession(sessionId)
+}
+
+func (e *Engine) cancelSession(sessionId uint32) []orders.Order {
+	cancelled := make([]orders.Order, 0)
+	e.eachBook(func(book *book.Orderbook) {
+		cancelled = append(cancelled, book.CancelSession(sessionId)...)
+	})
+	return cancelled
+}
@@ -0,0 +1,128 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"matching-engine-go/orders"
+)
+
+func newSessionEngine() (*Engine, *ManualClock) {
+	return newTestEngine(EngineConfig{}, time.Unix(0, 0), "BTC", "ETH")
+}
+
+func sessionOrder(price float32, sessionId uint32, cancelOnDisconnect bool) orders.IncomingOrder {
+	o := orders.NewIncomingOrder(price, 1, true, orders.LIMIT, 1)
+	o.SessionId = sessionId
+	o.CancelOnDisconnect = cancelOnDisconnect
+	return o
+}
+
+func TestSessionNotConnected(t *testing.T) {
+	e, _ := newSessionEngine()
+	if _, err := e.Submit("BTC", sessionOrder(10, 7, true)); err != ErrSessionNotConnected {
+		t.Errorf("order from an unknown session should be rejected, got %v", err)
+	}
+	e.Connect(7, 0)
+	if _, err := e.Submit("BTC", sessionOrder(10, 7, true)); err != nil {
+		t.Errorf("order from a connected session should pass: %v", err)
+	}
+	e.Disconnect(7)
+	if _, err := e.Submit("BTC", sessionOrder(10, 7, true)); err != ErrSessionNotConnected {
+		t.Errorf("order from a dropped session should be rejected, got %v", err)
+	}
+}
+
+func TestSessionCancelOnDisconnect(t *testing.T) {
+	e, _ := newSessionEngine()
+	e.Connect(1, 0)
+	e.Connect(2, 0)
+	e.Submit("BTC", sessionOrder(10, 1, true))
+	e.Submit("ETH", sessionOrder(11, 1, true))
+	e.Submit("BTC", sessionOrder(12, 1, false))
+	e.Submit("BTC", sessionOrder(13, 2, true))
+
+	cancelled := e.Disconnect(1)
+	if len(cancelled) != 2 {
+		t.Fatalf("only the flagged orders of session 1 should be cancelled, got %d", len(cancelled))
+	}
+	btc, _ := e.Book("BTC")
+	eth, _ := e.Book("ETH")
+	if btc.BLength() != 2 || btc.GetBestBid() != 13 || eth.BLength() != 0 {
+		t.Errorf("unflagged orders and other sessions should stay in the book")
+	}
+	if e.IsConnected(1) || !e.IsConnected(2) {
+		t.Errorf("only session 1 should be disconnected")
+	}
+}
+
+func TestSessionGraceTimer(t *testing.T) {
+	e, clock := newSessionEngine()
+	e.Connect(1, 5*time.Second)
+	e.Submit("BTC", sessionOrder(10, 1, true))
+	btc, _ := e.Book("BTC")
+
+	if cancelled := e.Disconnect(1); len(cancelled) != 0 {
+		t.Errorf("orders should survive during the grace period")
+	}
+	clock.Advance(4 * time.Second)
+	if btc.BLength() != 1 {
+		t.Errorf("order should rest until the grace period ends")
+	}
+	clock.Advance(time.Second)
+	if btc.BLength() != 0 {
+		t.Errorf("order should be cancelled once the grace period ends")
+	}
+}
+
+func TestSessionReconnectWithinGrace(t *testing.T) {
+	e, clock := newSessionEngine()
+	e.Connect(1, 5*time.Second)
+	e.Submit("BTC", sessionOrder(10, 1, true))
+	btc, _ := e.Book("BTC")
+
+	e.Disconnect(1)
+	clock.Advance(3 * time.Second)
+	e.Connect(1, 5*time.Second)
+	clock.Advance(10 * time.Second)
+	if btc.BLength() != 1 {
+		t.Errorf("reconnecting should stop the grace timer")
+	}
+
+	// a later drop starts a fresh grace period
+	e.Disconnect(1)
+	clock.Advance(4 * time.Second)
+	if btc.BLength() != 1 {
+		t.Errorf("the new grace period should start at the new disconnect")
+	}
+	clock.Advance(time.Second)
+	if btc.BLength() != 0 {
+		t.Errorf("order should be cancelled after the new grace period")
+	}
+}
+
+func TestManualClockTimers(t *testing.T) {
+	clock := NewManualClock(time.Unix(100, 0))
+	fired := make([]int, 0)
+	clock.AfterFunc(3*time.Second, func() { fired = append(fired, 3) })
+	clock.AfterFunc(1*time.Second, func() {
+		fired = append(fired, 1)
+		if clock.Now() != time.Unix(101, 0) {
+			t.Errorf("timer should see the time it was due at, got %v", clock.Now())
+		}
+		// scheduling from a callback
+		clock.AfterFunc(time.Second, func() { fired = append(fired, 2) })
+	})
+	stopped := clock.AfterFunc(2*time.Second, func() { fired = append(fired, -1) })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Errorf("a timer can only be stopped once")
+	}
+
+	clock.Advance(10 * time.Second)
+	if len(fired) != 3 || fired[0] != 1 || fired[1] != 2 || fired[2] != 3 {
+		t.Errorf("timers should fire in deadline order, got %v", fired)
+	}
+	if clock.Now() != time.Unix(110, 0) {
+		t.Errorf("clock should end at the target time, got %v", clock.Now())
+	}
+}
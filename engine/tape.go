@@ -0,0 +1,292 @@
+package engine
+
+import (
+	"time"
+
+	"matching-engine-go/book"
+	"matching-engine-go/ds"
+)
+
+const (
+	// trades and closed bars kept per instrument by default
+	DefaultTapeSize = 1024
+	DefaultBarsSize = 1024
+)
+
+// trade tape and bar settings, the zero value keeps 1024 trades and 1s and 1m bars
+type TapeConfig struct {
+	// recent trades kept per instrument
+	Size int
+	// bar lengths, each instrument gets a bar series per interval
+	Intervals []time.Duration
+	// closed bars kept per instrument and interval
+	Bars int
+}
+
+// a fill as printed on the tape
+type Trade struct {
+	Symbol string
+	// time of the match, from the book event
+	Time     time.Time
+	Price    float32
+	Quantity float32
+	// side of the incoming order, the one that took liquidity
+	BidOrAsk        bool
+	TakerSequenceId uint32
+	MakerSequenceId uint32
+}
+
+// open, high, low, close and volume of the trades of one time bucket
+type Bar struct {
+	Symbol   string
+	Interval time.Duration
+	// start of the bucket, a multiple of Interval
+	Start    time.Time
+	Open     float32
+	High     float32
+	Low      float32
+	Close    float32
+	Volume   float32
+	Notional float64
+	Trades   int
+}
+
+func (b Bar) End() time.Time {
+	return b.Start.Add(b.Interval)
+}
+
+// called with the engine locked, it must not call back into the engine
+type TradeHandler func(t Trade)
+
+// called with the engine locked for each bar once it is closed
+type BarHandler func(b Bar)
+
+// tape of one instrument
+type symbolTape struct {
+	trades *ds.Deque[Trade]
+	last   Trade
+	traded bool
+	bars   []*barSeries
+}
+
+// bars of one instrument at one interval. the bar in progress is closed by a timer at
+// its end, or by the first trade of a later bucket if that comes first. buckets without
+// trades give no bar
+type barSeries struct {
+	interval time.Duration
+	closed   *ds.Deque[Bar]
+	current  Bar
+	open     bool
+	timer    Timer
+}
+
+func (config TapeConfig) withDefaults() TapeConfig {
+	if config.Size <= 0 {
+		config.Size = DefaultTapeSize
+	}
+	if config.Bars <= 0 {
+		config.Bars = DefaultBarsSize
+	}
+	if len(config.Intervals) == 0 {
+		config.Intervals = []time.Duration{time.Second, time.Minute}
+	}
+	return config
+}
+
+func newSymbolTape(config TapeConfig) *symbolTape {
+	tape := &symbolTape{trades: ds.New[Trade]()}
+	for _, interval := range config.Intervals {
+		tape.bars = append(tape.bars, &barSeries{interval: interval, closed: ds.New[Bar]()})
+	}
+	return tape
+}
+
+// fills go on the tape of their instrument and into its bars
+func (e *Engine) recordTrade(ev book.Event) {
+	tape := e.tapes[ev.Symbol]
+	if tape == nil {
+		return
+	}
+	trade := Trade{
+		Symbol:          ev.Symbol,
+		Time:            time.Unix(0, ev.Time),
+		Price:           ev.Price,
+		Quantity:        ev.Quantity,
+		BidOrAsk:        ev.BidOrAsk,
+		TakerSequenceId: ev.SequenceId,
+		MakerSequenceId: ev.MakerSequenceId,
+	}
+	tape.last, tape.traded = trade, true
+	tape.trades.PushBack(trade)
+	if tape.trades.Len() > e.config.Tape.Size {
+		tape.trades.PopFront()
+	}
+	for _, h := range e.tradeHandlers {
+		h(trade)
+	}
+	for _, series := range tape.bars {
+		e.addToBar(series, trade)
+	}
+}
+
+func (e *Engine) addToBar(series *barSeries, trade Trade) {
+	start := trade.Time.Truncate(series.interval)
+	if series.open && !series.current.Start.Equal(start) {
+		e.closeBar(series)
+	}
+	if !series.open {
+		series.current = Bar{
+			Symbol:   trade.Symbol,
+			Interval: series.interval,
+			Start:    start,
+			Open:     trade.Price,
+			High:     trade.Price,
+			Low:      trade.Price,
+		}
+		series.open = true
+		series.timer = e.config.Clock.AfterFunc(start.Add(series.interval).Sub(trade.Time), func() {
+			e.expireBar(series, start)
+		})
+	}
+
+	bar := &series.current
+	if trade.Price > bar.High {
+		bar.High = trade.Price
+	}
+	if trade.Price < bar.Low {
+		bar.Low = trade.Price
+	}
+	bar.Close = trade.Price
+	bar.Volume += trade.Quantity
+	bar.Notional += float64(trade.Price) * float64(trade.Quantity)
+	bar.Trades++
+}
+
+// bar timer callback
+func (e *Engine) expireBar(series *barSeries, start time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !series.open || !series.current.Start.Equal(start) {
+		// closed by a later trade in the meantime
+		return
+	}
+	e.closeBar(series)
+}
+
+func (e *Engine) closeBar(series *barSeries) {
+	if series.timer != nil {
+		series.timer.Stop()
+		series.timer = nil
+	}
+	series.open = false
+	series.closed.PushBack(series.current)
+	if series.closed.Len() > e.config.Tape.Bars {
+		series.closed.PopFront()
+	}
+	for _, h := range e.barHandlers {
+		h(series.current)
+	}
+}
+
+// stops the timers of the bars in progress, they stay open
+func (e *Engine) stopBars() {
+	for _, tape := range e.tapes {
+		for _, series := range tape.bars {
+			if series.timer != nil {
+				series.timer.Stop()
+				series.timer = nil
+			}
+		}
+	}
+}
+
+// registers a handler for every trade of every instrument
+func (e *Engine) SubscribeTrades(h TradeHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.tradeHandlers = append(e.tradeHandlers, h)
+}
+
+// registers a handler for every bar closed, at any interval
+func (e *Engine) SubscribeBars(h BarHandler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.barHandlers = append(e.barHandlers, h)
+}
+
+// last trade of an instrument, false if it has not traded or is not listed
+func (e *Engine) LastTrade(symbol string) (Trade, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tape := e.tapes[symbol]
+	if tape == nil || !tape.traded {
+		return Trade{}, false
+	}
+	return tape.last, true
+}
+
+// up to n of the latest trades of an instrument, oldest first. n <= 0 returns the whole tape
+func (e *Engine) RecentTrades(symbol string, n int) ([]Trade, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tape := e.tapes[symbol]
+	if tape == nil {
+		return nil, ErrUnknownSymbol
+	}
+	return latest(tape.trades, n), nil
+}
+
+// up to n of the latest closed bars of an instrument at an interval, oldest first.
+// n <= 0 returns every bar kept. nil if the interval is not aggregated
+func (e *Engine) Bars(symbol string, interval time.Duration, n int) ([]Bar, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	series, err := e.barSeries(symbol, interval)
+	if series == nil {
+		return nil, err
+	}
+	return latest(series.closed, n), nil
+}
+
+// the bar in progress of an instrument at an interval, false if there is none
+func (e *Engine) CurrentBar(symbol string, interval time.Duration) (Bar, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	series, _ := e.barSeries(symbol, interval)
+	if series == nil || !series.open {
+		return Bar{}, false
+	}
+	return series.current, true
+}
+
+func (e *Engine) barSeries(symbol string, interval time.Duration) (*barSeries, error) {
+	tape := e.tapes[symbol]
+	if tape == nil {
+		return nil, ErrUnknownSymbol
+	}
+	for _, series := range tape.bars {
+		if series.interval == interval {
+			return series, nil
+		}
+	}
+	return nil, nil
+}
+
+func latest[T any](q *ds.Deque[T], n int) []T {
+	size := q.Len()
+	if n <= 0 || n > size {
+		n = size
+	}
+	items := make([]T, n)
+	for i := range items {
+		items[i] = q.At(size - n + i)
+	}
+	return items
+}
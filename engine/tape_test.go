@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"matching-engine-go/orders"
+)
+
+func newTapeEngine(config TapeConfig) (*Engine, *ManualClock) {
+	return newTestEngine(EngineConfig{Tape: config}, time.Unix(1000, 0), "BTC")
+}
+
+// account 1 rests an ask and account 2 lifts it, one trade at price
+func trade(e *Engine, symbol string, price float32, quantity float32) {
+	e.Submit(symbol, orders.NewIncomingOrder(price, quantity, false, orders.LIMIT, 1))
+	e.Submit(symbol, orders.NewIncomingOrder(price, quantity, true, orders.LIMIT, 2))
+}
+
+func TestTapeRecentTrades(t *testing.T) {
+	e, clock := newTapeEngine(TapeConfig{Size: 3})
+	if _, ok := e.LastTrade("BTC"); ok {
+		t.Errorf("no last trade before any fill")
+	}
+	var seen []Trade
+	e.SubscribeTrades(func(tr Trade) { seen = append(seen, tr) })
+
+	for i, price := range []float32{10, 11, 12, 13} {
+		trade(e, "BTC", price, float32(i+1))
+		clock.Advance(time.Millisecond)
+	}
+
+	last, ok := e.LastTrade("BTC")
+	if !ok || last.Price != 13 || last.Quantity != 4 || !last.BidOrAsk || last.Time != time.Unix(1000, 3e6) {
+		t.Errorf("last trade should be the buy of 4 at 13, got %+v", last)
+	}
+	if last.TakerSequenceId != 8 || last.MakerSequenceId != 7 {
+		t.Errorf("last trade should name the taker 8 and the maker 7, got %+v", last)
+	}
+	trades, _ := e.RecentTrades("BTC", 0)
+	if len(trades) != 3 || trades[0].Price != 11 || trades[2].Price != 13 {
+		t.Errorf("the tape should keep the last 3 trades oldest first, got %+v", trades)
+	}
+	if trades, _ := e.RecentTrades("BTC", 2); len(trades) != 2 || trades[0].Price != 12 {
+		t.Errorf("the last 2 trades should start at 12, got %+v", trades)
+	}
+	if len(seen) != 4 {
+		t.Errorf("subscribers should see every trade, got %d", len(seen))
+	}
+	if _, err := e.RecentTrades("ETH", 0); err != ErrUnknownSymbol {
+		t.Errorf("unknown symbol should fail, got %v", err)
+	}
+}
+
+func TestTapeBars(t *testing.T) {
+	e, clock := newTapeEngine(TapeConfig{})
+	var closed []Bar
+	e.SubscribeBars(func(b Bar) { closed = append(closed, b) })
+
+	trade(e, "BTC", 10, 1)
+	clock.Advance(200 * time.Millisecond)
+	trade(e, "BTC", 12, 2)
+	clock.Advance(200 * time.Millisecond)
+	trade(e, "BTC", 9, 1)
+
+	current, ok := e.CurrentBar("BTC", time.Second)
+	if !ok || current.Open != 10 || current.High != 12 || current.Low != 9 || current.Close != 9 ||
+		current.Volume != 4 || current.Notional != 43 || current.Trades != 3 {
+		t.Errorf("the bar in progress should be 10/12/9/9 for 4, got %+v", current)
+	}
+	if len(closed) != 0 {
+		t.Errorf("no bar should be closed yet")
+	}
+
+	// the timer closes the bar at the end of its second
+	clock.Advance(600 * time.Millisecond)
+	if len(closed) != 1 || closed[0].Start != time.Unix(1000, 0) || closed[0].End() != time.Unix(1001, 0) {
+		t.Fatalf("the first second should be closed, got %+v", closed)
+	}
+	if _, ok := e.CurrentBar("BTC", time.Second); ok {
+		t.Errorf("no bar in progress after the close")
+	}
+
+	// a quiet second gives no bar
+	clock.Advance(1500 * time.Millisecond)
+	trade(e, "BTC", 11, 5)
+	clock.Advance(time.Second)
+	bars, _ := e.Bars("BTC", time.Second, 0)
+	if len(bars) != 2 || bars[1].Start != time.Unix(1002, 0) || bars[1].Open != 11 || bars[1].Volume != 5 {
+		t.Errorf("the second bar should start at 1002, got %+v", bars)
+	}
+
+	minute, ok := e.CurrentBar("BTC", time.Minute)
+	if !ok || minute.Start != time.Unix(960, 0) || minute.Trades != 4 || minute.High != 12 {
+		t.Errorf("the minute bar should hold every trade, got %+v", minute)
+	}
+	if bars, err := e.Bars("BTC", 5*time.Second, 0); bars != nil || err != nil {
+		t.Errorf("an interval not aggregated should give nothing, got %v %v", bars, err)
+	}
+}
+
+func TestTapeCloseStopsBarTimers(t *testing.T) {
+	e, clock := newTapeEngine(TapeConfig{Intervals: []time.Duration{time.Second}})
+	var closed []Bar
+	e.SubscribeBars(func(b Bar) { closed = append(closed, b) })
+
+	trade(e, "BTC", 10, 1)
+	e.Close()
+	clock.Advance(time.Minute)
+	if len(closed) != 0 {
+		t.Errorf("a closed engine should not close bars, got %+v", closed)
+	}
+}
@@ -0,0 +1,5 @@
+// Package enginepb holds the protobuf schema of the matching engine gRPC service and
+// the Go code generated from it.
+package enginepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative engine.proto
@@ -1,4 +1,17 @@
 module matching-engine-go
 
-go 1.19
+go 1.21
 
+require (
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/term v0.22.0
+	google.golang.org/grpc v1.65.0
+	google.golang.org/protobuf v1.34.2
+)
+
+require (
+	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240528184218-531527333157 // indirect
+)
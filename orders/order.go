@@ -0,0 +1,42 @@
+// Package orders holds the order types shared by the book, the engine and its gateways.
+package orders
+
+type OrderType uint8
+
+const (
+	MARKET OrderType = iota
+	LIMIT
+)
+
+// single order in the orderqueue
+type Order struct {
+	Order IncomingOrder // 20
+	//each order is given a unique increasing sequenceId for deterministically handling
+	SequenceId       uint32  // 4
+	ExecutedQuantity float32 // 4
+	// unix nanoseconds the engine received the order and the book accepted it at, 0 if not stamped.
+	// matches and the cancel are stamped on their events, a cancelled order leaves the book
+	ReceivedAt int64 // 8
+	AcceptedAt int64 // 8
+}
+
+type IncomingOrder struct {
+	Price    float32 //4
+	Quantity float32 //4
+	BidOrAsk bool    // 1
+	// market / limit
+	OrderType OrderType // 1
+	// cancelled when its session disconnects, fits in the padding before AccountId
+	CancelOnDisconnect bool   // 1
+	AccountId          uint32 // 4
+	// gateway session the order was entered through, 0 for none
+	SessionId uint32 // 4
+}
+
+func NewIncomingOrder(price float32, quantity float32, BidOrAsk bool, orderType OrderType, accountId uint32) IncomingOrder {
+	return IncomingOrder{Price: price, Quantity: quantity, BidOrAsk: BidOrAsk, OrderType: orderType, AccountId: accountId}
+}
+
+func NewOrder(incomingOrder IncomingOrder, sequenceId uint32) Order {
+	return Order{Order: incomingOrder, SequenceId: sequenceId}
+}